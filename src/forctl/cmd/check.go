@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cmd
+
+import (
+	"forctl/io"
+	"skywalker/config"
+	"skywalker/rpc"
+)
+
+/*
+ * 指定了文件时由forctl自己读取并检查，skywalker只检查当前使用的配置文件，
+ * 避免通过命令端口读取任意的文件和环境变量
+ */
+func buildCheckRequest(cmd *Command, args ...string) *rpc.Request {
+	if len(args) == 0 {
+		return buildCommonRequest(cmd)
+	}
+	var problems []*rpc.CheckResponse_Problem
+	for _, p := range config.Check(args[0]) {
+		problems = append(problems, &rpc.CheckResponse_Problem{
+			File: p.File,
+			Line: int32(p.Line),
+			Msg:  p.Msg,
+		})
+	}
+	printProblems(args[0], problems)
+	return nil
+}
+
+/* 处理check命令的返回结果 */
+func processCheckResponse(v interface{}) error {
+	rep := v.(*rpc.CheckResponse)
+	printProblems(rep.GetFile(), rep.GetProblems())
+	return nil
+}
+
+func printProblems(file string, problems []*rpc.CheckResponse_Problem) {
+	for _, p := range problems {
+		if p.GetLine() > 0 {
+			io.PrintError("%s:%d: %s\n", p.GetFile(), p.GetLine(), p.GetMsg())
+		} else {
+			io.PrintError("%s: %s\n", p.GetFile(), p.GetMsg())
+		}
+	}
+	if len(problems) > 0 {
+		io.Print("%s: %d problem(s) found\n", file, len(problems))
+	} else {
+		io.Print("%s: config OK\n", file)
+	}
+}
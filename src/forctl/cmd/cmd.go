@@ -34,6 +34,8 @@ const (
 	COMMAND_QUIT       = "quit"
 	COMMAND_CLEARCACHE = "clearcache"
 	COMMAND_LIST       = "list"
+	COMMAND_RESOLVER   = "resolver"
+	COMMAND_CHECK      = "check"
 )
 
 type (
@@ -141,12 +143,30 @@ func init() {
 		COMMAND_LIST: &Command{
 			Optional:        -1,
 			Required:        0,
-			Help:            fmt.Sprintf("\tlist %-15ss\tlist all connections", "<name>..."),
+			Help:            fmt.Sprintf("\tlist %-15slist all connections\n\tlist -s %-12ssort connections by %s, largest first", "<name>...", "<key>", sortKeys()),
 			ReqType:         rpc.RequestType_LIST,
 			ResponseField:   "GetList",
-			BuildRequest:    buildCommonRequest,
+			BuildRequest:    buildListRequest,
 			ProcessResponse: processListResponse,
 		},
+		COMMAND_RESOLVER: &Command{
+			Optional:        -1,
+			Required:        0,
+			Help:            fmt.Sprintf("\tresolver %-15sShow DNS resolver statistics", "<name>..."),
+			ReqType:         rpc.RequestType_RESOLVER,
+			ResponseField:   "GetResolver",
+			BuildRequest:    buildCommonRequest,
+			ProcessResponse: processResolverResponse,
+		},
+		COMMAND_CHECK: &Command{
+			Optional:        1,
+			Required:        0,
+			Help:            fmt.Sprintf("\tcheck %-15sCheck local config file without applying it\n\tcheck %-15sCheck config file in use\n", "<file>", " "),
+			ReqType:         rpc.RequestType_CHECK,
+			ResponseField:   "GetCheck",
+			BuildRequest:    buildCheckRequest,
+			ProcessResponse: processCheckResponse,
+		},
 	}
 }
 
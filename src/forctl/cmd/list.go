@@ -21,15 +21,84 @@ import (
 	"fmt"
 	"forctl/io"
 	"skywalker/rpc"
+	"sort"
+	"strings"
 	"time"
 )
 
-/* 处理stop返回结果 */
+var (
+	/* 链接的排序方式，从大到小排序，为空时不排序 */
+	gListSortKeys = map[string]func(*rpc.ListResponse_Data_Chain) int64{
+		"sent":     func(c *rpc.ListResponse_Data_Chain) int64 { return c.GetSent() },
+		"received": func(c *rpc.ListResponse_Data_Chain) int64 { return c.GetReceived() },
+		"rate":     func(c *rpc.ListResponse_Data_Chain) int64 { return c.GetSentRate() + c.GetReceivedRate() },
+		"latency":  func(c *rpc.ListResponse_Data_Chain) int64 { return c.GetConnectLatency() },
+		"time":     chainDuration,
+	}
+	gListSort = ""
+)
+
+/* 链接已经持续的时间，单位纳秒 */
+func chainDuration(c *rpc.ListResponse_Data_Chain) int64 {
+	if c.GetConnectedTime() == 0 {
+		return 0
+	} else if c.GetClosedTime() > 0 {
+		return c.GetClosedTime() - c.GetConnectedTime()
+	}
+	return time.Now().UnixNano() - c.GetConnectedTime()
+}
+
+func sortKeys() string {
+	var keys []string
+	for key := range gListSortKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "|")
+}
+
+/* 构建list请求，-s <key>指定排序方式，不发送给skywalker */
+func buildListRequest(cmd *Command, args ...string) *rpc.Request {
+	var names []string
+	gListSort = ""
+	for i := 0; i < len(args); i++ {
+		if args[i] != "-s" {
+			names = append(names, args[i])
+		} else if i+1 < len(args) && gListSortKeys[args[i+1]] != nil {
+			gListSort = args[i+1]
+			i++
+		} else {
+			io.PrintError("-s requires one of %s\n", sortKeys())
+			return nil
+		}
+	}
+	return buildCommonRequest(cmd, names...)
+}
+
+/* 格式化链接的数据量、速度和连接耗时 */
+func formatChainStats(c *rpc.ListResponse_Data_Chain) string {
+	sent, sentUnit := formatDataSize(c.GetSent())
+	received, receivedUnit := formatDataSize(c.GetReceived())
+	sentRate, sentRateUnit := formatDataRate(c.GetSentRate())
+	receivedRate, receivedRateUnit := formatDataRate(c.GetReceivedRate())
+	s := fmt.Sprintf("sent %s %s (%s %s) received %s %s (%s %s)", sent, sentUnit, sentRate, sentRateUnit,
+		received, receivedUnit, receivedRate, receivedRateUnit)
+	if latency := c.GetConnectLatency(); latency > 0 {
+		s += fmt.Sprintf(" latency %dms", time.Duration(latency)/time.Millisecond)
+	}
+	return s
+}
+
+/* 处理list返回结果 */
 func processListResponse(v interface{}) error {
 	rep := v.(*rpc.ListResponse)
 	for _, data := range rep.GetData() {
 		io.Print("%s\n", data.GetName())
-		for _, c := range data.GetChain() {
+		chains := data.GetChain()
+		if key := gListSortKeys[gListSort]; key != nil {
+			sort.SliceStable(chains, func(i, j int) bool { return key(chains[i]) > key(chains[j]) })
+		}
+		for _, c := range chains {
 			var connectedTime, closedTime time.Time
 			var connected, closed, elapsed string
 			var status string = "\x1b[36m[UNCONNECTED]\x1b[0m"
@@ -54,6 +123,7 @@ func processListResponse(v interface{}) error {
 				elapsed = fmt.Sprintf(" :%ds", t.Sub(connectedTime)/time.Second)
 			}
 			io.Print("\t%s %s <==> %s  \x1b[34m[%s->%s%s]\x1b[0m\n", status, c.GetClientAddr(), c.GetRemoteAddr(), connected, closed, elapsed)
+			io.Print("\t    %s\n", formatChainStats(c))
 		}
 	}
 	return nil
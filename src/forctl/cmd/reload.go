@@ -20,6 +20,8 @@ package cmd
 import (
 	"forctl/io"
 	"skywalker/rpc"
+	"skywalker/util"
+	"strings"
 )
 
 /* 处理start命令的结果 */
@@ -34,8 +36,12 @@ func processReloadResponse(v interface{}) error {
 	for _, d := range rep.GetDeleted() {
 		io.Print("%s - DELETED\n", d)
 	}
-	for _, d := range rep.GetUpdated() {
-		io.Print("%s - UPDATED\n", d)
+	for _, c := range rep.GetChanges() {
+		io.Print("%s - UPDATED (%s)%s\n", c.GetName(), strings.Join(c.GetFields(), ", "),
+			util.IfString(c.GetRestarted(), " RESTARTED", ""))
+	}
+	if len(rep.GetCore()) > 0 {
+		io.Print("core - UPDATED (%s)\n", strings.Join(rep.GetCore(), ", "))
 	}
 
 	return nil
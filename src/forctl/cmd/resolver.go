@@ -0,0 +1,41 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cmd
+
+import (
+	"forctl/io"
+	"skywalker/rpc"
+	"time"
+)
+
+/* 处理resolver命令的返回结果 */
+func processResolverResponse(v interface{}) error {
+	rep := v.(*rpc.ResolverResponse)
+	for _, data := range rep.GetData() {
+		io.Print("%s\n", data.GetName())
+		io.Print("    queries %d  hits %d (negative %d)  merged %d  misses %d  failures %d  cached %d\n",
+			data.GetQueries(), data.GetHits(), data.GetNegativeHits(), data.GetMerged(),
+			data.GetMisses(), data.GetFailures(), data.GetCached())
+		for _, u := range data.GetUpstreams() {
+			latency := time.Duration(u.GetLatency()) / time.Millisecond
+			io.Print("    %-40s queries %-6d failures %-6d latency %dms\n",
+				u.GetAddr(), u.GetQueries(), u.GetFailures(), latency)
+		}
+	}
+	return nil
+}
@@ -63,5 +63,8 @@ func processStatusResponse(v interface{}) error {
 		}
 		io.Print("\n")
 	}
+	if err := rep.GetReloadErr(); err != "" {
+		io.PrintError("config reload failed at %s\n", err)
+	}
 	return nil
 }
@@ -31,7 +31,11 @@ func processStopResponse(v interface{}) error {
 		err := data.GetErr()
 		switch status {
 		case rpc.StopResponse_STOPPED:
-			io.Print("%s stopped\n", name)
+			if data.GetDrained() > 0 || data.GetKilled() > 0 {
+				io.Print("%s stopped (%d drained, %d killed)\n", name, data.GetDrained(), data.GetKilled())
+			} else {
+				io.Print("%s stopped\n", name)
+			}
 		case rpc.StopResponse_UNRUNNING:
 			io.Print("%s: ERROR (already stopped)\n", name)
 		case rpc.StopResponse_ERROR:
@@ -37,7 +37,9 @@ func main() {
 	var rl *reader.Reader
 	var line *reader.Line
 
-	config.Init()
+	if err = config.InitCore(); err != nil {
+		io.PrintError("%v\n", err)
+	}
 	if rl, err = reader.New(config.GetCoreConfig(), config.GetProxyConfigs()); err != nil {
 		io.Print("%v\n", err)
 		return
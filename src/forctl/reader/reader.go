@@ -50,6 +50,7 @@ func New(ccfg *config.CoreConfig, rcfg []*config.ProxyConfig) (*Reader, error) {
 		readline.PcItem(cmd.COMMAND_INFO, proxies...),
 		readline.PcItem(cmd.COMMAND_HELP, cmds...),
 		readline.PcItem(cmd.COMMAND_RELOAD),
+		readline.PcItem(cmd.COMMAND_CHECK),
 		readline.PcItem(cmd.COMMAND_QUIT),
 		readline.PcItem(cmd.COMMAND_CLEARCACHE),
 	)
@@ -25,12 +25,15 @@ import (
 	"skywalker/agent/echo"
 	"skywalker/agent/http"
 	"skywalker/agent/redirect"
+	"skywalker/agent/router"
 	"skywalker/agent/shadowsocks"
 	"skywalker/agent/socks"
+	"skywalker/agent/transparent"
 	"skywalker/agent/void"
 	"skywalker/agent/walker"
 	"skywalker/log"
 	"strings"
+	"sync"
 )
 
 /******************* 代理构造函数 ********************/
@@ -55,6 +58,10 @@ func NewHTTPClientAgent(name string) ClientAgent {
 	return &http.HTTPClientAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
 
+func NewHTTPServerAgent(name string) ServerAgent {
+	return &http.HTTPServerAgent{BaseAgent: base.BaseAgent{Name: name}}
+}
+
 func NewDirectAgent(name string) ServerAgent {
 	return &direct.DirectAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
@@ -63,6 +70,18 @@ func NewRedirectAgent(name string) ClientAgent {
 	return &redirect.RedirectAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
 
+func NewRouterAgent(name string) ServerAgent {
+	return &router.RouterAgent{BaseAgent: base.BaseAgent{Name: name}}
+}
+
+func NewTransparentAgent(name string) ClientAgent {
+	return &transparent.TransparentAgent{BaseAgent: base.BaseAgent{Name: name}}
+}
+
+func NewTProxyAgent(name string) ClientAgent {
+	return &transparent.TProxyAgent{BaseAgent: base.BaseAgent{Name: name}}
+}
+
 func NewVoidClientAgent(name string) ClientAgent {
 	return &void.VoidClientAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
@@ -79,6 +98,10 @@ func NewEchoServerAgent(name string) ServerAgent {
 	return &echo.EchoServerAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
 
+func NewWalkerClientAgent(name string) ClientAgent {
+	return &walker.WalkerClientAgent{BaseAgent: base.BaseAgent{Name: name}}
+}
+
 func NewWalkerServerAgent(name string) ServerAgent {
 	return &walker.WalkerServerAgent{BaseAgent: base.BaseAgent{Name: name}}
 }
@@ -90,12 +113,17 @@ var (
 		"socks":       NewSocksClientAgent,
 		"shadowsocks": NewShadowSocksClientAgent,
 		"redirect":    NewRedirectAgent,
+		"transparent": NewTransparentAgent,
+		"tproxy":      NewTProxyAgent,
 		"void":        NewVoidClientAgent,
 		"echo":        NewEchoClientAgent,
+		"walker":      NewWalkerClientAgent,
 	}
 	gSAMap = map[string]newServerAgentFunc{
+		"http":        NewHTTPServerAgent,
 		"socks":       NewSocksServerAgent,
 		"direct":      NewDirectAgent,
+		"router":      NewRouterAgent,
 		"shadowsocks": NewShadowSocksServerAgent,
 		"void":        NewVoidServerAgent,
 		"echo":        NewEchoServerAgent,
@@ -103,6 +131,24 @@ var (
 	}
 )
 
+/*
+ * 代理名和SA协议名的映射，router通过代理名使用其它代理的SA，
+ * 每次初始化所有代理之后整体替换，删除了的代理不会保留
+ */
+var (
+	gProxySALock sync.RWMutex
+	gProxySAMap  = make(map[string]string)
+)
+
+func init() {
+	router.NewServerAgent = func(name string) router.ServerAgent {
+		if sa := GetProxyServerAgent(name); sa != nil {
+			return sa
+		}
+		return nil
+	}
+}
+
 /*
  * 代理初始化init，全局调用一次
  */
@@ -122,6 +168,99 @@ func SAInit(sa string, name string, cfg map[string]interface{}) error {
 	}
 }
 
+/* 只检查CA的配置，不修改CA的全局配置 */
+func CACheck(ca string, name string, cfg map[string]interface{}) error {
+	if f := gCAMap[strings.ToLower(ca)]; f == nil {
+		return errors.New(fmt.Sprintf("Client Agent %s not found", ca))
+	} else if a, ok := f("check").(ConfigCheckAgent); ok {
+		return a.CheckConfig(name, cfg)
+	}
+	return nil
+}
+
+func SACheck(sa string, name string, cfg map[string]interface{}) error {
+	if f := gSAMap[strings.ToLower(sa)]; f == nil {
+		return errors.New(fmt.Sprintf("Server Agent %s not found", sa))
+	} else if a, ok := f("check").(ConfigCheckAgent); ok {
+		return a.CheckConfig(name, cfg)
+	}
+	return nil
+}
+
+/* 释放代理@name在CA中保存的状态，代理被删除或者改用其他CA时调用 */
+func CARelease(ca string, name string) {
+	if f := gCAMap[strings.ToLower(ca)]; f != nil {
+		if a, ok := f("release").(ReleaseAgent); ok {
+			a.OnRelease(name)
+		}
+	}
+}
+
+func SARelease(sa string, name string) {
+	if f := gSAMap[strings.ToLower(sa)]; f != nil {
+		if a, ok := f("release").(ReleaseAgent); ok {
+			a.OnRelease(name)
+		}
+	}
+}
+
+/* 所有代理初始化成功之后设置代理使用的SA，proxies是代理名和SA协议名的映射 */
+func SetProxyServerAgents(proxies map[string]string) {
+	gProxySALock.Lock()
+	defer gProxySALock.Unlock()
+	gProxySAMap = proxies
+}
+
+/* 返回CA声明的配置项，CA不存在时返回错误，没有声明配置项时返回nil */
+func CASchema(ca string) (base.ConfigSchema, error) {
+	f := gCAMap[strings.ToLower(ca)]
+	if f == nil {
+		return nil, fmt.Errorf("client agent %s not found", ca)
+	} else if a, ok := f("schema").(ConfigSchemaAgent); ok {
+		return a.ConfigSchema(), nil
+	}
+	return nil, nil
+}
+
+/* 返回SA声明的配置项，SA不存在时返回错误，没有声明配置项时返回nil */
+func SASchema(sa string) (base.ConfigSchema, error) {
+	f := gSAMap[strings.ToLower(sa)]
+	if f == nil {
+		return nil, fmt.Errorf("server agent %s not found", sa)
+	} else if a, ok := f("schema").(ConfigSchemaAgent); ok {
+		return a.ConfigSchema(), nil
+	}
+	return nil, nil
+}
+
+/*
+ * CA和SA是否都支持UDP转发，支持时代理会监听UDP端口，
+ * 只能通过控制连接建立UDP转发的CA不需要监听
+ */
+func UDPSupported(ca, sa string) bool {
+	fca := gCAMap[strings.ToLower(ca)]
+	fsa := gSAMap[strings.ToLower(sa)]
+	if fca == nil || fsa == nil {
+		return false
+	}
+	uca, caOK := fca("udp").(UDPClientAgent)
+	_, saOK := fsa("udp").(UDPServerAgent)
+	if a, ok := uca.(UDPAssociateAgent); ok && a.AssociateOnly() {
+		return false
+	}
+	return caOK && saOK
+}
+
+/* CA是否需要以TPROXY方式监听端口 */
+func TProxySupported(ca string) bool {
+	fca := gCAMap[strings.ToLower(ca)]
+	if fca == nil {
+		return false
+	}
+	tca, ok := fca("tproxy").(TProxyClientAgent)
+	return ok && tca.TProxy()
+}
+
 /*
  * 初始化CA实例
  */
@@ -135,6 +274,17 @@ func GetClientAgent(ca, name string) ClientAgent {
 	return agent
 }
 
+/* 初始化代理@name所配置的SA实例，代理不存在时返回nil */
+func GetProxyServerAgent(name string) ServerAgent {
+	gProxySALock.RLock()
+	sa, ok := gProxySAMap[name]
+	gProxySALock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return GetServerAgent(sa, name)
+}
+
 /*
  * 初始化SA实例
  */
@@ -0,0 +1,208 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package base
+
+import (
+	"fmt"
+	"sort"
+)
+
+/*
+ * 配置项的声明
+ * CA和SA通过ConfigSchema声明自己支持的配置项，检查配置时用于发现未知的配置项和类型错误，
+ * 配置项的路径是从被检查的配置开始的键和列表下标，以/连接
+ */
+
+const (
+	CONFIG_STRING  = "string"
+	CONFIG_INT     = "int"
+	CONFIG_FLOAT   = "float"
+	CONFIG_BOOL    = "bool"
+	CONFIG_STRINGS = "strings" /* 字符串或者字符串列表，和util.GetMapStringList对应 */
+	CONFIG_LIST    = "list"
+	CONFIG_MAP     = "map"
+	CONFIG_ANY     = "any"
+)
+
+type (
+	ConfigField struct {
+		Type   string       /* 配置项的类型 */
+		Fields ConfigSchema /* Type是map时允许的配置项，nil表示任意的键 */
+		Elem   *ConfigField /* Type是list时元素的类型，Type是map并且Fields为nil时值的类型，nil表示任意 */
+		Secret bool         /* 是否是秘密（密码等），在代理信息和日志中隐藏 */
+	}
+
+	ConfigSchema map[string]*ConfigField
+
+	/* 检查配置发现的问题 */
+	ConfigProblem struct {
+		Path string /* 配置项的路径 */
+		Msg  string /* 问题的描述，不包括路径 */
+	}
+)
+
+var (
+	STRING_FIELD  = &ConfigField{Type: CONFIG_STRING}
+	INT_FIELD     = &ConfigField{Type: CONFIG_INT}
+	FLOAT_FIELD   = &ConfigField{Type: CONFIG_FLOAT}
+	BOOL_FIELD    = &ConfigField{Type: CONFIG_BOOL}
+	STRINGS_FIELD = &ConfigField{Type: CONFIG_STRINGS}
+	ANY_FIELD     = &ConfigField{Type: CONFIG_ANY}
+	SECRET_FIELD  = &ConfigField{Type: CONFIG_STRING, Secret: true}
+)
+
+/* 元素是elem的列表 */
+func ListField(elem *ConfigField) *ConfigField {
+	return &ConfigField{Type: CONFIG_LIST, Elem: elem}
+}
+
+/* 包含fields中的配置项的map */
+func MapField(fields ConfigSchema) *ConfigField {
+	return &ConfigField{Type: CONFIG_MAP, Fields: fields}
+}
+
+/* 值都是elem的map，键是任意的 */
+func MapOf(elem *ConfigField) *ConfigField {
+	return &ConfigField{Type: CONFIG_MAP, Elem: elem}
+}
+
+/* 配置项是否声明为秘密 */
+func (s ConfigSchema) IsSecret(key string) bool {
+	f := s[key]
+	return f != nil && f.Secret
+}
+
+/* 检查配置，返回所有的问题 */
+func (s ConfigSchema) Check(cfg map[string]interface{}) []ConfigProblem {
+	var problems []ConfigProblem
+	MapField(s).check("", cfg, &problems)
+	return problems
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "/" + key
+}
+
+func (f *ConfigField) check(path string, v interface{}, problems *[]ConfigProblem) {
+	if f == nil || f.Type == CONFIG_ANY || v == nil {
+		return
+	}
+	wrongType := func() {
+		*problems = append(*problems, ConfigProblem{
+			Path: path,
+			Msg:  fmt.Sprintf("must be %s, got %s", f.Type, typeName(v)),
+		})
+	}
+	switch f.Type {
+	case CONFIG_STRING:
+		if _, ok := v.(string); !ok {
+			wrongType()
+		}
+	case CONFIG_INT:
+		if _, ok := v.(int); !ok {
+			wrongType()
+		}
+	case CONFIG_FLOAT:
+		switch v.(type) {
+		case int, float64:
+		default:
+			wrongType()
+		}
+	case CONFIG_BOOL:
+		if _, ok := v.(bool); !ok {
+			wrongType()
+		}
+	case CONFIG_STRINGS:
+		switch val := v.(type) {
+		case string, int:
+		case []interface{}:
+			for i, e := range val {
+				checkScalar(joinPath(path, fmt.Sprint(i)), e, problems)
+			}
+		default:
+			wrongType()
+		}
+	case CONFIG_LIST:
+		array, ok := v.([]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		for i, e := range array {
+			f.Elem.check(joinPath(path, fmt.Sprint(i)), e, problems)
+		}
+	case CONFIG_MAP:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		/* 按键排序，输出的顺序固定 */
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if f.Fields == nil {
+				f.Elem.check(joinPath(path, key), m[key], problems)
+			} else if field, ok := f.Fields[key]; ok {
+				field.check(joinPath(path, key), m[key], problems)
+			} else {
+				*problems = append(*problems, ConfigProblem{
+					Path: joinPath(path, key),
+					Msg:  "unknown key",
+				})
+			}
+		}
+	}
+}
+
+/* 字符串列表的元素只能是字符串或者数字 */
+func checkScalar(path string, v interface{}, problems *[]ConfigProblem) {
+	switch v.(type) {
+	case string, int:
+	default:
+		*problems = append(*problems, ConfigProblem{
+			Path: path,
+			Msg:  fmt.Sprintf("must be string, got %s", typeName(v)),
+		})
+	}
+}
+
+/* yaml解析出来的值的类型名 */
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return CONFIG_STRING
+	case int:
+		return CONFIG_INT
+	case float64:
+		return CONFIG_FLOAT
+	case bool:
+		return CONFIG_BOOL
+	case []interface{}:
+		return CONFIG_LIST
+	case map[string]interface{}:
+		return CONFIG_MAP
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,30 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package base
+
+import (
+	"time"
+)
+
+/* 上游服务器的健康状态，用于监控 */
+type UpstreamHealth struct {
+	Addr    string        /* 服务器地址，host:port */
+	Checked bool          /* 是否已经检查过，没有检查过的服务器当作可用 */
+	Healthy bool          /* 是否可用 */
+	Latency time.Duration /* 最近一次检查的延迟，不可用时为0 */
+}
@@ -19,6 +19,7 @@ package direct
 
 import (
 	. "skywalker/agent/base"
+	"skywalker/pkg"
 )
 
 /*
@@ -36,6 +37,11 @@ func (a *DirectAgent) OnInit(name string, cfg map[string]interface{}) error {
 	return nil
 }
 
+/* 没有配置项 */
+func (*DirectAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 func (a *DirectAgent) OnStart() error {
 	return nil
 }
@@ -44,6 +50,11 @@ func (a *DirectAgent) GetRemoteAddress(addr string, port int) (string, int) {
 	return addr, port
 }
 
+/* 在本地监听 */
+func (a *DirectAgent) GetBindAddress(addr string, port int) (string, int) {
+	return "", 0
+}
+
 func (a *DirectAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
 	return nil, nil, nil
 }
@@ -55,3 +66,12 @@ func (a *DirectAgent) ReadFromServer(data []byte) (interface{}, interface{}, err
 func (a *DirectAgent) ReadFromCA(data []byte) (interface{}, interface{}, error) {
 	return nil, data, nil
 }
+
+/* UDP数据包直接发送给目标地址 */
+func (a *DirectAgent) RecvFromServer(host string, port int, data []byte) (interface{}, interface{}, error) {
+	return pkg.NewUDPDataPackage(host, port, data), nil, nil
+}
+
+func (a *DirectAgent) RecvFromCA(host string, port int, data []byte) (interface{}, interface{}, error) {
+	return nil, data, nil
+}
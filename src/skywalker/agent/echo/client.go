@@ -33,6 +33,11 @@ func (*EchoClientAgent) OnInit(string, map[string]interface{}) error {
 	return nil
 }
 
+/* 没有配置项 */
+func (*EchoClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 func (*EchoClientAgent) OnStart() error {
 	return nil
 }
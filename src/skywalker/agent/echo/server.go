@@ -33,6 +33,11 @@ func (a *EchoServerAgent) OnInit(name string, cfg map[string]interface{}) error
 	return nil
 }
 
+/* 没有配置项 */
+func (*EchoServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 func (a *EchoServerAgent) OnStart() error {
 	return nil
 }
@@ -18,6 +18,7 @@
 package http
 
 import (
+	"bytes"
 	"net"
 	. "skywalker/agent/base"
 	"skywalker/pkg"
@@ -25,13 +26,38 @@ import (
 	"strconv"
 )
 
-/* 每次代理的请求数据 */
+/*
+ * HTTP代理
+ * 支持持久连接和管线化的请求，同一个客户端连接可以先后请求不同的服务器，
+ * 服务器不同时需要等之前的请求都收到完整应答之后再连接新的服务器，以保证应答的顺序
+ */
 type (
 	HTTPClientAgent struct {
 		BaseAgent
-		req  *httpRequest
-		host string
-		cfg  *httpCAConfig
+
+		buf    []byte       /* 还没有解析的客户端数据 */
+		req    *httpRequest /* 正在转发消息体的请求 */
+		body   *httpBody
+		tunnel bool   /* CONNECT或者协议升级之后直接转发客户端的数据 */
+		host   string /* 最后一次连接的服务器 */
+
+		out      []*pkg.Package /* 需要发送给SA的数据包 */
+		batches  []*httpBatch   /* 等待之前的应答结束才能发送的请求 */
+		sent     []string       /* 已经发送但还没有收到完整应答的请求方法 */
+		connects []bool         /* 已经发出的连接请求是否是CONNECT隧道 */
+
+		rbuf     []byte /* 还没有完整首部的应答数据 */
+		resp     *httpResponse
+		respBody *httpBody
+		upgraded bool /* 协议升级之后不再解析应答 */
+
+		cfg *httpCAConfig
+	}
+
+	/* 发往同一个服务器的请求 */
+	httpBatch struct {
+		packages []*pkg.Package
+		methods  []string
 	}
 
 	httpCAConfig struct {
@@ -58,8 +84,15 @@ func (a *HTTPClientAgent) OnInit(name string, cfg map[string]interface{}) error
 	return nil
 }
 
+/* 支持的配置项，用于检查配置 */
+func (*HTTPClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"username": SECRET_FIELD,
+		"password": SECRET_FIELD,
+	}
+}
+
 func (a *HTTPClientAgent) OnStart() error {
-	a.req = newHTTPRequest()
 	a.cfg = gCAConfigs[a.BaseAgent.Name]
 	return nil
 }
@@ -68,22 +101,29 @@ var (
 	HEADER_PROXY_AGENT           = "Proxy-agent: SkyWalker Proxy/1.0\r\n"
 	HEADER_PROXY_AUTHENTICATE    = "Proxy-Authenticate: Basic realm=\"SkyWalker Proxy Auth\""
 	CONNECT_SUCCESS              = []byte("HTTP/1.1 200 Connection established\r\n" + HEADER_PROXY_AGENT + "\r\n")
-	PROXY_AUTHORIZATION_REQUIRED = []byte("HTTP/1.1 407 Proxy Authentication Required\r\n" + HEADER_PROXY_AGENT + HEADER_PROXY_AUTHENTICATE + "\r\n")
+	PROXY_AUTHORIZATION_REQUIRED = []byte("HTTP/1.1 407 Proxy Authentication Required\r\n" + HEADER_PROXY_AGENT + HEADER_PROXY_AUTHENTICATE + "\r\n\r\n")
+	BAD_GATEWAY                  = []byte("HTTP/1.1 502 Bad Gateway\r\n" + HEADER_PROXY_AGENT + "Content-Length: 0\r\nConnection: close\r\n\r\n")
 )
 
+/* 连接结果按照连接请求的顺序返回 */
 func (a *HTTPClientAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
-	if result == pkg.CONNECT_RESULT_OK {
-		if a.req.Method == "CONNECT" { /* 连接成功且方法是CONNECT */
-			return nil, CONNECT_SUCCESS, nil
-		}
+	tunnel := false
+	if len(a.connects) > 0 {
+		tunnel = a.connects[0]
+		a.connects = a.connects[1:]
+	}
+	if result != pkg.CONNECT_RESULT_OK {
+		return nil, BAD_GATEWAY, nil
+	} else if tunnel { /* 连接成功且方法是CONNECT */
+		return nil, CONNECT_SUCCESS, nil
 	}
 	return nil, nil, nil
 }
 
-func (a *HTTPClientAgent) isAuthenticated() bool {
+func (a *HTTPClientAgent) isAuthenticated(req *httpRequest) bool {
 	if len(a.cfg.username) > 0 && len(a.cfg.password) > 0 { /* 验证Proxy代理 */
-		a.DEBUG("HTTP Proxy Authorization: %v||%v", a.req.ProxyAuthorization, (a.cfg.username + ":" + a.cfg.password))
-		if a.req.ProxyAuthorization != (a.cfg.username + ":" + a.cfg.password) {
+		if req.ProxyAuthorization != (a.cfg.username + ":" + a.cfg.password) {
+			a.DEBUG("HTTP Proxy Authorization failed")
 			return false
 		}
 	}
@@ -100,55 +140,180 @@ func splitHostPort(hostport string) (string, int) {
 	return host, port
 }
 
-/* 发送请求到指定服务器 */
-func (a *HTTPClientAgent) sendRequest(hostport string, request []byte) (interface{}, interface{}, error) {
-	if a.host != hostport { /* 如果请求的服务器与上一次不一样则重新连接 */
+/* 之前的请求都收到完整应答之后，发送下一批请求 */
+func (a *HTTPClientAgent) flush() {
+	for len(a.sent) == 0 && len(a.batches) > 0 {
+		batch := a.batches[0]
+		a.batches = a.batches[1:]
+		a.out = append(a.out, batch.packages...)
+		a.sent = append(a.sent, batch.methods...)
+	}
+}
+
+/* 发送请求首部，服务器和上一个请求不同时需要重新连接 */
+func (a *HTTPClientAgent) sendRequest(hostport string, method string, header []byte) {
+	if a.host != hostport {
 		a.host = hostport
 		host, port := splitHostPort(hostport)
-		connectCMD := pkg.NewConnectPackage(host, port)
-		transferCMD := pkg.NewDataPackage(request)
-		return []*pkg.Package{connectCMD, transferCMD}, nil, nil
+		a.batches = append(a.batches, &httpBatch{
+			packages: []*pkg.Package{pkg.NewConnectPackage(host, port)},
+		})
+		a.connects = append(a.connects, method == HTTP_METHOD_CONNECT)
 	}
-	return request, nil, nil
+	if len(a.batches) > 0 {
+		batch := a.batches[len(a.batches)-1]
+		batch.methods = append(batch.methods, method)
+		if header != nil {
+			batch.packages = append(batch.packages, pkg.NewDataPackage(header))
+		}
+	} else {
+		a.sent = append(a.sent, method)
+		if header != nil {
+			a.out = append(a.out, pkg.NewDataPackage(header))
+		}
+	}
+	a.flush()
 }
 
-/* 从客户端接收到数据 */
-func (a *HTTPClientAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
-	req := a.req
-	if req.Status == REQUEST_STATUS_UNKNOWN { /* 还没有解析到HTTP请求 */
-		err := req.feed(data)
-		if err != nil {
-			return nil, nil, err
+/* 发送请求的消息体，和请求首部在同一批 */
+func (a *HTTPClientAgent) sendData(data []byte) {
+	if len(data) == 0 {
+		return
+	} else if len(a.batches) > 0 {
+		batch := a.batches[len(a.batches)-1]
+		batch.packages = append(batch.packages, pkg.NewDataPackage(data))
+	} else {
+		a.out = append(a.out, pkg.NewDataPackage(data))
+	}
+}
+
+/* 获取并清空需要发送给SA的数据包 */
+func (a *HTTPClientAgent) output() interface{} {
+	out := a.out
+	a.out = nil
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+/* 解析客户端发送的请求，一次可能收到多个请求，也可能只收到请求的一部分 */
+func (a *HTTPClientAgent) readRequests() ([]byte, error) {
+	for len(a.buf) > 0 {
+		if a.tunnel { /* 隧道建立之后的数据直接转发 */
+			a.sendData(a.buf)
+			a.buf = nil
+			break
+		} else if a.req == nil {
+			/* 忽略请求之间多余的空行 */
+			if a.buf = bytes.TrimLeft(a.buf, "\r\n"); len(a.buf) == 0 {
+				break
+			}
+			n := findHeaderEnd(a.buf)
+			if n == 0 {
+				if len(a.buf) > MAX_HEADER_SIZE {
+					return nil, Error(ERROR_INVALID_FORMAT, "request header is too large")
+				}
+				break
+			}
+			req, err := parseRequest(a.buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			a.buf = a.buf[n:]
+			if !a.isAuthenticated(req) { /* 代理认证 */
+				return PROXY_AUTHORIZATION_REQUIRED, Error(ERROR_AUTH_REQUIRED, "Proxy Authorization Required")
+			}
+			if req.Method == HTTP_METHOD_CONNECT {
+				/* 隧道总是使用新的连接 */
+				a.host = ""
+				a.sendRequest(req.getHost(), req.Method, nil)
+				a.tunnel = true
+				continue
+			}
+			body, err := req.newBody()
+			if err != nil {
+				return nil, err
+			}
+			a.sendRequest(req.getHost(), req.Method, req.build())
+			a.req = req
+			a.body = body
 		} else {
-			if !a.isAuthenticated() { /* 代理认证 */
-				return nil, PROXY_AUTHORIZATION_REQUIRED, Error(ERROR_AUTH_REQUIRED, "Proxy Authorization Required")
+			n, err := a.body.feed(a.buf)
+			if err != nil {
+				return nil, err
 			}
-			if req.Status == REQUEST_STATUS_FULL_REQUEST { /* 解析到有效的HTTP请求 */
-				host := req.getHost()
-				if req.Method == "CONNECT" {
-					h, p := splitHostPort(host)
-					return pkg.NewConnectPackage(h, p), nil, nil
-				} else {
-					data := req.build()
-					req.reset()
-					return a.sendRequest(host, data)
+			a.sendData(a.buf[:n])
+			a.buf = a.buf[n:]
+		}
+		if a.req != nil && a.body.done() { /* 请求结束 */
+			a.req = nil
+		}
+	}
+	if len(a.buf) == 0 {
+		a.buf = nil
+	}
+	return nil, nil
+}
+
+/* 从客户端接收到数据 */
+func (a *HTTPClientAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
+	a.buf = append(a.buf, data...)
+	rdata, err := a.readRequests()
+	return a.output(), rdata, err
+}
+
+/*
+ * 解析服务器的应答，只用于确定应答的边界，数据原样返回给客户端
+ * 收到完整的应答之后才能发送等待中的请求
+ */
+func (a *HTTPClientAgent) readResponses(data []byte) error {
+	for len(data) > 0 && len(a.sent) > 0 && a.sent[0] != HTTP_METHOD_CONNECT && !a.upgraded {
+		if a.resp == nil {
+			a.rbuf = append(a.rbuf, data...)
+			n := findHeaderEnd(a.rbuf)
+			if n == 0 {
+				if len(a.rbuf) > MAX_HEADER_SIZE {
+					return Error(ERROR_INVALID_RESPONSE, "response header is too large")
 				}
-			} else if req.Status == REQUEST_STATUS_FULL_HEADER {
-				/* 解析到完整HTTP首部，但还没有完整数据 */
-				return a.sendRequest(req.getHost(), req.build())
+				return nil
+			}
+			resp, err := parseResponse(a.rbuf[:n])
+			if err != nil {
+				return err
 			}
+			data = a.rbuf[n:]
+			a.rbuf = nil
+			if resp.Status == 101 { /* 协议升级之后直接转发数据 */
+				a.upgraded = true
+				a.tunnel = true
+				return nil
+			} else if resp.Status >= 100 && resp.Status < 200 { /* 临时应答 */
+				continue
+			}
+			if a.respBody, err = resp.newBody(a.sent[0]); err != nil {
+				return err
+			}
+			a.resp = resp
+		} else {
+			n, err := a.respBody.feed(data)
+			if err != nil {
+				return err
+			}
+			data = data[n:]
 		}
-		/* 没有错误，但也不是完整的HTTP请求 */
-		return nil, nil, nil
-	} else if req.Status == REQUEST_STATUS_FULL_HEADER {
-		if req.Payload = append(req.Payload, data...); uint64(len(req.Payload)) >= req.ContentLength {
-			/* 接受到完整请求后重置请求 */
-			req.reset()
+		if a.respBody.done() { /* 应答结束 */
+			a.resp = nil
+			a.sent = a.sent[1:]
+			a.flush()
 		}
 	}
-	return data, nil, nil
+	return nil
 }
 
 func (a *HTTPClientAgent) ReadFromSA(data []byte) (interface{}, interface{}, error) {
-	return nil, data, nil
+	if err := a.readResponses(data); err != nil {
+		return nil, nil, err
+	}
+	return a.output(), data, nil
 }
@@ -21,8 +21,10 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"net/url"
 	. "skywalker/agent/base"
+	"skywalker/util"
 	"strconv"
 	"strings"
 )
@@ -36,80 +38,281 @@ const (
 	HTTP_METHOD_POST    = "POST"
 	HTTP_METHOD_PUT     = "PUT"
 	HTTP_METHOD_DELETE  = "DELETE"
+	HTTP_METHOD_HEAD    = "HEAD"
 	HTTP_METHOD_CONNECT = "CONNECT"
 )
 
 const (
-	ERROR_INVALID_FORMAT  = 1
-	ERROR_INVALID_METHOD  = 2
-	ERROR_INVALID_URI     = 3
-	ERROR_INVALID_VERSION = 4
-	ERROR_INVALID_HOST    = 5
-	ERROR_INVALID_HEADER  = 6
-	ERROR_AUTH_REQUIRED   = 7
+	ERROR_INVALID_FORMAT   = 1
+	ERROR_INVALID_METHOD   = 2
+	ERROR_INVALID_URI      = 3
+	ERROR_INVALID_VERSION  = 4
+	ERROR_INVALID_HOST     = 5
+	ERROR_INVALID_HEADER   = 6
+	ERROR_AUTH_REQUIRED    = 7
+	ERROR_INVALID_CONFIG   = 8
+	ERROR_INVALID_RESPONSE = 9
+	ERROR_CONNECT_FAILED   = 10
 )
 
+/* 消息体的传输状态 */
 const (
-	REQUEST_STATUS_UNKNOWN      = 0 /* 没有接受到请求 */
-	REQUEST_STATUS_FULL_HEADER  = 1 /* 没有接受到了完整请求头，但还没有完整的请求数据 */
-	REQUEST_STATUS_FULL_REQUEST = 2 /* 接受到了完整请求 */
+	BODY_STATE_DONE        = 0 /* 消息体已经结束 */
+	BODY_STATE_LENGTH      = 1 /* Content-Length指定长度的消息体 */
+	BODY_STATE_CHUNK_SIZE  = 2 /* 等待chunk长度行 */
+	BODY_STATE_CHUNK_DATA  = 3 /* chunk数据 */
+	BODY_STATE_CHUNK_END   = 4 /* chunk数据之后的CRLF */
+	BODY_STATE_TRAILER     = 5 /* 最后一个chunk之后的trailer */
+	BODY_STATE_UNTIL_CLOSE = 6 /* 消息体直到连接关闭才结束，只有应答会出现 */
 )
 
-func newHTTPRequest() *httpRequest {
-	return &httpRequest{}
+const (
+	MAX_HEADER_SIZE = 65536 /* 首部的最大长度 */
+	MAX_LINE_SIZE   = 4096  /* chunk长度行和trailer行的最大长度 */
+)
+
+type (
+	httpHeader struct {
+		key   string
+		value string
+	}
+
+	/* 保持原来顺序的HTTP首部，查找时不区分大小写 */
+	httpHeaders []httpHeader
+
+	httpRequest struct {
+		Method             string
+		URI                *url.URL
+		Version            string
+		Headers            httpHeaders
+		Host               string
+		ProxyAuthorization string
+	}
+
+	httpResponse struct {
+		Version string
+		Status  int
+		Headers httpHeaders
+	}
+
+	/* 记录消息体的传输状态，用于确定消息的边界 */
+	httpBody struct {
+		state int
+		left  uint64 /* 当前长度或者chunk剩余的字节数 */
+		line  []byte /* 不完整的chunk长度行或者trailer行 */
+	}
+)
+
+func (h httpHeaders) get(key string) string {
+	for _, header := range h {
+		if strings.EqualFold(header.key, key) {
+			return header.value
+		}
+	}
+	return ""
+}
+
+func (h httpHeaders) has(key string) bool {
+	for _, header := range h {
+		if strings.EqualFold(header.key, key) {
+			return true
+		}
+	}
+	return false
 }
 
-type httpRequest struct {
-	Method             string
-	URI                *url.URL
-	Version            string
-	Headers            map[string]string
-	Host               string
-	ProxyAuthorization string
-	ContentLength      uint64
-	Payload            []byte
-
-	Status int
-	data   []byte
+/* 替换第一个同名首部，不存在则添加 */
+func (h *httpHeaders) set(key, value string) {
+	for i, header := range *h {
+		if strings.EqualFold(header.key, key) {
+			(*h)[i].value = value
+			return
+		}
+	}
+	*h = append(*h, httpHeader{key: key, value: value})
+}
+
+func (h *httpHeaders) del(key string) {
+	var headers httpHeaders
+	for _, header := range *h {
+		if !strings.EqualFold(header.key, key) {
+			headers = append(headers, header)
+		}
+	}
+	*h = headers
 }
 
 func (req *httpRequest) getHost() string {
 	host := req.Host
-	if !strings.Contains(host, ":") {
-		host += ":80"
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
 	}
 	return host
 }
 
-func (req *httpRequest) reset() {
-	req.Status = REQUEST_STATUS_UNKNOWN
-	req.data = []byte("")
+/* 生成发送给服务器的HTTP请求首部，去掉代理相关的首部 */
+func (req *httpRequest) build() []byte {
+	headers := append(httpHeaders{}, req.Headers...)
+	if conn := headers.get("Proxy-Connection"); conn != "" && !headers.has("Connection") {
+		headers.set("Connection", conn)
+	}
+	headers.del("Proxy-Connection")
+	headers.del("Proxy-Authorization")
+	headers.set("Host", req.Host)
+
+	request := fmt.Sprintf("%s %s HTTP/%s\r\n", req.Method, req.URI.RequestURI(), req.Version)
+	for _, header := range headers {
+		request += fmt.Sprintf("%s: %s\r\n", header.key, header.value)
+	}
+	request += "\r\n"
+	return []byte(request)
 }
 
-/* 生成HTTP请求数据 */
-func (req *httpRequest) build() []byte {
-	var request string
-	path := req.URI.Path
-	if len(path) == 0 {
-		path = "/"
-	}
-	query := req.URI.RawQuery
-	if len(query) > 0 {
-		query = "?" + query
-	}
-	request = fmt.Sprintf("%s %s%s HTTP/%s\r\n", req.Method, path, query, req.Version)
-	for k := range req.Headers {
-		v := req.Headers[k]
-		if !strings.HasPrefix(v, "Proxy-") { /* 不添加Proxy相关的首部 */
-			request += fmt.Sprintf("%s: %s\r\n", k, v)
+/*
+ * 根据请求首部确定消息体的长度
+ * 请求没有Content-Length和chunked编码时没有消息体
+ */
+func (req *httpRequest) newBody() (*httpBody, error) {
+	if te := req.Headers.get("Transfer-Encoding"); te != "" {
+		if !isChunked(te) {
+			return nil, Error(ERROR_INVALID_HEADER, "unsupported transfer encoding %s", te)
 		}
+		/*
+		 * 同时有Content-Length时以chunked为准，转发之前删除Content-Length，
+		 * 否则上游可能按照Content-Length确定请求的边界（请求走私）
+		 */
+		req.Headers.del("Content-Length")
+		return &httpBody{state: BODY_STATE_CHUNK_SIZE}, nil
 	}
-	request += "\r\n" + string(req.Payload)
-	return []byte(request)
+	return newLengthBody(req.Headers)
+}
+
+/*
+ * 根据应答首部和对应的请求方法确定消息体的长度
+ * https://tools.ietf.org/html/rfc7230#section-3.3.3
+ */
+func (rep *httpResponse) newBody(method string) (*httpBody, error) {
+	if method == HTTP_METHOD_HEAD || rep.Status == 204 || rep.Status == 304 ||
+		(rep.Status >= 100 && rep.Status < 200) {
+		return &httpBody{state: BODY_STATE_DONE}, nil
+	}
+	if te := rep.Headers.get("Transfer-Encoding"); te != "" {
+		if !isChunked(te) {
+			return &httpBody{state: BODY_STATE_UNTIL_CLOSE}, nil
+		}
+		return &httpBody{state: BODY_STATE_CHUNK_SIZE}, nil
+	} else if !rep.Headers.has("Content-Length") {
+		return &httpBody{state: BODY_STATE_UNTIL_CLOSE}, nil
+	}
+	return newLengthBody(rep.Headers)
+}
+
+/* 最后一个传输编码是chunked */
+func isChunked(te string) bool {
+	codings := strings.Split(te, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+func newLengthBody(headers httpHeaders) (*httpBody, error) {
+	cl := headers.get("Content-Length")
+	if cl == "" {
+		return &httpBody{state: BODY_STATE_DONE}, nil
+	}
+	/* 多个不同的Content-Length无法确定消息的边界 */
+	for _, header := range headers {
+		if strings.EqualFold(header.key, "Content-Length") && strings.TrimSpace(header.value) != strings.TrimSpace(cl) {
+			return nil, Error(ERROR_INVALID_HEADER, "conflicting content length %s and %s", cl, header.value)
+		}
+	}
+	length, err := strconv.ParseUint(strings.TrimSpace(cl), 10, 64)
+	if err != nil {
+		return nil, Error(ERROR_INVALID_HEADER, "invalid content length %s", cl)
+	} else if length == 0 {
+		return &httpBody{state: BODY_STATE_DONE}, nil
+	}
+	return &httpBody{state: BODY_STATE_LENGTH, left: length}, nil
+}
+
+func (b *httpBody) done() bool {
+	return b.state == BODY_STATE_DONE
+}
+
+/*
+ * 读取一行，没有完整的行时缓存起来
+ * 返回行（不包括行尾），消耗的字节数，以及是否读取到了完整的行
+ */
+func (b *httpBody) readLine(data []byte) (string, int, bool, error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		if b.line = append(b.line, data...); len(b.line) > MAX_LINE_SIZE {
+			return "", 0, false, Error(ERROR_INVALID_FORMAT, "chunk line is too long")
+		}
+		return "", len(data), false, nil
+	}
+	line := strings.TrimRight(string(b.line)+string(data[:i]), "\r")
+	b.line = nil
+	return line, i + 1, true, nil
+}
+
+/*
+ * 读取消息体数据，返回属于当前消息的字节数
+ * 消息体结束时done()返回true，剩余的数据属于下一个消息
+ */
+func (b *httpBody) feed(data []byte) (int, error) {
+	i := 0
+	for i < len(data) && b.state != BODY_STATE_DONE {
+		switch b.state {
+		case BODY_STATE_LENGTH, BODY_STATE_CHUNK_DATA:
+			n := uint64(len(data) - i)
+			if n > b.left {
+				n = b.left
+			}
+			i += int(n)
+			if b.left -= n; b.left == 0 {
+				if b.state == BODY_STATE_LENGTH {
+					b.state = BODY_STATE_DONE
+				} else {
+					b.state = BODY_STATE_CHUNK_END
+				}
+			}
+		case BODY_STATE_UNTIL_CLOSE:
+			return len(data), nil
+		default:
+			line, n, ok, err := b.readLine(data[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+			if !ok {
+				break
+			}
+			if b.state == BODY_STATE_CHUNK_SIZE {
+				/* 忽略chunk扩展 */
+				size := strings.TrimSpace(strings.SplitN(line, ";", 2)[0])
+				length, err := strconv.ParseUint(size, 16, 64)
+				if err != nil {
+					return 0, Error(ERROR_INVALID_FORMAT, "invalid chunk size %s", size)
+				} else if length == 0 {
+					b.state = BODY_STATE_TRAILER
+				} else {
+					b.state = BODY_STATE_CHUNK_DATA
+					b.left = length
+				}
+			} else if b.state == BODY_STATE_CHUNK_END {
+				if len(line) != 0 {
+					return 0, Error(ERROR_INVALID_FORMAT, "invalid chunk ending")
+				}
+				b.state = BODY_STATE_CHUNK_SIZE
+			} else if len(line) == 0 { /* trailer以空行结束 */
+				b.state = BODY_STATE_DONE
+			}
+		}
+	}
+	return i, nil
 }
 
 var (
-	allowedMethods  = []string{"GET", "PUT", "POST", "HEAD", "OPTIONS", "DELETE", "CONNECT", "TRACE"}
+	allowedMethods  = []string{"GET", "PUT", "POST", "HEAD", "OPTIONS", "DELETE", "CONNECT", "TRACE", "PATCH"}
 	allowedVersions = []string{"HTTP/1.0", "HTTP/1.1"}
 )
 
@@ -143,33 +346,9 @@ func parseRequestVersion(version string) string {
 	return ""
 }
 
-/* 检查是否已经有完成的HTTP首部 */
-func getHTTPHeaders(lines [][]byte) ([][]byte, bool) {
-	var headers [][]byte
-	var full bool
-	for _, h := range lines {
-		if len(h) == 0 {
-			full = true
-			break
-		}
-		headers = append(headers, h)
-	}
-	return headers, full
-}
-
-/* 获取HTTP首部中Content-Length字段，或者默认为0 */
-func getContentLength(headers map[string]string) uint64 {
-	content_length, ok := headers["Content-Length"]
-	if !ok {
-		return 0
-	}
-	length, _ := strconv.Atoi(content_length)
-	return uint64(length)
-}
-
-func getProxyAuthorization(headers map[string]string) string {
-	auth, ok := headers["Proxy-Authorization"]
-	if !ok || !strings.HasPrefix(auth, "Basic ") { /* 不存在或者认证方法无效，目前只支持Basic认证 */
+func getProxyAuthorization(headers httpHeaders) string {
+	auth := headers.get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, "Basic ") { /* 不存在或者认证方法无效，目前只支持Basic认证 */
 		return ""
 	}
 	if decoded, err := base64.StdEncoding.DecodeString(auth[6:]); err == nil {
@@ -179,93 +358,128 @@ func getProxyAuthorization(headers map[string]string) string {
 }
 
 /*
- * 解析HTTP请求，如果解析到完整的请求，则返回nil并且req的相应字段都会设置
- * 如果没有解析到完整的请求，但暂时没有发现错误，则返回nil，但req的字段不会被设置
- * 如果检测到错误，则返回对应的错误信息
+ * 查找首部的结束位置，返回包括结尾空行在内的首部长度
+ * 没有完整的首部时返回0
  */
-func (req *httpRequest) parse(data []byte) error {
-	lines := bytes.Split(data, []byte("\r\n"))
-	if len(lines) <= 1 {
-		return nil
-	}
-	var firstline [][]byte = nil
-	var method string
-	var uri *url.URL = nil
-	var version string
-	var headers map[string]string = make(map[string]string)
-	var host string
-	var full bool
-	for _, t := range bytes.Split(lines[0], []byte(" ")) {
-		e := bytes.Trim(t, " ")
-		if len(e) > 0 {
-			firstline = append(firstline, e)
+func findHeaderEnd(data []byte) int {
+	i := 0
+	for {
+		j := bytes.IndexByte(data[i:], '\n')
+		if j < 0 {
+			return 0
+		}
+		line := data[i : i+j]
+		i += j + 1
+		if len(line) == 0 || (len(line) == 1 && line[0] == '\r') {
+			return i
 		}
 	}
+}
+
+/* 把首部分割成行，去掉结尾的空行 */
+func splitHeaderLines(header []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(header), "\n") {
+		if line = strings.TrimRight(line, "\r"); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func parseHeaders(lines []string) (httpHeaders, error) {
+	var headers httpHeaders
+	for _, line := range lines {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 || strings.ContainsAny(kv[0], " \t") {
+			return nil, Error(ERROR_INVALID_HEADER, "invalid header format")
+		}
+		headers = append(headers, httpHeader{key: kv[0], value: strings.TrimSpace(kv[1])})
+	}
+	return headers, nil
+}
+
+/* 解析完整的HTTP请求首部 */
+func parseRequest(header []byte) (*httpRequest, error) {
+	lines := splitHeaderLines(header)
+	if len(lines) == 0 {
+		return nil, Error(ERROR_INVALID_FORMAT, "invalid request line")
+	}
+	firstline := strings.Fields(lines[0])
 	if len(firstline) != 3 {
-		return Error(ERROR_INVALID_FORMAT, "invalid request line")
+		return nil, Error(ERROR_INVALID_FORMAT, "invalid request line")
 	}
+	req := &httpRequest{}
 	/* 检查方法是否有效 */
-	if method = parseRequestMethod(string(firstline[0])); len(method) == 0 {
-		return Error(ERROR_INVALID_METHOD, "invalid method %s", firstline[0])
+	if req.Method = parseRequestMethod(firstline[0]); len(req.Method) == 0 {
+		return nil, Error(ERROR_INVALID_METHOD, "invalid method %s", firstline[0])
+	}
+	if req.URI = parseRequestURI(req.Method, firstline[1]); req.URI == nil {
+		return nil, Error(ERROR_INVALID_URI, "invalid uri %s", firstline[1])
 	}
-	if uri = parseRequestURI(method, string(firstline[1])); uri == nil {
-		return Error(ERROR_INVALID_URI, "invalid uri %s", firstline[1])
+	if req.Version = parseRequestVersion(firstline[2]); len(req.Version) == 0 {
+		return nil, Error(ERROR_INVALID_VERSION, "invalid http version %s", firstline[2])
 	}
-	if version = parseRequestVersion(string(firstline[2])); len(version) == 0 {
-		return Error(ERROR_INVALID_VERSION, "invalid http version %s", firstline[2])
+	headers, err := parseHeaders(lines[1:])
+	if err != nil {
+		return nil, err
 	}
+	if len(req.URI.Host) > 0 {
+		req.Host = req.URI.Host
+	} else {
+		req.Host = headers.get("Host")
+	}
+	if len(req.Host) <= 0 {
+		return nil, Error(ERROR_INVALID_HOST, "host not found")
+	}
+	req.Headers = headers
+	req.ProxyAuthorization = getProxyAuthorization(headers)
+	return req, nil
+}
 
-	lines, full = getHTTPHeaders(lines[1:])
-	if full { /* 如果已经读取了完整的HTTP首部 */
-		lines = lines
-	} else { /* 没有读取完成的HTTP首部，则暂时忽略最后一行 */
-		lines = lines[:len(lines)-1]
+/* 解析完整的HTTP应答首部 */
+func parseResponse(header []byte) (*httpResponse, error) {
+	lines := splitHeaderLines(header)
+	if len(lines) == 0 {
+		return nil, Error(ERROR_INVALID_RESPONSE, "invalid status line")
 	}
-	for _, line := range lines {
-		kv := bytes.SplitN(line, []byte(":"), 2)
-		if len(kv) != 2 {
-			return Error(ERROR_INVALID_HEADER, "invalid header format")
-		}
-		key := string(bytes.Trim(kv[0], " "))
-		value := string(bytes.Trim(kv[1], " "))
-		headers[key] = value
-	}
-	if full { /* 如果已经读取到了完整的HTTP首部，则检查Host */
-		if len(uri.Host) > 0 {
-			host = uri.Host
-		} else {
-			host = headers["Host"]
-		}
-		if len(host) <= 0 {
-			return Error(ERROR_INVALID_HOST, "host not found")
-		}
-		headers["Host"] = host
-		req.Method = method
-		req.URI = uri
-		req.Version = version
-		req.Headers = headers
-		req.Host = host
-		req.ContentLength = getContentLength(headers)
-		req.ProxyAuthorization = getProxyAuthorization(headers)
-		tmp := bytes.SplitN(data, []byte("\r\n\r\n"), 2)
-		if len(tmp) == 2 {
-			req.Payload = tmp[1]
-		} else {
-			req.Payload = nil
-		}
-		if uint64(len(req.Payload)) < req.ContentLength {
-			req.Status = REQUEST_STATUS_FULL_HEADER
-		} else {
-			req.Status = REQUEST_STATUS_FULL_REQUEST
-		}
+	status, err := parseResponseStatus([]byte(lines[0]))
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	headers, err := parseHeaders(lines[1:])
+	if err != nil {
+		return nil, err
+	}
+	return &httpResponse{
+		Version: parseRequestVersion(strings.Fields(lines[0])[0]),
+		Status:  status,
+		Headers: headers,
+	}, nil
 }
 
-/*
- * 解析数据，成功返回nil，失败返回错误信息
- */
-func (req *httpRequest) feed(data []byte) error {
-	req.data = append(req.data, data...)
-	return req.parse(req.data)
+/* 生成发送给上游代理的CONNECT请求，用户名密码为空时不认证 */
+func buildConnectRequest(host string, port int, username, password string) []byte {
+	hostport := util.JoinHostPort(host, port)
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", hostport, hostport)
+	if len(username) > 0 || len(password) > 0 {
+		auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		request += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	request += HEADER_PROXY_AGENT + "\r\n"
+	return []byte(request)
+}
+
+/* 解析HTTP应答首部的状态行，返回状态码 */
+func parseResponseStatus(header []byte) (int, error) {
+	line := bytes.SplitN(header, []byte("\r\n"), 2)[0]
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || parseRequestVersion(fields[0]) == "" {
+		return 0, Error(ERROR_INVALID_RESPONSE, "invalid status line")
+	}
+	status, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, Error(ERROR_INVALID_RESPONSE, "invalid status code %s", fields[1])
+	}
+	return status, nil
 }
@@ -0,0 +1,238 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package http
+
+import (
+	. "skywalker/agent/base"
+	"skywalker/pkg"
+	"skywalker/util"
+	"strings"
+	"testing"
+)
+
+/* 把data按照每次size个字节喂给消息体，返回属于消息体的字节数 */
+func feedBody(b *httpBody, data string, size int) (int, error) {
+	total := 0
+	for len(data) > 0 && !b.done() {
+		chunk := data
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		n, err := b.feed([]byte(chunk))
+		if err != nil {
+			return total, err
+		}
+		total += n
+		data = data[n:]
+	}
+	return total, nil
+}
+
+func TestChunkedBody(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		size int  /* 消息体的长度，之后的数据属于下一个请求 */
+		fail bool /* 是否是错误的消息体 */
+	}{
+		{"simple", "5\r\nhello\r\n0\r\n\r\nNEXT", 15, false},
+		{"extension", "5;name=value\r\nhello\r\n0;last\r\n\r\nNEXT", 31, false},
+		{"upper hex", "A\r\n0123456789\r\n0\r\n\r\n", 20, false},
+		{"trailer", "1\r\nx\r\n0\r\nX-Sum: 1\r\nX-Other: 2\r\n\r\nNEXT", 33, false},
+		{"bare lf", "1\nx\n0\n\nNEXT", 7, false},
+		{"invalid size", "zz\r\nhello\r\n0\r\n\r\n", 0, true},
+		{"missing crlf", "5\r\nhelloXX0\r\n\r\n", 0, true},
+	}
+	for _, test := range tests {
+		/* 每种拆分方式都要得到相同的结果，包括chunk长度行被拆开 */
+		for split := 1; split <= len(test.data); split++ {
+			b := &httpBody{state: BODY_STATE_CHUNK_SIZE}
+			n, err := feedBody(b, test.data, split)
+			if test.fail {
+				if err == nil {
+					t.Errorf("%s/%d: no error", test.name, split)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s/%d: %s", test.name, split, err)
+			} else if !b.done() || n != test.size {
+				t.Errorf("%s/%d: done %v size %d, want done size %d", test.name, split, b.done(), n, test.size)
+			}
+		}
+	}
+}
+
+/* 没有结束的chunk长度行不能无限缓存 */
+func TestChunkLineTooLong(t *testing.T) {
+	b := &httpBody{state: BODY_STATE_CHUNK_SIZE}
+	if _, err := feedBody(b, "1;"+strings.Repeat("x", 2*MAX_LINE_SIZE), 100); err == nil {
+		t.Error("no error for a chunk line longer than MAX_LINE_SIZE")
+	}
+}
+
+func TestRequestBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers httpHeaders
+		state   int
+		left    uint64
+		fail    bool
+		removed bool /* 转发之前删除了Content-Length */
+	}{
+		{"no body", nil, BODY_STATE_DONE, 0, false, false},
+		{"length", httpHeaders{{"Content-Length", "10"}}, BODY_STATE_LENGTH, 10, false, false},
+		{"zero length", httpHeaders{{"Content-Length", "0"}}, BODY_STATE_DONE, 0, false, false},
+		{"same lengths", httpHeaders{{"Content-Length", "10"}, {"content-length", " 10"}}, BODY_STATE_LENGTH, 10, false, false},
+		{"conflicting lengths", httpHeaders{{"Content-Length", "10"}, {"Content-Length", "20"}}, 0, 0, true, false},
+		{"invalid length", httpHeaders{{"Content-Length", "-1"}}, 0, 0, true, false},
+		{"chunked", httpHeaders{{"Transfer-Encoding", "chunked"}}, BODY_STATE_CHUNK_SIZE, 0, false, false},
+		{"chunked and length", httpHeaders{{"Content-Length", "10"}, {"Transfer-Encoding", "gzip, chunked"}}, BODY_STATE_CHUNK_SIZE, 0, false, true},
+		{"not chunked", httpHeaders{{"Transfer-Encoding", "gzip"}}, 0, 0, true, false},
+	}
+	for _, test := range tests {
+		req := &httpRequest{Headers: test.headers}
+		b, err := req.newBody()
+		if test.fail {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if b.state != test.state || b.left != test.left {
+			t.Errorf("%s: state %d left %d, want %d %d", test.name, b.state, b.left, test.state, test.left)
+		} else if test.removed && req.Headers.has("Content-Length") {
+			t.Errorf("%s: Content-Length is forwarded", test.name)
+		}
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		status  int
+		headers httpHeaders
+		state   int
+	}{
+		{"head", HTTP_METHOD_HEAD, 200, httpHeaders{{"Content-Length", "10"}}, BODY_STATE_DONE},
+		{"no content", HTTP_METHOD_GET, 204, nil, BODY_STATE_DONE},
+		{"not modified", HTTP_METHOD_GET, 304, httpHeaders{{"Content-Length", "10"}}, BODY_STATE_DONE},
+		{"length", HTTP_METHOD_GET, 200, httpHeaders{{"Content-Length", "10"}}, BODY_STATE_LENGTH},
+		{"chunked", HTTP_METHOD_GET, 200, httpHeaders{{"Transfer-Encoding", "chunked"}}, BODY_STATE_CHUNK_SIZE},
+		{"until close", HTTP_METHOD_GET, 200, nil, BODY_STATE_UNTIL_CLOSE},
+		{"other encoding", HTTP_METHOD_GET, 200, httpHeaders{{"Transfer-Encoding", "gzip"}}, BODY_STATE_UNTIL_CLOSE},
+	}
+	for _, test := range tests {
+		rep := &httpResponse{Status: test.status, Headers: test.headers}
+		if b, err := rep.newBody(test.method); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if b.state != test.state {
+			t.Errorf("%s: state %d, want %d", test.name, b.state, test.state)
+		}
+	}
+}
+
+/* 创建没有代理认证的HTTP CA */
+func newTestClientAgent(t *testing.T) *HTTPClientAgent {
+	gCAConfigs["test"] = &httpCAConfig{}
+	a := &HTTPClientAgent{BaseAgent: BaseAgent{Name: "test"}}
+	if err := a.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+/* 把CA发给SA的数据包转化成字符串，连接请求写成CONNECT host:port */
+func packagesString(tdata interface{}) string {
+	packages, _ := tdata.([]*pkg.Package)
+	s := ""
+	for _, p := range packages {
+		if p.Type() == pkg.PKG_CONNECT {
+			host, port := p.GetConnectRequest()
+			s += "CONNECT " + util.JoinHostPort(host, port) + "|"
+		} else {
+			for _, data := range p.GetData() {
+				s += string(data)
+			}
+		}
+	}
+	return s
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	defer delete(gCAConfigs, "test")
+	a := newTestClientAgent(t)
+	tdata, _, err := a.ReadFromClient([]byte("GET http://a.test/1 HTTP/1.1\r\nHost: a.test\r\n\r\n" +
+		"POST http://a.test/2 HTTP/1.1\r\nContent-Length: 2\r\n\r\nok" +
+		"GET http://b.test/3 HTTP/1.1\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "CONNECT a.test:80|GET /1 HTTP/1.1\r\nHost: a.test\r\n\r\n" +
+		"POST /2 HTTP/1.1\r\nContent-Length: 2\r\nHost: a.test\r\n\r\nok"
+	if got := packagesString(tdata); got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+	a.OnConnectResult(pkg.CONNECT_RESULT_OK, "a.test", 80)
+
+	/* 另一个服务器的请求要等之前的应答都结束才发送 */
+	tdata, _, _ = a.ReadFromSA([]byte("HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n1"))
+	if tdata != nil {
+		t.Fatalf("%q sent before all responses are received", packagesString(tdata))
+	}
+	tdata, _, _ = a.ReadFromSA([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n1\r\n2\r\n0\r\n\r\n"))
+	want = "CONNECT b.test:80|GET /3 HTTP/1.1\r\nHost: b.test\r\n\r\n"
+	if got := packagesString(tdata); got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+}
+
+func TestPipelinedRequestAfterUpgrade(t *testing.T) {
+	defer delete(gCAConfigs, "test")
+	a := newTestClientAgent(t)
+	upgrade := "GET http://a.test/ws HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	next := "GET http://a.test/next HTTP/1.1\r\n"
+	tdata, _, err := a.ReadFromClient([]byte(upgrade + next))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := packagesString(tdata); !strings.HasPrefix(got, "CONNECT a.test:80|GET /ws HTTP/1.1\r\n") {
+		t.Fatalf("sent %q", got)
+	}
+	a.OnConnectResult(pkg.CONNECT_RESULT_OK, "a.test", 80)
+
+	/* 101之后的应答和请求都不再解析，后面的请求原样转发 */
+	_, rdata, err := a.ReadFromSA([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n\x81\x00"))
+	if err != nil {
+		t.Fatal(err)
+	} else if string(rdata.([]byte)) != "HTTP/1.1 101 Switching Protocols\r\n\r\n\x81\x00" {
+		t.Fatalf("response %q not forwarded", rdata)
+	}
+	if _, rdata, err = a.ReadFromSA([]byte("HTTP/1.1 garbage")); err != nil {
+		t.Fatalf("data after upgrade is parsed: %s", err)
+	}
+	tdata, _, err = a.ReadFromClient([]byte("\r\n\x81\x80"))
+	if err != nil {
+		t.Fatalf("data after upgrade is parsed: %s", err)
+	} else if got := packagesString(tdata); got != next+"\r\n\x81\x80" {
+		t.Fatalf("sent %q, want %q", got, next+"\r\n\x81\x80")
+	}
+}
@@ -0,0 +1,346 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	. "skywalker/agent/base"
+	"skywalker/pkg"
+	"skywalker/resolver"
+	"skywalker/util"
+	"strconv"
+	"strings"
+)
+
+/*
+ * HTTP Server Agent
+ * 通过上游HTTP代理的CONNECT方法建立隧道，隧道建立之后直接转发数据
+ */
+type (
+	HTTPServerAgent struct {
+		BaseAgent
+
+		server     *httpServerAddress
+		first      int /* 最先选择的服务器，连接失败时从这里往后切换 */
+		skip       int /* 已经切换过的服务器个数 */
+		targetAddr string
+		targetPort int
+
+		/* 隧道是否已经建立 */
+		connected bool
+
+		rbuf []byte   /* 还没有完整的应答首部 */
+		buf  [][]byte /* 隧道建立之前CA发送的数据 */
+
+		cfg *httpSAConfig
+	}
+
+	httpServerAddress struct {
+		serverAddr string
+		serverPort int
+		username   string
+		password   string
+	}
+
+	/* 配置参数 */
+	httpSAConfig struct {
+		httpServerAddress
+
+		/* 多服务器设置 */
+		selection   string /* 服务器选择策略，配置了多服务器时有效 */
+		serverAddrs []httpServerAddress
+		retry       int /* 每个服务器的重试次数，默认为3 */
+		sindex      int /* 当前选中的服务器 */
+		try         int /* 当前尝试次数 */
+	}
+)
+
+const (
+	http_SERVER_SELECT_BETTER   = "better"   /* *智能*选择 */
+	http_SERVER_SELECT_RANDOM   = "random"   /* 随机 */
+	http_SERVER_SELECT_ROTATION = "rotation" /* 轮流 */
+
+	MAX_RESPONSE_HEADER_SIZE = 8192 /* 应答首部的最大长度 */
+)
+
+var (
+	gSAConfigs = map[string]*httpSAConfig{}
+)
+
+/* 更改当前服务器 */
+func (a *HTTPServerAgent) onServerError(server string) {
+	cfg := a.cfg
+	if cfg.selection != http_SERVER_SELECT_BETTER {
+		return
+	}
+	addrSize := len(cfg.serverAddrs)
+	if addrSize > 0 && cfg.serverAddrs[cfg.sindex].serverAddr == server {
+		/* 出错次数过多就考虑更换服务器 */
+		if cfg.try += 1; cfg.try >= cfg.retry {
+			cfg.try = 0
+			if cfg.sindex += 1; cfg.sindex >= addrSize {
+				cfg.sindex = 0
+			}
+		}
+	}
+}
+
+/*
+ * 返回当前服务的信息
+ * 如果配置了多个会从多个中选择一个
+ */
+func (a *HTTPServerAgent) getServerInfo() *httpServerAddress {
+	cfg := a.cfg
+	addrSize := len(cfg.serverAddrs)
+	if addrSize == 0 { /*  选择唯一的服务器 */
+		return &cfg.httpServerAddress
+	}
+	if cfg.selection == http_SERVER_SELECT_BETTER {
+		return &cfg.serverAddrs[cfg.sindex]
+	} else if cfg.selection == http_SERVER_SELECT_RANDOM { /* 随机选择服务器 */
+		return &cfg.serverAddrs[rand.Intn(addrSize)]
+	}
+	/* 轮流 */
+	if cfg.sindex += 1; cfg.sindex >= addrSize {
+		cfg.sindex = 0
+	}
+	return &cfg.serverAddrs[cfg.sindex]
+}
+
+func (a *HTTPServerAgent) Name() string {
+	return "http"
+}
+
+/* 解析配置，不修改全局的配置 */
+func parseSAConfig(cfg map[string]interface{}) (*httpSAConfig, error) {
+	var serverAddrs []httpServerAddress
+
+	serverAddr := util.GetMapString(cfg, "serverAddr")
+	serverPort := util.GetMapInt(cfg, "serverPort")
+	username := util.GetMapString(cfg, "username")
+	password := util.GetMapString(cfg, "password")
+	selection := util.GetMapStringDefault(cfg, "select", http_SERVER_SELECT_ROTATION)
+
+	if val, ok := cfg["serverAddr[]"]; ok {
+		array, _ := val.([]interface{})
+		for _, e := range array {
+			m, ok := e.(map[string]interface{})
+			if m == nil || ok == false {
+				return nil, Error(ERROR_INVALID_CONFIG, "serverAddr[] must be an object array")
+			}
+			saddr := httpServerAddress{
+				serverAddr: util.GetMapStringDefault(m, "serverAddr", serverAddr),
+				serverPort: util.GetMapIntDefault(m, "serverPort", serverPort),
+				username:   util.GetMapStringDefault(m, "username", username),
+				password:   util.GetMapStringDefault(m, "password", password),
+			}
+			if len(saddr.serverAddr) == 0 || saddr.serverPort <= 0 {
+				return nil, Error(ERROR_INVALID_CONFIG, "invalid serverAddrs")
+			}
+			serverAddrs = append(serverAddrs, saddr)
+		}
+	} else if len(serverAddr) == 0 || serverPort <= 0 {
+		return nil, Error(ERROR_INVALID_CONFIG, "invalid server config")
+	}
+
+	return &httpSAConfig{
+		httpServerAddress: httpServerAddress{
+			serverAddr: serverAddr,
+			serverPort: serverPort,
+			username:   username,
+			password:   password,
+		},
+		serverAddrs: serverAddrs,
+		selection:   selection,
+		retry:       3,
+		sindex:      0,
+		try:         0,
+	}, nil
+}
+
+/* 初始化，载入配置 */
+func (a *HTTPServerAgent) OnInit(name string, cfg map[string]interface{}) error {
+	saConfig, err := parseSAConfig(cfg)
+	if err != nil {
+		return err
+	}
+	for _, addr := range saConfig.serverAddrs {
+		go resolver.Get(name).ResolveHost(addr.serverAddr)
+	}
+	gSAConfigs[name] = saConfig
+	return nil
+}
+
+/* 只检查配置，重新加载配置时使用 */
+func (*HTTPServerAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseSAConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*HTTPServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"serverAddr": STRING_FIELD,
+		"serverPort": INT_FIELD,
+		"username":   SECRET_FIELD,
+		"password":   SECRET_FIELD,
+		"select":     STRING_FIELD,
+		"serverAddr[]": ListField(MapField(ConfigSchema{
+			"serverAddr": STRING_FIELD,
+			"serverPort": INT_FIELD,
+			"username":   SECRET_FIELD,
+			"password":   SECRET_FIELD,
+		})),
+	}
+}
+
+func (a *HTTPServerAgent) OnStart() error {
+	a.cfg = gSAConfigs[a.BaseAgent.Name]
+	a.server = a.getServerInfo()
+	a.first = 0
+	a.skip = 0
+	for i := range a.cfg.serverAddrs {
+		if &a.cfg.serverAddrs[i] == a.server {
+			a.first = i
+		}
+	}
+	a.reset()
+	return nil
+}
+
+/* 清空隧道的状态，CA要求重新连接时SA会被重用，需要重新建立隧道 */
+func (a *HTTPServerAgent) reset() {
+	a.connected = false
+	a.rbuf = nil
+	a.buf = nil
+}
+
+/* 获取并清空缓存数据 */
+func (a *HTTPServerAgent) buffer() [][]byte {
+	buf := a.buf
+	a.buf = nil
+	return buf
+}
+
+func (a *HTTPServerAgent) GetRemoteAddress(addr string, port int) (string, int) {
+	a.reset()
+	a.targetAddr = addr
+	a.targetPort = port
+	return a.server.serverAddr, a.server.serverPort
+}
+
+/* 连接上游代理失败，从最先选择的服务器往后切换到下一个服务器 */
+func (a *HTTPServerAgent) NextRemoteAddress(host string, port int, result int) (string, int) {
+	a.onServerError(host)
+	addrSize := len(a.cfg.serverAddrs)
+	if a.skip+1 >= addrSize {
+		return "", 0
+	}
+	a.skip += 1
+	a.server = &a.cfg.serverAddrs[(a.first+a.skip)%addrSize]
+	return a.server.serverAddr, a.server.serverPort
+}
+
+/* 连接上游代理成功后发送CONNECT请求 */
+func (a *HTTPServerAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
+	if result == pkg.CONNECT_RESULT_OK {
+		a.reset()
+		return nil, buildConnectRequest(a.targetAddr, a.targetPort, a.server.username, a.server.password), nil
+	}
+	/* 出错 */
+	a.onServerError(a.server.serverAddr)
+	return nil, nil, nil
+}
+
+/* 读取CONNECT的应答，应答之后的数据直接转发 */
+func (a *HTTPServerAgent) ReadFromServer(data []byte) (interface{}, interface{}, error) {
+	if a.connected {
+		return data, nil, nil
+	}
+	a.rbuf = append(a.rbuf, data...)
+	i := bytes.Index(a.rbuf, []byte("\r\n\r\n"))
+	if i < 0 {
+		if len(a.rbuf) > MAX_RESPONSE_HEADER_SIZE {
+			return nil, nil, Error(ERROR_INVALID_RESPONSE, "response header is too large")
+		}
+		return nil, nil, nil
+	}
+	status, err := parseResponseStatus(a.rbuf[:i])
+	if err != nil {
+		return nil, nil, err
+	} else if status == 407 {
+		return nil, nil, Error(ERROR_AUTH_REQUIRED, "upstream proxy authorization required")
+	} else if status < 200 || status >= 300 {
+		return nil, nil, Error(ERROR_CONNECT_FAILED, "upstream proxy responds %d", status)
+	}
+	a.connected = true
+	left := a.rbuf[i+4:]
+	a.rbuf = nil
+	if len(left) == 0 {
+		return nil, a.buffer(), nil
+	}
+	return left, a.buffer(), nil
+}
+
+func (a *HTTPServerAgent) ReadFromCA(data []byte) (interface{}, interface{}, error) {
+	if !a.connected {
+		a.buf = append(a.buf, data)
+		return nil, nil, nil
+	}
+	return nil, data, nil
+}
+
+func (a *HTTPServerAgent) OnClose(closedByClient bool) {
+	if !closedByClient && !a.connected { /* 没有建立隧道就断开，且不是客户端断开的 */
+		a.WARN("Connection Closed Unexpectedly")
+		a.onServerError(a.server.serverAddr)
+	}
+}
+
+func (a *HTTPServerAgent) GetInfo() []map[string]string {
+	formatServerAddrs := func(addrs []httpServerAddress) string {
+		var s []string
+		for _, addr := range addrs {
+			s = append(s, fmt.Sprintf("%s:%d", addr.serverAddr, addr.serverPort))
+		}
+		return "[" + strings.Join(s, ", ") + "]"
+	}
+	if len(a.cfg.serverAddrs) > 0 {
+		return []map[string]string{
+			map[string]string{
+				"key":   "serverAddrs",
+				"value": formatServerAddrs(a.cfg.serverAddrs),
+			},
+			map[string]string{
+				"key":   "selection",
+				"value": a.cfg.selection,
+			},
+		}
+	}
+	return []map[string]string{
+		map[string]string{
+			"key":   "serverAddr",
+			"value": a.cfg.httpServerAddress.serverAddr,
+		},
+		map[string]string{
+			"key":   "serverPort",
+			"value": strconv.Itoa(a.cfg.httpServerAddress.serverPort),
+		},
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package http
+
+import (
+	"bytes"
+	. "skywalker/agent/base"
+	"skywalker/pkg"
+	"testing"
+)
+
+const testEstablished = "HTTP/1.1 200 Connection established\r\n\r\n"
+
+/* 通过上游代理连接host:port，检查CONNECT请求，返回SA收到的数据 */
+func connectThrough(t *testing.T, a *HTTPServerAgent, host string, port int, reply string) (interface{}, interface{}) {
+	if addr, p := a.GetRemoteAddress(host, port); addr != "proxy.test" || p != 3128 {
+		t.Fatalf("remote address %s:%d, want proxy.test:3128", addr, p)
+	}
+	_, req, err := a.OnConnectResult(pkg.CONNECT_RESULT_OK, "proxy.test", 3128)
+	if err != nil {
+		t.Fatalf("OnConnectResult: %s", err)
+	}
+	want := "CONNECT " + host + ":"
+	if !bytes.HasPrefix(req.([]byte), []byte(want)) {
+		t.Fatalf("request %q, want prefix %q", req, want)
+	}
+	/* 隧道建立之前CA发送的数据需要缓存 */
+	if _, data, _ := a.ReadFromCA([]byte("early")); data != nil {
+		t.Fatalf("data %q sent before tunnel is established", data)
+	}
+	toCA, toServer, err := a.ReadFromServer([]byte(reply))
+	if err != nil {
+		t.Fatalf("ReadFromServer: %s", err)
+	}
+	return toCA, toServer
+}
+
+/* CA更换目标地址时SA被重用，第二次连接也要解析CONNECT的应答 */
+func TestServerAgentReconnect(t *testing.T) {
+	gSAConfigs["reconnect"] = &httpSAConfig{
+		httpServerAddress: httpServerAddress{serverAddr: "proxy.test", serverPort: 3128},
+	}
+	defer delete(gSAConfigs, "reconnect")
+	a := &HTTPServerAgent{BaseAgent: BaseAgent{Name: "reconnect"}}
+	if err := a.OnStart(); err != nil {
+		t.Fatal(err)
+	}
+
+	toCA, toServer := connectThrough(t, a, "first.test", 443, testEstablished)
+	if toCA != nil {
+		t.Errorf("first: %q forwarded to client", toCA)
+	}
+	if buf, _ := toServer.([][]byte); len(buf) != 1 || string(buf[0]) != "early" {
+		t.Errorf("first: buffered data %q, want [early]", toServer)
+	}
+	if _, data, _ := a.ReadFromCA([]byte("tunnel")); string(data.([]byte)) != "tunnel" {
+		t.Errorf("first: data %q not forwarded", data)
+	}
+
+	toCA, toServer = connectThrough(t, a, "second.test", 80, testEstablished+"hello")
+	if data, _ := toCA.([]byte); string(data) != "hello" {
+		t.Errorf("second: %q forwarded to client, want hello", toCA)
+	}
+	if buf, _ := toServer.([][]byte); len(buf) != 1 || string(buf[0]) != "early" {
+		t.Errorf("second: buffered data %q, want [early]", toServer)
+	}
+}
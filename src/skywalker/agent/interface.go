@@ -17,6 +17,11 @@
 
 package agent
 
+import (
+	"net"
+	"skywalker/agent/base"
+)
+
 /*
  * 代理模型
  *
@@ -102,6 +107,137 @@ type (
 		GetInfo() []map[string]string
 	}
 
+	/*
+	 * 支持UDP转发的客户端代理
+	 * 每个客户端地址对应一个代理实例，每个UDP数据包单独处理，
+	 * 返回值的含义和TCP的数据处理接口相同，
+	 * 转发给SA的数据应该是pkg.PKG_UDP_DATA类型的数据包
+	 */
+	UDPClientAgent interface {
+		ClientAgent
+
+		/* 从客户端接收到UDP数据包 */
+		RecvFromClient([]byte) (interface{}, interface{}, error)
+		/* 从SA接收到UDP数据，前两个参数是数据的来源地址 */
+		RecvFromSA(string, int, []byte) (interface{}, interface{}, error)
+	}
+
+	/*
+	 * 只能通过TCP控制连接建立UDP转发的客户端代理，比如socks5的UDP ASSOCIATE，
+	 * 客户端的UDP数据包发送到为每个控制连接单独分配的端口，因此不需要监听代理的UDP端口
+	 */
+	UDPAssociateAgent interface {
+		UDPClientAgent
+
+		/* 返回true表示UDP转发只能通过控制连接建立 */
+		AssociateOnly() bool
+	}
+
+	/*
+	 * 需要客户端连接信息的客户端代理，比如透明代理从socket中获取原始目标地址
+	 */
+	ConnClientAgent interface {
+		ClientAgent
+
+		/* 接受客户端连接之后调用，返回值的含义和数据处理接口相同 */
+		OnAccept(net.Conn) (interface{}, interface{}, error)
+	}
+
+	/*
+	 * TPROXY透明代理的客户端代理，代理使用IP_TRANSPARENT监听TCP和UDP端口，
+	 * 目标地址从socket中获取，通过OnAccept传递给CA，
+	 * UDP会话的net.Conn的本地地址就是数据包的原始目标地址
+	 */
+	TProxyClientAgent interface {
+		ConnClientAgent
+
+		/* 返回true表示需要以TPROXY方式监听 */
+		TProxy() bool
+	}
+
+	/*
+	 * 支持监听端口的客户端代理，比如socks5的BIND，
+	 * 监听成功后收到PKG_BIND_RESULT，远程服务器连接之后再收到PKG_CONNECT_RESULT
+	 */
+	BindClientAgent interface {
+		ClientAgent
+
+		/* 监听结果，后两个参数是监听的地址 */
+		OnBindResult(int, string, int) (interface{}, interface{}, error)
+	}
+
+	/*
+	 * 支持UDP转发的服务端代理
+	 * 数据包的发送地址由GetRemoteAddress决定，
+	 * 转发给CA的数据应该是pkg.PKG_UDP_DATA类型的数据包
+	 */
+	UDPServerAgent interface {
+		ServerAgent
+
+		/* 从服务器接收到UDP数据包，前两个参数是数据包的来源地址 */
+		RecvFromServer(string, int, []byte) (interface{}, interface{}, error)
+		/* 从CA接收到UDP数据，前两个参数是数据的目标地址 */
+		RecvFromCA(string, int, []byte) (interface{}, interface{}, error)
+	}
+
+	/*
+	 * 支持监听端口的服务端代理
+	 * 返回的地址为空时由代理在本地监听，否则代理连接返回的上游服务器，
+	 * 由SA负责生成监听结果和连接结果
+	 */
+	BindServerAgent interface {
+		ServerAgent
+
+		/* 获取上游服务器地址，参数是预期连接过来的远程服务器地址 */
+		GetBindAddress(string, int) (string, int)
+	}
+
+	/*
+	 * 配置了多个上游服务器的服务端代理
+	 * 连接GetRemoteAddress返回的服务器失败时，代理调用NextRemoteAddress获取下一个候选服务器并继续连接，
+	 * SA需要切换到返回的服务器，OnConnectResult只在连接成功或者所有候选服务器都失败之后调用
+	 */
+	FailoverServerAgent interface {
+		ServerAgent
+
+		/* 参数是连接失败的服务器地址和连接结果，返回下一个候选服务器，没有候选服务器时返回空的地址 */
+		NextRemoteAddress(string, int, int) (string, int)
+	}
+
+	/*
+	 * 声明了支持的配置项的CA或者SA，检查配置时用于发现未知的配置项和类型错误，
+	 * 没有实现的代理不检查配置项
+	 */
+	ConfigSchemaAgent interface {
+		ConfigSchema() base.ConfigSchema
+	}
+
+	/*
+	 * 可以只检查配置而不初始化的CA或者SA，参数和OnInit相同，
+	 * 重新加载配置时先检查所有代理的配置，都没有问题之后才调用OnInit，
+	 * OnInit可能失败的代理都需要实现，没有实现的代理不检查
+	 */
+	ConfigCheckAgent interface {
+		CheckConfig(string, map[string]interface{}) error
+	}
+
+	/*
+	 * OnInit之后在后台保存了状态（比如健康检查）的CA或者SA，
+	 * 代理被删除或者改用其他的CA、SA时调用，参数是代理名
+	 */
+	ReleaseAgent interface {
+		OnRelease(string)
+	}
+
+	/*
+	 * 有多个上游服务器的SA，返回每个服务器的健康状态，用于监控
+	 */
+	UpstreamServerAgent interface {
+		ServerAgent
+
+		GetUpstreams() []base.UpstreamHealth
+	}
+
 	newClientAgentFunc func(string) ClientAgent
 	newServerAgentFunc func(string) ServerAgent
 )
@@ -49,17 +49,41 @@ func (a *RedirectAgent) Name() string {
 	return "direct"
 }
 
-func (a *RedirectAgent) OnInit(name string, cfg map[string]interface{}) error {
-	gConfigs[name] = &redirectConfig{
+/* 解析配置，不修改全局的配置 */
+func parseConfig(cfg map[string]interface{}) (*redirectConfig, error) {
+	rcfg := &redirectConfig{
 		host: util.GetMapString(cfg, "host"),
 		port: uint16(util.GetMapInt(cfg, "port")),
 	}
-	if gConfigs[name].host == "" || gConfigs[name].port == 0 {
-		return Error(-1, "invalid host/port")
+	if rcfg.host == "" || rcfg.port == 0 {
+		return nil, Error(-1, "invalid host/port")
+	}
+	return rcfg, nil
+}
+
+func (a *RedirectAgent) OnInit(name string, cfg map[string]interface{}) error {
+	rcfg, err := parseConfig(cfg)
+	if err != nil {
+		return err
 	}
+	gConfigs[name] = rcfg
 	return nil
 }
 
+/* 只检查配置，重新加载配置时使用 */
+func (*RedirectAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*RedirectAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"host": STRING_FIELD,
+		"port": INT_FIELD,
+	}
+}
+
 func (a *RedirectAgent) OnStart() error {
 	a.cfg = gConfigs[a.BaseAgent.Name]
 	a.status = STATUS_INIT
@@ -0,0 +1,394 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package router
+
+import (
+	"fmt"
+	"net"
+	. "skywalker/agent/base"
+	"skywalker/resolver"
+	"skywalker/util"
+	"strconv"
+	"strings"
+)
+
+/*
+ * 路由代理只能用作ServerAgent
+ * 根据目标地址按顺序匹配规则，选择其它代理的SA作为出口，
+ * 规则可以按域名后缀、域名关键字、CIDR和端口范围匹配，
+ * 同一条规则中的不同条件都要满足，同一个条件的多个值满足一个即可
+ */
+type (
+	/* 和agent.ServerAgent相同，agent包引用了本包，不能反过来引用 */
+	ServerAgent interface {
+		Name() string
+		OnInit(string, map[string]interface{}) error
+		OnStart() error
+		GetRemoteAddress(string, int) (string, int)
+		OnConnectResult(int, string, int) (interface{}, interface{}, error)
+		ReadFromServer([]byte) (interface{}, interface{}, error)
+		ReadFromCA([]byte) (interface{}, interface{}, error)
+		OnClose(bool)
+		GetInfo() []map[string]string
+	}
+
+	/* 端口范围，包含两端 */
+	portRange struct {
+		min int
+		max int
+	}
+
+	routerRule struct {
+		domainSuffixes []string
+		domainKeywords []string
+		cidrs          []*net.IPNet
+		ports          []portRange
+		proxy          string /* 出口代理的名字 */
+	}
+
+	routerConfig struct {
+		rules   []*routerRule
+		def     string /* 没有规则匹配时使用的代理 */
+		resolve bool   /* 目标地址是域名时，是否解析后再匹配CIDR */
+	}
+
+	RouterAgent struct {
+		BaseAgent
+		cfg   *routerConfig
+		sa    ServerAgent /* 当前使用的出口代理的SA */
+		proxy string
+	}
+)
+
+const (
+	ERROR_INVALID_CONFIG = 1
+	ERROR_NO_PROXY       = 2
+)
+
+var (
+	gSAConfigs = make(map[string]*routerConfig)
+
+	/* 获取代理的SA实例，由agent包设置 */
+	NewServerAgent func(string) ServerAgent
+)
+
+func parseRule(m map[string]interface{}) (*routerRule, error) {
+	rule := &routerRule{proxy: util.GetMapString(m, "proxy")}
+	if rule.proxy == "" {
+		return nil, Error(ERROR_INVALID_CONFIG, "proxy of rule is required")
+	}
+
+	suffixes, err := util.GetMapStringList(m, "domainSuffix")
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+	}
+	for _, s := range suffixes {
+		rule.domainSuffixes = append(rule.domainSuffixes, strings.Trim(strings.ToLower(s), "."))
+	}
+	keywords, err := util.GetMapStringList(m, "domainKeyword")
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+	}
+	for _, s := range keywords {
+		rule.domainKeywords = append(rule.domainKeywords, strings.ToLower(s))
+	}
+	cidrs, err := util.GetMapStringList(m, "cidr")
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+	}
+	for _, s := range cidrs {
+		ipnet, err := util.ParseCIDR(s)
+		if err != nil {
+			return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+		}
+		rule.cidrs = append(rule.cidrs, ipnet)
+	}
+	ports, err := util.GetMapStringList(m, "port")
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+	}
+	for _, s := range ports {
+		min, max, err := util.ParsePortRange(s)
+		if err != nil {
+			return nil, Error(ERROR_INVALID_CONFIG, "%s", err)
+		}
+		rule.ports = append(rule.ports, portRange{min, max})
+	}
+	return rule, nil
+}
+
+/*
+ * 规则是否匹配目标地址
+ * @domain 目标地址是域名时为小写的域名，否则为空
+ * @ip 目标地址的IP，域名没有解析时为nil
+ */
+func (r *routerRule) match(domain string, ip net.IP, port int) bool {
+	if len(r.domainSuffixes) > 0 {
+		matched := false
+		for _, suffix := range r.domainSuffixes {
+			if domain != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.domainKeywords) > 0 {
+		matched := false
+		for _, keyword := range r.domainKeywords {
+			if domain != "" && strings.Contains(domain, keyword) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.cidrs) > 0 {
+		matched := false
+		for _, ipnet := range r.cidrs {
+			if ip != nil && ipnet.Contains(ip) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.ports) > 0 {
+		matched := false
+		for _, pr := range r.ports {
+			if port >= pr.min && port <= pr.max {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *routerRule) String() string {
+	var conds []string
+	if len(r.domainSuffixes) > 0 {
+		conds = append(conds, "domainSuffix="+strings.Join(r.domainSuffixes, ","))
+	}
+	if len(r.domainKeywords) > 0 {
+		conds = append(conds, "domainKeyword="+strings.Join(r.domainKeywords, ","))
+	}
+	if len(r.cidrs) > 0 {
+		var cidrs []string
+		for _, ipnet := range r.cidrs {
+			cidrs = append(cidrs, ipnet.String())
+		}
+		conds = append(conds, "cidr="+strings.Join(cidrs, ","))
+	}
+	if len(r.ports) > 0 {
+		var ports []string
+		for _, pr := range r.ports {
+			if pr.min == pr.max {
+				ports = append(ports, strconv.Itoa(pr.min))
+			} else {
+				ports = append(ports, fmt.Sprintf("%d-%d", pr.min, pr.max))
+			}
+		}
+		conds = append(conds, "port="+strings.Join(ports, ","))
+	}
+	if len(conds) == 0 {
+		conds = append(conds, "*")
+	}
+	return strings.Join(conds, " ")
+}
+
+/* 选择目标地址对应的出口代理，r是解析域名使用的解析器 */
+func (cfg *routerConfig) route(r *resolver.Resolver, host string, port int) string {
+	var domain string
+	ip := net.ParseIP(host)
+	if ip == nil {
+		domain = strings.TrimSuffix(strings.ToLower(host), ".")
+	}
+	resolved := ip != nil
+	for _, rule := range cfg.rules {
+		if len(rule.cidrs) > 0 && !resolved && cfg.resolve {
+			/* 只有用到CIDR时才解析域名，解析失败则不匹配CIDR规则 */
+			resolved = true
+			if s, err := r.ResolveHost(host); err == nil {
+				ip = net.ParseIP(s)
+			}
+		}
+		if rule.match(domain, ip, port) {
+			return rule.proxy
+		}
+	}
+	return cfg.def
+}
+
+func (a *RouterAgent) Name() string {
+	return "router"
+}
+
+/* 解析配置，不修改全局的配置，name是代理名，路由不能指向自己 */
+func parseConfig(name string, cfg map[string]interface{}) (*routerConfig, error) {
+	rcfg := &routerConfig{
+		def: util.GetMapString(cfg, "default"),
+	}
+	if resolve, ok := cfg["resolve"].(bool); ok {
+		rcfg.resolve = resolve
+	}
+	if rcfg.def == "" {
+		return nil, Error(ERROR_INVALID_CONFIG, "default proxy is required")
+	}
+	if val, ok := cfg["rules"]; ok {
+		array, _ := val.([]interface{})
+		for _, e := range array {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				return nil, Error(ERROR_INVALID_CONFIG, "rules must be an object array")
+			}
+			rule, err := parseRule(m)
+			if err != nil {
+				return nil, err
+			}
+			rcfg.rules = append(rcfg.rules, rule)
+		}
+	}
+	for _, rule := range append(rcfg.rules, &routerRule{proxy: rcfg.def}) {
+		if rule.proxy == name {
+			return nil, Error(ERROR_INVALID_CONFIG, "proxy %s can not route to itself", name)
+		}
+	}
+	return rcfg, nil
+}
+
+func (a *RouterAgent) OnInit(name string, cfg map[string]interface{}) error {
+	rcfg, err := parseConfig(name, cfg)
+	if err != nil {
+		return err
+	}
+	gSAConfigs[name] = rcfg
+	return nil
+}
+
+/* 只检查配置，重新加载配置时使用 */
+func (*RouterAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseConfig(name, cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*RouterAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"default": STRING_FIELD,
+		"resolve": BOOL_FIELD,
+		"rules": ListField(MapField(ConfigSchema{
+			"proxy":         STRING_FIELD,
+			"domainSuffix":  STRINGS_FIELD,
+			"domainKeyword": STRINGS_FIELD,
+			"cidr":          STRINGS_FIELD,
+			"port":          STRINGS_FIELD,
+		})),
+	}
+}
+
+func (a *RouterAgent) OnStart() error {
+	a.cfg = gSAConfigs[a.BaseAgent.Name]
+	if a.cfg == nil {
+		return Error(ERROR_INVALID_CONFIG, "config not found")
+	}
+	return nil
+}
+
+/*
+ * 选择出口代理并返回它的远程地址，
+ * 每次连接（比如HTTP代理更换服务器）都会重新选择并创建新的SA
+ */
+func (a *RouterAgent) GetRemoteAddress(host string, port int) (string, int) {
+	if a.sa != nil {
+		a.sa.OnClose(true)
+		a.sa = nil
+	}
+	a.proxy = a.cfg.route(resolver.Get(a.BaseAgent.Name), host, port)
+	if sa := NewServerAgent(a.proxy); sa == nil {
+		a.WARN("proxy %s for %s is not available", a.proxy, util.JoinHostPort(host, port))
+	} else if sa.Name() == a.Name() {
+		sa.OnClose(true)
+		a.WARN("proxy %s is a router, nested routers are not supported", a.proxy)
+	} else {
+		a.sa = sa
+		a.DEBUG("%s is routed to %s", util.JoinHostPort(host, port), a.proxy)
+		return sa.GetRemoteAddress(host, port)
+	}
+	return "", 0
+}
+
+/* 出口代理配置了多个上游服务器时，由出口代理的SA选择下一个服务器 */
+func (a *RouterAgent) NextRemoteAddress(host string, port int, result int) (string, int) {
+	if fsa, ok := a.sa.(interface {
+		NextRemoteAddress(string, int, int) (string, int)
+	}); ok {
+		return fsa.NextRemoteAddress(host, port, result)
+	}
+	return "", 0
+}
+
+func (a *RouterAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
+	if a.sa == nil {
+		return nil, nil, Error(ERROR_NO_PROXY, "proxy %s is not available", a.proxy)
+	}
+	return a.sa.OnConnectResult(result, host, port)
+}
+
+func (a *RouterAgent) ReadFromServer(data []byte) (interface{}, interface{}, error) {
+	if a.sa == nil {
+		return nil, nil, Error(ERROR_NO_PROXY, "proxy %s is not available", a.proxy)
+	}
+	return a.sa.ReadFromServer(data)
+}
+
+func (a *RouterAgent) ReadFromCA(data []byte) (interface{}, interface{}, error) {
+	if a.sa == nil {
+		return nil, nil, Error(ERROR_NO_PROXY, "proxy %s is not available", a.proxy)
+	}
+	return a.sa.ReadFromCA(data)
+}
+
+func (a *RouterAgent) OnClose(closedByClient bool) {
+	if a.sa != nil {
+		a.sa.OnClose(closedByClient)
+	}
+}
+
+func (a *RouterAgent) GetInfo() []map[string]string {
+	var info []map[string]string
+	for i, rule := range a.cfg.rules {
+		info = append(info, map[string]string{
+			"key":   fmt.Sprintf("rule %d", i+1),
+			"value": rule.String() + " -> " + rule.proxy,
+		})
+	}
+	info = append(info, map[string]string{
+		"key":   "default",
+		"value": a.cfg.def,
+	})
+	return info
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package shadowsocks
+
+import (
+	_cipher "crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha1"
+	"encoding/binary"
+	. "skywalker/agent/base"
+	"skywalker/cipher"
+	"strings"
+)
+
+/*
+ * ShadowSocks的加密方式，分为流加密和AEAD加密两种
+ *
+ * 流加密的数据格式为 [IV][加密的数据流]
+ *
+ * AEAD加密的数据格式为 [SALT][加密的长度][长度的TAG][加密的数据][数据的TAG]...
+ * 每个连接使用随机的SALT通过HKDF-SHA1从主密钥生成子密钥，
+ * 数据被分割成不超过0x3FFF字节的块，每个块的长度和数据分别加密，
+ * nonce从0开始，每次加解密之后按小端序加1
+ */
+
+const (
+	ss_AEAD_MAX_PAYLOAD_SIZE = 0x3FFF
+	ss_AEAD_SUBKEY_INFO      = "ss-subkey"
+)
+
+type (
+	ssCipher struct {
+		key []byte /* 根据密码生成的主密钥 */
+
+		streamInfo *cipher.CipherInfo
+		aeadInfo   *cipher.AEADInfo
+	}
+
+	/* 加密，第一次加密的数据包含IV或者SALT */
+	ssEncoder interface {
+		encode([]byte) []byte
+	}
+
+	/* 解密，数据不完整时会缓存下来，等到下次读取 */
+	ssDecoder interface {
+		decode([]byte) ([]byte, error)
+	}
+)
+
+/* 根据加密方式和密码创建，加密方式不支持时返回nil */
+func newSSCipher(method, password string) *ssCipher {
+	method = strings.ToLower(method)
+	if info := cipher.GetAEADInfo(method); info != nil {
+		return &ssCipher{
+			key:      generateKey([]byte(password), info.KeySize),
+			aeadInfo: info,
+		}
+	} else if info := cipher.GetCipherInfo(method); info != nil {
+		return &ssCipher{
+			key:        generateKey([]byte(password), info.KeySize),
+			streamInfo: info,
+		}
+	}
+	return nil
+}
+
+func (c *ssCipher) newEncoder() ssEncoder {
+	if c.aeadInfo != nil {
+		salt := generateIV(c.aeadInfo.SaltSize)
+		aead, _ := newSubkeyAEAD(c.aeadInfo, c.key, salt)
+		return &ssAEADEncoder{
+			aead:  aead,
+			nonce: make([]byte, aead.NonceSize()),
+			salt:  salt,
+		}
+	}
+	iv := generateIV(c.streamInfo.IvSize)
+	return &ssStreamEncoder{
+		encrypter: c.streamInfo.EncrypterFunc(c.key, iv),
+		iv:        iv,
+	}
+}
+
+func (c *ssCipher) newDecoder() ssDecoder {
+	if c.aeadInfo != nil {
+		return &ssAEADDecoder{info: c.aeadInfo, key: c.key, size: -1}
+	}
+	return &ssStreamDecoder{info: c.streamInfo, key: c.key}
+}
+
+/*
+ * UDP数据包单独加密，每个数据包都有自己的IV或者SALT，
+ * AEAD加密的数据包不分块，nonce为0
+ */
+func (c *ssCipher) encryptPacket(data []byte) []byte {
+	if c.aeadInfo != nil {
+		salt := generateIV(c.aeadInfo.SaltSize)
+		aead, _ := newSubkeyAEAD(c.aeadInfo, c.key, salt)
+		return aead.Seal(salt, make([]byte, aead.NonceSize()), data, nil)
+	}
+	iv := generateIV(c.streamInfo.IvSize)
+	encrypter := c.streamInfo.EncrypterFunc(c.key, iv)
+	return append(iv, encrypter.Encrypt(data)...)
+}
+
+func (c *ssCipher) decryptPacket(data []byte) ([]byte, error) {
+	if c.aeadInfo != nil {
+		saltSize := c.aeadInfo.SaltSize
+		if len(data) < saltSize {
+			return nil, Error(ERROR_INVALID_PACKAGE_SIZE, "package size is too short")
+		}
+		aead, err := newSubkeyAEAD(c.aeadInfo, c.key, data[:saltSize])
+		if err != nil {
+			return nil, Error(ERROR_DECRYPT_FAILURE, "%s", err)
+		}
+		plain, err := aead.Open(nil, make([]byte, aead.NonceSize()), data[saltSize:], nil)
+		if err != nil {
+			return nil, Error(ERROR_DECRYPT_FAILURE, "fail to decrypt package: %s", err)
+		}
+		return plain, nil
+	}
+	ivSize := c.streamInfo.IvSize
+	if len(data) < ivSize {
+		return nil, Error(ERROR_INVALID_PACKAGE_SIZE, "package size is too short")
+	}
+	var iv []byte
+	if ivSize > 0 {
+		iv = data[:ivSize]
+	}
+	decrypter := c.streamInfo.DecrypterFunc(c.key, iv)
+	return decrypter.Decrypt(data[ivSize:]), nil
+}
+
+/* 使用HKDF-SHA1生成子密钥 */
+func newSubkeyAEAD(info *cipher.AEADInfo, key, salt []byte) (_cipher.AEAD, error) {
+	subkey, err := hkdf.Key(sha1.New, key, salt, ss_AEAD_SUBKEY_INFO, info.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return info.AEADFunc(subkey)
+}
+
+/* nonce按小端序加1 */
+func increaseNonce(nonce []byte) {
+	for i := range nonce {
+		if nonce[i]++; nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+/* 流加密 */
+type ssStreamEncoder struct {
+	encrypter cipher.Encrypter
+	iv        []byte /* 还没有发送的IV */
+}
+
+func (e *ssStreamEncoder) encode(data []byte) []byte {
+	if e.iv != nil {
+		iv := e.iv
+		e.iv = nil
+		return append(iv, e.encrypter.Encrypt(data)...)
+	}
+	return e.encrypter.Encrypt(data)
+}
+
+type ssStreamDecoder struct {
+	info      *cipher.CipherInfo
+	key       []byte
+	decrypter cipher.Decrypter
+	buf       []byte /* 不完整的IV */
+}
+
+func (d *ssStreamDecoder) decode(data []byte) ([]byte, error) {
+	if d.decrypter == nil {
+		ivSize := d.info.IvSize
+		if ivSize <= 0 {
+			d.decrypter = d.info.DecrypterFunc(d.key, nil)
+		} else {
+			if d.buf = append(d.buf, data...); len(d.buf) < ivSize {
+				return nil, nil
+			}
+			d.decrypter = d.info.DecrypterFunc(d.key, d.buf[:ivSize])
+			data = d.buf[ivSize:]
+			d.buf = nil
+		}
+	}
+	return d.decrypter.Decrypt(data), nil
+}
+
+/* AEAD加密 */
+type ssAEADEncoder struct {
+	aead  _cipher.AEAD
+	nonce []byte
+	salt  []byte /* 还没有发送的SALT */
+}
+
+func (e *ssAEADEncoder) encode(data []byte) []byte {
+	var buf []byte
+	if e.salt != nil {
+		buf = e.salt
+		e.salt = nil
+	}
+	size := make([]byte, 2)
+	for len(data) > 0 {
+		n := len(data)
+		if n > ss_AEAD_MAX_PAYLOAD_SIZE {
+			n = ss_AEAD_MAX_PAYLOAD_SIZE
+		}
+		binary.BigEndian.PutUint16(size, uint16(n))
+		buf = e.aead.Seal(buf, e.nonce, size, nil)
+		increaseNonce(e.nonce)
+		buf = e.aead.Seal(buf, e.nonce, data[:n], nil)
+		increaseNonce(e.nonce)
+		data = data[n:]
+	}
+	return buf
+}
+
+type ssAEADDecoder struct {
+	info  *cipher.AEADInfo
+	key   []byte
+	aead  _cipher.AEAD
+	nonce []byte
+	size  int    /* 当前块的数据长度，-1表示还没有读取长度 */
+	buf   []byte /* 不完整的块 */
+}
+
+func (d *ssAEADDecoder) decode(data []byte) ([]byte, error) {
+	buf := append(d.buf, data...)
+	if d.aead == nil {
+		saltSize := d.info.SaltSize
+		if len(buf) < saltSize {
+			d.buf = buf
+			return nil, nil
+		}
+		aead, err := newSubkeyAEAD(d.info, d.key, buf[:saltSize])
+		if err != nil {
+			return nil, Error(ERROR_DECRYPT_FAILURE, "%s", err)
+		}
+		d.aead = aead
+		d.nonce = make([]byte, aead.NonceSize())
+		buf = buf[saltSize:]
+	}
+
+	var plain []byte
+	overhead := d.aead.Overhead()
+	for {
+		if d.size < 0 {
+			if len(buf) < 2+overhead {
+				break
+			}
+			size, err := d.aead.Open(nil, d.nonce, buf[:2+overhead], nil)
+			if err != nil {
+				return nil, Error(ERROR_DECRYPT_FAILURE, "fail to decrypt chunk size: %s", err)
+			}
+			increaseNonce(d.nonce)
+			if d.size = int(binary.BigEndian.Uint16(size)); d.size > ss_AEAD_MAX_PAYLOAD_SIZE {
+				return nil, Error(ERROR_INVALID_PACKAGE_SIZE, "invalid chunk size %d", d.size)
+			}
+			buf = buf[2+overhead:]
+		}
+		if len(buf) < d.size+overhead {
+			break
+		}
+		var err error
+		if plain, err = d.aead.Open(plain, d.nonce, buf[:d.size+overhead], nil); err != nil {
+			return nil, Error(ERROR_DECRYPT_FAILURE, "fail to decrypt chunk: %s", err)
+		}
+		increaseNonce(d.nonce)
+		buf = buf[d.size+overhead:]
+		d.size = -1
+	}
+
+	/* 保存不完整的块 */
+	if len(buf) > 0 {
+		d.buf = append([]byte(nil), buf...)
+	} else {
+		d.buf = nil
+	}
+	return plain, nil
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package shadowsocks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var testAEADMethods = []string{"aes-128-gcm", "aes-192-gcm", "aes-256-gcm", "chacha20-ietf-poly1305"}
+
+/* 测试数据，包括需要分成多个块的数据 */
+func testPayloads() [][]byte {
+	big := make([]byte, 2*ss_AEAD_MAX_PAYLOAD_SIZE+10)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	return [][]byte{[]byte("hello"), []byte("x"), big, []byte("world")}
+}
+
+/* 把数据按照每次size个字节解密，流加密在原来的数据上解密，因此先复制一份 */
+func decodeSplit(t *testing.T, d ssDecoder, data []byte, size int) []byte {
+	var plain []byte
+	data = append([]byte{}, data...)
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		out, err := d.decode(data[:n])
+		if err != nil {
+			t.Fatalf("decode: %s", err)
+		}
+		plain = append(plain, out...)
+		data = data[n:]
+	}
+	return plain
+}
+
+func TestAEADFraming(t *testing.T) {
+	for _, method := range testAEADMethods {
+		c := newSSCipher(method, "password")
+		if c == nil || c.aeadInfo == nil {
+			t.Fatalf("%s: not an AEAD method", method)
+		}
+		e := c.newEncoder()
+		var stream, plain []byte
+		chunks := 0
+		for _, payload := range testPayloads() {
+			plain = append(plain, payload...)
+			stream = append(stream, e.encode(payload)...)
+			chunks += (len(payload) + ss_AEAD_MAX_PAYLOAD_SIZE - 1) / ss_AEAD_MAX_PAYLOAD_SIZE
+		}
+
+		/* [SALT]之后每个块是[长度][TAG][数据][TAG] */
+		overhead := 16
+		if want := c.aeadInfo.SaltSize + chunks*(2+2*overhead) + len(plain); len(stream) != want {
+			t.Fatalf("%s: stream size %d, want %d", method, len(stream), want)
+		}
+
+		/* 盐、长度和数据被拆分到多次读取中 */
+		for _, size := range []int{1, 7, c.aeadInfo.SaltSize + 1, 2 + overhead + 1, 1000, len(stream)} {
+			if got := decodeSplit(t, c.newDecoder(), stream, size); !bytes.Equal(got, plain) {
+				t.Errorf("%s/%d: decoded %d bytes, want %d", method, size, len(got), len(plain))
+			}
+		}
+
+		/* 另一个加密器使用不同的盐 */
+		if other := c.newEncoder().encode([]byte("hello")); bytes.Equal(other[:c.aeadInfo.SaltSize], stream[:c.aeadInfo.SaltSize]) {
+			t.Errorf("%s: salt is reused", method)
+		}
+	}
+}
+
+func TestAEADDecodeFailure(t *testing.T) {
+	c := newSSCipher("chacha20-ietf-poly1305", "password")
+	saltSize := c.aeadInfo.SaltSize
+	stream := c.newEncoder().encode([]byte("hello world"))
+
+	tests := []struct {
+		name   string
+		offset int /* 修改的字节 */
+	}{
+		{"salt", 0},
+		{"length", saltSize},
+		{"length tag", saltSize + 2},
+		{"payload", saltSize + 2 + 16},
+		{"payload tag", len(stream) - 1},
+	}
+	for _, test := range tests {
+		data := append([]byte{}, stream...)
+		data[test.offset] ^= 1
+		if _, err := c.newDecoder().decode(data); err == nil {
+			t.Errorf("%s: modified stream decoded", test.name)
+		}
+	}
+
+	/* 块的长度不能超过0x3FFF */
+	salt := stream[:saltSize]
+	aead, _ := newSubkeyAEAD(c.aeadInfo, c.key, salt)
+	size := make([]byte, 2)
+	binary.BigEndian.PutUint16(size, ss_AEAD_MAX_PAYLOAD_SIZE+1)
+	data := aead.Seal(append([]byte{}, salt...), make([]byte, aead.NonceSize()), size, nil)
+	if _, err := c.newDecoder().decode(data); err == nil {
+		t.Error("oversized chunk accepted")
+	}
+}
+
+func TestStreamCipher(t *testing.T) {
+	for _, method := range []string{"aes-256-cfb", "rc4-md5", "chacha20"} {
+		c := newSSCipher(method, "password")
+		e := c.newEncoder()
+		var stream, plain []byte
+		for _, payload := range testPayloads() {
+			plain = append(plain, payload...)
+			stream = append(stream, e.encode(payload)...)
+		}
+		if len(stream) != c.streamInfo.IvSize+len(plain) {
+			t.Fatalf("%s: stream size %d, want %d", method, len(stream), c.streamInfo.IvSize+len(plain))
+		}
+		for _, size := range []int{1, c.streamInfo.IvSize + 1, len(stream)} {
+			if got := decodeSplit(t, c.newDecoder(), stream, size); !bytes.Equal(got, plain) {
+				t.Errorf("%s/%d: decoded data differs", method, size)
+			}
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	for _, method := range append([]string{"aes-256-cfb"}, testAEADMethods...) {
+		c := newSSCipher(method, "password")
+		packet := c.encryptPacket([]byte("packet"))
+		if plain, err := c.decryptPacket(append([]byte{}, packet...)); err != nil || string(plain) != "packet" {
+			t.Errorf("%s: decrypted %q %v", method, plain, err)
+		} else if _, err := c.decryptPacket(packet[:4]); err == nil {
+			t.Errorf("%s: truncated packet decrypted", method)
+		}
+	}
+}
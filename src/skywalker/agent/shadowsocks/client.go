@@ -19,19 +19,15 @@ package shadowsocks
 
 import (
 	. "skywalker/agent/base"
-	"skywalker/cipher"
 	"skywalker/pkg"
-	"strings"
 )
 
 type (
 	ShadowSocksClientAgent struct {
 		BaseAgent
-		encrypter cipher.Encrypter
-		decrypter cipher.Decrypter
-		key       []byte
-		iv        []byte
-		ivSent    bool
+		encoder ssEncoder
+		decoder ssDecoder
+		buf     []byte /* 不完整的连接请求 */
 
 		targetAddr string
 		targetPort string
@@ -44,7 +40,7 @@ type (
 		password string
 		method   string
 
-		cipherInfo *cipher.CipherInfo
+		cipher *ssCipher
 	}
 )
 
@@ -56,17 +52,18 @@ func (p *ShadowSocksClientAgent) Name() string {
 	return "ShadowSocks"
 }
 
-func (a *ShadowSocksClientAgent) OnInit(name string, cfg map[string]interface{}) error {
+/* 解析配置，不修改全局的配置 */
+func parseCAConfig(cfg map[string]interface{}) (*ssCAConfig, error) {
 	var password, method string
 	var val interface{}
 	var ok bool
 	val, ok = cfg["password"]
 	if ok == false {
-		return Error(ERROR_INVALID_CONFIG, "password not found")
+		return nil, Error(ERROR_INVALID_CONFIG, "password not found")
 	}
 	password, ok = val.(string)
 	if ok == false {
-		return Error(ERROR_INVALID_CONFIG, "password must be type of string")
+		return nil, Error(ERROR_INVALID_CONFIG, "password must be type of string")
 	}
 	val, ok = cfg["method"]
 	if ok == false {
@@ -74,34 +71,51 @@ func (a *ShadowSocksClientAgent) OnInit(name string, cfg map[string]interface{})
 	} else {
 		method, ok = val.(string)
 		if ok == false {
-			return Error(ERROR_INVALID_CONFIG, "method must be type of string")
+			return nil, Error(ERROR_INVALID_CONFIG, "method must be type of string")
 		}
 	}
 
 	/* 验证加密方式 */
-	info := cipher.GetCipherInfo(strings.ToLower(method))
-	if info == nil {
-		return Error(ERROR_INVALID_CONFIG, "unknown cipher method")
+	c := newSSCipher(method, password)
+	if c == nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "unknown cipher method")
 	}
 
-	gCAConfigs[name] = &ssCAConfig{
-		password:   password,
-		method:     method,
-		cipherInfo: info,
+	return &ssCAConfig{
+		password: password,
+		method:   method,
+		cipher:   c,
+	}, nil
+}
+
+func (a *ShadowSocksClientAgent) OnInit(name string, cfg map[string]interface{}) error {
+	caConfig, err := parseCAConfig(cfg)
+	if err != nil {
+		return err
 	}
+	gCAConfigs[name] = caConfig
 	return nil
 }
 
+/* 只检查配置，重新加载配置时使用 */
+func (*ShadowSocksClientAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseCAConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*ShadowSocksClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"password": SECRET_FIELD,
+		"method":   STRING_FIELD,
+	}
+}
+
 func (a *ShadowSocksClientAgent) OnStart() error {
 	a.cfg = gCAConfigs[a.BaseAgent.Name]
-	key := generateKey([]byte(a.cfg.password), a.cfg.cipherInfo.KeySize)
-	iv := generateIV(a.cfg.cipherInfo.IvSize)
-
-	a.encrypter = a.cfg.cipherInfo.EncrypterFunc(key, iv)
-	a.decrypter = nil
-	a.key = key
-	a.iv = iv
-	a.ivSent = false
+	a.encoder = a.cfg.cipher.newEncoder()
+	a.decoder = a.cfg.cipher.newDecoder()
+	a.buf = nil
 	a.connected = false
 
 	return nil
@@ -114,30 +128,24 @@ func (a *ShadowSocksClientAgent) OnConnectResult(result int, host string, port i
 func (a *ShadowSocksClientAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
 	var tdata []*pkg.Package
 
-	if a.decrypter == nil {
-		/* 第一个数据包，应该包含IV和请求数据 */
-		ivSize := a.cfg.cipherInfo.IvSize
-		if ivSize > 0 {
-			if len(data) < ivSize {
-				return nil, nil, Error(ERROR_INVALID_PACKAGE, "invalid package")
-			}
-			iv := data[:ivSize]
-			a.decrypter = a.cfg.cipherInfo.DecrypterFunc(a.key, iv)
-			data = data[ivSize:]
-		} else {
-			a.decrypter = a.cfg.cipherInfo.DecrypterFunc(a.key, nil)
-		}
-	}
-
 	/* 解密数据 */
-	data = a.decrypter.Decrypt(data)
-	if data != nil && a.connected == false {
+	data, err := a.decoder.decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > 0 && a.connected == false {
 		/* 还没有收到客户端的连接请求包，解析 */
+		a.buf = append(a.buf, data...)
+		if size := addressRequestSize(a.buf); size == 0 || len(a.buf) < size {
+			/* 连接请求不完整，等待下一次读取 */
+			return nil, nil, nil
+		}
 		req := &ssAddressRequest{}
-		if err := req.parse(data); err != nil {
+		if err := req.parse(a.buf); err != nil {
 			return nil, nil, err
 		}
 		a.connected = true
+		a.buf = nil
 		tdata = append(tdata, pkg.NewConnectPackage(req.addr, int(req.port)))
 		data = req.left
 	}
@@ -148,13 +156,23 @@ func (a *ShadowSocksClientAgent) ReadFromClient(data []byte) (interface{}, inter
 }
 
 func (a *ShadowSocksClientAgent) ReadFromSA(data []byte) (interface{}, interface{}, error) {
-	var rdata [][]byte
-	if a.ivSent == false {
-		if a.iv != nil {
-			rdata = append(rdata, a.iv)
-		}
-		a.ivSent = true
+	return nil, a.encoder.encode(data), nil
+}
+
+/* UDP数据包的格式为 [IV][加密的[地址][数据]] */
+func (a *ShadowSocksClientAgent) RecvFromClient(data []byte) (interface{}, interface{}, error) {
+	plain, err := a.cfg.cipher.decryptPacket(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req := &ssAddressRequest{}
+	if err := req.parse(plain); err != nil {
+		return nil, nil, err
 	}
-	rdata = append(rdata, a.encrypter.Encrypt(data))
-	return nil, rdata, nil
+	return pkg.NewUDPDataPackage(req.addr, int(req.port), req.left), nil, nil
+}
+
+func (a *ShadowSocksClientAgent) RecvFromSA(host string, port int, data []byte) (interface{}, interface{}, error) {
+	req := &ssAddressRequest{addr: host, port: uint16(port)}
+	return nil, a.cfg.cipher.encryptPacket(append(req.build(), data...)), nil
 }
@@ -0,0 +1,291 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package shadowsocks
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"skywalker/log"
+	"skywalker/resolver"
+	"skywalker/util"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * 多服务器的健康检查
+ * 每个代理一个后台goroutine，每隔healthCheck秒检查所有的服务器，
+ * 没有配置probe时只检查能否连接服务器，延迟是TCP连接的时间，
+ * 配置了probe时通过服务器向probe发送一个HTTP HEAD请求，延迟是收到第一个应答数据的时间，
+ * 选择服务器时跳过不可用的服务器，better策略选择延迟最低的服务器
+ */
+
+const (
+	ss_HEALTH_CHECK_TIMEOUT = 5 /* 每次检查的超时时间，单位秒 */
+)
+
+type (
+	ssServerHealth struct {
+		checked  bool /* 是否已经检查过 */
+		healthy  bool
+		latency  time.Duration
+		err      string
+		failures int /* 连续连接失败的次数 */
+	}
+
+	ssHealthChecker struct {
+		sync.Mutex
+		interval  int    /* 检查的间隔，单位秒，0表示不检查 */
+		probeHost string /* 通过服务器连接的目标，为空时只检查能否连接服务器 */
+		probePort int
+		stop      chan bool
+	}
+)
+
+func (h *ssServerHealth) String() string {
+	if !h.checked {
+		return "unchecked"
+	} else if !h.healthy {
+		return "down, " + h.err
+	}
+	return fmt.Sprintf("up, %dms", h.latency/time.Millisecond)
+}
+
+/* 解析probe配置，格式为host:port */
+func parseProbe(probe string) (string, int, error) {
+	if probe == "" {
+		return "", 0, nil
+	}
+	host, port, err := net.SplitHostPort(probe)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return "", 0, errors.New("invalid probe port " + port)
+	}
+	return host, p, nil
+}
+
+/*
+ * 启动健康检查，配置重新加载时需要先停止旧的检查，
+ * checked表示已经有了健康状态，等到下一次再检查
+ */
+func (cfg *ssSAConfig) startHealthCheck(name string, checked bool) {
+	if cfg.interval <= 0 || len(cfg.serverAddrs) == 0 {
+		return
+	}
+	cfg.stop = make(chan bool)
+	go func(stop chan bool) {
+		ticker := time.NewTicker(time.Second * time.Duration(cfg.interval))
+		defer ticker.Stop()
+		for {
+			if !checked {
+				cfg.checkServers(name)
+			}
+			checked = false
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}(cfg.stop)
+}
+
+func (cfg *ssSAConfig) stopHealthCheck() {
+	if cfg.stop != nil {
+		close(cfg.stop)
+		cfg.stop = nil
+	}
+}
+
+/*
+ * 重新加载配置时服务器列表和probe都没有改变，沿用旧配置的健康状态和当前选中的服务器，
+ * 返回是否沿用了检查过的健康状态
+ */
+func (cfg *ssSAConfig) inheritHealth(old *ssSAConfig) bool {
+	old.Lock()
+	defer old.Unlock()
+	if len(cfg.serverAddrs) != len(old.serverAddrs) || cfg.probeHost != old.probeHost || cfg.probePort != old.probePort {
+		return false
+	}
+	for i := range cfg.serverAddrs {
+		a, b := &cfg.serverAddrs[i], &old.serverAddrs[i]
+		if a.serverAddr != b.serverAddr || a.serverPort != b.serverPort || a.password != b.password || a.method != b.method {
+			return false
+		}
+	}
+	checked := false
+	for i := range cfg.serverAddrs {
+		cfg.serverAddrs[i].health = old.serverAddrs[i].health
+		checked = checked || cfg.serverAddrs[i].health.checked
+	}
+	cfg.sindex = old.sindex
+	return checked
+}
+
+/* 同时检查所有的服务器，等待检查结束 */
+func (cfg *ssSAConfig) checkServers(name string) {
+	var wg sync.WaitGroup
+	for i := range cfg.serverAddrs {
+		wg.Add(1)
+		go func(addr *ssServerAddress) {
+			defer wg.Done()
+			latency, err := cfg.probeServer(name, addr)
+
+			cfg.Lock()
+			defer cfg.Unlock()
+			health := &addr.health
+			server := util.JoinHostPort(addr.serverAddr, addr.serverPort)
+			if err != nil {
+				if health.healthy || !health.checked {
+					log.WARN(name, "server %s is down, %s", server, err)
+				}
+				health.healthy = false
+				health.err = err.Error()
+			} else {
+				if !health.healthy && health.checked {
+					log.INFO(name, "server %s is up", server)
+				}
+				health.healthy = true
+				health.latency = latency
+				health.err = ""
+				health.failures = 0
+			}
+			health.checked = true
+		}(&cfg.serverAddrs[i])
+	}
+	wg.Wait()
+}
+
+/* 检查一个服务器，返回延迟 */
+func (cfg *ssSAConfig) probeServer(name string, addr *ssServerAddress) (time.Duration, error) {
+	timeout := time.Second * ss_HEALTH_CHECK_TIMEOUT
+	ip, err := resolver.Get(name).ResolveHost(addr.serverAddr)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	/* 和代理的连接使用相同的出站线路 */
+	dialer := util.GetOutbound(name).Dialer("tcp", timeout)
+	conn, err := dialer.Dial("tcp", util.JoinHostPort(ip, addr.serverPort))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if cfg.probeHost == "" {
+		return time.Since(start), nil
+	}
+
+	/* 完整的握手，密码错误时服务器会断开连接或者不返回数据 */
+	encoder := addr.cipher.newEncoder()
+	decoder := addr.cipher.newDecoder()
+	req := &ssAddressRequest{addr: cfg.probeHost, port: uint16(cfg.probePort)}
+	payload := fmt.Sprintf("HEAD / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", cfg.probeHost)
+	conn.SetDeadline(start.Add(timeout))
+	if _, err := conn.Write(encoder.encode(append(req.build(), payload...))); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		data, err := decoder.decode(buf[:n])
+		if err != nil {
+			return 0, err
+		} else if len(data) > 0 {
+			return time.Since(start), nil
+		}
+	}
+}
+
+/*
+ * 根据健康状态选择服务器，没有可用的服务器时返回nil
+ * 没有检查过的服务器当作可用，但是better策略优先选择检查过的服务器
+ */
+func (cfg *ssSAConfig) selectHealthyServer() *ssServerAddress {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	var healthy []int
+	for i := range cfg.serverAddrs {
+		if health := &cfg.serverAddrs[i].health; health.healthy || !health.checked {
+			healthy = append(healthy, i)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch cfg.selection {
+	case ss_SERVER_SELECT_BETTER:
+		best := -1
+		for _, i := range healthy {
+			health := &cfg.serverAddrs[i].health
+			if best < 0 {
+				best = i
+			} else if b := &cfg.serverAddrs[best].health; health.checked &&
+				(!b.checked || health.latency < b.latency) {
+				best = i
+			}
+		}
+		cfg.sindex = best
+	case ss_SERVER_SELECT_RANDOM:
+		cfg.sindex = healthy[rand.Intn(len(healthy))]
+	default: /* 轮流，选择下一个可用的服务器 */
+		next := healthy[0]
+		for _, i := range healthy {
+			if i > cfg.sindex {
+				next = i
+				break
+			}
+		}
+		cfg.sindex = next
+	}
+	return &cfg.serverAddrs[cfg.sindex]
+}
+
+/* 服务器是否可用，没有检查过的服务器当作可用 */
+func (cfg *ssSAConfig) isHealthy(addr *ssServerAddress) bool {
+	cfg.Lock()
+	defer cfg.Unlock()
+	return addr.health.healthy || !addr.health.checked
+}
+
+/* 连接服务器失败，连续失败retry次之后标记为不可用，等待下次检查 */
+func (cfg *ssSAConfig) onHealthError(server string, port int) {
+	cfg.Lock()
+	defer cfg.Unlock()
+
+	for i := range cfg.serverAddrs {
+		addr := &cfg.serverAddrs[i]
+		if addr.serverAddr == server && addr.serverPort == port {
+			if addr.health.failures += 1; addr.health.failures >= cfg.retry {
+				addr.health.checked = true
+				addr.health.healthy = false
+				addr.health.err = "too many connection failures"
+			}
+			return
+		}
+	}
+}
@@ -80,8 +80,9 @@ func (req *ssAddressRequest) build() []byte {
 	ip := net.ParseIP(req.addr)
 	atype := ATYPE_DOMAIN
 	if ip != nil {
-		if len(ip) == 4 {
+		if ip4 := ip.To4(); ip4 != nil { /* ParseIP返回的IPv4地址也是16字节 */
 			atype = ATYPE_IPV4
+			ip = ip4
 		} else {
 			atype = ATYPE_IPV6
 		}
@@ -100,6 +101,25 @@ func (req *ssAddressRequest) build() []byte {
 	return buf.Bytes()
 }
 
+/*
+ * 返回连接请求的完整长度，用于判断连接请求是否接收完整，
+ * 数据不足以判断长度时返回0，地址类型错误时直接交给parse处理
+ */
+func addressRequestSize(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	switch data[0] {
+	case ATYPE_IPV4:
+		return 7
+	case ATYPE_DOMAIN:
+		return int(data[1]) + 4
+	case ATYPE_IPV6:
+		return 19
+	}
+	return len(data)
+}
+
 /* 解析连接请求 */
 func (req *ssAddressRequest) parse(data []byte) error {
 	if data == nil || len(data) < 7 {
@@ -18,12 +18,11 @@
 package shadowsocks
 
 import (
-	"bytes"
 	"fmt"
 	"math/rand"
 	. "skywalker/agent/base"
-	"skywalker/cipher"
 	"skywalker/pkg"
+	"skywalker/resolver"
 	"skywalker/util"
 	"strconv"
 	"strings"
@@ -37,14 +36,15 @@ import (
 type (
 	ShadowSocksServerAgent struct {
 		BaseAgent
-		cipherInfo *cipher.CipherInfo
-		encrypter  cipher.Encrypter
-		decrypter  cipher.Decrypter
-		key        []byte
-		iv         []byte
+		cipher  *ssCipher
+		encoder ssEncoder
+		decoder ssDecoder
 
+		server     *ssServerAddress
 		serverAddr string
 		serverPort int
+		first      int /* 最先选择的服务器，连接失败时从这里往后切换 */
+		skip       int /* 已经切换过的服务器个数 */
 		targetAddr string
 		targetPort int
 
@@ -59,6 +59,9 @@ type (
 		serverPort int
 		password   string
 		method     string
+
+		cipher *ssCipher
+		health ssServerHealth /* 健康状态，开启了健康检查时有效 */
 	}
 )
 
@@ -72,6 +75,9 @@ type ssSAConfig struct {
 	retry       int /* 每个服务器的重试次数，默认为3 */
 	sindex      int /* 当前选中的服务器 */
 	try         int /* 当前尝试次数 */
+
+	/* 健康检查 */
+	ssHealthChecker
 }
 
 const (
@@ -86,11 +92,17 @@ var (
 )
 
 /* 更改当前服务器 */
-func (a *ShadowSocksServerAgent) onServerError(server string) {
+func (a *ShadowSocksServerAgent) onServerError(server string, port int) {
 	cfg := a.cfg
+	if cfg.interval > 0 { /* 开启了健康检查，由健康状态决定选择哪个服务器 */
+		cfg.onHealthError(server, port)
+		return
+	}
 	if cfg.selection != ss_SERVER_SELECT_BETTER {
 		return
 	}
+	cfg.Lock()
+	defer cfg.Unlock()
 	addrSize := len(cfg.serverAddrs)
 	if addrSize > 0 && cfg.serverAddrs[cfg.sindex].serverAddr == server {
 		/* 出错次数过多就考虑更换服务器 */
@@ -105,62 +117,68 @@ func (a *ShadowSocksServerAgent) onServerError(server string) {
 
 /*
  * 返回当前服务的信息
- * 如果配置了多个会从多个中选择一个
+ * 如果配置了多个会从多个中选择一个，
+ * 选择状态和健康状态一样由cfg的锁保护
  */
-func (a *ShadowSocksServerAgent) getServerInfo() (string, int, string, string) {
-	var password, method, serverAddr string
-	var serverPort int
-
+func (a *ShadowSocksServerAgent) getServerInfo() *ssServerAddress {
 	cfg := a.cfg
-
 	addrSize := len(cfg.serverAddrs)
-	selection := cfg.selection
-	if addrSize > 0 { /*  配置了多个服务器 */
-		var addrinfo *ssServerAddress
-		if selection == ss_SERVER_SELECT_BETTER {
-			addrinfo = &cfg.serverAddrs[cfg.sindex]
-		} else if selection == ss_SERVER_SELECT_RANDOM { /* 随机选择服务器 */
-			addrinfo = &cfg.serverAddrs[rand.Intn(addrSize)]
-		} else { /* 轮流 */
-			if cfg.sindex += 1; cfg.sindex >= addrSize {
-				cfg.sindex = 0
-			}
-			addrinfo = &cfg.serverAddrs[cfg.sindex]
+	if addrSize == 0 { /*  选择唯一的服务器 */
+		return &cfg.ssServerAddress
+	}
+	if cfg.interval > 0 { /* 根据健康状态选择服务器 */
+		if addrinfo := cfg.selectHealthyServer(); addrinfo != nil {
+			return addrinfo
 		}
-		password = addrinfo.password
-		method = addrinfo.method
-		serverAddr = addrinfo.serverAddr
-		serverPort = addrinfo.serverPort
-	} else { /*  选择唯一的服务器 */
-		password = cfg.ssServerAddress.password
-		method = cfg.ssServerAddress.method
-		serverAddr = cfg.ssServerAddress.serverAddr
-		serverPort = cfg.ssServerAddress.serverPort
 	}
-	return serverAddr, serverPort, password, method
+	cfg.Lock()
+	defer cfg.Unlock()
+	if cfg.selection == ss_SERVER_SELECT_BETTER {
+		return &cfg.serverAddrs[cfg.sindex]
+	} else if cfg.selection == ss_SERVER_SELECT_RANDOM { /* 随机选择服务器 */
+		return &cfg.serverAddrs[rand.Intn(addrSize)]
+	}
+	/* 轮流 */
+	if cfg.sindex += 1; cfg.sindex >= addrSize {
+		cfg.sindex = 0
+	}
+	return &cfg.serverAddrs[cfg.sindex]
+}
+
+/* 切换到指定的服务器 */
+func (a *ShadowSocksServerAgent) useServer(addr *ssServerAddress) {
+	a.server = addr
+	a.serverAddr = addr.serverAddr
+	a.serverPort = addr.serverPort
+	a.cipher = addr.cipher
+	a.encoder = addr.cipher.newEncoder()
+	a.decoder = addr.cipher.newDecoder()
 }
 
 func (p *ShadowSocksServerAgent) Name() string {
 	return "ShadowSocks"
 }
 
-/*
- * 初始化读取配置，此方法全局调用一次，且
- * a参数在调用后会被立即释放
- */
-func (a *ShadowSocksServerAgent) OnInit(name string, cfg map[string]interface{}) error {
+/* 解析配置，不修改全局的配置，也不启动健康检查 */
+func parseSAConfig(cfg map[string]interface{}) (*ssSAConfig, error) {
 	var serverAddr, password, method, selection string
 	var serverPort int
 	var serverAddrs []ssServerAddress
 	var val interface{}
 	var retry int = 3
 	var ok bool
+	var err error
 
 	serverAddr = util.GetMapString(cfg, "serverAddr")
 	serverPort = util.GetMapInt(cfg, "serverPort")
 	password = util.GetMapString(cfg, "password")
 	method = util.GetMapStringDefault(cfg, "method", "aes-256-cfb")
 	selection = util.GetMapStringDefault(cfg, "select", ss_SERVER_SELECT_ROTATION)
+	checker := ssHealthChecker{interval: util.GetMapInt(cfg, "healthCheck")}
+	checker.probeHost, checker.probePort, err = parseProbe(util.GetMapString(cfg, "probe"))
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "invalid probe, %s", err)
+	}
 
 	val, ok = cfg["serverAddr[]"]
 	if ok == true {
@@ -168,7 +186,7 @@ func (a *ShadowSocksServerAgent) OnInit(name string, cfg map[string]interface{})
 		for _, e := range array {
 			m, ok := e.(map[string]interface{})
 			if m == nil || ok == false {
-				return Error(ERROR_INVALID_CONFIG, "serverAddr[] must be an object array")
+				return nil, Error(ERROR_INVALID_CONFIG, "serverAddr[] must be an object array")
 			}
 			addr := util.GetMapStringDefault(m, "serverAddr", serverAddr)
 			port := util.GetMapIntDefault(m, "serverPort", serverPort)
@@ -176,53 +194,120 @@ func (a *ShadowSocksServerAgent) OnInit(name string, cfg map[string]interface{})
 			method := util.GetMapStringDefault(m, "method", method)
 
 			if len(addr) == 0 || port <= 0 || len(password) == 0 || len(method) == 0 {
-				return Error(ERROR_INVALID_CONFIG, "invalid serverAddrs")
+				return nil, Error(ERROR_INVALID_CONFIG, "invalid serverAddrs")
+			}
+			c := newSSCipher(method, password)
+			if c == nil {
+				return nil, Error(ERROR_INVALID_CONFIG, "unknown cipher method %s", method)
 			}
 			saddr := ssServerAddress{
 				serverAddr: addr,
 				serverPort: port,
 				password:   password,
 				method:     method,
+				cipher:     c,
 			}
 			serverAddrs = append(serverAddrs, saddr)
-			go util.ResolveHost(addr)
 		}
 	} else if len(serverAddr) == 0 || serverPort <= 0 || len(password) == 0 || len(method) == 0 {
-		return Error(ERROR_INVALID_CONFIG, "invalid server config")
+		return nil, Error(ERROR_INVALID_CONFIG, "invalid server config")
+	}
+	var c *ssCipher
+	if len(serverAddrs) == 0 { /* 单个服务器 */
+		if c = newSSCipher(method, password); c == nil {
+			return nil, Error(ERROR_INVALID_CONFIG, "unknown cipher method %s", method)
+		}
 	}
 
-	gSAConfigs[name] = &ssSAConfig{
+	return &ssSAConfig{
 		ssServerAddress: ssServerAddress{
 			serverAddr: serverAddr,
 			serverPort: serverPort,
 			password:   password,
 			method:     method,
+			cipher:     c,
 		},
 		serverAddrs: serverAddrs,
 		selection:   selection,
 		retry:       retry,
 		sindex:      0,
 		try:         3,
+		ssHealthChecker: ssHealthChecker{
+			interval:  checker.interval,
+			probeHost: checker.probeHost,
+			probePort: checker.probePort,
+		},
+	}, nil
+}
+
+/*
+ * 初始化读取配置，此方法全局调用一次，且
+ * a参数在调用后会被立即释放
+ */
+func (a *ShadowSocksServerAgent) OnInit(name string, cfg map[string]interface{}) error {
+	saConfig, err := parseSAConfig(cfg)
+	if err != nil {
+		return err
+	}
+	for _, addr := range saConfig.serverAddrs {
+		go resolver.Get(name).ResolveHost(addr.serverAddr)
+	}
+
+	checked := false
+	if old := gSAConfigs[name]; old != nil { /* 重新加载配置，停止旧的健康检查，服务器没有改变时沿用健康状态 */
+		old.stopHealthCheck()
+		checked = saConfig.inheritHealth(old)
 	}
+	gSAConfigs[name] = saConfig
+	saConfig.startHealthCheck(name, checked)
 
 	return nil
 }
 
+/* 只检查配置，重新加载配置时使用 */
+func (*ShadowSocksServerAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseSAConfig(cfg)
+	return err
+}
+
+/* 代理被删除或者改用了其他SA，停止健康检查 */
+func (*ShadowSocksServerAgent) OnRelease(name string) {
+	if old := gSAConfigs[name]; old != nil {
+		old.stopHealthCheck()
+		delete(gSAConfigs, name)
+	}
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*ShadowSocksServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"serverAddr":  STRING_FIELD,
+		"serverPort":  INT_FIELD,
+		"password":    SECRET_FIELD,
+		"method":      STRING_FIELD,
+		"select":      STRING_FIELD,
+		"healthCheck": INT_FIELD,
+		"probe":       STRING_FIELD,
+		"serverAddr[]": ListField(MapField(ConfigSchema{
+			"serverAddr": STRING_FIELD,
+			"serverPort": INT_FIELD,
+			"password":   SECRET_FIELD,
+			"method":     STRING_FIELD,
+		})),
+	}
+}
+
 /* 初始化读取配置 */
 func (a *ShadowSocksServerAgent) OnStart() error {
 	a.cfg = gSAConfigs[a.BaseAgent.Name]
-	serverAddr, serverPort, password, method := a.getServerInfo()
-	info := cipher.GetCipherInfo(strings.ToLower(method))
-	key := generateKey([]byte(password), info.KeySize)
-	iv := generateIV(info.IvSize)
-
-	a.cipherInfo = info
-	a.serverAddr = serverAddr
-	a.serverPort = serverPort
-	a.encrypter = info.EncrypterFunc(key, iv)
-	a.decrypter = nil
-	a.key = key
-	a.iv = iv
+	a.useServer(a.getServerInfo())
+	a.first = 0
+	a.skip = 0
+	for i := range a.cfg.serverAddrs {
+		if &a.cfg.serverAddrs[i] == a.server {
+			a.first = i
+		}
+	}
 	a.connected = false
 	return nil
 }
@@ -234,49 +319,95 @@ func (a *ShadowSocksServerAgent) GetRemoteAddress(addr string, port int) (string
 	return a.serverAddr, a.serverPort
 }
 
+/*
+ * 连接服务器失败，从最先选择的服务器往后切换到下一个服务器，
+ * 开启了健康检查时跳过不可用的服务器
+ */
+func (a *ShadowSocksServerAgent) NextRemoteAddress(host string, port int, result int) (string, int) {
+	a.onServerError(host, port)
+	cfg := a.cfg
+	addrSize := len(cfg.serverAddrs)
+	for a.skip+1 < addrSize {
+		a.skip += 1
+		addr := &cfg.serverAddrs[(a.first+a.skip)%addrSize]
+		if cfg.interval > 0 && !cfg.isHealthy(addr) {
+			continue
+		}
+		a.useServer(addr)
+		return a.serverAddr, a.serverPort
+	}
+	return "", 0
+}
+
 func (a *ShadowSocksServerAgent) OnConnectResult(result int, host string, p int) (interface{}, interface{}, error) {
 	if result == pkg.CONNECT_RESULT_OK {
 		req := &ssAddressRequest{addr: a.targetAddr, port: uint16(a.targetPort)}
-		plain := req.build()
-		buf := bytes.Buffer{}
-		if a.iv != nil {
-			buf.Write(a.iv)
-		}
-		buf.Write(a.encrypter.Encrypt(plain))
-		return nil, buf.Bytes(), nil
+		return nil, a.encoder.encode(req.build()), nil
 	}
 	/* 出错 */
-	a.onServerError(a.serverAddr)
+	a.onServerError(a.serverAddr, a.serverPort)
 	return nil, nil, nil
 }
 
 func (a *ShadowSocksServerAgent) ReadFromServer(data []byte) (interface{}, interface{}, error) {
-	if a.decrypter == nil {
-		ivSize := a.cipherInfo.IvSize
-		if ivSize > 0 {
-			if len(data) < ivSize {
-				return nil, nil, Error(ERROR_INVALID_PACKAGE, "invalid package")
-			}
-			iv := data[:ivSize]
-			data = data[ivSize:]
-			a.decrypter = a.cipherInfo.DecrypterFunc(a.key, iv)
-		} else {
-			a.decrypter = a.cipherInfo.DecrypterFunc(a.key, nil)
-		}
-		a.connected = true
+	data, err := a.decoder.decode(data)
+	if err != nil {
+		return nil, nil, err
 	}
-	return a.decrypter.Decrypt(data), nil, nil
+	a.connected = true
+	if len(data) == 0 {
+		return nil, nil, nil
+	}
+	return data, nil, nil
 }
 
 func (a *ShadowSocksServerAgent) ReadFromCA(data []byte) (interface{}, interface{}, error) {
-	return nil, a.encrypter.Encrypt(data), nil
+	return nil, a.encoder.encode(data), nil
+}
+
+/* UDP数据包都发送给ss服务器，数据包中包含目标地址 */
+func (a *ShadowSocksServerAgent) RecvFromServer(host string, port int, data []byte) (interface{}, interface{}, error) {
+	plain, err := a.cipher.decryptPacket(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req := &ssAddressRequest{}
+	if err := req.parse(plain); err != nil {
+		return nil, nil, err
+	}
+	a.connected = true
+	return pkg.NewUDPDataPackage(req.addr, int(req.port), req.left), nil, nil
+}
+
+func (a *ShadowSocksServerAgent) RecvFromCA(host string, port int, data []byte) (interface{}, interface{}, error) {
+	req := &ssAddressRequest{addr: host, port: uint16(port)}
+	return nil, a.cipher.encryptPacket(append(req.build(), data...)), nil
 }
 
 func (a *ShadowSocksServerAgent) OnClose(closed_by_client bool) {
 	if !closed_by_client && !a.connected { /* 没有建立链接就断开，且不是客户端断开的 */
 		a.WARN("Connection Closed Unexpectedly")
-		a.onServerError(a.serverAddr)
+		a.onServerError(a.serverAddr, a.serverPort)
+	}
+}
+
+/* 多服务器时返回每个服务器的健康状态 */
+func (a *ShadowSocksServerAgent) GetUpstreams() []UpstreamHealth {
+	defer a.cfg.Unlock()
+	a.cfg.Lock()
+	var upstreams []UpstreamHealth
+	for _, addr := range a.cfg.serverAddrs {
+		upstream := UpstreamHealth{
+			Addr:    util.JoinHostPort(addr.serverAddr, addr.serverPort),
+			Checked: addr.health.checked,
+			Healthy: addr.health.healthy || !addr.health.checked,
+		}
+		if addr.health.healthy {
+			upstream.Latency = addr.health.latency
+		}
+		upstreams = append(upstreams, upstream)
 	}
+	return upstreams
 }
 
 func (a *ShadowSocksServerAgent) GetInfo() []map[string]string {
@@ -288,7 +419,7 @@ func (a *ShadowSocksServerAgent) GetInfo() []map[string]string {
 		return "[" + strings.Join(s, ", ") + "]"
 	}
 	if len(a.cfg.serverAddrs) > 0 {
-		return []map[string]string{
+		info := []map[string]string{
 			map[string]string{
 				"key":   "serverAddrs",
 				"value": formatServerAddrs(a.cfg.serverAddrs),
@@ -298,6 +429,17 @@ func (a *ShadowSocksServerAgent) GetInfo() []map[string]string {
 				"value": a.cfg.selection,
 			},
 		}
+		if a.cfg.interval > 0 {
+			a.cfg.Lock()
+			for _, addr := range a.cfg.serverAddrs {
+				info = append(info, map[string]string{
+					"key":   fmt.Sprintf("health %s:%d", addr.serverAddr, addr.serverPort),
+					"value": addr.health.String(),
+				})
+			}
+			a.cfg.Unlock()
+		}
+		return info
 	}
 	return []map[string]string{
 		map[string]string{
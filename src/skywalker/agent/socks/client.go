@@ -34,6 +34,7 @@ type (
 		BaseAgent
 		version uint8
 
+		cmd   uint8
 		atype uint8
 		addr  string
 		port  uint16
@@ -81,29 +82,76 @@ func (a *SocksClientAgent) OnInit(name string, cfg map[string]interface{}) error
 	return nil
 }
 
+/* 支持的配置项，用于检查配置 */
+func (*SocksClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"version":  INT_FIELD,
+		"username": SECRET_FIELD,
+		"password": SECRET_FIELD,
+	}
+}
+
 func (a *SocksClientAgent) OnStart() error {
 	a.state = STATE_INIT
 	a.cfg = gCAConfigs[a.BaseAgent.Name]
 	return nil
 }
 
-/* socks5连接结果的处理 */
-func (a *SocksClientAgent) onConnectResult5(result int, host string, port int) (interface{}, interface{}, error) {
-	var reply uint8 = REPLY_GENERAL_FAILURE
+/* 连接结果对应的socks5应答 */
+func getReply5(result int) uint8 {
 	if result == pkg.CONNECT_RESULT_OK {
-		reply = REPLY_SUCCEED
+		return REPLY_SUCCEED
 	} else if result == pkg.CONNECT_RESULT_UNKNOWN_HOST {
-		reply = REPLY_HOST_UNREACHABLE
+		return REPLY_HOST_UNREACHABLE
 	} else if result == pkg.CONNECT_RESULT_UNREACHABLE {
-		reply = REPLY_NETWORK_UNREACHABLE
+		return REPLY_NETWORK_UNREACHABLE
 	}
+	return REPLY_GENERAL_FAILURE
+}
+
+/* socks5连接结果的处理 */
+func (a *SocksClientAgent) onConnectResult5(result int, host string, port int) (interface{}, interface{}, error) {
 	rep := socks5Response{
 		version: a.version,
-		reply:   reply,
+		reply:   getReply5(result),
 		atype:   a.atype,
 		addr:    a.addr,
 		port:    a.port,
 	}
+	if a.cmd == CMD_BIND { /* BIND的第二个应答，返回连接过来的远程服务器地址 */
+		rep.atype = getAddressType(host)
+		rep.addr = host
+		rep.port = uint16(port)
+	} else if a.cmd == CMD_UDP_ASSOCIATE { /* 返回为客户端分配的UDP转发地址 */
+		rep.atype = getAddressType(host)
+		rep.addr = host
+		rep.port = uint16(port)
+	}
+	return nil, rep.build(), nil
+}
+
+/* BIND的第一个应答，返回监听的地址 */
+func (a *SocksClientAgent) OnBindResult(result int, host string, port int) (interface{}, interface{}, error) {
+	if a.version == SOCKS_VERSION_4 {
+		var cd uint8 = CD_REQUEST_REJECTED
+		if result == pkg.CONNECT_RESULT_OK {
+			cd = CD_REQUEST_GRANTED
+		}
+		rep := socks4Response{
+			vn:   0,
+			cd:   cd,
+			ip:   host,
+			port: uint16(port),
+		}
+		return nil, rep.build(), nil
+	}
+	rep := socks5Response{
+		version: a.version,
+		reply:   getReply5(result),
+		atype:   getAddressType(host),
+		addr:    host,
+		port:    uint16(port),
+	}
 	return nil, rep.build(), nil
 }
 
@@ -119,6 +167,10 @@ func (a *SocksClientAgent) onConnectResult4(result int, host string, port int) (
 		ip:   a.addr,
 		port: a.port,
 	}
+	if a.cmd == CMD_BIND { /* BIND的第二个应答，返回连接过来的远程服务器地址 */
+		rep.ip = host
+		rep.port = uint16(port)
+	}
 	return nil, rep.build(), nil
 }
 
@@ -137,14 +189,18 @@ func (a *SocksClientAgent) init4(data []byte) (interface{}, interface{}, error)
 	req := &socks4Request{}
 	if err := req.parse(data); err != nil {
 		return nil, nil, err
-	} else if req.cd != CMD_CONNECT {
+	} else if req.cd != CMD_CONNECT && req.cd != CMD_BIND {
 		return nil, nil, Error(ERROR_UNSUPPORTED_CMD, "unsupported socks4 command %d", req.cd)
 	}
 	a.version = req.vn
+	a.cmd = req.cd
 	a.atype = ATYPE_IPV4 /* socks4 只支持IPv4 */
 	a.addr = req.ip
 	a.port = req.port
 	a.state = STATE_TUNNEL
+	if req.cd == CMD_BIND {
+		return pkg.NewBindPackage(req.ip, int(req.port)), nil, nil
+	}
 	return pkg.NewConnectPackage(req.ip, int(req.port)), nil, nil
 }
 
@@ -211,13 +267,21 @@ func (a *SocksClientAgent) ReadFromClient(data []byte) (interface{}, interface{}
 			return nil, nil, err
 		} else if req.version != a.version {
 			return nil, nil, Error(ERROR_UNSUPPORTED_VERSION, "unsupported protocol version %d", req.version)
-		} else if req.cmd != CMD_CONNECT {
+		} else if req.cmd != CMD_CONNECT && req.cmd != CMD_BIND && req.cmd != CMD_UDP_ASSOCIATE {
 			return nil, nil, Error(ERROR_UNSUPPORTED_CMD, "unsupported protocol command %d", req.cmd)
 		}
+		a.cmd = req.cmd
 		a.atype = req.atype
 		a.addr = req.addr
 		a.port = req.port
 		a.state = STATE_TUNNEL
+		if req.cmd == CMD_UDP_ASSOCIATE {
+			/* 请求中的地址是客户端发送UDP数据包的地址 */
+			return pkg.NewUDPAssociatePackage(req.addr, int(req.port)), nil, nil
+		} else if req.cmd == CMD_BIND {
+			/* 请求中的地址是预期连接过来的远程服务器地址 */
+			return pkg.NewBindPackage(req.addr, int(req.port)), nil, nil
+		}
 		return pkg.NewConnectPackage(req.addr, int(req.port)), nil, nil
 	case STATE_TUNNEL: /* 直接转发数据 */
 		return data, nil, nil
@@ -229,6 +293,31 @@ func (a *SocksClientAgent) ReadFromSA(data []byte) (interface{}, interface{}, er
 	return nil, data, nil
 }
 
+/* UDP转发只能通过UDP ASSOCIATE命令建立 */
+func (a *SocksClientAgent) AssociateOnly() bool {
+	return true
+}
+
+/* 从客户端接收到UDP数据包，不支持分片，分片的数据包直接丢弃 */
+func (a *SocksClientAgent) RecvFromClient(data []byte) (interface{}, interface{}, error) {
+	req, err := ParseSocks5UDPRequest(data)
+	if err != nil {
+		return nil, nil, err
+	} else if req.frag != 0 {
+		return nil, nil, nil
+	}
+	return pkg.NewUDPDataPackage(req.GetAddr(), int(req.GetPort()), req.GetData()), nil, nil
+}
+
+/* 从SA接收到UDP数据，加上socks5的UDP头部返回给客户端 */
+func (a *SocksClientAgent) RecvFromSA(host string, port int, data []byte) (interface{}, interface{}, error) {
+	rdata, err := CreateSocks5UDPRequest(host, port, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, rdata, nil
+}
+
 func (a *SocksClientAgent) GetInfo() []map[string]string {
 	return []map[string]string{
 		map[string]string{
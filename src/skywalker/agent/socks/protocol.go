@@ -88,8 +88,31 @@ const (
 	STATE_CONNECT = 2 /* 等待客户端发送链接请求 */
 	STATE_TUNNEL  = 3 /* 转发数据 */
 	STATE_ERROR   = 4 /* 已经出错 */
+	STATE_BIND    = 5 /* 等待BIND的第二个应答 */
 )
 
+/* 根据地址获取地址类型 */
+func getAddressType(addr string) uint8 {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ATYPE_DOMAIN
+	} else if ip.To4() != nil {
+		return ATYPE_IPV4
+	}
+	return ATYPE_IPV6
+}
+
+/* IP地址的二进制形式，IPv4地址是4个字节 */
+func ipBytes(atype uint8, addr string) []byte {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	} else if atype == ATYPE_IPV4 {
+		return []byte(ip.To4())
+	}
+	return []byte(ip.To16())
+}
+
 /*
  * http://ftp.icm.edu.pl/packages/socks/socks4/SOCKS4.protocol
  * +----+----+----+----+----+----+----+----+----+----+....+----+
@@ -368,11 +391,11 @@ func (req *socks5Request) build() []byte {
 		binary.Write(&buf, binary.BigEndian, uint8(len(req.addr)))
 		binary.Write(&buf, binary.BigEndian, []byte(req.addr))
 	} else {
-		ip := net.ParseIP(req.addr)
+		ip := ipBytes(req.atype, req.addr)
 		if ip == nil {
 			return nil
 		}
-		binary.Write(&buf, binary.BigEndian, []byte(ip))
+		binary.Write(&buf, binary.BigEndian, ip)
 	}
 	binary.Write(&buf, binary.BigEndian, req.port)
 	return buf.Bytes()
@@ -444,7 +467,7 @@ func (rep *socks5Response) build() []byte {
 	binary.Write(&buf, binary.BigEndian, uint8(0))
 	binary.Write(&buf, binary.BigEndian, rep.atype)
 	if rep.atype == ATYPE_IPV4 || rep.atype == ATYPE_IPV6 {
-		binary.Write(&buf, binary.BigEndian, []byte(net.ParseIP(rep.addr)))
+		binary.Write(&buf, binary.BigEndian, ipBytes(rep.atype, rep.addr))
 	} else {
 		binary.Write(&buf, binary.BigEndian, uint8(len(rep.addr)))
 		binary.Write(&buf, binary.BigEndian, []byte(rep.addr))
@@ -453,6 +476,23 @@ func (rep *socks5Response) build() []byte {
 	return buf.Bytes()
 }
 
+/* socks5应答的长度，数据不完整时返回0 */
+func socks5ResponseSize(data []byte) int {
+	if len(data) < 5 {
+		return 0
+	}
+	size := 7 + int(data[4])
+	if data[3] == ATYPE_IPV4 {
+		size = 10
+	} else if data[3] == ATYPE_IPV6 {
+		size = 22
+	}
+	if len(data) < size {
+		return 0
+	}
+	return size
+}
+
 func (rep *socks5Response) parse(data []byte) error {
 	if len(data) < 10 {
 		return Error(ERROR_INVALID_MESSAGE_SIZE, "address reply message is too short")
@@ -579,23 +619,14 @@ func (req *socks5UDPRequest) parse(data []byte) error {
 }
 
 func (req *socks5UDPRequest) build() []byte {
-	ip := net.ParseIP(req.addr)
-	atype := ATYPE_DOMAIN
-	if ip != nil {
-		if len(ip) == 4 {
-			atype = ATYPE_IPV4
-		} else {
-			atype = ATYPE_IPV6
-		}
-	}
-	req.atype = atype
+	req.atype = getAddressType(req.addr)
 
 	buf := bytes.Buffer{}
 	binary.Write(&buf, binary.BigEndian, uint16(0))
 	binary.Write(&buf, binary.BigEndian, req.frag)
 	binary.Write(&buf, binary.BigEndian, req.atype)
 	if req.atype == ATYPE_IPV4 || req.atype == ATYPE_IPV6 {
-		binary.Write(&buf, binary.BigEndian, []byte(ip))
+		binary.Write(&buf, binary.BigEndian, ipBytes(req.atype, req.addr))
 	} else {
 		binary.Write(&buf, binary.BigEndian, uint8(len(req.addr)))
 		binary.Write(&buf, binary.BigEndian, []byte(req.addr))
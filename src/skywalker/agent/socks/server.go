@@ -21,33 +21,47 @@ import (
 	"net"
 	. "skywalker/agent/base"
 	"skywalker/pkg"
+	"skywalker/resolver"
 	"skywalker/util"
+	"strings"
 )
 
 type (
 	SocksServerAgent struct {
 		BaseAgent
 
+		cmd   uint8
 		atype uint8
 		addr  string
 		port  uint16
 
 		state uint8
 
-		buf [][]byte
+		buf  [][]byte
+		rbuf []byte /* 不完整的应答 */
+
+		server *socksServerAddress
+		index  int /* 当前服务器在serverAddrs中的位置 */
 
 		cfg *socksSAConfig
 	}
 
-	socksSAConfig struct {
+	socksServerAddress struct {
 		serverAddr string
 		serverPort int
 		username   string
 		password   string
-		version    uint8
 
 		methods []byte
 	}
+
+	socksSAConfig struct {
+		socksServerAddress
+		version uint8
+
+		/* 多服务器设置，按顺序使用，连接失败时切换到下一个 */
+		serverAddrs []socksServerAddress
+	}
 )
 
 var (
@@ -63,44 +77,112 @@ func (a *SocksServerAgent) Name() string {
 	return "socks"
 }
 
-/* 初始化，读取配置 */
-func (a *SocksServerAgent) OnInit(name string, cfg map[string]interface{}) error {
-	var serverAddr string
-	var serverPort int
-
-	if serverAddr = util.GetMapString(cfg, "serverAddr"); len(serverAddr) == 0 {
-		return Error(ERROR_INVALID_CONFIG, "serverAddr not found")
+/* 认证方式，设置了用户名和密码时支持用户名/密码认证 */
+func getMethods(username, password string) []byte {
+	methods := []byte{METHOD_NO_AUTH_REQUIRED}
+	if len(username) > 0 && len(password) > 0 {
+		methods = append(methods, METHOD_USERNAME_PASSWORD)
 	}
+	return methods
+}
 
-	if serverPort = int(util.GetMapInt(cfg, "serverPort")); serverPort < 0 {
-		return Error(ERROR_INVALID_CONFIG, "serverPort is illegal")
-	}
+/* 解析配置，不修改全局的配置 */
+func parseSAConfig(cfg map[string]interface{}) (*socksSAConfig, error) {
+	var serverAddrs []socksServerAddress
+
+	serverAddr := util.GetMapString(cfg, "serverAddr")
+	serverPort := util.GetMapInt(cfg, "serverPort")
 	username := util.GetMapString(cfg, "username")
 	password := util.GetMapString(cfg, "password")
 	version := uint8(util.GetMapIntDefault(cfg, "version", SOCKS_VERSION_5))
 
 	if version != SOCKS_VERSION_4 && version != SOCKS_VERSION_5 {
-		return Error(ERROR_UNSUPPORTED_VERSION, "supported socks version %d", version)
+		return nil, Error(ERROR_UNSUPPORTED_VERSION, "supported socks version %d", version)
 	}
-	methods := []byte{METHOD_NO_AUTH_REQUIRED}
-	if len(username) > 0 && len(password) > 0 {
-		methods = append(methods, METHOD_USERNAME_PASSWORD)
+	if val, ok := cfg["serverAddr[]"]; ok {
+		array, _ := val.([]interface{})
+		for _, e := range array {
+			m, ok := e.(map[string]interface{})
+			if m == nil || ok == false {
+				return nil, Error(ERROR_INVALID_CONFIG, "serverAddr[] must be an object array")
+			}
+			saddr := socksServerAddress{
+				serverAddr: util.GetMapStringDefault(m, "serverAddr", serverAddr),
+				serverPort: util.GetMapIntDefault(m, "serverPort", serverPort),
+				username:   util.GetMapStringDefault(m, "username", username),
+				password:   util.GetMapStringDefault(m, "password", password),
+			}
+			if len(saddr.serverAddr) == 0 || saddr.serverPort <= 0 {
+				return nil, Error(ERROR_INVALID_CONFIG, "invalid serverAddrs")
+			}
+			saddr.methods = getMethods(saddr.username, saddr.password)
+			serverAddrs = append(serverAddrs, saddr)
+		}
+	} else if len(serverAddr) == 0 {
+		return nil, Error(ERROR_INVALID_CONFIG, "serverAddr not found")
+	} else if serverPort < 0 {
+		return nil, Error(ERROR_INVALID_CONFIG, "serverPort is illegal")
+	}
+	return &socksSAConfig{
+		socksServerAddress: socksServerAddress{
+			serverAddr: serverAddr,
+			serverPort: serverPort,
+			username:   username,
+			password:   password,
+			methods:    getMethods(username, password),
+		},
+		version:     version,
+		serverAddrs: serverAddrs,
+	}, nil
+}
+
+/* 初始化，读取配置 */
+func (a *SocksServerAgent) OnInit(name string, cfg map[string]interface{}) error {
+	saConfig, err := parseSAConfig(cfg)
+	if err != nil {
+		return err
 	}
-	gSAConfig[name] = &socksSAConfig{
-		serverAddr: serverAddr,
-		serverPort: serverPort,
-		username:   username,
-		password:   password,
-		version:    version,
-		methods:    methods,
+	for _, addr := range saConfig.serverAddrs {
+		go resolver.Get(name).ResolveHost(addr.serverAddr)
 	}
+	gSAConfig[name] = saConfig
 	return nil
 }
 
+/* 只检查配置，重新加载配置时使用 */
+func (*SocksServerAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseSAConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*SocksServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"serverAddr": STRING_FIELD,
+		"serverPort": INT_FIELD,
+		"username":   SECRET_FIELD,
+		"password":   SECRET_FIELD,
+		"version":    INT_FIELD,
+		"serverAddr[]": ListField(MapField(ConfigSchema{
+			"serverAddr": STRING_FIELD,
+			"serverPort": INT_FIELD,
+			"username":   SECRET_FIELD,
+			"password":   SECRET_FIELD,
+		})),
+	}
+}
+
 func (a *SocksServerAgent) OnStart() error {
 	a.cfg = gSAConfig[a.BaseAgent.Name]
+	a.cmd = CMD_CONNECT
 	a.state = STATE_INIT
 	a.buf = nil
+	a.rbuf = nil
+	a.index = 0
+	a.server = &a.cfg.socksServerAddress
+	if len(a.cfg.serverAddrs) > 0 {
+		a.server = &a.cfg.serverAddrs[0]
+	}
 	return nil
 }
 
@@ -112,25 +194,40 @@ func (a *SocksServerAgent) buffer() [][]byte {
 }
 
 func (a *SocksServerAgent) GetRemoteAddress(addr string, port int) (string, int) {
+	/* CA要求重新连接时SA会被重用，握手从头开始 */
+	a.cmd = CMD_CONNECT
+	a.state = STATE_INIT
+	a.buf = nil
+	a.rbuf = nil
 	a.addr = addr
 	a.port = uint16(port)
-	ip := net.ParseIP(addr)
-	if ip == nil {
-		a.atype = ATYPE_DOMAIN
-	} else if len(ip) == 4 {
-		a.atype = ATYPE_IPV4
-	} else {
-		a.atype = ATYPE_IPV6
+	a.atype = getAddressType(addr)
+	return a.server.serverAddr, a.server.serverPort
+}
+
+/* 连接上游服务器失败，按顺序切换到下一个服务器 */
+func (a *SocksServerAgent) NextRemoteAddress(host string, port int, result int) (string, int) {
+	if a.index+1 >= len(a.cfg.serverAddrs) {
+		return "", 0
 	}
-	return a.cfg.serverAddr, a.cfg.serverPort
+	a.index += 1
+	a.server = &a.cfg.serverAddrs[a.index]
+	return a.server.serverAddr, a.server.serverPort
+}
+
+/* 通过上游服务器监听，参数是预期连接过来的远程服务器地址 */
+func (a *SocksServerAgent) GetBindAddress(addr string, port int) (string, int) {
+	a.GetRemoteAddress(addr, port)
+	a.cmd = CMD_BIND
+	return a.server.serverAddr, a.server.serverPort
 }
 
 func (a *SocksServerAgent) onConnectResult5(result int, host string, port int) (interface{}, interface{}, error) {
 	if result == pkg.CONNECT_RESULT_OK {
 		req := &socks5VersionRequest{
 			version:  a.cfg.version,
-			nmethods: uint8(len(a.cfg.methods)),
-			methods:  a.cfg.methods,
+			nmethods: uint8(len(a.server.methods)),
+			methods:  a.server.methods,
 		}
 		return nil, req.build(), nil
 	} else {
@@ -142,7 +239,7 @@ func (a *SocksServerAgent) onConnectResult4(result int, host string, port int) (
 	if result == pkg.CONNECT_RESULT_OK {
 		req := &socks4Request{
 			vn:   a.cfg.version,
-			cd:   CD_CONNECT,
+			cd:   a.cmd,
 			port: a.port,
 			ip:   a.addr,
 		}
@@ -170,15 +267,15 @@ func (a *SocksServerAgent) init5(data []byte) (interface{}, interface{}, error)
 		a.state = STATE_CONNECT
 		req := &socks5Request{
 			version: a.cfg.version,
-			cmd:     CMD_CONNECT,
+			cmd:     a.cmd,
 			atype:   a.atype,
 			addr:    a.addr,
 			port:    a.port,
 		}
 		return nil, req.build(), nil
 	} else if rep.method == METHOD_USERNAME_PASSWORD {
-		username := a.cfg.username
-		password := a.cfg.password
+		username := a.server.username
+		password := a.server.password
 		if len(username) == 0 || len(password) == 0 {
 			return nil, nil, Error(ERROR_UNSUPPORTED_METHOD, "username password required!")
 		}
@@ -195,20 +292,83 @@ func (a *SocksServerAgent) init5(data []byte) (interface{}, interface{}, error)
 	return nil, nil, Error(ERROR_UNSUPPORTED_METHOD, "socks5 auth method not allowed")
 }
 
-func (a *SocksServerAgent) init4(data []byte) (interface{}, interface{}, error) {
-	rep := socks4Response{}
-	if err := rep.parse(data); err != nil {
-		return nil, nil, err
-	} else if rep.vn != 0 {
-		return nil, nil, Error(ERROR_UNSUPPORTED_VERSION, "unsupported protocol version %d", rep.vn)
-	} else if rep.cd != CD_REQUEST_GRANTED {
-		return nil, nil, Error(ERROR_INVALID_REPLY, "sock reply code %d", rep.cd)
+/*
+ * 解析连接应答
+ * 返回应答的长度，是否成功，以及应答中的地址；应答不完整时长度为0并且没有错误
+ */
+func (a *SocksServerAgent) parseResponse(data []byte) (int, bool, string, int, error) {
+	if a.cfg.version == SOCKS_VERSION_4 {
+		rep := socks4Response{}
+		if len(data) < 8 {
+			return 0, false, "", 0, nil
+		} else if err := rep.parse(data[:8]); err != nil {
+			return 0, false, "", 0, err
+		} else if rep.vn != 0 {
+			return 0, false, "", 0, Error(ERROR_UNSUPPORTED_VERSION, "unsupported protocol version %d", rep.vn)
+		} else if rep.cd != CD_REQUEST_GRANTED {
+			return 8, false, "", 0, Error(ERROR_INVALID_REPLY, "sock reply code %d", rep.cd)
+		}
+		return 8, true, rep.ip, int(rep.port), nil
 	}
-	a.state = STATE_TUNNEL
-	if a.buf == nil {
-		return nil, nil, nil
+	rep := &socks5Response{}
+	size := socks5ResponseSize(data)
+	if size == 0 {
+		return 0, false, "", 0, nil
+	} else if err := rep.parse(data[:size]); err != nil {
+		return 0, false, "", 0, err
+	} else if rep.reply != REPLY_SUCCEED {
+		return size, false, "", 0, Error(ERROR_INVALID_REPLY, "socks5 connect reply %d", rep.reply)
+	} else if rep.version != a.cfg.version {
+		return 0, false, "", 0, Error(ERROR_UNSUPPORTED_VERSION, "unsupported protocol version %d", rep.version)
+	}
+	return size, true, rep.addr, int(rep.port), nil
+}
+
+/*
+ * 处理连接应答，应答之后可能紧跟着服务器的数据
+ * BIND命令有两个应答，第一个是上游服务器监听的地址，第二个是连接过来的远程服务器地址
+ */
+func (a *SocksServerAgent) readResponse(data []byte) (interface{}, interface{}, error) {
+	var packages []*pkg.Package
+	if len(a.rbuf) > 0 {
+		data = append(a.rbuf, data...)
+		a.rbuf = nil
+	}
+	for a.state != STATE_TUNNEL {
+		size, ok, addr, port, err := a.parseResponse(data)
+		if size == 0 && err == nil {
+			/* 应答可能被拆分到多个TCP分段中（比如域名地址），等待下一次读取 */
+			a.rbuf = append([]byte(nil), data...)
+			return packages, nil, nil
+		} else if !ok {
+			if a.cmd == CMD_BIND && a.state == STATE_BIND {
+				packages = append(packages, pkg.NewConnectResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, a.addr, int(a.port)))
+			} else if a.cmd == CMD_BIND {
+				packages = append(packages, pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, a.addr, int(a.port)))
+			}
+			return packages, nil, err
+		}
+		data = data[size:]
+		if a.cmd == CMD_BIND && a.state != STATE_BIND {
+			/* 上游服务器没有指定监听的IP时，使用上游服务器的地址 */
+			if ip := net.ParseIP(addr); ip != nil && ip.IsUnspecified() {
+				addr = a.server.serverAddr
+			}
+			a.state = STATE_BIND
+			packages = append(packages, pkg.NewBindResultPackage(pkg.CONNECT_RESULT_OK, addr, port))
+			if len(data) == 0 {
+				return packages, nil, nil
+			}
+			continue
+		} else if a.cmd == CMD_BIND {
+			packages = append(packages, pkg.NewConnectResultPackage(pkg.CONNECT_RESULT_OK, addr, port))
+		}
+		a.state = STATE_TUNNEL
 	}
-	return nil, a.buffer(), nil
+	if len(data) > 0 {
+		packages = append(packages, pkg.NewDataPackage(data))
+	}
+	return packages, a.buffer(), nil
 }
 
 func (a *SocksServerAgent) ReadFromServer(data []byte) (interface{}, interface{}, error) {
@@ -217,22 +377,10 @@ func (a *SocksServerAgent) ReadFromServer(data []byte) (interface{}, interface{}
 		if a.cfg.version == SOCKS_VERSION_5 {
 			return a.init5(data)
 		} else if a.cfg.version == SOCKS_VERSION_4 {
-			return a.init4(data)
-		}
-	case STATE_CONNECT:
-		rep := &socks5Response{}
-		if err := rep.parse(data); err != nil {
-			return nil, nil, err
-		} else if rep.reply != REPLY_SUCCEED {
-			return nil, nil, Error(ERROR_INVALID_REPLY, "socks5 connect reply %d", rep.reply)
-		} else if rep.version != a.cfg.version {
-			return nil, nil, Error(ERROR_UNSUPPORTED_VERSION, "unsupported protocol version %d", rep.version)
-		}
-		a.state = STATE_TUNNEL
-		if a.buf == nil {
-			return nil, nil, nil
+			return a.readResponse(data)
 		}
-		return nil, a.buffer(), nil
+	case STATE_CONNECT, STATE_BIND:
+		return a.readResponse(data)
 	case STATE_AUTH:
 		rep := &socks5AuthResponse{}
 		if err := rep.parse(data); err != nil {
@@ -243,7 +391,7 @@ func (a *SocksServerAgent) ReadFromServer(data []byte) (interface{}, interface{}
 		a.state = STATE_CONNECT
 		req := &socks5Request{
 			version: a.cfg.version,
-			cmd:     CMD_CONNECT,
+			cmd:     a.cmd,
 			atype:   a.atype,
 			addr:    a.addr,
 			port:    a.port,
@@ -268,5 +416,17 @@ func (a *SocksServerAgent) OnClose(bool) {
 }
 
 func (a *SocksServerAgent) GetInfo() []map[string]string {
-	return nil
+	if len(a.cfg.serverAddrs) == 0 {
+		return nil
+	}
+	var s []string
+	for _, addr := range a.cfg.serverAddrs {
+		s = append(s, util.JoinHostPort(addr.serverAddr, addr.serverPort))
+	}
+	return []map[string]string{
+		map[string]string{
+			"key":   "serverAddrs",
+			"value": "[" + strings.Join(s, ", ") + "]",
+		},
+	}
 }
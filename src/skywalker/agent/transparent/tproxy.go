@@ -0,0 +1,97 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package transparent
+
+import (
+	"net"
+	. "skywalker/agent/base"
+	"skywalker/pkg"
+)
+
+/*
+ * TPROXY透明代理
+ * 客户端的TCP连接和UDP数据包被iptables TPROXY转发到代理端口，
+ * 代理以IP_TRANSPARENT监听，连接的本地地址就是原始目标地址，
+ * UDP数据包的原始目标地址由代理从IP_RECVORIGDSTADDR中读取，
+ * 回复的数据包以原始目标地址为源地址发送给客户端
+ */
+type TProxyAgent struct {
+	BaseAgent
+	host string /* UDP会话的目标地址 */
+	port int
+}
+
+func (a *TProxyAgent) Name() string {
+	return "tproxy"
+}
+
+func (a *TProxyAgent) OnInit(name string, cfg map[string]interface{}) error {
+	return nil
+}
+
+/* 没有配置项 */
+func (*TProxyAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
+func (a *TProxyAgent) OnStart() error {
+	return nil
+}
+
+func (a *TProxyAgent) TProxy() bool {
+	return true
+}
+
+/*
+ * TCP连接直接连接原始目标地址，不需要等待客户端的数据，
+ * UDP会话只记录目标地址，数据包到达时再转发
+ */
+func (a *TProxyAgent) OnAccept(conn net.Conn) (interface{}, interface{}, error) {
+	switch addr := conn.LocalAddr().(type) {
+	case *net.TCPAddr:
+		return pkg.NewConnectPackage(addr.IP.String(), addr.Port), nil, nil
+	case *net.UDPAddr:
+		a.host = addr.IP.String()
+		a.port = addr.Port
+		return nil, nil, nil
+	}
+	return nil, nil, Error(ERROR_NO_ORIGINAL_DST, "unknown address %s", conn.LocalAddr())
+}
+
+func (a *TProxyAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
+	return nil, nil, nil
+}
+
+/* 从客户端接收到数据 */
+func (a *TProxyAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
+	return data, nil, nil
+}
+
+/* 从SA接收到数据 */
+func (a *TProxyAgent) ReadFromSA(data []byte) (interface{}, interface{}, error) {
+	return nil, data, nil
+}
+
+/* 从客户端接收到UDP数据包，数据包本身就是负载 */
+func (a *TProxyAgent) RecvFromClient(data []byte) (interface{}, interface{}, error) {
+	return pkg.NewUDPDataPackage(a.host, a.port, data), nil, nil
+}
+
+/* 从SA接收到UDP数据，代理会以原始目标地址为源地址发送给客户端 */
+func (a *TProxyAgent) RecvFromSA(host string, port int, data []byte) (interface{}, interface{}, error) {
+	return nil, data, nil
+}
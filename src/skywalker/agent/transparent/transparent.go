@@ -0,0 +1,82 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package transparent
+
+import (
+	"net"
+	. "skywalker/agent/base"
+	"skywalker/pkg"
+	"skywalker/util"
+)
+
+/*
+ * 透明代理
+ * 客户端的连接被iptables REDIRECT到代理端口，
+ * 从socket中获取原始目标地址，RedirectAgent则是连接固定的地址
+ */
+type TransparentAgent struct {
+	BaseAgent
+}
+
+const (
+	ERROR_NO_ORIGINAL_DST = 1
+)
+
+func (a *TransparentAgent) Name() string {
+	return "transparent"
+}
+
+func (a *TransparentAgent) OnInit(name string, cfg map[string]interface{}) error {
+	return nil
+}
+
+/* 没有配置项 */
+func (*TransparentAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
+func (a *TransparentAgent) OnStart() error {
+	return nil
+}
+
+/* 接受连接时就获取原始目标地址并连接，不需要等待客户端的数据 */
+func (a *TransparentAgent) OnAccept(conn net.Conn) (interface{}, interface{}, error) {
+	host, port, err := util.GetOriginalDst(conn)
+	if err != nil {
+		return nil, nil, Error(ERROR_NO_ORIGINAL_DST, "failed to get original destination, %s", err)
+	}
+	/* 直接连接代理端口时原始目标地址就是代理本身 */
+	if util.JoinHostPort(host, port) == conn.LocalAddr().String() {
+		return nil, nil, Error(ERROR_NO_ORIGINAL_DST, "connection is not redirected")
+	}
+	return pkg.NewConnectPackage(host, port), nil, nil
+}
+
+func (a *TransparentAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
+	return nil, nil, nil
+}
+
+/* 从客户端接收到数据 */
+func (a *TransparentAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
+	return data, nil, nil
+}
+
+/* 从SA接收到数据 */
+func (a *TransparentAgent) ReadFromSA(data []byte) (interface{}, interface{}, error) {
+	return nil, data, nil
+}
@@ -33,6 +33,11 @@ func (*VoidClientAgent) OnInit(string, map[string]interface{}) error {
 	return nil
 }
 
+/* 没有配置项 */
+func (*VoidClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 func (*VoidClientAgent) OnStart() error {
 	return nil
 }
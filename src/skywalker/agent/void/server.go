@@ -33,6 +33,11 @@ func (*VoidServerAgent) OnInit(string, map[string]interface{}) error {
 	return nil
 }
 
+/* 没有配置项 */
+func (*VoidServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{}
+}
+
 func (*VoidServerAgent) OnStart() error {
 	return nil
 }
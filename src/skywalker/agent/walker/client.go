@@ -16,4 +16,150 @@
  */
 package walker
 
-/* TODO */
+import (
+	. "skywalker/agent/base"
+	"skywalker/cipher"
+	"skywalker/pkg"
+	"skywalker/util"
+)
+
+/*
+ * Walker Client Agent
+ * 实现的是walker协议的服务端，与WalkerServerAgent配合使用
+ *
+ * 客户端发送长度前缀的Request，其中包含目标地址和使用服务器公钥加密的会话密钥；
+ * 连接目标服务器后，返回长度前缀的Response，其中包含服务端加密使用的key/iv，
+ * 以及持有私钥的证明，客户端据此验证服务器；
+ * 此后双方都使用各自协商的key/iv加密数据
+ */
+type (
+	WalkerClientAgent struct {
+		BaseAgent
+
+		addr      string
+		port      int
+		encrypter cipher.Encrypter
+		decrypter cipher.Decrypter
+		secret    *Secret /* 客户端的会话密钥 */
+
+		buf []byte /* 还没有解析的握手数据 */
+
+		cfg *walkerCAConfig
+	}
+
+	walkerCAConfig struct {
+		method     string
+		privateKey string /* 服务器的私钥文件 */
+
+		cipherInfo *cipher.CipherInfo
+		rsa        *cipher.RSA
+	}
+)
+
+var (
+	gCAConfigs = map[string]*walkerCAConfig{}
+)
+
+func (a *WalkerClientAgent) Name() string {
+	return "walker"
+}
+
+/* 解析配置，不修改全局的配置 */
+func parseCAConfig(cfg map[string]interface{}) (*walkerCAConfig, error) {
+	method := util.GetMapStringDefault(cfg, "method", "aes-256-cfb")
+	info, err := getCipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	privateKey := util.GetMapString(cfg, "privateKey")
+	rsa, err := loadRSA(privateKey, true)
+	if err != nil {
+		return nil, err
+	}
+	return &walkerCAConfig{
+		method:     method,
+		privateKey: privateKey,
+		cipherInfo: info,
+		rsa:        rsa,
+	}, nil
+}
+
+func (a *WalkerClientAgent) OnInit(name string, cfg map[string]interface{}) error {
+	caConfig, err := parseCAConfig(cfg)
+	if err != nil {
+		return err
+	}
+	gCAConfigs[name] = caConfig
+	return nil
+}
+
+/* 只检查配置，重新加载配置时使用 */
+func (*WalkerClientAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseCAConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*WalkerClientAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"method":     STRING_FIELD,
+		"privateKey": STRING_FIELD,
+	}
+}
+
+func (a *WalkerClientAgent) OnStart() error {
+	a.cfg = gCAConfigs[a.BaseAgent.Name]
+	a.buf = nil
+	a.encrypter = nil
+	a.decrypter = nil
+	a.secret = nil
+	return nil
+}
+
+/* 返回握手结果，失败时连接会被关闭 */
+func (a *WalkerClientAgent) OnConnectResult(result int, host string, port int) (interface{}, interface{}, error) {
+	data, encrypter := packResponse(connectResultString(result), a.cfg.cipherInfo, a.secret)
+	a.encrypter = encrypter
+	return nil, data, nil
+}
+
+func (a *WalkerClientAgent) ReadFromClient(data []byte) (interface{}, interface{}, error) {
+	var tdata []*pkg.Package
+
+	if a.decrypter == nil { /* 等待客户端的请求 */
+		a.buf = append(a.buf, data...)
+		req, secret, decrypter, left, err := unpackRequest(a.buf, a.cfg.cipherInfo, a.cfg.rsa)
+		if err != nil {
+			return nil, nil, err
+		} else if req == nil { /* 请求还不完整 */
+			return nil, nil, nil
+		}
+		a.decrypter = decrypter
+		a.secret = secret
+		a.addr = req.Addr
+		a.port = int(req.Port)
+		a.buf = nil
+		tdata = append(tdata, pkg.NewConnectPackage(a.addr, a.port))
+		data = left
+	}
+	if len(data) > 0 {
+		tdata = append(tdata, pkg.NewDataPackage(a.decrypter.Decrypt(data)))
+	}
+	return tdata, nil, nil
+}
+
+func (a *WalkerClientAgent) ReadFromSA(data []byte) (interface{}, interface{}, error) {
+	if a.encrypter != nil {
+		return nil, a.encrypter.Encrypt(data), nil
+	}
+	return nil, nil, nil
+}
+
+func (a *WalkerClientAgent) GetInfo() []map[string]string {
+	return []map[string]string{
+		map[string]string{
+			"key":   "method",
+			"value": a.cfg.method,
+		},
+	}
+}
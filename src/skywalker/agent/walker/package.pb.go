@@ -41,7 +41,62 @@ func (x AType) String() string {
 	return proto.EnumName(AType_name, int32(x))
 }
 func (AType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_package_c7a9dd13999fd66a, []int{0}
+	return fileDescriptor_package_399df828b7d793ba, []int{0}
+}
+
+// 客户端的会话密钥，使用服务器的RSA公钥加密后放在Request.secret中
+type Secret struct {
+	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Iv                   []byte   `protobuf:"bytes,2,opt,name=iv,proto3" json:"iv,omitempty"`
+	Nonce                []byte   `protobuf:"bytes,3,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Secret) Reset()         { *m = Secret{} }
+func (m *Secret) String() string { return proto.CompactTextString(m) }
+func (*Secret) ProtoMessage()    {}
+func (*Secret) Descriptor() ([]byte, []int) {
+	return fileDescriptor_package_399df828b7d793ba, []int{0}
+}
+func (m *Secret) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Secret.Unmarshal(m, b)
+}
+func (m *Secret) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Secret.Marshal(b, m, deterministic)
+}
+func (dst *Secret) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Secret.Merge(dst, src)
+}
+func (m *Secret) XXX_Size() int {
+	return xxx_messageInfo_Secret.Size(m)
+}
+func (m *Secret) XXX_DiscardUnknown() {
+	xxx_messageInfo_Secret.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Secret proto.InternalMessageInfo
+
+func (m *Secret) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Secret) GetIv() []byte {
+	if m != nil {
+		return m.Iv
+	}
+	return nil
+}
+
+func (m *Secret) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
 }
 
 type Request struct {
@@ -49,8 +104,7 @@ type Request struct {
 	Atype                AType    `protobuf:"varint,2,opt,name=atype,proto3,enum=walker.AType" json:"atype,omitempty"`
 	Addr                 string   `protobuf:"bytes,3,opt,name=addr,proto3" json:"addr,omitempty"`
 	Port                 int32    `protobuf:"varint,4,opt,name=port,proto3" json:"port,omitempty"`
-	Key                  []byte   `protobuf:"bytes,5,opt,name=key,proto3" json:"key,omitempty"`
-	Iv                   []byte   `protobuf:"bytes,6,opt,name=iv,proto3" json:"iv,omitempty"`
+	Secret               []byte   `protobuf:"bytes,5,opt,name=secret,proto3" json:"secret,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -60,7 +114,7 @@ func (m *Request) Reset()         { *m = Request{} }
 func (m *Request) String() string { return proto.CompactTextString(m) }
 func (*Request) ProtoMessage()    {}
 func (*Request) Descriptor() ([]byte, []int) {
-	return fileDescriptor_package_c7a9dd13999fd66a, []int{0}
+	return fileDescriptor_package_399df828b7d793ba, []int{1}
 }
 func (m *Request) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Request.Unmarshal(m, b)
@@ -108,16 +162,9 @@ func (m *Request) GetPort() int32 {
 	return 0
 }
 
-func (m *Request) GetKey() []byte {
-	if m != nil {
-		return m.Key
-	}
-	return nil
-}
-
-func (m *Request) GetIv() []byte {
+func (m *Request) GetSecret() []byte {
 	if m != nil {
-		return m.Iv
+		return m.Secret
 	}
 	return nil
 }
@@ -127,6 +174,7 @@ type Response struct {
 	Result               string   `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
 	Key                  []byte   `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
 	Iv                   []byte   `protobuf:"bytes,4,opt,name=iv,proto3" json:"iv,omitempty"`
+	Proof                []byte   `protobuf:"bytes,5,opt,name=proof,proto3" json:"proof,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -136,7 +184,7 @@ func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 func (*Response) Descriptor() ([]byte, []int) {
-	return fileDescriptor_package_c7a9dd13999fd66a, []int{1}
+	return fileDescriptor_package_399df828b7d793ba, []int{2}
 }
 func (m *Response) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Response.Unmarshal(m, b)
@@ -184,32 +232,42 @@ func (m *Response) GetIv() []byte {
 	return nil
 }
 
+func (m *Response) GetProof() []byte {
+	if m != nil {
+		return m.Proof
+	}
+	return nil
+}
+
 func init() {
+	proto.RegisterType((*Secret)(nil), "walker.Secret")
 	proto.RegisterType((*Request)(nil), "walker.Request")
 	proto.RegisterType((*Response)(nil), "walker.Response")
 	proto.RegisterEnum("walker.AType", AType_name, AType_value)
 }
 
 func init() {
-	proto.RegisterFile("src/skywalker/agent/walker/package.proto", fileDescriptor_package_c7a9dd13999fd66a)
-}
-
-var fileDescriptor_package_c7a9dd13999fd66a = []byte{
-	// 254 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x90, 0x4f, 0x4b, 0xc3, 0x30,
-	0x18, 0xc6, 0x4d, 0xff, 0x6d, 0x7b, 0xd1, 0x52, 0x72, 0x90, 0x1c, 0xcb, 0xbc, 0x04, 0x85, 0x16,
-	0x54, 0xbc, 0x17, 0xf4, 0xb0, 0xc3, 0xa6, 0x04, 0xf1, 0x28, 0xc4, 0xed, 0x65, 0x94, 0x8e, 0x26,
-	0x26, 0x59, 0xa5, 0x5f, 0xc3, 0x4f, 0x2c, 0x4b, 0xdb, 0x8b, 0x87, 0xdd, 0x7e, 0xcf, 0x13, 0xf2,
-	0xe3, 0xe1, 0x05, 0x6e, 0xcd, 0xb6, 0xb4, 0x4d, 0xff, 0x23, 0x0f, 0x0d, 0x9a, 0x52, 0xee, 0xb1,
-	0x75, 0xe5, 0x18, 0xb4, 0xdc, 0x36, 0x72, 0x8f, 0x85, 0x36, 0xca, 0x29, 0x9a, 0x0c, 0xed, 0xf2,
-	0x97, 0xc0, 0x4c, 0xe0, 0xf7, 0x11, 0xad, 0xa3, 0x0c, 0x66, 0x1d, 0x1a, 0x5b, 0xab, 0x96, 0x91,
-	0x9c, 0xf0, 0x58, 0x4c, 0x91, 0xde, 0x40, 0x2c, 0x5d, 0xaf, 0x91, 0x05, 0x39, 0xe1, 0xe9, 0xfd,
-	0x55, 0x31, 0xfc, 0x2e, 0xaa, 0xf7, 0x5e, 0xa3, 0x18, 0xde, 0x28, 0x85, 0x48, 0xee, 0x76, 0x86,
-	0x85, 0x39, 0xe1, 0x0b, 0xe1, 0xf9, 0xd4, 0x69, 0x65, 0x1c, 0x8b, 0xbc, 0xcf, 0x33, 0xcd, 0x20,
-	0x6c, 0xb0, 0x67, 0x71, 0x4e, 0xf8, 0xa5, 0x38, 0x21, 0x4d, 0x21, 0xa8, 0x3b, 0x96, 0xf8, 0x22,
-	0xa8, 0xbb, 0xe5, 0x27, 0xcc, 0x05, 0x5a, 0xad, 0x5a, 0x8b, 0x67, 0x46, 0x5d, 0x43, 0x62, 0xd0,
-	0x1e, 0x0f, 0xce, 0xaf, 0x5a, 0x88, 0x31, 0x4d, 0xfe, 0xf0, 0xbf, 0x3f, 0x9a, 0xfc, 0xb7, 0x77,
-	0x10, 0xfb, 0xe5, 0x74, 0x0e, 0xd1, 0xea, 0xed, 0xe3, 0x31, 0xbb, 0x18, 0xe9, 0x29, 0x23, 0x34,
-	0x05, 0x78, 0x7e, 0x5d, 0x57, 0xab, 0xcd, 0xa6, 0x5a, 0xbf, 0x64, 0xc1, 0x57, 0xe2, 0x0f, 0xf6,
-	0xf0, 0x17, 0x00, 0x00, 0xff, 0xff, 0x5c, 0xbc, 0x68, 0xf9, 0x5c, 0x01, 0x00, 0x00,
+	proto.RegisterFile("src/skywalker/agent/walker/package.proto", fileDescriptor_package_399df828b7d793ba)
+}
+
+var fileDescriptor_package_399df828b7d793ba = []byte{
+	// 281 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x90, 0xcf, 0x4a, 0xf3, 0x40,
+	0x14, 0xc5, 0xbf, 0xfc, 0x6d, 0x7b, 0xf9, 0x0c, 0x61, 0x90, 0x32, 0x4b, 0x89, 0x9b, 0xa2, 0x90,
+	0x80, 0x8a, 0x6b, 0x03, 0xba, 0xe8, 0xa2, 0x55, 0x46, 0x71, 0x3f, 0xa6, 0xd7, 0x12, 0x52, 0x32,
+	0xe3, 0xcc, 0x34, 0x92, 0x37, 0xf0, 0xb1, 0xa5, 0x37, 0x29, 0x88, 0x0b, 0x77, 0xe7, 0x77, 0x06,
+	0xce, 0x3d, 0x73, 0x60, 0x61, 0x4d, 0x55, 0xd8, 0xa6, 0xff, 0x94, 0xbb, 0x06, 0x4d, 0x21, 0xb7,
+	0xd8, 0xba, 0x62, 0x04, 0x2d, 0xab, 0x46, 0x6e, 0x31, 0xd7, 0x46, 0x39, 0xc5, 0xe2, 0xc1, 0xcd,
+	0xee, 0x20, 0x7e, 0xc6, 0xca, 0xa0, 0x63, 0x29, 0x04, 0x0d, 0xf6, 0xdc, 0x3b, 0xf3, 0x16, 0xff,
+	0xc5, 0x41, 0xb2, 0x04, 0xfc, 0xba, 0xe3, 0x3e, 0x19, 0x7e, 0xdd, 0xb1, 0x53, 0x88, 0x5a, 0xd5,
+	0x56, 0xc8, 0x03, 0xb2, 0x06, 0xc8, 0xbe, 0x3c, 0x98, 0x08, 0xfc, 0xd8, 0xa3, 0x75, 0x8c, 0xc3,
+	0xa4, 0x43, 0x63, 0x6b, 0xd5, 0x52, 0x4e, 0x24, 0x8e, 0xc8, 0xce, 0x21, 0x92, 0xae, 0xd7, 0x48,
+	0x71, 0xc9, 0xd5, 0x49, 0x3e, 0xdc, 0xcf, 0xcb, 0x97, 0x5e, 0xa3, 0x18, 0xde, 0x18, 0x83, 0x50,
+	0x6e, 0x36, 0x86, 0xf2, 0x67, 0x82, 0xf4, 0xc1, 0xd3, 0xca, 0x38, 0x1e, 0x52, 0x1e, 0x69, 0x36,
+	0x87, 0xd8, 0x52, 0x69, 0x1e, 0x51, 0x93, 0x91, 0x32, 0x07, 0x53, 0x81, 0x56, 0xab, 0xd6, 0xe2,
+	0x1f, 0x55, 0xe6, 0x10, 0x1b, 0xb4, 0xfb, 0x9d, 0xa3, 0x2e, 0x33, 0x31, 0xd2, 0x71, 0x80, 0xe0,
+	0xf7, 0x00, 0xe1, 0xcf, 0x01, 0xb4, 0x51, 0xea, 0x7d, 0x3c, 0x3b, 0xc0, 0xc5, 0x25, 0x44, 0xf4,
+	0x0b, 0x36, 0x85, 0x70, 0xf9, 0xf4, 0x7a, 0x93, 0xfe, 0x1b, 0xd5, 0x6d, 0xea, 0xb1, 0x04, 0xe0,
+	0xfe, 0x71, 0x55, 0x2e, 0xd7, 0xeb, 0x72, 0xf5, 0x90, 0xfa, 0x6f, 0x31, 0xcd, 0x7f, 0xfd, 0x3d,
+	0x00, 0xdd, 0x32, 0xe9, 0x61, 0xaa, 0x01, 0x00, 0x00,
 }
@@ -18,23 +18,45 @@ package walker
 
 import (
 	"bytes"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/binary"
 	"github.com/golang/protobuf/proto"
 	"net"
 	. "skywalker/agent/base"
 	"skywalker/cipher"
+	"skywalker/pkg"
+	"skywalker/util"
 	"strings"
 )
 
 const (
-	ERROR_DATA_ERROR     = 1
-	ERROR_PORT_INVALID   = 2
-	ERROR_RESULT_FAILURE = 3
+	ERROR_DATA_ERROR      = 1
+	ERROR_PORT_INVALID    = 2
+	ERROR_RESULT_FAILURE  = 3
+	ERROR_INVALID_CONFIG  = 4
+	ERROR_INVALID_KEY     = 5
+	ERROR_INVALID_VERSION = 6
+	ERROR_AUTH_FAILURE    = 7
 )
 
 const (
-	RESULT_SUCCESS = "success"
+	WALKER_VERSION = 0x02
+
+	/* 用于验证服务器的随机数长度 */
+	NONCE_SIZE = 16
+
+	/* 握手数据包的最大长度 */
+	MAX_PACKAGE_SIZE = 64 * 1024
+)
+
+const (
+	RESULT_SUCCESS      = "success"
+	RESULT_UNKNOWN_HOST = "unknown host"
+	RESULT_UNREACHABLE  = "unreachable"
+	RESULT_FAILURE      = "failure"
 )
 
 func pack(data []byte) []byte {
@@ -50,6 +72,23 @@ func unpack(data []byte) int {
 	return int(size)
 }
 
+/*
+ * 从数据中取出一个完整的数据包
+ * 数据不完整时返回nil,nil,nil
+ */
+func unpackMessage(data []byte) ([]byte, []byte, error) {
+	if len(data) < 4 {
+		return nil, nil, nil
+	}
+	size := unpack(data)
+	if size < 0 || size > MAX_PACKAGE_SIZE {
+		return nil, nil, Error(ERROR_DATA_ERROR, "invalid package size %d", size)
+	} else if len(data) < size+4 {
+		return nil, nil, nil
+	}
+	return data[4 : 4+size], data[4+size:], nil
+}
+
 /* 随机生成IV */
 func randomKey(ilen int) []byte {
 	if ilen <= 0 {
@@ -60,46 +99,187 @@ func randomKey(ilen int) []byte {
 	return iv
 }
 
-func packRequest(addr string, port uint16, method string) ([]byte, cipher.Encrypter) {
+/* 检查加密方式，返回对应的加密信息 */
+func getCipherInfo(method string) (*cipher.CipherInfo, error) {
+	info := cipher.GetCipherInfo(strings.ToLower(method))
+	if info == nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "unknown cipher method %s", method)
+	}
+	return info, nil
+}
+
+/* 连接结果转化为握手结果 */
+func connectResultString(result int) string {
+	switch result {
+	case pkg.CONNECT_RESULT_OK:
+		return RESULT_SUCCESS
+	case pkg.CONNECT_RESULT_UNKNOWN_HOST:
+		return RESULT_UNKNOWN_HOST
+	case pkg.CONNECT_RESULT_UNREACHABLE:
+		return RESULT_UNREACHABLE
+	}
+	return RESULT_FAILURE
+}
+
+/*
+ * 用客户端的会话密钥生成掩码，用于保护服务端返回的key/iv
+ * label用于区分key和iv，保证两者的掩码不同
+ */
+func maskKey(secret *Secret, label string, data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	h := sha512.New()
+	h.Write(secret.Key)
+	h.Write(secret.Nonce)
+	h.Write([]byte(label))
+	mask := h.Sum(nil)
+	if len(data) > len(mask) {
+		return nil
+	}
+	masked := make([]byte, len(data))
+	for i := range data {
+		masked[i] = data[i] ^ mask[i]
+	}
+	return masked
+}
+
+/* 服务端持有私钥的证明，只有解开Secret才能计算出来 */
+func makeProof(secret *Secret, key, iv []byte) []byte {
+	h := hmac.New(sha256.New, secret.Key)
+	h.Write(secret.Nonce)
+	h.Write(key)
+	h.Write(iv)
+	return h.Sum(nil)
+}
+
+/*
+ * 生成请求，会话密钥使用服务器的公钥加密
+ * 返回请求数据、加密器和会话密钥，会话密钥用于验证服务器的回应
+ */
+func packRequest(addr string, port uint16, info *cipher.CipherInfo, r *cipher.RSA) ([]byte, cipher.Encrypter, *Secret, error) {
 	ip := net.ParseIP(addr)
 	atype := AType_DOMAINNAME
 	if ip != nil {
-		if len(ip) == 4 {
+		if ip.To4() != nil {
 			atype = AType_IPV4
 		} else {
 			atype = AType_IPV6
 		}
 	}
 
-	info := cipher.GetCipherInfo(strings.ToLower(method))
-	key := randomKey(info.KeySize)
-	iv := randomKey(info.IvSize)
+	secret := &Secret{
+		Key:   randomKey(info.KeySize),
+		Iv:    randomKey(info.IvSize),
+		Nonce: randomKey(NONCE_SIZE),
+	}
+	plain, _ := proto.Marshal(secret)
+	encrypted, err := r.Encrypt(plain)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	req := Request{
-		Version: 0x01,
+		Version: WALKER_VERSION,
 		Atype:   atype,
 		Addr:    addr,
 		Port:    int32(port),
-		Key:     key,
-		Iv:      iv,
+		Secret:  encrypted,
 	}
 	data, _ := proto.Marshal(&req)
-	return pack(data), info.EncrypterFunc(key, iv)
+	return pack(data), info.EncrypterFunc(secret.Key, secret.Iv), secret, nil
 }
 
-func unpackResponse(data []byte, method string) (*Response, cipher.Decrypter, []byte, error) {
-	if len(data) <= 4 {
-		return nil, nil, nil, Error(ERROR_DATA_ERROR, "unpack error")
+/*
+ * 解析客户端的请求，使用私钥解出会话密钥
+ * 返回请求、会话密钥、解密器和剩余的数据，数据不完整时返回的请求为nil
+ */
+func unpackRequest(data []byte, info *cipher.CipherInfo, r *cipher.RSA) (*Request, *Secret, cipher.Decrypter, []byte, error) {
+	msg, left, err := unpackMessage(data)
+	if err != nil || msg == nil {
+		return nil, nil, nil, nil, err
 	}
-	size := unpack(data)
-	if len(data) < size+4 {
-		return nil, nil, nil, Error(ERROR_DATA_ERROR, "unpack error")
+	req := &Request{}
+	if err := proto.Unmarshal(msg, req); err != nil {
+		return nil, nil, nil, nil, err
+	} else if req.Version != WALKER_VERSION {
+		return nil, nil, nil, nil, Error(ERROR_INVALID_VERSION, "unsupported walker version %d", req.Version)
+	} else if req.Port <= 0 || req.Port > 65535 {
+		return nil, nil, nil, nil, Error(ERROR_PORT_INVALID, "invalid port %d", req.Port)
+	}
+	plain, err := r.Decrypt(req.Secret)
+	if err != nil {
+		return nil, nil, nil, nil, Error(ERROR_INVALID_KEY, "fail to decrypt secret: %s", err)
+	}
+	secret := &Secret{}
+	if err := proto.Unmarshal(plain, secret); err != nil {
+		return nil, nil, nil, nil, err
+	} else if len(secret.Key) != info.KeySize || len(secret.Iv) != info.IvSize || len(secret.Nonce) != NONCE_SIZE {
+		return nil, nil, nil, nil, Error(ERROR_INVALID_KEY, "invalid key/iv size")
+	}
+	return req, secret, info.DecrypterFunc(secret.Key, secret.Iv), left, nil
+}
+
+/*
+ * 生成握手结果，成功时同时返回服务端使用的加密器
+ * 服务端的key/iv使用客户端的会话密钥掩码，并附带持有私钥的证明
+ */
+func packResponse(result string, info *cipher.CipherInfo, secret *Secret) ([]byte, cipher.Encrypter) {
+	rep := Response{
+		Version: WALKER_VERSION,
+		Result:  result,
+	}
+	var encrypter cipher.Encrypter
+	if result == RESULT_SUCCESS {
+		key := randomKey(info.KeySize)
+		iv := randomKey(info.IvSize)
+		rep.Key = maskKey(secret, "key", key)
+		rep.Iv = maskKey(secret, "iv", iv)
+		rep.Proof = makeProof(secret, rep.Key, rep.Iv)
+		encrypter = info.EncrypterFunc(key, iv)
+	}
+	data, _ := proto.Marshal(&rep)
+	return pack(data), encrypter
+}
+
+/*
+ * 解析服务端的握手结果，验证服务端持有私钥
+ * 返回结果、解密器和剩余的数据，数据不完整时返回的结果为nil
+ */
+func unpackResponse(data []byte, info *cipher.CipherInfo, secret *Secret) (*Response, cipher.Decrypter, []byte, error) {
+	msg, left, err := unpackMessage(data)
+	if err != nil || msg == nil {
+		return nil, nil, nil, err
 	}
 	rep := &Response{}
-	if err := proto.Unmarshal(data[4:4+size], rep); err != nil {
+	if err := proto.Unmarshal(msg, rep); err != nil {
 		return nil, nil, nil, err
+	} else if rep.Result != RESULT_SUCCESS {
+		return rep, nil, left, nil
+	} else if !hmac.Equal(rep.Proof, makeProof(secret, rep.Key, rep.Iv)) {
+		return nil, nil, nil, Error(ERROR_AUTH_FAILURE, "server authentication failure")
 	}
-	info := cipher.GetCipherInfo(strings.ToLower(method))
-	key := randomKey(info.KeySize)
-	iv := randomKey(info.IvSize)
-	return rep, info.DecrypterFunc(key, iv), data[4+size:], nil
+	key := maskKey(secret, "key", rep.Key)
+	iv := maskKey(secret, "iv", rep.Iv)
+	if len(key) != info.KeySize || len(iv) != info.IvSize {
+		return nil, nil, nil, Error(ERROR_INVALID_KEY, "invalid key/iv size")
+	}
+	return rep, info.DecrypterFunc(key, iv), left, nil
+}
+
+/* 载入RSA密钥，私钥用于服务端，公钥用于客户端 */
+func loadRSA(filename string, private bool) (*cipher.RSA, error) {
+	if len(filename) == 0 {
+		return nil, Error(ERROR_INVALID_CONFIG, "key file not specified")
+	}
+	var r *cipher.RSA
+	var err error
+	if filename = util.ResolveHomePath(filename); private {
+		r, err = cipher.LoadRSAFromFile(filename)
+	} else {
+		r, err = cipher.LoadRSAPublicKeyFromFile(filename)
+	}
+	if err != nil {
+		return nil, Error(ERROR_INVALID_CONFIG, "%s: %s", filename, err)
+	}
+	return r, nil
 }
@@ -20,7 +20,10 @@ import (
 	. "skywalker/agent/base"
 	"skywalker/cipher"
 	"skywalker/pkg"
+	"skywalker/resolver"
 	"skywalker/util"
+	"strconv"
+	"strings"
 )
 
 type (
@@ -31,14 +34,27 @@ type (
 		port      uint16
 		encrypter cipher.Encrypter
 		decrypter cipher.Decrypter
+		secret    *Secret /* 会话密钥，用于验证服务器 */
+
+		buf []byte /* 还没有解析的握手数据 */
+
+		server *WalkerServerConfig /* 当前使用的服务器 */
+		index  int                 /* 当前服务器在servers中的位置 */
 
 		cfg *WalkerServerConfig
 	}
 
 	WalkerServerConfig struct {
-		addr   string
-		port   uint16
-		method string
+		addr      string
+		port      uint16
+		method    string
+		publicKey string /* 服务器的公钥文件 */
+
+		cipherInfo *cipher.CipherInfo
+		rsa        *cipher.RSA
+
+		/* 多服务器设置，按顺序使用，连接失败时切换到下一个 */
+		servers []*WalkerServerConfig
 	}
 )
 
@@ -50,34 +66,133 @@ func (a *WalkerServerAgent) Name() string {
 	return "walker"
 }
 
-func (a *WalkerServerAgent) OnInit(name string, cfg map[string]interface{}) error {
-	port := uint16(util.GetMapIntDefault(cfg, "port", 0))
+/* 读取一个服务器的配置，没有配置的项使用def中的值 */
+func parseServerConfig(cfg map[string]interface{}, def *WalkerServerConfig) (*WalkerServerConfig, error) {
+	port := uint16(util.GetMapIntDefault(cfg, "port", int(def.port)))
 	if port == 0 {
-		return Error(ERROR_PORT_INVALID, "invalid port")
+		return nil, Error(ERROR_PORT_INVALID, "invalid port")
+	}
+	method := util.GetMapStringDefault(cfg, "method", def.method)
+	info, err := getCipherInfo(method)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := util.GetMapStringDefault(cfg, "publicKey", def.publicKey)
+	rsa, err := loadRSA(publicKey, false)
+	if err != nil {
+		return nil, err
+	}
+	return &WalkerServerConfig{
+		addr:       util.GetMapStringDefault(cfg, "addr", def.addr),
+		port:       port,
+		method:     method,
+		publicKey:  publicKey,
+		cipherInfo: info,
+		rsa:        rsa,
+	}, nil
+}
+
+/* 解析配置，不修改全局的配置，配置了addr[]时返回的配置包含所有的服务器 */
+func parseSAConfig(cfg map[string]interface{}) (*WalkerServerConfig, error) {
+	def := &WalkerServerConfig{
+		addr:      util.GetMapString(cfg, "addr"),
+		port:      uint16(util.GetMapIntDefault(cfg, "port", 0)),
+		method:    util.GetMapStringDefault(cfg, "method", "aes-256-cfb"),
+		publicKey: util.GetMapString(cfg, "publicKey"),
+	}
+	val, ok := cfg["addr[]"]
+	if !ok {
+		return parseServerConfig(cfg, def)
+	}
+	array, _ := val.([]interface{})
+	for _, e := range array {
+		m, ok := e.(map[string]interface{})
+		if m == nil || !ok {
+			return nil, Error(ERROR_INVALID_CONFIG, "addr[] must be an object array")
+		}
+		server, err := parseServerConfig(m, def)
+		if err != nil {
+			return nil, err
+		}
+		def.servers = append(def.servers, server)
 	}
-	gWAConfigs[name] = &WalkerServerConfig{
-		addr:   util.GetMapString(cfg, "addr"),
-		port:   port,
-		method: util.GetMapString(cfg, "method"),
+	if len(def.servers) == 0 {
+		return nil, Error(ERROR_INVALID_CONFIG, "addr[] is empty")
 	}
+	return def, nil
+}
+
+func (a *WalkerServerAgent) OnInit(name string, cfg map[string]interface{}) error {
+	wcfg, err := parseSAConfig(cfg)
+	if err != nil {
+		return err
+	}
+	for _, server := range wcfg.servers {
+		go resolver.Get(name).ResolveHost(server.addr)
+	}
+	gWAConfigs[name] = wcfg
 	return nil
 }
 
+/* 只检查配置，重新加载配置时使用 */
+func (*WalkerServerAgent) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseSAConfig(cfg)
+	return err
+}
+
+/* 支持的配置项，用于检查配置 */
+func (*WalkerServerAgent) ConfigSchema() ConfigSchema {
+	return ConfigSchema{
+		"addr":      STRING_FIELD,
+		"port":      INT_FIELD,
+		"method":    STRING_FIELD,
+		"publicKey": STRING_FIELD,
+		"addr[]": ListField(MapField(ConfigSchema{
+			"addr":      STRING_FIELD,
+			"port":      INT_FIELD,
+			"method":    STRING_FIELD,
+			"publicKey": STRING_FIELD,
+		})),
+	}
+}
+
 func (a *WalkerServerAgent) OnStart() error {
 	a.cfg = gWAConfigs[a.BaseAgent.Name]
+	a.buf = nil
+	a.secret = nil
+	a.index = 0
+	a.server = a.cfg
+	if len(a.cfg.servers) > 0 {
+		a.server = a.cfg.servers[0]
+	}
 	return nil
 }
 
 func (a *WalkerServerAgent) GetRemoteAddress(addr string, port int) (string, int) {
 	a.addr = addr
 	a.port = uint16(port)
-	return a.cfg.addr, int(a.cfg.port)
+	return a.server.addr, int(a.server.port)
+}
+
+/* 连接服务器失败，按顺序切换到下一个服务器 */
+func (a *WalkerServerAgent) NextRemoteAddress(host string, port int, result int) (string, int) {
+	if a.index+1 >= len(a.cfg.servers) {
+		return "", 0
+	}
+	a.index += 1
+	a.server = a.cfg.servers[a.index]
+	return a.server.addr, int(a.server.port)
 }
 
 func (a *WalkerServerAgent) OnConnectResult(result int, addr string, port int) (interface{}, interface{}, error) {
 	if result == pkg.CONNECT_RESULT_OK {
-		data, encrypter := packRequest(addr, uint16(port), a.cfg.method)
+		/* 请求的是目标地址，而不是walker服务器的地址 */
+		data, encrypter, secret, err := packRequest(a.addr, a.port, a.server.cipherInfo, a.server.rsa)
+		if err != nil {
+			return nil, nil, err
+		}
 		a.encrypter = encrypter
+		a.secret = secret
 		return nil, data, nil
 	} else {
 		return nil, nil, nil
@@ -86,13 +201,17 @@ func (a *WalkerServerAgent) OnConnectResult(result int, addr string, port int) (
 
 func (a *WalkerServerAgent) ReadFromServer(data []byte) (interface{}, interface{}, error) {
 	if a.decrypter == nil { /* 等待服务器回应 */
-		rep, decrypter, left, err := unpackResponse(data, a.cfg.method)
+		a.buf = append(a.buf, data...)
+		rep, decrypter, left, err := unpackResponse(a.buf, a.server.cipherInfo, a.secret)
 		if err != nil {
 			return nil, nil, err
+		} else if rep == nil { /* 回应还不完整 */
+			return nil, nil, nil
 		} else if rep.Result != RESULT_SUCCESS {
-			return nil, nil, Error(ERROR_RESULT_FAILURE, rep.Result)
+			return nil, nil, Error(ERROR_RESULT_FAILURE, "%s", rep.Result)
 		}
 		a.decrypter = decrypter
+		a.buf = nil
 		data = left
 	}
 	return a.decrypter.Decrypt(data), nil, nil
@@ -109,5 +228,34 @@ func (a *WalkerServerAgent) OnClose(closed_by_client bool) {
 }
 
 func (a *WalkerServerAgent) GetInfo() []map[string]string {
-	return nil
+	if len(a.cfg.servers) > 0 {
+		var s []string
+		for _, server := range a.cfg.servers {
+			s = append(s, util.JoinHostPort(server.addr, int(server.port)))
+		}
+		return []map[string]string{
+			map[string]string{
+				"key":   "addrs",
+				"value": "[" + strings.Join(s, ", ") + "]",
+			},
+		}
+	}
+	return []map[string]string{
+		map[string]string{
+			"key":   "addr",
+			"value": a.cfg.addr,
+		},
+		map[string]string{
+			"key":   "port",
+			"value": strconv.Itoa(int(a.cfg.port)),
+		},
+		map[string]string{
+			"key":   "method",
+			"value": a.cfg.method,
+		},
+		map[string]string{
+			"key":   "publicKey",
+			"value": a.cfg.publicKey,
+		},
+	}
 }
@@ -29,6 +29,9 @@ const (
 	NonceSize = 8
 	// XNonceSize is the length of XChaCha20 nonces, in bytes.
 	XNonceSize = 24
+	// IETFNonceSize is the length of IETF ChaCha20 (RFC 7539) nonces, in
+	// bytes.
+	IETFNonceSize = 12
 )
 
 var (
@@ -39,6 +42,9 @@ var (
 	// ErrInvalidXNonce is returned when the provided nonce is not 192 bits
 	// long.
 	ErrInvalidXNonce = errors.New("invalid nonce length (must be 192 bits)")
+	// ErrInvalidIETFNonce is returned when the provided nonce is not 96 bits
+	// long.
+	ErrInvalidIETFNonce = errors.New("invalid nonce length (must be 96 bits)")
 	// ErrInvalidRounds is returned when the provided rounds is not
 	// 8, 12, or 20.
 	ErrInvalidRounds = errors.New("invalid rounds number (must be 8, 12, or 20)")
@@ -117,11 +123,33 @@ func NewXChaChaWithRounds(key []byte, nonce []byte, rounds uint8) (cipher.Stream
 	return s, nil
 }
 
+// NewIETF creates and returns a new cipher.Stream for the IETF variant of
+// ChaCha20 described in RFC 7539. The key argument must be 256 bits long, and
+// the nonce argument must be 96 bits long. The block counter starts at zero
+// and is only 32 bits wide, so this Stream instance must not be used to
+// encrypt more than 2^38 bytes (256 GiB).
+func NewIETF(key []byte, nonce []byte) (cipher.Stream, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	if len(nonce) != IETFNonceSize {
+		return nil, ErrInvalidIETFNonce
+	}
+
+	s := new(stream)
+	s.init(key, nonce, 20)
+	s.advance()
+
+	return s, nil
+}
+
 type stream struct {
 	state  [stateSize]uint32 // the state as an array of 16 32-bit words
 	block  [blockSize]byte   // the keystream as an array of 64 bytes
 	offset int               // the offset of used bytes in block
 	rounds uint8
+	ietf   bool // whether the counter is only 32 bits wide
 }
 
 func (s *stream) XORKeyStream(dst, src []byte) {
@@ -176,6 +204,13 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 		s.state[13] = 0
 		s.state[14] = binary.LittleEndian.Uint32(nonce[0:])
 		s.state[15] = binary.LittleEndian.Uint32(nonce[4:])
+	case IETFNonceSize:
+		// IETF ChaCha20 uses a 32 bit counter and 12 byte nonces.
+		s.state[12] = 0
+		s.state[13] = binary.LittleEndian.Uint32(nonce[0:])
+		s.state[14] = binary.LittleEndian.Uint32(nonce[4:])
+		s.state[15] = binary.LittleEndian.Uint32(nonce[8:])
+		s.ietf = true
 	case XNonceSize:
 		// XChaCha20 derives the subkey via HChaCha initialized
 		// with the first 16 bytes of the nonce.
@@ -184,7 +219,7 @@ func (s *stream) init(key []byte, nonce []byte, rounds uint8) {
 		s.state[14] = binary.LittleEndian.Uint32(nonce[8:])
 		s.state[15] = binary.LittleEndian.Uint32(nonce[12:])
 	default:
-		// Never happens, both ctors validate the nonce length.
+		// Never happens, all ctors validate the nonce length.
 		panic("invalid nonce size")
 	}
 
@@ -209,7 +244,7 @@ func (s *stream) advance() {
 	s.offset = 0
 	i := s.state[12] + 1
 	s.state[12] = i
-	if i == 0 {
+	if i == 0 && !s.ietf {
 		s.state[13]++
 	}
 }
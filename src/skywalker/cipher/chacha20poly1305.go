@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	_cipher "crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"skywalker/cipher/chacha20"
+	"skywalker/cipher/poly1305"
+)
+
+/* ChaCha20-Poly1305 AEAD，参见RFC 7539 */
+type chacha20Poly1305 struct {
+	key [chacha20.KeySize]byte
+}
+
+var errOpen = errors.New("message authentication failed")
+
+func newChacha20Poly1305(key []byte) (_cipher.AEAD, error) {
+	if len(key) != chacha20.KeySize {
+		return nil, chacha20.ErrInvalidKey
+	}
+	c := &chacha20Poly1305{}
+	copy(c.key[:], key)
+	return c, nil
+}
+
+func (c *chacha20Poly1305) NonceSize() int {
+	return chacha20.IETFNonceSize
+}
+
+func (c *chacha20Poly1305) Overhead() int {
+	return poly1305.TagSize
+}
+
+/* 使用第0个块的密钥流作为Poly1305的密钥，加解密从第1个块开始 */
+func (c *chacha20Poly1305) init(nonce []byte) (_cipher.Stream, *poly1305.MAC) {
+	if len(nonce) != chacha20.IETFNonceSize {
+		panic("chacha20poly1305: bad nonce length passed")
+	}
+	stream, _ := chacha20.NewIETF(c.key[:], nonce)
+	var block [64]byte
+	var polyKey [poly1305.KeySize]byte
+	stream.XORKeyStream(block[:], block[:])
+	copy(polyKey[:], block[:])
+	return stream, poly1305.New(&polyKey)
+}
+
+/* 认证数据为 ad | pad | ciphertext | pad | len(ad) | len(ciphertext) */
+func (c *chacha20Poly1305) authenticate(mac *poly1305.MAC, ad, ciphertext []byte) {
+	var pad [16]byte
+	var lens [16]byte
+	mac.Write(ad)
+	if n := len(ad) % 16; n > 0 {
+		mac.Write(pad[n:])
+	}
+	mac.Write(ciphertext)
+	if n := len(ciphertext) % 16; n > 0 {
+		mac.Write(pad[n:])
+	}
+	binary.LittleEndian.PutUint64(lens[0:8], uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lens[8:16], uint64(len(ciphertext)))
+	mac.Write(lens[:])
+}
+
+func (c *chacha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	stream, mac := c.init(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+poly1305.TagSize)
+	ciphertext := out[:len(plaintext)]
+	stream.XORKeyStream(ciphertext, plaintext)
+	c.authenticate(mac, additionalData, ciphertext)
+	mac.Sum(out[len(plaintext):len(plaintext)])
+	return ret
+}
+
+func (c *chacha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < poly1305.TagSize {
+		return nil, errOpen
+	}
+	tag := ciphertext[len(ciphertext)-poly1305.TagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-poly1305.TagSize]
+
+	stream, mac := c.init(nonce)
+	c.authenticate(mac, additionalData, ciphertext)
+	if !mac.Verify(tag) {
+		return nil, errOpen
+	}
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	stream.XORKeyStream(out, ciphertext)
+	return ret, nil
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package cipher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+/* RFC 8439 2.8.2中的测试向量 */
+const (
+	testChachaKey        = "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"
+	testChachaNonce      = "070000004041424344454647"
+	testChachaAD         = "50515253c0c1c2c3c4c5c6c7"
+	testChachaPlaintext  = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+	testChachaCiphertext = "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+		"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+		"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+		"3ff4def08e4b7a9de576d26586cec64b6116" +
+		"1ae10b594f09e26a7e902ecbd0600691"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestChacha20Poly1305Vector(t *testing.T) {
+	aead, err := newChacha20Poly1305(decodeHex(t, testChachaKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := decodeHex(t, testChachaNonce)
+	ad := decodeHex(t, testChachaAD)
+	want := decodeHex(t, testChachaCiphertext)
+
+	/* dst中原有的数据需要保留 */
+	sealed := aead.Seal([]byte("prefix"), nonce, []byte(testChachaPlaintext), ad)
+	if !bytes.Equal(sealed[:6], []byte("prefix")) || !bytes.Equal(sealed[6:], want) {
+		t.Fatalf("sealed %x, want %x", sealed[6:], want)
+	}
+	plain, err := aead.Open(nil, nonce, want, ad)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(plain) != testChachaPlaintext {
+		t.Fatalf("opened %q", plain)
+	}
+}
+
+func TestChacha20Poly1305Open(t *testing.T) {
+	aead, _ := newChacha20Poly1305(decodeHex(t, testChachaKey))
+	nonce := decodeHex(t, testChachaNonce)
+	ad := decodeHex(t, testChachaAD)
+	sealed := decodeHex(t, testChachaCiphertext)
+
+	tests := []struct {
+		name   string
+		modify func(nonce, sealed, ad []byte) ([]byte, []byte, []byte)
+	}{
+		{"ciphertext", func(n, s, a []byte) ([]byte, []byte, []byte) { s[0] ^= 1; return n, s, a }},
+		{"tag", func(n, s, a []byte) ([]byte, []byte, []byte) { s[len(s)-1] ^= 1; return n, s, a }},
+		{"additional data", func(n, s, a []byte) ([]byte, []byte, []byte) { a[0] ^= 1; return n, s, a }},
+		{"nonce", func(n, s, a []byte) ([]byte, []byte, []byte) { n[0] ^= 1; return n, s, a }},
+		{"too short", func(n, s, a []byte) ([]byte, []byte, []byte) { return n, s[:15], a }},
+	}
+	for _, test := range tests {
+		n, s, a := test.modify(append([]byte{}, nonce...), append([]byte{}, sealed...), append([]byte{}, ad...))
+		if _, err := aead.Open(nil, n, s, a); err == nil {
+			t.Errorf("%s: modified message opened", test.name)
+		}
+	}
+
+	/* 空的明文和附加数据 */
+	empty := aead.Seal(nil, nonce, nil, nil)
+	if len(empty) != aead.Overhead() {
+		t.Fatalf("sealed %d bytes, want %d", len(empty), aead.Overhead())
+	} else if plain, err := aead.Open(nil, nonce, empty, nil); err != nil || len(plain) != 0 {
+		t.Fatalf("opened %q %v", plain, err)
+	}
+}
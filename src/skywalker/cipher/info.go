@@ -45,3 +45,24 @@ func GetCipherInfo(name string) *CipherInfo {
 	info := cipherInfos[name]
 	return info
 }
+
+/* AEAD加密算法，SaltSize是每个连接随机生成的盐的长度 */
+type AEADInfo struct {
+	KeySize  int
+	SaltSize int
+	AEADFunc newAEADFunc
+}
+
+var (
+	aeadInfos = map[string]*AEADInfo{
+		"aes-128-gcm":            &AEADInfo{16, 16, newAESGCM},
+		"aes-192-gcm":            &AEADInfo{24, 24, newAESGCM},
+		"aes-256-gcm":            &AEADInfo{32, 32, newAESGCM},
+		"chacha20-ietf-poly1305": &AEADInfo{32, 32, newChacha20Poly1305},
+	}
+)
+
+func GetAEADInfo(name string) *AEADInfo {
+	info := aeadInfos[name]
+	return info
+}
@@ -17,8 +17,13 @@
 
 package cipher
 
+import (
+	_cipher "crypto/cipher"
+)
+
 type newEncrypterFunc func([]byte, []byte) Encrypter
 type newDecrypterFunc func([]byte, []byte) Decrypter
+type newAEADFunc func([]byte) (_cipher.AEAD, error)
 
 type Encrypter interface {
 	Encrypt([]byte) []byte
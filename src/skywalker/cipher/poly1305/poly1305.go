@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+/*
+ * Poly1305一次性消息认证码，参见RFC 7539
+ * 使用64位整数实现，累加器和r都保存在多个64位字中
+ */
+package poly1305
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	KeySize = 32 /* 密钥长度 */
+	TagSize = 16 /* 认证码长度 */
+)
+
+const (
+	rMask0 = 0x0FFFFFFC0FFFFFFF
+	rMask1 = 0x0FFFFFFC0FFFFFFC
+
+	/* p = 2^130-5 */
+	p0 = 0xFFFFFFFFFFFFFFFB
+	p1 = 0xFFFFFFFFFFFFFFFF
+	p2 = 0x0000000000000003
+)
+
+/* Poly1305计算器，同一个key只能用于一条消息 */
+type MAC struct {
+	r [2]uint64
+	s [2]uint64
+	h [3]uint64
+
+	buf    [TagSize]byte /* 还不满一个块的数据 */
+	offset int
+}
+
+func New(key *[KeySize]byte) *MAC {
+	m := &MAC{}
+	m.r[0] = binary.LittleEndian.Uint64(key[0:8]) & rMask0
+	m.r[1] = binary.LittleEndian.Uint64(key[8:16]) & rMask1
+	m.s[0] = binary.LittleEndian.Uint64(key[16:24])
+	m.s[1] = binary.LittleEndian.Uint64(key[24:32])
+	return m
+}
+
+func (m *MAC) Write(p []byte) (int, error) {
+	n := len(p)
+	if m.offset > 0 {
+		c := copy(m.buf[m.offset:], p)
+		if m.offset += c; m.offset < TagSize {
+			return n, nil
+		}
+		m.block(m.buf[:], 1)
+		m.offset = 0
+		p = p[c:]
+	}
+	for len(p) >= TagSize {
+		m.block(p[:TagSize], 1)
+		p = p[TagSize:]
+	}
+	if len(p) > 0 {
+		m.offset = copy(m.buf[:], p)
+	}
+	return n, nil
+}
+
+/* 计算认证码并追加到b之后，不改变当前状态 */
+func (m *MAC) Sum(b []byte) []byte {
+	t := *m
+	if t.offset > 0 {
+		/* 最后一个不完整的块，末尾添加1之后补0 */
+		t.buf[t.offset] = 1
+		for i := t.offset + 1; i < TagSize; i++ {
+			t.buf[i] = 0
+		}
+		t.block(t.buf[:], 0)
+	}
+
+	/* 如果h >= p，则h -= p */
+	h0, h1, h2 := t.h[0], t.h[1], t.h[2]
+	d0, borrow := bits.Sub64(h0, p0, 0)
+	d1, borrow := bits.Sub64(h1, p1, borrow)
+	_, borrow = bits.Sub64(h2, p2, borrow)
+	if borrow == 0 {
+		h0, h1 = d0, d1
+	}
+
+	/* 加上s，超过128位的部分直接丢弃 */
+	var c uint64
+	h0, c = bits.Add64(h0, t.s[0], 0)
+	h1, _ = bits.Add64(h1, t.s[1], c)
+
+	var tag [TagSize]byte
+	binary.LittleEndian.PutUint64(tag[0:8], h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h1)
+	return append(b, tag[:]...)
+}
+
+/* 验证认证码 */
+func (m *MAC) Verify(tag []byte) bool {
+	return subtle.ConstantTimeCompare(m.Sum(nil), tag) == 1
+}
+
+/*
+ * 处理一个16字节的块，hibit是块末尾添加的第129位，
+ * 完整的块为1，最后一个不完整的块已经自行添加，为0
+ * h = (h + block) * r mod p
+ */
+func (m *MAC) block(p []byte, hibit uint64) {
+	h0, h1, h2 := m.h[0], m.h[1], m.h[2]
+	r0, r1 := m.r[0], m.r[1]
+
+	var c uint64
+	h0, c = bits.Add64(h0, binary.LittleEndian.Uint64(p[0:8]), 0)
+	h1, c = bits.Add64(h1, binary.LittleEndian.Uint64(p[8:16]), c)
+	h2 += c + hibit
+
+	/* h * r，h2最多只有3位，r的高4位被清零，所以h2*r不会溢出 */
+	h0r0hi, h0r0lo := bits.Mul64(h0, r0)
+	h1r0hi, h1r0lo := bits.Mul64(h1, r0)
+	h0r1hi, h0r1lo := bits.Mul64(h0, r1)
+	h1r1hi, h1r1lo := bits.Mul64(h1, r1)
+	h2r0 := h2 * r0
+	h2r1 := h2 * r1
+
+	/* m1 = h1*r0 + h0*r1，m2 = h2*r0 + h1*r1，m3 = h2*r1 */
+	m1lo, c := bits.Add64(h1r0lo, h0r1lo, 0)
+	m1hi, _ := bits.Add64(h1r0hi, h0r1hi, c)
+	m2lo, c := bits.Add64(h1r1lo, h2r0, 0)
+	m2hi, _ := bits.Add64(h1r1hi, 0, c)
+
+	t0 := h0r0lo
+	t1, c := bits.Add64(m1lo, h0r0hi, 0)
+	t2, c := bits.Add64(m2lo, m1hi, c)
+	t3, _ := bits.Add64(h2r1, m2hi, c)
+
+	/*
+	 * 模p约简：2^130 ≡ 5 (mod p)
+	 * 把第130位以上的部分c乘以5加回到低130位，即加上4c和c
+	 */
+	h0, h1, h2 = t0, t1, t2&3
+	cc0, cc1 := t2&^3, t3 /* 4c */
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+	cc0, cc1 = cc0>>2|cc1<<62, cc1>>2 /* c */
+	h0, c = bits.Add64(h0, cc0, 0)
+	h1, c = bits.Add64(h1, cc1, c)
+	h2 += c
+
+	m.h[0], m.h[1], m.h[2] = h0, h1, h2
+}
+
+/* 计算消息的认证码 */
+func Sum(out *[TagSize]byte, msg []byte, key *[KeySize]byte) {
+	m := New(key)
+	m.Write(msg)
+	m.Sum(out[:0])
+}
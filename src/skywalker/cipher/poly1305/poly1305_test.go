@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package poly1305
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+/* RFC 8439 2.5.2和附录A.3中的测试向量，后几个覆盖了模p约简的边界情况 */
+var testVectors = []struct {
+	key string
+	msg string
+	tag string
+}{
+	{
+		"85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b",
+		hex.EncodeToString([]byte("Cryptographic Forum Research Group")),
+		"a8061dc1305136c6c22b8baf0c0127a9",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		"00000000000000000000000000000000",
+	},
+	{
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"ffffffffffffffffffffffffffffffff",
+		"03000000000000000000000000000000",
+	},
+	{
+		"02000000000000000000000000000000ffffffffffffffffffffffffffffffff",
+		"02000000000000000000000000000000",
+		"03000000000000000000000000000000",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"fffffffffffffffffffffffffffffffff0ffffffffffffffffffffffffffffff11000000000000000000000000000000",
+		"05000000000000000000000000000000",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"fffffffffffffffffffffffffffffffffbfefefefefefefefefefefefefefefe01010101010101010101010101010101",
+		"00000000000000000000000000000000",
+	},
+	{
+		"0200000000000000000000000000000000000000000000000000000000000000",
+		"fdffffffffffffffffffffffffffffff",
+		"faffffffffffffffffffffffffffffff",
+	},
+	{
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd01000000000000000000000000000000000000000000000001000000000000000000000000000000",
+		"14000000000000005500000000000000",
+	},
+	{
+		"0100000000000000040000000000000000000000000000000000000000000000",
+		"e33594d7505e43b900000000000000003394d7505e4379cd010000000000000000000000000000000000000000000000",
+		"13000000000000000000000000000000",
+	},
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestVectors(t *testing.T) {
+	for i, v := range testVectors {
+		var key [KeySize]byte
+		var tag [TagSize]byte
+		copy(key[:], decodeHex(t, v.key))
+		msg := decodeHex(t, v.msg)
+		want := decodeHex(t, v.tag)
+
+		if Sum(&tag, msg, &key); !bytes.Equal(tag[:], want) {
+			t.Errorf("%d: tag %x, want %x", i, tag, want)
+		}
+		/* 分多次写入的结果相同 */
+		for size := 1; size < len(msg); size++ {
+			m := New(&key)
+			for p := msg; len(p) > 0; {
+				n := size
+				if n > len(p) {
+					n = len(p)
+				}
+				m.Write(p[:n])
+				p = p[n:]
+			}
+			if !m.Verify(want) {
+				t.Errorf("%d/%d: tag %x, want %x", i, size, m.Sum(nil), want)
+			}
+		}
+	}
+}
+
+func TestVerifyFailure(t *testing.T) {
+	var key [KeySize]byte
+	copy(key[:], decodeHex(t, testVectors[0].key))
+	tag := decodeHex(t, testVectors[0].tag)
+	tag[0] ^= 1
+	m := New(&key)
+	m.Write([]byte("Cryptographic Forum Research Group"))
+	if m.Verify(tag) {
+		t.Error("modified tag verified")
+	}
+}
@@ -14,17 +14,27 @@ type RSA struct {
 	pubKey  *rsa.PublicKey
 }
 
+/* 从PEM中读取私钥，支持PKCS1和PKCS8格式 */
 func ParseRsaPrivateKeyFromPem(privPEM []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privPEM)
 	if block == nil {
 		return nil, errors.New("failed to parse PEM block containing the key")
 	}
+	return parseRsaPrivateKey(block)
+}
 
-	priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+func parseRsaPrivateKey(block *pem.Block) (*rsa.PrivateKey, error) {
+	if block.Type != "PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
 		return nil, err
 	}
-
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key type is not RSA")
+	}
 	return priv, nil
 }
 
@@ -60,10 +70,63 @@ func LoadRSAFromPem(pem []byte) (*RSA, error) {
 	}, nil
 }
 
+/*
+ * 从PEM中读取公钥，支持PKIX和PKCS1格式的公钥，
+ * 如果是私钥，则使用其中的公钥部分
+ */
+func ParseRsaPublicKeyFromPem(pubPEM []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(pubPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block containing the key")
+	}
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "RSA PRIVATE KEY", "PRIVATE KEY":
+		priv, err := parseRsaPrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+		return &priv.PublicKey, nil
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if pubKey, ok := pub.(*rsa.PublicKey); ok {
+		return pubKey, nil
+	}
+	return nil, errors.New("key type is not RSA")
+}
+
+func ParseRsaPublicKeyFromFile(filename string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRsaPublicKeyFromPem(data)
+}
+
+/* 只载入公钥，返回的RSA只能用于加密 */
+func LoadRSAPublicKeyFromFile(filename string) (*RSA, error) {
+	pubKey, err := ParseRsaPublicKeyFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &RSA{
+		privKey: nil,
+		pubKey:  pubKey,
+	}, nil
+}
+
 func (r *RSA) Encrypt(plain []byte) ([]byte, error) {
 	return rsa.EncryptPKCS1v15(rand.Reader, r.pubKey, plain)
 }
 
 func (r *RSA) Decrypt(ciphertext []byte) ([]byte, error) {
+	if r.privKey == nil {
+		return nil, errors.New("private key not loaded")
+	}
 	return rsa.DecryptPKCS1v15(rand.Reader, r.privKey, ciphertext)
 }
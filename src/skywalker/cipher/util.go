@@ -28,3 +28,18 @@ func cipherStreamXOR(stream _cipher.Stream, data []byte) []byte {
 	stream.XORKeyStream(data, data)
 	return data
 }
+
+/*
+ * 在in之后扩展n个字节，返回扩展后的整个切片以及扩展出来的部分，
+ * 容量足够时不重新分配内存
+ */
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
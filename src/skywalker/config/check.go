@@ -0,0 +1,280 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"skywalker/agent"
+	"skywalker/agent/base"
+	"skywalker/hook"
+	"skywalker/resolver"
+	"skywalker/util"
+	"sort"
+	"strings"
+)
+
+/* 检查配置文件发现的问题 */
+type Problem struct {
+	File string
+	Line int /* 问题所在的行，从1开始，0表示无法确定 */
+	Msg  string
+}
+
+func (p *Problem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Msg)
+	}
+	return fmt.Sprintf("%s: %s", p.File, p.Msg)
+}
+
+var (
+	coreSchema  = fieldOf(reflect.TypeOf(CoreConfig{})).Fields
+	proxySchema = fieldOf(reflect.TypeOf(ProxyConfig{})).Fields
+)
+
+/*
+ * 根据配置结构体的类型生成配置项的声明，配置项使用yaml中的名字，
+ * 带有secret:"true"标签的字段声明为秘密
+ */
+func fieldOf(t reflect.Type) *base.ConfigField {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return base.STRING_FIELD
+	case reflect.Bool:
+		return base.BOOL_FIELD
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return base.INT_FIELD
+	case reflect.Float32, reflect.Float64:
+		return base.FLOAT_FIELD
+	case reflect.Slice:
+		return base.ListField(fieldOf(t.Elem()))
+	case reflect.Map:
+		return base.MapOf(fieldOf(t.Elem()))
+	case reflect.Struct:
+		fields := base.ConfigSchema{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" { /* 未导出的字段 */
+				continue
+			}
+			name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if f.Tag.Get("secret") == "true" {
+				fields[name] = base.SECRET_FIELD
+			} else {
+				fields[name] = fieldOf(f.Type)
+			}
+		}
+		return base.MapField(fields)
+	}
+	return base.ANY_FIELD
+}
+
+type checker struct {
+	file     string
+	files    []*configFile
+	problems []*Problem
+}
+
+/* 查找配置项所在的文件和行 */
+func (c *checker) position(path string) (string, int) {
+	top := strings.Split(path, "/")[0]
+	for _, f := range c.files {
+		if _, ok := f.raw[top]; ok {
+			return f.path, f.line(path)
+		}
+	}
+	return c.file, 0
+}
+
+func (c *checker) add(path string, format string, args ...interface{}) {
+	file, line := c.position(path)
+	c.problems = append(c.problems, &Problem{
+		File: file,
+		Line: line,
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+/* 按照声明检查prefix下的配置 */
+func (c *checker) checkSchema(prefix string, schema base.ConfigSchema, v interface{}) int {
+	if v == nil || schema == nil {
+		return 0
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		c.add(prefix, "%s: must be map", prefix)
+		return 1
+	}
+	problems := schema.Check(m)
+	for _, p := range problems {
+		c.add(prefix+"/"+p.Path, "%s/%s: %s", prefix, p.Path, p.Msg)
+	}
+	return len(problems)
+}
+
+/* 检查代理的CA和SA配置 */
+func (c *checker) checkAgents(name string, raw map[string]interface{}) int {
+	n := 0
+	ca, _ := raw["clientAgent"].(string)
+	if schema, err := agent.CASchema(ca); err != nil {
+		c.add(name+"/clientAgent", "%s: %s", name, err)
+		n++
+	} else {
+		n += c.checkSchema(name+"/clientConfig", schema, raw["clientConfig"])
+	}
+	sa, _ := raw["serverAgent"].(string)
+	if schema, err := agent.SASchema(sa); err != nil {
+		c.add(name+"/serverAgent", "%s: %s", name, err)
+		n++
+	} else {
+		n += c.checkSchema(name+"/serverConfig", schema, raw["serverConfig"])
+	}
+	return n
+}
+
+/* 两个监听地址是否会冲突，空地址和未指定地址会监听所有的网卡 */
+func addrConflict(a, b string) bool {
+	ipa, ipb := net.ParseIP(a), net.ParseIP(b)
+	if a == "" || b == "" || (ipa != nil && ipa.IsUnspecified()) || (ipb != nil && ipb.IsUnspecified()) {
+		return true
+	} else if ipa != nil && ipb != nil {
+		return ipa.Equal(ipb)
+	}
+	return a == b
+}
+
+/* 检查监听端口的冲突，包括代理之间以及代理和管理服务之间 */
+func (c *checker) checkPorts(cConfig *CoreConfig, pConfigs []*ProxyConfig) {
+	for i, cfg := range pConfigs {
+		if cfg.BindPort == 0 {
+			continue
+		}
+		if inet := cConfig.Inet; inet != nil && inet.Port == int(cfg.BindPort) && addrConflict(inet.IP, cfg.BindAddr) {
+			c.add(cfg.Name+"/bindPort", "%s: port %d conflicts with core inet", cfg.Name, cfg.BindPort)
+		}
+		for _, other := range pConfigs[:i] {
+			if other.BindPort == cfg.BindPort && addrConflict(other.BindAddr, cfg.BindAddr) {
+				c.add(cfg.Name+"/bindPort", "%s: port %d conflicts with proxy %s", cfg.Name, cfg.BindPort, other.Name)
+			}
+		}
+	}
+}
+
+/* 检查代理的解析器、出站地址和钩子，以及CA、SA和钩子能否解析各自的配置 */
+func (c *checker) checkProxy(cConfig *CoreConfig, cfg *ProxyConfig) {
+	name := cfg.Name
+	if err := cfg.validate(); err != nil {
+		c.add(name, "%s", err)
+	}
+	if !cConfig.hasResolver(cfg.Resolver) {
+		c.add(name+"/resolver", "%s: resolver %s not found", name, cfg.Resolver)
+	}
+	if _, err := util.ParseOutbound(cfg.OutboundAddr, cfg.OutboundInterface, cfg.FWMark); err != nil {
+		c.add(name, "%s: %s", name, err)
+	}
+	hooksFound := true /* 钩子不存在时已经报告过了 */
+	for i, h := range cfg.CAHooks {
+		if !hook.Exists(h) {
+			c.add(fmt.Sprintf("%s/caHooks/%d", name, i), "%s: hook %s not found", name, h)
+			hooksFound = false
+		}
+	}
+	for i, h := range cfg.SAHooks {
+		if !hook.Exists(h) {
+			c.add(fmt.Sprintf("%s/saHooks/%d", name, i), "%s: hook %s not found", name, h)
+			hooksFound = false
+		}
+	}
+	if hooksFound {
+		if err := cfg.checkAgents(); err != nil {
+			c.add(name, "%s: %s", name, err)
+		}
+	}
+}
+
+/*
+ * 检查配置文件，返回发现的问题，没有问题时返回nil
+ * 检查的内容包括yaml语法、未知的配置项、配置项的类型、解析器、钩子和端口冲突，
+ * 只按照声明检查配置，不会初始化代理，也不会修改正在使用的日志、解析器和CA、SA的配置
+ */
+func Check(path string) []*Problem {
+	c := &checker{file: path}
+	cConfig, files, err := loadConfigFiles(path)
+	if err != nil {
+		return []*Problem{err.(*Problem)}
+	}
+	c.files = files
+	/* 所有文件中的配置，代理的名字不会重复 */
+	raw := make(map[string]interface{})
+	for i := len(files) - 1; i >= 0; i-- {
+		for key, val := range files[i].raw {
+			raw[key] = val
+		}
+	}
+
+	if c.checkSchema("core", coreSchema, raw["core"]) == 0 {
+		if err := resolver.Check(cConfig.Resolver, cConfig.Resolvers, cConfig.Hosts); err != nil {
+			c.add("core", "core: %s", err)
+		}
+	}
+	pConfigs := cConfig.GetProxyConfigs(proxyConfigs(files))
+	sort.Slice(pConfigs, func(i, j int) bool { return pConfigs[i].Name < pConfigs[j].Name })
+	for _, cfg := range pConfigs {
+		name := cfg.Name
+		section, ok := raw[name].(map[string]interface{})
+		if !ok {
+			c.add(name, "%s: must be map", name)
+			continue
+		}
+		n := c.checkSchema(name, proxySchema, section)
+		n += c.checkAgents(name, section)
+		if n > 0 { /* 配置项的类型有问题时解析出来的配置不可靠，避免重复报告 */
+			continue
+		}
+		c.checkProxy(cConfig, cfg)
+	}
+	c.checkPorts(cConfig, pConfigs)
+	return c.sorted()
+}
+
+/* 按照文件的加载顺序和行号排序 */
+func (c *checker) sorted() []*Problem {
+	order := make(map[string]int)
+	for i, f := range c.files {
+		order[f.path] = i
+	}
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		pi, pj := c.problems[i], c.problems[j]
+		if order[pi.File] != order[pj.File] {
+			return order[pi.File] < order[pj.File]
+		}
+		return pi.Line < pj.Line
+	})
+	return c.problems
+}
@@ -19,9 +19,12 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"skywalker/agent"
+	"skywalker/hook"
 	"skywalker/log"
+	"skywalker/resolver"
 	"skywalker/util"
 	"strings"
 )
@@ -31,16 +34,22 @@ type (
 	UnixConfig struct {
 		File     string `yaml:"file"`
 		Chmod    uint   `yaml:"chmod"` /* 套接字文件的权限 */
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
+		Username string `yaml:"username" secret:"true"`
+		Password string `yaml:"password" secret:"true"`
 	}
 
 	/* IP/TCP网络配置 */
 	InetConfig struct {
 		IP       string `yaml:"ip"`   /* 监听地址 */
 		Port     int    `yaml:"port"` /* 监听端口 */
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
+		Username string `yaml:"username" secret:"true"`
+		Password string `yaml:"password" secret:"true"`
+	}
+
+	/* Prometheus监控的HTTP服务配置 */
+	MetricsConfig struct {
+		IP   string `yaml:"ip"`   /* 监听地址 */
+		Port int    `yaml:"port"` /* 监听端口 */
 	}
 
 	/* 通用配置 */
@@ -49,14 +58,33 @@ type (
 		Inet        *InetConfig `yaml:"inet"`    /* TCP/IP服务配置 */
 		Log         *log.Config `yaml:"log"`     /* 日志配置 */
 		HistoryFile string      `yaml:"history"` /* 命令的历史记录文件 */
+
+		Hosts     map[string]string           `yaml:"hosts"`     /* 静态的域名解析，多个IP以逗号分隔 */
+		Resolver  *resolver.Config            `yaml:"resolver"`  /* 默认的DNS解析器 */
+		Resolvers map[string]*resolver.Config `yaml:"resolvers"` /* 以名字区分的DNS解析器，代理可以选择使用 */
+
+		Include []string       `yaml:"include"` /* 包含的其他配置文件，支持通配符 */
+		Metrics *MetricsConfig `yaml:"metrics"` /* 在/metrics提供Prometheus格式的监控数据，不配置时不监听 */
+
+		secrets []string /* 所有配置文件中的秘密，配置生效时才需要隐藏 */
 	}
 
 	/* 代理配置 */
 	ProxyConfig struct {
-		Name     string `yaml:"name"`
-		BindAddr string `yaml:"bindAddr"`
-		BindPort uint16 `yaml:"bindPort"`
-		Timeout  int    `yaml:"timeout"`
+		Name       string `yaml:"name"`
+		BindAddr   string `yaml:"bindAddr"`
+		BindPort   uint16 `yaml:"bindPort"`
+		Timeout    int    `yaml:"timeout"`
+		UDPTimeout int    `yaml:"udpTimeout"` /* UDP会话的空闲超时时间 */
+		Resolver   string `yaml:"resolver"`   /* 使用的DNS解析器，默认使用core中的resolver */
+
+		ConnectTimeout int    `yaml:"connectTimeout"` /* 连接远程服务器的超时时间 */
+		Prefer         string `yaml:"prefer"`         /* 连接远程服务器时优先的地址类型，ipv4或者ipv6 */
+		DrainTimeout   int    `yaml:"drainTimeout"`   /* 停止时等待已有连接结束的时间，负数表示立即关闭 */
+
+		OutboundAddr      string `yaml:"outboundAddr"`      /* 连接远程服务器使用的本地地址 */
+		OutboundInterface string `yaml:"outboundInterface"` /* 连接远程服务器使用的网卡，只支持linux */
+		FWMark            int    `yaml:"fwmark"`            /* 出站连接的防火墙标记，用于策略路由，只支持linux */
 
 		ClientAgent  string                 `yaml:"clientAgent"`
 		ClientConfig map[string]interface{} `yaml:"clientConfig"`
@@ -64,8 +92,9 @@ type (
 		ServerAgent  string                 `yaml:"serverAgent"`
 		ServerConfig map[string]interface{} `yaml:"serverConfig"`
 
-		CAHooks []string `yaml:"caHooks"`
-		SAHooks []string `yaml:"saHooks"`
+		CAHooks    []string                          `yaml:"caHooks"`
+		SAHooks    []string                          `yaml:"saHooks"`
+		HookConfig map[string]map[string]interface{} `yaml:"hookConfig"` /* 以钩子名区分的钩子配置 */
 
 		Log       *log.Config `yaml:"log"`
 		AutoStart bool        `yaml:"autoStart"`
@@ -95,7 +124,10 @@ const (
 	DEFAULT_USER_CONFIG = "~/.config/skywalker.yml"
 	DEFAULT_SYS_CONFIG  = "/etc/skywalker.yml"
 
-	DEFAULT_TIMEOUT = 30
+	DEFAULT_TIMEOUT     = 30
+	DEFAULT_UDP_TIMEOUT = 60
+
+	DEFAULT_DRAIN_TIMEOUT = 10
 )
 
 func (cfg *CoreConfig) init() {
@@ -117,7 +149,6 @@ func (cfg *CoreConfig) init() {
 	} else if cfg.Unix != nil && cfg.Unix.Chmod == 0 {
 		cfg.Unix.Chmod = 0644 /* 套接字默认的文件权限 */
 	}
-	log.InitDefault(cfg.Log)
 }
 
 /*
@@ -125,10 +156,20 @@ func (cfg *CoreConfig) init() {
  * 设置日志、插件并检查CA和SA
  */
 func (cfg *ProxyConfig) Init() error {
-	if cfg.Name == "all" {
-		return errors.New("'all' is reserved, not allowed as proxy name")
+	if err := cfg.validate(); err != nil {
+		return err
 	}
 	log.Init(cfg.Log)
+	/* 需要在CA和SA之前设置，agent初始化时可能会解析域名 */
+	if err := resolver.Bind(cfg.Name, cfg.Resolver); err != nil {
+		return err
+	}
+	/* 和解析器一样需要在CA和SA之前设置，SA的健康检查也会使用 */
+	outbound, err := util.ParseOutbound(cfg.OutboundAddr, cfg.OutboundInterface, cfg.FWMark)
+	if err != nil {
+		return fmt.Errorf("%s: %s", cfg.Name, err)
+	}
+	util.SetOutbound(cfg.Name, outbound)
 	ca := cfg.ClientAgent
 	sa := cfg.ServerAgent
 	if err := agent.CAInit(ca, cfg.Name, cfg.ClientConfig); err != nil {
@@ -136,6 +177,122 @@ func (cfg *ProxyConfig) Init() error {
 	} else if err := agent.SAInit(sa, cfg.Name, cfg.ServerConfig); err != nil {
 		return err
 	}
+	for _, h := range append(cfg.CAHooks, cfg.SAHooks...) {
+		if err := hook.Init(h, cfg.Name, cfg.HookConfig[h]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+ * 初始化代理的配置，全部成功之后才替换代理名和SA的映射，这样映射中只有当前配置中的代理；
+ * old是正在使用的代理配置，启动时为nil，重新加载时只初始化新增加的和配置改变了的代理，
+ * 没有改变的代理保留原来的CA、SA和钩子的配置（比如SA的健康检查），
+ * 删除了的代理以及改用了其他CA、SA的代理释放原来的CA、SA
+ */
+func InitProxies(pConfigs []*ProxyConfig, old []*ProxyConfig) error {
+	olds := make(map[string]*ProxyConfig)
+	for _, cfg := range old {
+		olds[cfg.Name] = cfg
+	}
+	proxies := make(map[string]string)
+	for _, cfg := range pConfigs {
+		if prev := olds[cfg.Name]; prev == nil || len(cfg.Diff(prev)) > 0 {
+			if err := cfg.Init(); err != nil {
+				return err
+			}
+		}
+		proxies[cfg.Name] = cfg.ServerAgent
+	}
+	agent.SetProxyServerAgents(proxies)
+
+	cur := make(map[string]*ProxyConfig)
+	for _, cfg := range pConfigs {
+		cur[cfg.Name] = cfg
+	}
+	for name, prev := range olds {
+		cfg := cur[name]
+		if cfg == nil || !strings.EqualFold(cfg.ClientAgent, prev.ClientAgent) {
+			agent.CARelease(prev.ClientAgent, name)
+		}
+		if cfg == nil || !strings.EqualFold(cfg.ServerAgent, prev.ServerAgent) {
+			agent.SARelease(prev.ServerAgent, name)
+		}
+	}
+	return nil
+}
+
+/*
+ * 只检查代理的配置，不修改任何全局状态，cConfig是代理所在的配置，
+ * 检查通过之后Init只会因为配置之外的原因（比如文件被删除）失败
+ */
+func (cfg *ProxyConfig) check(cConfig *CoreConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	if !cConfig.hasResolver(cfg.Resolver) {
+		return fmt.Errorf("%s: resolver %s not found", cfg.Name, cfg.Resolver)
+	}
+	if _, err := util.ParseOutbound(cfg.OutboundAddr, cfg.OutboundInterface, cfg.FWMark); err != nil {
+		return fmt.Errorf("%s: %s", cfg.Name, err)
+	} else if err := cfg.checkAgents(); err != nil {
+		return fmt.Errorf("%s: %s", cfg.Name, err)
+	}
+	return nil
+}
+
+/* 只解析CA、SA和钩子的配置，不修改它们的全局配置 */
+func (cfg *ProxyConfig) checkAgents() error {
+	if err := agent.CACheck(cfg.ClientAgent, cfg.Name, cfg.ClientConfig); err != nil {
+		return err
+	} else if err := agent.SACheck(cfg.ServerAgent, cfg.Name, cfg.ServerConfig); err != nil {
+		return err
+	}
+	for _, h := range append(cfg.CAHooks, cfg.SAHooks...) {
+		if err := hook.Check(h, cfg.Name, cfg.HookConfig[h]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* 是否配置了名为name的解析器，空的名字表示默认解析器 */
+func (cConfig *CoreConfig) hasResolver(name string) bool {
+	if name == "" || name == resolver.DEFAULT {
+		return true
+	}
+	_, ok := cConfig.Resolvers[name]
+	return ok
+}
+
+/*
+ * 应用检查过的core配置：日志、需要隐藏的秘密和DNS解析器，
+ * old是正在使用的配置，启动时为nil，解析器的配置没有改变时保留原来的解析器和缓存
+ */
+func (cConfig *CoreConfig) Init(old *CoreConfig) error {
+	log.InitDefault(cConfig.Log)
+	log.SetSecrets(cConfig.secrets)
+	changed := old == nil
+	for _, name := range cConfig.Diff(old) {
+		if name == "resolver" || name == "resolvers" || name == "hosts" {
+			changed = true
+		}
+	}
+	if changed {
+		return resolver.Init(cConfig.Resolver, cConfig.Resolvers, cConfig.Hosts)
+	}
+	return nil
+}
+
+/* 检查代理名和不需要初始化的配置 */
+func (cfg *ProxyConfig) validate() error {
+	if cfg.Name == "all" {
+		return errors.New("'all' is reserved, not allowed as proxy name")
+	}
+	if cfg.Prefer != "" && cfg.Prefer != resolver.PREFER_IPV4 && cfg.Prefer != resolver.PREFER_IPV6 {
+		return fmt.Errorf("%s: prefer must be %s or %s", cfg.Name, resolver.PREFER_IPV4, resolver.PREFER_IPV6)
+	}
 	return nil
 }
 
@@ -159,6 +316,15 @@ func (cConfig *CoreConfig) GetProxyConfigs(rawConfigs map[string]*ProxyConfig) [
 		if cfg.Timeout == 0 {
 			cfg.Timeout = DEFAULT_TIMEOUT
 		}
+		if cfg.UDPTimeout == 0 {
+			cfg.UDPTimeout = DEFAULT_UDP_TIMEOUT
+		}
+		if cfg.ConnectTimeout == 0 {
+			cfg.ConnectTimeout = util.TCP_CONNECT_TIMEOUT
+		}
+		if cfg.DrainTimeout == 0 {
+			cfg.DrainTimeout = DEFAULT_DRAIN_TIMEOUT
+		}
 		cfg.Name = name
 		cfg.Log.Name = name
 		pConfigs = append(pConfigs, cfg)
@@ -217,33 +383,73 @@ func Init() {
 	}
 	var err error
 	if gCore, gConfigs, err = LoadConfigFromPath(cfile); err != nil {
-		util.FatalError("%s: %s", cfile, err)
+		util.FatalError("%s", err)
+	} else if err = gCore.Init(nil); err != nil {
+		util.FatalError("%s", err)
 	}
 }
 
-func LoadConfigFromPath(path string) (*CoreConfig, map[string]*ProxyConfig, error) {
-	var yamlMap map[string]interface{}
-	var data []byte
+/*
+ * forctl只需要core中的配置连接skywalker，
+ * 完整的配置有错误时只读取主配置文件中的core，返回完整配置的错误
+ */
+func InitCore() error {
+	cfile := GetConfigFilePath()
+	if len(cfile) == 0 {
+		util.FatalError("No Config Found!")
+	}
+	var err error
+	if gCore, gConfigs, err = LoadConfigFromPath(cfile); err != nil {
+		gCore, gConfigs = loadCoreSection(cfile), map[string]*ProxyConfig{}
+		gCore.init()
+		log.InitDefault(gCore.Log)
+		return err
+	}
+	return gCore.Init(nil)
+}
+
+/* 重新加载配置之后替换全局配置 */
+func Update(cConfig *CoreConfig, pConfigs map[string]*ProxyConfig) {
+	gCore = cConfig
+	gConfigs = pConfigs
+}
 
-	if err := util.LoadYamlFile(path, &yamlMap); err != nil { /* 读取配置文件 */
+/*
+ * 读取并检查配置文件以及包含的配置文件，不修改任何全局状态，
+ * 返回的配置通过CoreConfig.Init和InitProxies生效，
+ * core和文件的错误是*Problem，包含出错的文件和行号
+ */
+func LoadConfigFromPath(path string) (*CoreConfig, map[string]*ProxyConfig, error) {
+	cConfig, files, err := loadConfigFiles(path)
+	if err != nil {
 		return nil, nil, err
 	}
+	if err := resolver.Check(cConfig.Resolver, cConfig.Resolvers, cConfig.Hosts); err != nil {
+		return nil, nil, &Problem{File: path, Line: files[0].lines["core"], Msg: err.Error()}
+	}
+	for _, f := range files {
+		cConfig.secrets = append(cConfig.secrets, f.secrets...)
+	}
+	pConfigs := proxyConfigs(files)
+	for _, cfg := range cConfig.GetProxyConfigs(pConfigs) {
+		if err := cfg.check(cConfig); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cConfig, pConfigs, nil
+}
 
-	/*
-	 * 将yaml数据读取到一个通用的map[string]interface{}中
-	 * 然后分离log和代理，分别读取
-	 */
-	cConfig := CoreConfig{}
+/* 分别读取每个文件中的代理配置，core已经读取过了 */
+func proxyConfigs(files []*configFile) map[string]*ProxyConfig {
 	pConfigs := make(map[string]*ProxyConfig)
-	if yamlMap["core"] != nil { /* 读取log并从map中删除 */
-		data = util.YamlMarshal(yamlMap["core"])
-		util.YamlUnmarshal(data, &cConfig)
-		delete(yamlMap, "core")
+	for _, f := range files {
+		raw := make(map[string]interface{})
+		for key, val := range f.raw {
+			if key != "core" {
+				raw[key] = val
+			}
+		}
+		util.YamlUnmarshal(util.YamlMarshal(raw), &pConfigs)
 	}
-	cConfig.init()
-
-	data = util.YamlMarshal(yamlMap)
-	util.YamlUnmarshal(data, &pConfigs)
-
-	return &cConfig, pConfigs, nil
+	return pConfigs
 }
@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+/* 空的map和slice当作没有配置 */
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return false
+}
+
+/*
+ * 比较两份配置，返回改变了的配置项，配置项使用yaml中的名字，
+ * 没有yaml名字的字段不参与比较
+ */
+func diffFields(cur, old interface{}) []string {
+	var changes []string
+	cv := reflect.Indirect(reflect.ValueOf(cur))
+	ov := reflect.Indirect(reflect.ValueOf(old))
+	t := cv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		cf, of := cv.Field(i), ov.Field(i)
+		if isEmpty(cf) && isEmpty(of) {
+			continue
+		} else if !reflect.DeepEqual(cf.Interface(), of.Interface()) {
+			changes = append(changes, name)
+		}
+	}
+	return changes
+}
+
+/* 和旧的代理配置比较，old为nil时所有的配置项都当作改变了 */
+func (cfg *ProxyConfig) Diff(old *ProxyConfig) []string {
+	if old == nil {
+		old = &ProxyConfig{}
+	}
+	return diffFields(cfg, old)
+}
+
+/* 和旧的通用配置比较 */
+func (cfg *CoreConfig) Diff(old *CoreConfig) []string {
+	if old == nil {
+		old = &CoreConfig{}
+	}
+	return diffFields(cfg, old)
+}
@@ -35,6 +35,7 @@ var (
 /* 命令行参数 */
 type Flag struct {
 	CFile string
+	Test  bool /* 只检查配置文件 */
 	Args  []string
 }
 
@@ -55,6 +56,7 @@ func GetFlag() *Flag {
 
 func getFlag() *Flag {
 	cfile := flag.String("c", "", "config file. if not specialed, ~/.config/skywalker.yml or /etc/skywalker.yml will be used")
+	test := flag.Bool("t", false, "check the config file and exit, no proxy will be started")
 	help := flag.Bool("help", false, "show help message")
 	version := flag.Bool("version", false, "show skywalker version")
 	flag.Parse()
@@ -64,7 +66,7 @@ func getFlag() *Flag {
 	} else if *version {
 		printVersion()
 	} else {
-		return &Flag{CFile: *cfile, Args: flag.Args()}
+		return &Flag{CFile: *cfile, Test: *test, Args: flag.Args()}
 	}
 
 	os.Exit(0)
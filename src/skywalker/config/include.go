@@ -0,0 +1,255 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package config
+
+import (
+	"fmt"
+	"github.com/wiiiky/yaml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"skywalker/agent/base"
+	"skywalker/util"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * 配置文件可以拆分成多个文件
+ * 主配置文件core中的include指定其他配置文件（支持通配符，相对路径相对于主配置文件所在的目录），
+ * 主配置文件所在目录下skywalker.d中的.yml和.yaml文件也会自动加载；
+ * 其他配置文件中只能配置代理，代理的名字不能重复，
+ * ~开头的配置在之后加载的文件中共享，可以引用其中定义的锚点
+ */
+
+const (
+	CONFIG_DIR = "skywalker.d"
+)
+
+var (
+	/* yaml错误中的行号 */
+	yamlLineRegexp = regexp.MustCompile(`line (\d+): `)
+)
+
+/* 一个配置文件 */
+type configFile struct {
+	path  string
+	lines map[string]int         /* 每个配置项所在的行 */
+	raw   map[string]interface{} /* 文件中的配置，不包括共享的~配置 */
+
+	secrets []string /* 替换进配置的秘密和声明为秘密的配置项的值，配置生效时才需要隐藏 */
+}
+
+/* 按照加载顺序共享的~配置 */
+type sharedSections struct {
+	names    []string
+	sections map[string]string
+}
+
+func (p *Problem) Error() string {
+	return p.String()
+}
+
+/* 把yaml错误转换成Problem，offset是文件之前加入的共享配置的行数 */
+func yamlProblem(path string, offset int, err error) *Problem {
+	p := &Problem{File: path, Msg: strings.TrimPrefix(err.Error(), "yaml: ")}
+	if m := yamlLineRegexp.FindStringSubmatchIndex(p.Msg); m != nil {
+		line, _ := strconv.Atoi(p.Msg[m[2]:m[3]])
+		if line > offset {
+			p.Line = line - offset
+		}
+		p.Msg = p.Msg[:m[0]] + p.Msg[m[1]:]
+	}
+	return p
+}
+
+/* 顶层配置项在文件中的原文，用于共享~配置 */
+func splitSections(data []byte, lines map[string]int) map[string]string {
+	var names []string
+	for path := range lines {
+		if !strings.Contains(path, "/") {
+			names = append(names, path)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return lines[names[i]] < lines[names[j]] })
+
+	text := strings.Split(string(data), "\n")
+	sections := make(map[string]string)
+	for i, name := range names {
+		end := len(text)
+		if i+1 < len(names) {
+			end = lines[names[i+1]] - 1
+		}
+		sections[name] = strings.Join(text[lines[name]-1:end], "\n")
+	}
+	return sections
+}
+
+/* 读取一个配置文件，文件之前加入还没有在文件中定义的共享配置 */
+func (s *sharedSections) load(path string) (*configFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &Problem{File: path, Msg: err.Error()}
+	}
+	lines := scanLines(content)
+
+	var names, prefix []string
+	for _, name := range s.names {
+		if _, ok := lines[name]; !ok {
+			names = append(names, name)
+			prefix = append(prefix, s.sections[name])
+		}
+	}
+	data, offset := content, 0
+	if len(prefix) > 0 {
+		shared := strings.Join(prefix, "\n") + "\n"
+		offset = strings.Count(shared, "\n")
+		data = append([]byte(shared), content...)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, yamlProblem(path, offset, err)
+	}
+	/* 删除共享的配置，只保留文件中定义的 */
+	for _, name := range names {
+		delete(raw, name)
+	}
+
+	for name, section := range splitSections(content, lines) {
+		if !strings.HasPrefix(name, "~") {
+			continue
+		} else if _, ok := s.sections[name]; !ok {
+			s.names = append(s.names, name)
+		}
+		s.sections[name] = section
+	}
+	return &configFile{path: path, lines: lines, raw: raw}, nil
+}
+
+/*
+ * 返回需要加载的其他配置文件，按照加载顺序，不包括主配置文件，
+ * 以及需要监视的模式（绝对路径），符合模式的文件改变、增加或者删除时需要重新加载
+ */
+func includedFiles(path string, include []string) ([]string, []string, error) {
+	var files, watches []string
+	base := filepath.Dir(path)
+	dir := filepath.Join(base, CONFIG_DIR)
+	patterns := append(append([]string{}, include...), filepath.Join(dir, "*.yml"), filepath.Join(dir, "*.yaml"))
+
+	mainPath, _ := filepath.Abs(path)
+	seen := map[string]bool{mainPath: true}
+	watched := map[string]bool{}
+	for _, pattern := range patterns {
+		pattern = util.ResolveHomePath(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(base, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("include %s: %s", pattern, err)
+		}
+		/* 目录中有通配符时无法监视 */
+		abs, _ := filepath.Abs(pattern)
+		if !strings.ContainsAny(filepath.Dir(abs), "*?[") && !watched[abs] {
+			watched[abs] = true
+			watches = append(watches, abs)
+		}
+		for _, m := range matches {
+			abs, _ := filepath.Abs(m)
+			if info, err := os.Stat(abs); err != nil || !info.Mode().IsRegular() || seen[abs] {
+				continue
+			}
+			seen[abs] = true
+			files = append(files, m)
+		}
+	}
+	return files, watches, nil
+}
+
+/* 读取主配置文件以及包含的配置文件，返回core配置和所有的配置文件 */
+func loadConfigFiles(path string) (*CoreConfig, []*configFile, error) {
+	shared := &sharedSections{sections: make(map[string]string)}
+	mainFile, err := shared.load(path)
+	if err != nil {
+		return nil, nil, err
+	} else if err := mainFile.resolveSecrets(); err != nil {
+		return nil, nil, err
+	}
+	cConfig := &CoreConfig{}
+	if mainFile.raw["core"] != nil {
+		util.YamlUnmarshal(util.YamlMarshal(mainFile.raw["core"]), cConfig)
+	}
+	cConfig.init()
+	includes, _, err := includedFiles(path, cConfig.Include)
+	if err != nil {
+		return nil, nil, &Problem{File: path, Line: mainFile.lines["core/include"], Msg: err.Error()}
+	}
+
+	files := []*configFile{mainFile}
+	owners := make(map[string]string)
+	for key := range mainFile.raw {
+		owners[key] = path
+	}
+	for _, include := range includes {
+		f, err := shared.load(include)
+		if err != nil {
+			return nil, nil, err
+		} else if err := f.resolveSecrets(); err != nil {
+			return nil, nil, err
+		}
+		for key := range f.raw {
+			if key == "core" {
+				return nil, nil, &Problem{File: include, Line: f.lines[key], Msg: "core is only allowed in the main config file"}
+			} else if strings.HasPrefix(key, "~") {
+				continue
+			} else if owner, ok := owners[key]; ok {
+				return nil, nil, &Problem{File: include, Line: f.lines[key], Msg: fmt.Sprintf("proxy %s is already defined in %s", key, owner)}
+			}
+			owners[key] = include
+		}
+		files = append(files, f)
+	}
+	return cConfig, files, nil
+}
+
+/* 只读取主配置文件中的core，忽略所有错误 */
+func loadCoreSection(path string) *CoreConfig {
+	cConfig := &CoreConfig{}
+	shared := &sharedSections{sections: make(map[string]string)}
+	if f, err := shared.load(path); err == nil && f.raw["core"] != nil {
+		if core, err := f.resolve("core", base.MapField(coreSchema), f.raw["core"]); err == nil {
+			util.YamlUnmarshal(util.YamlMarshal(core), cConfig)
+		}
+	}
+	return cConfig
+}
+
+/*
+ * 需要监视的文件和模式，包括主配置文件、包含的配置文件以及include和skywalker.d的模式，
+ * 符合的文件改变、增加或者删除时需要重新加载配置，同一目录中的其他文件（比如编辑器的临时文件）不会触发；
+ * 主配置文件有错误时只监视主配置文件
+ */
+func WatchPaths(path string) []string {
+	files, watches, err := includedFiles(path, loadCoreSection(path).Include)
+	if err != nil {
+		return []string{path}
+	}
+	return append(append([]string{path}, files...), watches...)
+}
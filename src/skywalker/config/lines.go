@@ -0,0 +1,222 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+ * 配置项所在的行
+ * yaml库不提供节点的位置，这里按照缩进扫描块格式的映射和列表，
+ * 路径是从文档开始的键和列表下标，以/连接，比如p1/serverConfig/serverAddr[]/0/password；
+ * 流格式（{}和[]）中的配置项不记录，查找时使用上一级配置项的行，
+ * 语法错误由yaml库解析时报告
+ */
+
+/* 扫描时的上一级配置项 */
+type lineNode struct {
+	indent int    /* 键或者列表项-所在的列 */
+	path   string /* 配置项的路径 */
+	item   bool   /* 是否是列表项 */
+	open   bool   /* 键后面没有值，值在之后的行中 */
+	next   int    /* 下一个列表项的下标 */
+}
+
+type lineScanner struct {
+	lines map[string]int
+	stack []*lineNode
+
+	block int /* 块标量（|和>）所在的列，之后缩进更多的行都是标量的内容，-1表示不在块标量中 */
+	flow  int /* 未闭合的流格式括号数 */
+}
+
+/* 返回每个配置项所在的行，从1开始 */
+func scanLines(data []byte) map[string]int {
+	s := &lineScanner{lines: make(map[string]int), block: -1}
+	for i, line := range strings.Split(string(data), "\n") {
+		s.scan(i+1, strings.TrimRight(line, "\r"))
+	}
+	return s.lines
+}
+
+func joinLinePath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+func (s *lineScanner) top() *lineNode {
+	if len(s.stack) == 0 {
+		return &lineNode{indent: -1}
+	}
+	return s.stack[len(s.stack)-1]
+}
+
+func (s *lineScanner) scan(lineno int, line string) {
+	text := strings.TrimLeft(line, " ")
+	indent := len(line) - len(text)
+	if s.block >= 0 {
+		if text == "" || indent > s.block {
+			return
+		}
+		s.block = -1
+	}
+	if s.flow > 0 {
+		s.flow += flowDepth(text)
+		return
+	}
+	text = stripComment(text)
+	if text == "" {
+		return
+	} else if indent == 0 && (strings.HasPrefix(text, "---") || strings.HasPrefix(text, "...")) {
+		s.stack = nil
+		return
+	}
+
+	/* 列表项，一行可能有多个嵌套的- */
+	for text == "-" || strings.HasPrefix(text, "- ") {
+		for len(s.stack) > 0 {
+			t := s.top()
+			if t.indent < indent || (t.indent == indent && !t.item && t.open) {
+				break
+			}
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+		parent := s.top()
+		path := joinLinePath(parent.path, strconv.Itoa(parent.next))
+		parent.next++
+		s.lines[path] = lineno
+		s.stack = append(s.stack, &lineNode{indent: indent, path: path, item: true})
+
+		rest := strings.TrimLeft(text[1:], " ")
+		indent += len(text) - len(rest)
+		text = rest
+	}
+	if text == "" {
+		return
+	}
+
+	key, value, ok := splitKey(text)
+	if !ok {
+		s.value(indent, text)
+		return
+	}
+	for len(s.stack) > 0 && s.top().indent >= indent {
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+	path := joinLinePath(s.top().path, key)
+	s.lines[path] = lineno
+	node := &lineNode{indent: indent, path: path}
+	s.stack = append(s.stack, node)
+	node.open = s.value(indent, value)
+}
+
+/* 处理值，返回值是否在之后的行中 */
+func (s *lineScanner) value(indent int, value string) bool {
+	/* 跳过锚点和标签 */
+	for strings.HasPrefix(value, "&") || strings.HasPrefix(value, "!") {
+		i := strings.IndexByte(value, ' ')
+		if i < 0 {
+			return true
+		}
+		value = strings.TrimLeft(value[i:], " ")
+	}
+	switch {
+	case value == "":
+		return true
+	case value[0] == '|' || value[0] == '>':
+		s.block = indent
+	case value[0] == '{' || value[0] == '[':
+		s.flow = flowDepth(value)
+	}
+	return false
+}
+
+/* 分离键和值，键可以使用引号 */
+func splitKey(text string) (string, string, bool) {
+	var key, rest string
+	if q := text[0]; q == '"' || q == '\'' {
+		end := strings.IndexByte(text[1:], q)
+		if end < 0 {
+			return "", "", false
+		}
+		key, rest = text[1:end+1], text[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else if q == '{' || q == '[' {
+		return "", "", false
+	} else if i := strings.Index(text, ": "); i >= 0 {
+		key, rest = text[:i], text[i+1:]
+	} else if strings.HasSuffix(text, ":") {
+		key = text[:len(text)-1]
+	} else {
+		return "", "", false
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", false
+	}
+	return strings.TrimRight(key, " "), strings.TrimSpace(rest), true
+}
+
+/* 删除行末的注释，引号中的#不是注释 */
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return strings.TrimRight(text, " ")
+}
+
+/* 流格式中未闭合的括号数，忽略引号中的括号 */
+func flowDepth(text string) int {
+	var quote byte
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return depth
+		}
+	}
+	return depth
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"skywalker/agent"
+	"skywalker/agent/base"
+	"skywalker/util"
+	"strings"
+)
+
+/*
+ * 配置中的秘密
+ * 字符串类型的配置项可以写成{env: NAME}或者{file: PATH}，读取配置时替换成环境变量或者文件的内容，
+ * 文件末尾的换行会被删除，相对路径相对于配置文件所在的目录；
+ * 只有声明为字符串（或者没有声明类型）的配置项才会替换，日志配置中的file等不受影响，
+ * 替换后的值以及声明为秘密的配置项（用户名和密码）的值在日志和forctl info中隐藏
+ */
+
+const (
+	SECRET_ENV  = "env"
+	SECRET_FILE = "file"
+)
+
+/* 是否是秘密的引用：只有一个env或者file键，并且值是字符串 */
+func secretRef(v interface{}) (string, string, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", "", false
+	}
+	for kind, val := range m {
+		if name, ok := val.(string); ok && (kind == SECRET_ENV || kind == SECRET_FILE) {
+			return kind, name, true
+		}
+	}
+	return "", "", false
+}
+
+/* 查找配置项所在的行，找不到时使用上一级配置项的行 */
+func (f *configFile) line(path string) int {
+	for path != "" {
+		if line, ok := f.lines[path]; ok {
+			return line
+		}
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return 0
+}
+
+func (f *configFile) readSecret(kind, name string) (string, error) {
+	if kind == SECRET_ENV {
+		if val, ok := os.LookupEnv(name); ok {
+			return val, nil
+		}
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	path := util.ResolveHomePath(name)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(f.path), path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+/* 按照声明替换v中的秘密，返回替换之后的值 */
+func (f *configFile) resolve(path string, field *base.ConfigField, v interface{}) (interface{}, error) {
+	if field == nil {
+		field = base.ANY_FIELD
+	}
+	switch field.Type {
+	case base.CONFIG_STRING, base.CONFIG_STRINGS, base.CONFIG_ANY:
+		if kind, name, ok := secretRef(v); ok {
+			val, err := f.readSecret(kind, name)
+			if err != nil {
+				return nil, &Problem{File: f.path, Line: f.line(path), Msg: fmt.Sprintf("%s: %s", path, err)}
+			}
+			f.secrets = append(f.secrets, val)
+			return val, nil
+		} else if s, ok := v.(string); ok && field.Secret && s != "" {
+			f.secrets = append(f.secrets, s)
+		}
+	}
+
+	var err error
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if field.Type != base.CONFIG_MAP && field.Type != base.CONFIG_ANY {
+			break
+		}
+		for key, e := range val {
+			elem := field.Elem
+			if field.Fields != nil {
+				elem = field.Fields[key]
+			}
+			if val[key], err = f.resolve(path+"/"+key, elem, e); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		elem := field.Elem
+		if field.Type == base.CONFIG_STRINGS {
+			elem = base.STRING_FIELD
+		} else if field.Type != base.CONFIG_LIST && field.Type != base.CONFIG_ANY {
+			break
+		}
+		for i, e := range val {
+			if val[i], err = f.resolve(fmt.Sprintf("%s/%d", path, i), elem, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+/* 代理配置的声明，包括CA和SA声明的配置项 */
+func proxyField(raw map[string]interface{}) *base.ConfigField {
+	fields := base.ConfigSchema{}
+	for key, field := range proxySchema {
+		fields[key] = field
+	}
+	ca, _ := raw["clientAgent"].(string)
+	if schema, _ := agent.CASchema(ca); schema != nil {
+		fields["clientConfig"] = base.MapField(schema)
+	}
+	sa, _ := raw["serverAgent"].(string)
+	if schema, _ := agent.SASchema(sa); schema != nil {
+		fields["serverConfig"] = base.MapField(schema)
+	}
+	return base.MapField(fields)
+}
+
+/* 替换文件中core和代理配置中的秘密，~开头的配置只用于共享，不替换 */
+func (f *configFile) resolveSecrets() error {
+	var err error
+	for key, val := range f.raw {
+		if strings.HasPrefix(key, "~") {
+			continue
+		}
+		field := base.MapField(coreSchema)
+		if key != "core" {
+			m, _ := val.(map[string]interface{})
+			field = proxyField(m)
+		}
+		if f.raw[key], err = f.resolve(key, field, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -21,9 +21,13 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"skywalker/agent"
+	"skywalker/agent/base"
+	"skywalker/log"
 	"skywalker/proxy"
+	"skywalker/resolver"
 	"skywalker/rpc"
-	"skywalker/util"
+	"sync"
 )
 
 type (
@@ -89,6 +93,16 @@ func init() {
 			RequestField: "GetCommon",
 			PostHandle:   nil,
 		},
+		rpc.RequestType_RESOLVER: &Command{
+			Handle:       handleResolver,
+			RequestField: "GetCommon",
+			PostHandle:   nil,
+		},
+		rpc.RequestType_CHECK: &Command{
+			Handle:       handleCheck,
+			RequestField: "GetCommon",
+			PostHandle:   nil,
+		},
 	}
 }
 
@@ -137,7 +151,7 @@ func handleStatus(f *Force, v interface{}) (*rpc.Response, error) {
 
 	return &rpc.Response{
 		Type:   rpc.RequestType_STATUS,
-		Status: &rpc.StatusResponse{Data: result},
+		Status: &rpc.StatusResponse{Data: result, ReloadErr: f.GetReloadError()},
 	}, nil
 }
 
@@ -187,23 +201,31 @@ func handleStop(f *Force, v interface{}) (*rpc.Response, error) {
 	} else if len(names) == 1 && names[0] == "all" {
 		names = f.GetProxyNames()
 	}
-	for _, name := range names {
-		p := f.proxies[name]
-		status := rpc.StopResponse_UNRUNNING
-		errmsg := ""
-		if p == nil {
-			status = rpc.StopResponse_ERROR
-			errmsg = fmt.Sprintf("no such proxy")
-		} else if p.Status == proxy.STATUS_RUNNING {
-			if e := p.Stop(); e != nil {
-				status = rpc.StopResponse_ERROR
-				errmsg = e.Error()
-			} else {
-				status = rpc.StopResponse_STOPPED
+	/* 每个代理都可能等待连接结束，同时停止 */
+	var wg sync.WaitGroup
+	result = make([]*rpc.StopResponse_Data, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string, p *proxy.Proxy) {
+			defer wg.Done()
+			data := &rpc.StopResponse_Data{Name: name, Status: rpc.StopResponse_UNRUNNING}
+			if p == nil {
+				data.Status = rpc.StopResponse_ERROR
+				data.Err = fmt.Sprintf("no such proxy")
+			} else if p.Status == proxy.STATUS_RUNNING {
+				if drained, killed, e := p.Stop(); e != nil {
+					data.Status = rpc.StopResponse_ERROR
+					data.Err = e.Error()
+				} else {
+					data.Status = rpc.StopResponse_STOPPED
+					data.Drained = int32(drained)
+					data.Killed = int32(killed)
+				}
 			}
-		}
-		result = append(result, &rpc.StopResponse_Data{Name: name, Status: status, Err: errmsg})
+			result[i] = data
+		}(i, name, f.proxies[name])
 	}
+	wg.Wait()
 	return &rpc.Response{
 		Type: rpc.RequestType_STOP,
 		Stop: &rpc.StopResponse{Data: result},
@@ -243,17 +265,27 @@ func handleRestart(f *Force, v interface{}) (*rpc.Response, error) {
 	}, nil
 }
 
+/* 转化CA或SA的信息，声明为秘密的配置项不论长短都隐藏，其他的值再按子串隐藏秘密 */
+func agentInfo(schema base.ConfigSchema, infos []map[string]string) []*rpc.InfoResponse_Info {
+	var result []*rpc.InfoResponse_Info
+	for _, info := range infos {
+		value := log.Redact(info["value"])
+		if schema.IsSecret(info["key"]) {
+			value = log.REDACTED
+		}
+		result = append(result, &rpc.InfoResponse_Info{Key: info["key"], Value: value})
+	}
+	return result
+}
+
 /* 代理详情 */
 func getProxyInfoData(p *proxy.Proxy) *rpc.InfoResponse_Data {
-	var caInfo, saInfo []*rpc.InfoResponse_Info
 	status := rpc.InfoResponse_Status(p.Status)
 	ca, sa := p.GetAgents()
-	for _, info := range ca.GetInfo() {
-		caInfo = append(caInfo, &rpc.InfoResponse_Info{Key: info["key"], Value: info["value"]})
-	}
-	for _, info := range sa.GetInfo() {
-		saInfo = append(saInfo, &rpc.InfoResponse_Info{Key: info["key"], Value: info["value"]})
-	}
+	caSchema, _ := agent.CASchema(p.CAName)
+	saSchema, _ := agent.SASchema(p.SAName)
+	caInfo := agentInfo(caSchema, ca.GetInfo())
+	saInfo := agentInfo(saSchema, sa.GetInfo())
 	return &rpc.InfoResponse_Data{
 		Name:         p.Name,
 		Cname:        p.CAName,
@@ -306,23 +338,40 @@ func handleInfo(f *Force, v interface{}) (*rpc.Response, error) {
 }
 
 func handleReload(f *Force, v interface{}) (*rpc.Response, error) {
-	unchanged, added, deleted, updated, err := f.Reload()
+	result, err := f.Reload()
 	if err != nil {
 		return nil, err
 	}
-
-	result := &rpc.ReloadResponse{
-		Unchanged: unchanged,
-		Added:     added,
-		Deleted:   deleted,
-		Updated:   updated,
-	}
 	return &rpc.Response{
 		Type:   rpc.RequestType_RELOAD,
 		Reload: result,
 	}, nil
 }
 
+/*
+ * 检查当前使用的配置文件，不接受其他文件，
+ * 否则可以通过命令端口读取任意的文件和环境变量，其他文件由forctl自己检查
+ */
+func handleCheck(f *Force, v interface{}) (*rpc.Response, error) {
+	req := v.(*rpc.CommonRequest)
+	if len(req.GetName()) > 0 {
+		return nil, errors.New("only the config file in use can be checked")
+	}
+	cfile, problems := f.CheckConfig()
+	result := &rpc.CheckResponse{File: cfile}
+	for _, p := range problems {
+		result.Problems = append(result.Problems, &rpc.CheckResponse_Problem{
+			File: p.File,
+			Line: int32(p.Line),
+			Msg:  p.Msg,
+		})
+	}
+	return &rpc.Response{
+		Type:  rpc.RequestType_CHECK,
+		Check: result,
+	}, nil
+}
+
 func handleQuit(f *Force, v interface{}) (*rpc.Response, error) {
 	result := &rpc.QuitResponse{
 		Status: rpc.QuitResponse_QUITED,
@@ -346,7 +395,7 @@ func handleClearCache(f *Force, v interface{}) (*rpc.Response, error) {
 	result := &rpc.ClearCacheResponse{
 		Status: rpc.ClearCacheResponse_SUCCESS,
 	}
-	util.DNSCache.Flush()
+	resolver.Flush()
 	return &rpc.Response{
 		Type:  rpc.RequestType_CLEARCACHE,
 		Clear: result,
@@ -366,6 +415,12 @@ func getProxyListData(p *proxy.Proxy) *rpc.ListResponse_Data {
 			ClientAddr:    c.ClientAddr,
 			ConnectedTime: c.ConnectedTime,
 			ClosedTime:    c.ClosedTime,
+
+			Sent:           c.Sent,
+			Received:       c.Received,
+			SentRate:       c.SentQueue.Rate(),
+			ReceivedRate:   c.ReceivedQueue.Rate(),
+			ConnectLatency: c.ConnectLatency,
 		})
 	}
 	p.Info.Unlock()
@@ -393,3 +448,42 @@ func handleList(f *Force, v interface{}) (*rpc.Response, error) {
 		List: &rpc.ListResponse{Data: result},
 	}, nil
 }
+
+/* DNS解析器的统计数据，没有指定名字时返回所有解析器 */
+func handleResolver(f *Force, v interface{}) (*rpc.Response, error) {
+	var result []*rpc.ResolverResponse_Data
+
+	req := v.(*rpc.CommonRequest)
+	names := map[string]bool{}
+	for _, name := range req.GetName() {
+		names[name] = true
+	}
+	for _, stats := range resolver.GetStats() {
+		if len(names) > 0 && !names[stats.Name] {
+			continue
+		}
+		data := &rpc.ResolverResponse_Data{
+			Name:         stats.Name,
+			Queries:      stats.Queries,
+			Hits:         stats.Hits,
+			NegativeHits: stats.NegativeHits,
+			Merged:       stats.Merged,
+			Misses:       stats.Misses,
+			Failures:     stats.Failures,
+			Cached:       stats.Cached,
+		}
+		for _, u := range stats.Upstreams {
+			data.Upstreams = append(data.Upstreams, &rpc.ResolverResponse_Upstream{
+				Addr:     u.Addr,
+				Queries:  u.Queries,
+				Failures: u.Failures,
+				Latency:  int64(u.Latency),
+			})
+		}
+		result = append(result, data)
+	}
+	return &rpc.Response{
+		Type:     rpc.RequestType_RESOLVER,
+		Resolver: &rpc.ResolverResponse{Data: result},
+	}, nil
+}
@@ -29,7 +29,9 @@ import (
 	"skywalker/rpc"
 	"skywalker/util"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Force struct {
@@ -37,9 +39,17 @@ type Force struct {
 	InetListener *net.TCPListener
 	UnixListener *net.UnixListener
 
+	MetricsListener *net.TCPListener /* Prometheus监控，没有配置时为nil */
+
 	/* 当前服务列表，map用户快速查询某一代理，list用于返回固定顺序的服务 */
 	proxies        map[string]*proxy.Proxy
 	orderedProxies []*proxy.Proxy
+
+	reloadLock  sync.Mutex  /* 同时只能有一个重新加载 */
+	reloadError string      /* 最近一次重新加载失败的原因，成功之后清空 */
+	reloadC     chan string /* 请求自动重新加载，值是原因 */
+
+	watcher *util.FileWatcher /* 监视配置文件，不支持时为nil */
 }
 
 func NewForce(inetListener *net.TCPListener, unixListener *net.UnixListener) *Force {
@@ -63,11 +73,11 @@ func (f *Force) LoadProxiesFromConfig(pConfigs []*config.ProxyConfig) error {
 	defer f.Unlock()
 	f.Lock()
 
+	if err := config.InitProxies(pConfigs, nil); err != nil {
+		return err
+	}
 	names := []string{}
 	for _, cfg := range pConfigs {
-		if err := cfg.Init(); err != nil {
-			return err
-		}
 		f.proxies[cfg.Name] = proxy.New(cfg)
 		names = append(names, cfg.Name)
 		log.D("load proxy %s %s/%s %s\n", cfg.Name, cfg.ClientAgent, cfg.ServerAgent,
@@ -104,20 +114,25 @@ func Run() *Force {
 	pConfigs := config.GetProxyConfigs()
 
 	if cConfig.Inet != nil {
-		if inetListener, err = util.TCPListen(cConfig.Inet.IP, cConfig.Inet.Port, false); err != nil {
+		if inetListener, err = listenInet(cConfig.Inet); err != nil {
 			log.E("%v", err)
 			return nil
 		}
 	}
 	if cConfig.Unix != nil {
-		if unixListener, err = util.UnixListen(cConfig.Unix.File); err != nil {
+		if unixListener, err = listenUnix(cConfig.Unix); err != nil {
 			log.E("%v", err)
 			return nil
 		}
-		os.Chmod(unixListener.Addr().String(), os.FileMode(cConfig.Unix.Chmod))
 	}
 
 	force := NewForce(inetListener, unixListener)
+	if cConfig.Metrics != nil {
+		if force.MetricsListener, err = listenMetrics(cConfig.Metrics); err != nil {
+			log.E("%v", err)
+			return nil
+		}
+	}
 
 	if err = force.LoadProxiesFromConfig(pConfigs); err != nil {
 		log.E("%v", err)
@@ -126,6 +141,7 @@ func Run() *Force {
 
 	force.AutoStartProxies()
 	force.Listen(cConfig)
+	force.WatchConfig()
 
 	return force
 }
@@ -136,23 +152,42 @@ func (f *Force) Finish() {
 	}
 }
 
-/* 监听命令请求 */
-func (f *Force) Listen(cfg *config.CoreConfig) {
-	listenFunc := func(listener net.Listener, username, password string) {
-		for {
-			if conn, err := listener.Accept(); err == nil {
-				go f.handleConn(rpc.NewConn(conn), username, password)
-			} else {
-				log.W("%v", err)
-			}
-		}
+func listenInet(cfg *config.InetConfig) (*net.TCPListener, error) {
+	return util.TCPListen(cfg.IP, cfg.Port, false)
+}
+
+func listenUnix(cfg *config.UnixConfig) (*net.UnixListener, error) {
+	listener, err := util.UnixListen(cfg.File)
+	if err != nil {
+		return nil, err
 	}
+	os.Chmod(listener.Addr().String(), os.FileMode(cfg.Chmod))
+	return listener, nil
+}
 
+/* 监听命令请求 */
+func (f *Force) Listen(cfg *config.CoreConfig) {
 	if f.InetListener != nil {
-		go listenFunc(f.InetListener, cfg.Inet.Username, cfg.Inet.Password)
+		go f.listen(f.InetListener, cfg.Inet.Username, cfg.Inet.Password)
 	}
 	if f.UnixListener != nil {
-		go listenFunc(f.UnixListener, cfg.Unix.Username, cfg.Unix.Password)
+		go f.listen(f.UnixListener, cfg.Unix.Username, cfg.Unix.Password)
+	}
+	if f.MetricsListener != nil {
+		go f.serveMetrics(f.MetricsListener)
+	}
+}
+
+func (f *Force) listen(listener net.Listener, username, password string) {
+	for {
+		if conn, err := listener.Accept(); err == nil {
+			go f.handleConn(rpc.NewConn(conn), username, password)
+		} else if errors.Is(err, net.ErrClosed) {
+			/* 重新加载配置时关闭了监听 */
+			return
+		} else {
+			log.W("%v", err)
+		}
 	}
 }
 
@@ -228,20 +263,138 @@ func (f *Force) handleConn(c *rpc.Conn, username, password string) {
 	}
 }
 
-func (f *Force) Reload() ([]string, []string, []string, []string, error) {
+/*
+ * 重新加载配置文件
+ * 返回每个代理的变化以及core中改变了的配置项，
+ * 新配置有错误时不做任何修改，正在运行的代理不受影响，错误记录在reloadError中
+ */
+func (f *Force) Reload() (*rpc.ReloadResponse, error) {
+	defer f.reloadLock.Unlock()
+	f.reloadLock.Lock()
+
+	rep, err := f.reload()
+	/* 配置文件可能增加或者删除了 */
+	f.watchConfigFiles()
+	f.Lock()
+	if err != nil {
+		f.reloadError = fmt.Sprintf("%s %s", time.Now().Format("2006/01/02 15:04:05"), err.Error())
+	} else {
+		f.reloadError = ""
+	}
+	f.Unlock()
+	return rep, err
+}
+
+/*
+ * 读取和检查新配置不会修改任何全局状态，检查通过之后才替换日志、解析器，
+ * 以及新增加和改变了的代理的CA、SA和钩子的配置
+ */
+func (f *Force) reload() (*rpc.ReloadResponse, error) {
 	cfile := config.GetConfigFilePath()
 	cConfig, pConfig, err := config.LoadConfigFromPath(cfile)
 	if err != nil {
-		return nil, nil, nil, nil, err
+		return nil, err
 	}
-	return f.ReloadProxies(cConfig.GetProxyConfigs(pConfig))
+	old := config.GetCoreConfig()
+	if err := cConfig.Init(old); err != nil {
+		return nil, err
+	}
+	rep, err := f.ReloadProxies(cConfig.GetProxyConfigs(pConfig), config.GetProxyConfigs())
+	if err != nil {
+		return nil, err
+	}
+	rep.Core = f.reloadCore(cConfig, old)
+	config.Update(cConfig, pConfig)
+	return rep, nil
+}
+
+/*
+ * 检查当前使用的配置文件，返回检查的文件和发现的问题，
+ * 检查不会修改正在使用的配置
+ */
+func (*Force) CheckConfig() (string, []*config.Problem) {
+	cfile := config.GetConfigFilePath()
+	return cfile, config.Check(cfile)
 }
 
-func (f *Force) ReloadProxies(pConfigs []*config.ProxyConfig) ([]string, []string, []string, []string, error) {
+/* 最近一次重新加载失败的原因 */
+func (f *Force) GetReloadError() string {
 	defer f.Unlock()
 	f.Lock()
+	return f.reloadError
+}
+
+/*
+ * 应用core中改变了的配置，返回改变了的配置项
+ * 命令的监听地址或者用户名密码改变时重新监听，已经建立的命令连接不受影响
+ */
+func (f *Force) reloadCore(cur, old *config.CoreConfig) []string {
+	defer f.Unlock()
+	f.Lock()
+
+	changes := cur.Diff(old)
+	for i, name := range changes {
+		var err error
+		switch name {
+		case "inet":
+			if f.InetListener != nil {
+				f.InetListener.Close()
+				f.InetListener = nil
+			}
+			if cur.Inet != nil {
+				if f.InetListener, err = listenInet(cur.Inet); err == nil {
+					go f.listen(f.InetListener, cur.Inet.Username, cur.Inet.Password)
+				}
+			}
+		case "unix":
+			/* 关闭时会删除套接字文件 */
+			if f.UnixListener != nil {
+				f.UnixListener.Close()
+				f.UnixListener = nil
+			}
+			if cur.Unix != nil {
+				if f.UnixListener, err = listenUnix(cur.Unix); err == nil {
+					go f.listen(f.UnixListener, cur.Unix.Username, cur.Unix.Password)
+				}
+			}
+		case "metrics":
+			if f.MetricsListener != nil {
+				f.MetricsListener.Close()
+				f.MetricsListener = nil
+			}
+			if cur.Metrics != nil {
+				if f.MetricsListener, err = listenMetrics(cur.Metrics); err == nil {
+					go f.serveMetrics(f.MetricsListener)
+				}
+			}
+		}
+		if err != nil {
+			log.E("reload %s error: %s", name, err.Error())
+			changes[i] = fmt.Sprintf("%s (%s)", name, err.Error())
+		} else {
+			log.I("core %s updated", name)
+		}
+	}
+	return changes
+}
+
+/* old是正在使用的代理配置 */
+func (f *Force) ReloadProxies(pConfigs, old []*config.ProxyConfig) (*rpc.ReloadResponse, error) {
+	f.Lock()
+	rep, pending, err := f.reloadProxies(pConfigs, old)
+	f.Unlock()
 
-	var unchanged, added, deleted, updated []string
+	/* 等待删除或者重启了的代理的旧连接结束可能需要很久，释放锁之后所有代理同时等待 */
+	for p, conns := range pending {
+		go p.Drain(conns)
+	}
+	return rep, err
+}
+
+/* 替换代理，返回删除或者重启了的代理需要等待结束的连接 */
+func (f *Force) reloadProxies(pConfigs, old []*config.ProxyConfig) (*rpc.ReloadResponse, map[*proxy.Proxy][]net.Conn, error) {
+	rep := &rpc.ReloadResponse{}
+	pending := make(map[*proxy.Proxy][]net.Conn)
 
 	unchangedProxies := make([]*proxy.Proxy, 0)
 	addedProxies := make([]*proxy.Proxy, 0)
@@ -253,20 +406,26 @@ func (f *Force) ReloadProxies(pConfigs []*config.ProxyConfig) ([]string, []strin
 	}
 
 	names := []string{}
+	/* 配置已经检查过了，只初始化新增加和改变了的代理的配置 */
+	if err := config.InitProxies(pConfigs, old); err != nil {
+		return nil, nil, err
+	}
+	changes := make(map[string]*rpc.ReloadResponse_Change)
 	for _, cfg := range pConfigs {
-		if err := cfg.Init(); err != nil {
-			return nil, nil, nil, nil, err
-		}
 		p, ok := f.proxies[cfg.Name]
 		if !ok { /* */
 			addedProxies = append(addedProxies, proxy.New(cfg))
-			added = append(added, cfg.Name)
-		} else if p.Update(cfg) {
+			rep.Added = append(rep.Added, cfg.Name)
+		} else if fields := p.Update(cfg); len(fields) > 0 {
 			updatedProxies = append(updatedProxies, p)
-			updated = append(updated, p.Name)
+			rep.Updated = append(rep.Updated, p.Name)
+			log.I("%s updated: %s", p.Name, strings.Join(fields, ", "))
+			change := &rpc.ReloadResponse_Change{Name: p.Name, Fields: fields}
+			rep.Changes = append(rep.Changes, change)
+			changes[p.Name] = change
 		} else {
 			unchangedProxies = append(unchangedProxies, p)
-			unchanged = append(unchanged, p.Name)
+			rep.Unchanged = append(rep.Unchanged, p.Name)
 		}
 
 		names = append(names, cfg.Name)
@@ -276,13 +435,16 @@ func (f *Force) ReloadProxies(pConfigs []*config.ProxyConfig) ([]string, []strin
 	for _, p := range f.proxies {
 		if p.Flag == proxy.FLAG_UNSET {
 			deletedProxies = append(deletedProxies, p)
-			deleted = append(deleted, p.Name)
+			rep.Deleted = append(rep.Deleted, p.Name)
 		}
 	}
 
+	/* 先删除和重启，新的代理可能使用被删除的代理的端口 */
+	f.deleteProxies(deletedProxies, pending)
+	for _, name := range f.updateProxies(updatedProxies, pending) {
+		changes[name].Restarted = true
+	}
 	f.addProxies(addedProxies)
-	f.deleteProxies(deletedProxies)
-	f.updateProxies(updatedProxies)
 
 	/* */
 	f.orderedProxies = make([]*proxy.Proxy, 0)
@@ -290,7 +452,7 @@ func (f *Force) ReloadProxies(pConfigs []*config.ProxyConfig) ([]string, []strin
 	for _, name := range names {
 		f.orderedProxies = append(f.orderedProxies, f.proxies[name])
 	}
-	return unchanged, added, deleted, updated, nil
+	return rep, pending, nil
 }
 
 func (f *Force) addProxies(proxies []*proxy.Proxy) {
@@ -305,24 +467,34 @@ func (f *Force) addProxies(proxies []*proxy.Proxy) {
 	}
 }
 
-func (f *Force) deleteProxies(proxies []*proxy.Proxy) {
+/* 删除代理，正在转发的连接记录在pending中 */
+func (f *Force) deleteProxies(proxies []*proxy.Proxy, pending map[*proxy.Proxy][]net.Conn) {
 	for _, p := range proxies {
 		delete(f.proxies, p.Name)
 		log.I("%s deleted", p.Name)
-		if err := p.Stop(); err != nil {
-			log.W("stop %s error: %s", p.Name, err.Error())
-		}
+		pending[p] = p.Shutdown()
 	}
 }
 
-func (f *Force) updateProxies(proxies []*proxy.Proxy) {
+/*
+ * 应用更新了的代理配置，只重启正在运行并且监听改变了的代理，返回重启了的代理，
+ * 重启之前的连接记录在pending中
+ */
+func (f *Force) updateProxies(proxies []*proxy.Proxy, pending map[*proxy.Proxy][]net.Conn) []string {
+	var restarted []string
 	for _, p := range proxies {
 		if p.Flag == proxy.FLAG_AGENT_CHANGED {
 			log.I("%s changed to %s/%s", p.Name, p.CAName, p.SAName)
-		} else if p.Flag == proxy.FLAG_ADDR_CHANGED {
-			if err := p.Restart(); err != nil {
+		}
+		if p.Flag == proxy.FLAG_ADDR_CHANGED && p.Status == proxy.STATUS_RUNNING {
+			conns, err := p.Relisten()
+			pending[p] = conns
+			if err != nil {
 				log.W("restart %s error: %s", p.Name, err.Error())
+			} else {
+				restarted = append(restarted, p.Name)
 			}
 		}
 	}
+	return restarted
 }
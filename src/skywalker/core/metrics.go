@@ -0,0 +1,284 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"skywalker/agent"
+	"skywalker/config"
+	"skywalker/log"
+	"skywalker/pkg"
+	"skywalker/proxy"
+	"skywalker/resolver"
+	"skywalker/util"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Prometheus监控
+ * core中配置了metrics时监听HTTP服务，在/metrics以Prometheus的文本格式输出每个代理的流量、连接数、
+ * 连接远程服务器的结果和耗时，SA上游服务器的健康状态以及DNS解析器的统计数据
+ */
+
+const (
+	METRICS_PATH = "/metrics"
+)
+
+var (
+	/* 连接结果在监控数据中的名字 */
+	gConnectResultNames = []struct {
+		result int
+		name   string
+	}{
+		{pkg.CONNECT_RESULT_OK, "ok"},
+		{pkg.CONNECT_RESULT_UNKNOWN_HOST, "unknown_host"},
+		{pkg.CONNECT_RESULT_UNREACHABLE, "unreachable"},
+		{pkg.CONNECT_RESULT_UNKNOWN_ERROR, "unknown_error"},
+	}
+
+	gLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type (
+	/* 一个代理的监控数据，输出时同一个指标的数据需要放在一起 */
+	proxyMetrics struct {
+		name           string
+		up             bool
+		sent           int64
+		received       int64
+		connections    int64
+		activeConns    int
+		activeChains   int
+		connectResults map[int]int64
+		buckets        []int64
+		count          int64
+		sum            float64
+		upstreams      []upstreamMetrics
+	}
+
+	upstreamMetrics struct {
+		addr    string
+		up      bool
+		latency float64
+	}
+
+	metricsWriter struct {
+		bytes.Buffer
+	}
+)
+
+func listenMetrics(cfg *config.MetricsConfig) (*net.TCPListener, error) {
+	return util.TCPListen(cfg.IP, cfg.Port, false)
+}
+
+/* 提供监控数据的HTTP服务，监听关闭之后返回 */
+func (f *Force) serveMetrics(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(METRICS_PATH, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(f.metrics())
+	})
+	if err := http.Serve(listener, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.W("metrics server error: %s", err)
+	}
+}
+
+/* 输出指标的说明和类型 */
+func (w *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+/* 输出一个数据，labels是标签名和值交替的列表 */
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], gLabelEscaper.Replace(labels[i+1])))
+		}
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(w, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/* 收集代理的监控数据 */
+func getProxyMetrics(p *proxy.Proxy) *proxyMetrics {
+	m := &proxyMetrics{
+		name:           p.Name,
+		up:             p.Status == proxy.STATUS_RUNNING,
+		activeConns:    p.ConnCount(),
+		connectResults: make(map[int]int64),
+	}
+	p.Info.Lock()
+	m.sent, m.received, m.connections = p.Info.Sent, p.Info.Received, p.Info.Connections
+	for e := p.Info.Chains.Front(); e != nil; e = e.Next() {
+		if e.Value.(*proxy.Chain).ClosedTime == 0 {
+			m.activeChains++
+		}
+	}
+	for result, n := range p.Info.ConnectResults {
+		m.connectResults[result] = n
+	}
+	p.Info.Unlock()
+	m.buckets, m.count, m.sum = p.Info.ConnectLatency.Snapshot()
+
+	if _, sa := p.GetAgents(); sa != nil {
+		if usa, ok := sa.(agent.UpstreamServerAgent); ok {
+			for _, u := range usa.GetUpstreams() {
+				m.upstreams = append(m.upstreams, upstreamMetrics{
+					addr:    u.Addr,
+					up:      u.Healthy,
+					latency: u.Latency.Seconds(),
+				})
+			}
+		}
+	}
+	return m
+}
+
+/* 生成Prometheus文本格式的监控数据 */
+func (f *Force) metrics() []byte {
+	f.Lock()
+	proxies := append([]*proxy.Proxy{}, f.orderedProxies...)
+	f.Unlock()
+	var pm []*proxyMetrics
+	for _, p := range proxies {
+		pm = append(pm, getProxyMetrics(p))
+	}
+
+	w := &metricsWriter{}
+	families := []struct {
+		name, typ, help string
+		value           func(*proxyMetrics) float64
+	}{
+		{"skywalker_proxy_up", "gauge", "Whether the proxy is running.",
+			func(m *proxyMetrics) float64 { return boolValue(m.up) }},
+		{"skywalker_proxy_sent_bytes_total", "counter", "Bytes sent to the server side.",
+			func(m *proxyMetrics) float64 { return float64(m.sent) }},
+		{"skywalker_proxy_received_bytes_total", "counter", "Bytes sent back to the client side.",
+			func(m *proxyMetrics) float64 { return float64(m.received) }},
+		{"skywalker_proxy_connections_total", "counter", "Client connections accepted.",
+			func(m *proxyMetrics) float64 { return float64(m.connections) }},
+		{"skywalker_proxy_active_connections", "gauge", "Client connections being forwarded.",
+			func(m *proxyMetrics) float64 { return float64(m.activeConns) }},
+		{"skywalker_proxy_active_chains", "gauge", "Chains not closed yet.",
+			func(m *proxyMetrics) float64 { return float64(m.activeChains) }},
+	}
+	for _, g := range families {
+		w.family(g.name, g.typ, g.help)
+		for _, m := range pm {
+			w.sample(g.name, g.value(m), "proxy", m.name)
+		}
+	}
+
+	w.family("skywalker_proxy_connect_results_total", "counter", "Results of connecting to remote servers.")
+	for _, m := range pm {
+		for _, r := range gConnectResultNames {
+			w.sample("skywalker_proxy_connect_results_total", float64(m.connectResults[r.result]),
+				"proxy", m.name, "result", r.name)
+		}
+	}
+
+	w.family("skywalker_proxy_connect_duration_seconds", "histogram", "Time spent connecting to remote servers.")
+	for _, m := range pm {
+		for i, le := range proxy.CONNECT_LATENCY_BUCKETS {
+			w.sample("skywalker_proxy_connect_duration_seconds_bucket", float64(m.buckets[i]),
+				"proxy", m.name, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		w.sample("skywalker_proxy_connect_duration_seconds_bucket", float64(m.count), "proxy", m.name, "le", "+Inf")
+		w.sample("skywalker_proxy_connect_duration_seconds_sum", m.sum, "proxy", m.name)
+		w.sample("skywalker_proxy_connect_duration_seconds_count", float64(m.count), "proxy", m.name)
+	}
+
+	w.family("skywalker_upstream_up", "gauge", "Whether the upstream server is healthy.")
+	for _, m := range pm {
+		for _, u := range m.upstreams {
+			w.sample("skywalker_upstream_up", boolValue(u.up), "proxy", m.name, "upstream", u.addr)
+		}
+	}
+	w.family("skywalker_upstream_latency_seconds", "gauge", "Latency of the last health check.")
+	for _, m := range pm {
+		for _, u := range m.upstreams {
+			w.sample("skywalker_upstream_latency_seconds", u.latency, "proxy", m.name, "upstream", u.addr)
+		}
+	}
+
+	writeResolverMetrics(w)
+	return w.Bytes()
+}
+
+/* DNS解析器的统计数据 */
+func writeResolverMetrics(w *metricsWriter) {
+	stats := resolver.GetStats()
+	counters := []struct {
+		name, typ, help string
+		value           func(*resolver.Stats) int64
+	}{
+		{"skywalker_resolver_queries_total", "counter", "DNS queries.",
+			func(s *resolver.Stats) int64 { return s.Queries }},
+		{"skywalker_resolver_cache_hits_total", "counter", "DNS queries answered from the cache.",
+			func(s *resolver.Stats) int64 { return s.Hits }},
+		{"skywalker_resolver_cache_negative_hits_total", "counter", "DNS queries answered from the negative cache.",
+			func(s *resolver.Stats) int64 { return s.NegativeHits }},
+		{"skywalker_resolver_merged_total", "counter", "DNS queries merged into an ongoing query.",
+			func(s *resolver.Stats) int64 { return s.Merged }},
+		{"skywalker_resolver_cache_misses_total", "counter", "DNS queries sent to upstreams.",
+			func(s *resolver.Stats) int64 { return s.Misses }},
+		{"skywalker_resolver_failures_total", "counter", "DNS queries failed.",
+			func(s *resolver.Stats) int64 { return s.Failures }},
+		{"skywalker_resolver_cached_entries", "gauge", "Entries in the DNS cache.",
+			func(s *resolver.Stats) int64 { return s.Cached }},
+	}
+	for _, c := range counters {
+		w.family(c.name, c.typ, c.help)
+		for _, s := range stats {
+			w.sample(c.name, float64(c.value(s)), "resolver", s.Name)
+		}
+	}
+
+	w.family("skywalker_resolver_upstream_queries_total", "counter", "DNS queries sent to the upstream.")
+	for _, s := range stats {
+		for _, u := range s.Upstreams {
+			w.sample("skywalker_resolver_upstream_queries_total", float64(u.Queries), "resolver", s.Name, "upstream", u.Addr)
+		}
+	}
+	w.family("skywalker_resolver_upstream_failures_total", "counter", "DNS queries failed on the upstream.")
+	for _, s := range stats {
+		for _, u := range s.Upstreams {
+			w.sample("skywalker_resolver_upstream_failures_total", float64(u.Failures), "resolver", s.Name, "upstream", u.Addr)
+		}
+	}
+	w.family("skywalker_resolver_upstream_latency_seconds", "gauge", "Average latency of successful queries.")
+	for _, s := range stats {
+		for _, u := range s.Upstreams {
+			w.sample("skywalker_resolver_upstream_latency_seconds", u.Latency.Seconds(), "resolver", s.Name, "upstream", u.Addr)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package core
+
+import (
+	"skywalker/config"
+	"skywalker/log"
+	"skywalker/util"
+	"time"
+)
+
+/*
+ * 自动重新加载配置
+ * 配置文件改变后等待CONFIG_RELOAD_DELAY，期间没有新的改变才重新加载，
+ * 避免编辑器多次写入时重复加载；收到SIGHUP时立即重新加载
+ */
+
+const (
+	CONFIG_RELOAD_DELAY = 500 * time.Millisecond
+)
+
+/* 开始监视配置文件，无法监视时只能通过SIGHUP和forctl重新加载 */
+func (f *Force) WatchConfig() {
+	f.reloadC = make(chan string, 1)
+	var changed chan string
+	if watcher, err := util.NewFileWatcher(); err != nil {
+		log.W("failed to watch config file: %s", err)
+	} else {
+		f.watcher = watcher
+		f.watchConfigFiles()
+		changed = watcher.C
+	}
+	go f.autoReload(changed)
+}
+
+/*
+ * 监视主配置文件和包含的配置文件，每次重新加载之后调用，
+ * 新增加的配置文件和目录也会被监视
+ */
+func (f *Force) watchConfigFiles() {
+	if f.watcher == nil {
+		return
+	}
+	for _, path := range config.WatchPaths(config.GetConfigFilePath()) {
+		if err := f.watcher.Add(path); err != nil {
+			log.D("failed to watch %s: %s", path, err)
+		}
+	}
+}
+
+/* 请求重新加载配置，已经有一个请求在等待时忽略 */
+func (f *Force) RequestReload(reason string) {
+	select {
+	case f.reloadC <- reason:
+	default:
+	}
+}
+
+func (f *Force) autoReload(changed chan string) {
+	var delay <-chan time.Time
+	reason := ""
+	for {
+		select {
+		case path := <-changed:
+			reason = path + " changed"
+			delay = time.After(CONFIG_RELOAD_DELAY)
+		case <-delay:
+			delay = nil
+			f.reloadWithLog(reason)
+		case r := <-f.reloadC:
+			f.reloadWithLog(r)
+		}
+	}
+}
+
+func (f *Force) reloadWithLog(reason string) {
+	log.I("reloading config: %s", reason)
+	rep, err := f.Reload()
+	if err != nil {
+		log.E("reload config failed, keep running with the old one: %s", err.Error())
+		return
+	}
+	log.I("config reloaded, %d added, %d deleted, %d updated", len(rep.GetAdded()),
+		len(rep.GetDeleted()), len(rep.GetUpdated()))
+}
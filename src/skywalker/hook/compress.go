@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"skywalker/util"
+)
+
+/*
+ * 压缩钩子，每次写入的数据单独压缩成一帧，格式为 [4字节大端序的长度][deflate数据]
+ * 读取时等待完整的帧再解压，两端需要同时配置
+ * level配置压缩级别，1-9，默认为-1即deflate的默认级别
+ */
+type CompressHook struct {
+	BaseHook
+	level  int
+	writer *flate.Writer
+	wbuf   bytes.Buffer
+	rbuf   []byte /* 不完整的帧 */
+}
+
+const (
+	COMPRESS_MAX_FRAME_SIZE = 1 << 24
+)
+
+var (
+	gCompressConfigs = make(map[string]int)
+)
+
+func (h *CompressHook) Name() string {
+	return "compress"
+}
+
+func parseCompressLevel(cfg map[string]interface{}) (int, error) {
+	level := util.GetMapIntDefault(cfg, "level", flate.DefaultCompression)
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		return 0, fmt.Errorf("invalid compression level %d", level)
+	}
+	return level, nil
+}
+
+func (h *CompressHook) OnInit(name string, cfg map[string]interface{}) error {
+	level, err := parseCompressLevel(cfg)
+	if err != nil {
+		return err
+	}
+	gCompressConfigs[name] = level
+	return nil
+}
+
+func (*CompressHook) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseCompressLevel(cfg)
+	return err
+}
+
+func (h *CompressHook) OnStart() error {
+	h.level = gCompressConfigs[h.BaseHook.Name]
+	return nil
+}
+
+func (h *CompressHook) OnWrite(data []byte) ([]byte, error) {
+	h.wbuf.Reset()
+	h.wbuf.Write([]byte{0, 0, 0, 0})
+	if h.writer == nil {
+		w, err := flate.NewWriter(&h.wbuf, h.level)
+		if err != nil {
+			return nil, err
+		}
+		h.writer = w
+	} else {
+		h.writer.Reset(&h.wbuf)
+	}
+	h.writer.Write(data)
+	if err := h.writer.Close(); err != nil {
+		return nil, err
+	}
+	frame := util.CopyBytes(h.wbuf.Bytes(), h.wbuf.Len())
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	return frame, nil
+}
+
+func (h *CompressHook) OnRead(data []byte) ([]byte, error) {
+	h.rbuf = append(h.rbuf, data...)
+	var plain []byte
+	for len(h.rbuf) >= 4 {
+		size := int(binary.BigEndian.Uint32(h.rbuf))
+		if size > COMPRESS_MAX_FRAME_SIZE {
+			return nil, fmt.Errorf("compressed frame is too large")
+		} else if len(h.rbuf) < 4+size {
+			break
+		}
+		r := flate.NewReader(bytes.NewReader(h.rbuf[4 : 4+size]))
+		data, err := ioutil.ReadAll(io.LimitReader(r, COMPRESS_MAX_FRAME_SIZE))
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		plain = append(plain, data...)
+		h.rbuf = h.rbuf[4+size:]
+	}
+	return plain, nil
+}
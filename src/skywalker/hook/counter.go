@@ -0,0 +1,63 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"skywalker/pkg"
+)
+
+/*
+ * 计数钩子，统计一个远程连接读写的字节数或者一个方向转发的数据包数，关闭时输出
+ */
+type CounterHook struct {
+	BaseHook
+	read     int64
+	written  int64
+	packages int64
+}
+
+func (h *CounterHook) Name() string {
+	return "counter"
+}
+
+func (h *CounterHook) OnInit(name string, cfg map[string]interface{}) error {
+	return nil
+}
+
+func (h *CounterHook) OnRead(data []byte) ([]byte, error) {
+	h.read += int64(len(data))
+	return data, nil
+}
+
+func (h *CounterHook) OnWrite(data []byte) ([]byte, error) {
+	h.written += int64(len(data))
+	return data, nil
+}
+
+func (h *CounterHook) OnPackage(p *pkg.Package) (*pkg.Package, error) {
+	h.packages += 1
+	return p, nil
+}
+
+func (h *CounterHook) OnClose() {
+	if h.read > 0 || h.written > 0 {
+		h.INFO("[hook] %d bytes read, %d bytes written", h.read, h.written)
+	}
+	if h.packages > 0 {
+		h.INFO("[hook] %d packages", h.packages)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"fmt"
+	"net"
+	"skywalker/pkg"
+	"skywalker/util"
+	"strings"
+)
+
+/*
+ * 目标地址过滤钩子，用在caHooks中，检查CA发出的连接请求和UDP数据包的目标地址
+ * allow/deny的每一项是域名后缀、IP或者CIDR，ports是端口或者端口范围
+ * 匹配deny，或者allow/ports不为空但是没有匹配时拒绝，
+ * 连接请求被拒绝时关闭连接，UDP数据包被拒绝时丢弃
+ */
+type (
+	hostList struct {
+		domains []string
+		cidrs   []*net.IPNet
+	}
+
+	filterConfig struct {
+		allow *hostList
+		deny  *hostList
+		ports [][2]int
+	}
+
+	FilterHook struct {
+		BaseHook
+		cfg *filterConfig
+	}
+)
+
+var (
+	gFilterConfigs = make(map[string]*filterConfig)
+)
+
+func newHostList(cfg map[string]interface{}, name string) (*hostList, error) {
+	items, err := util.GetMapStringList(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	l := &hostList{}
+	for _, item := range items {
+		if ipnet, err := util.ParseCIDR(item); err == nil {
+			l.cidrs = append(l.cidrs, ipnet)
+		} else {
+			l.domains = append(l.domains, strings.Trim(strings.ToLower(item), "."))
+		}
+	}
+	return l, nil
+}
+
+func (l *hostList) empty() bool {
+	return len(l.domains) == 0 && len(l.cidrs) == 0
+}
+
+func (l *hostList) match(host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, ipnet := range l.cidrs {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range l.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+/* 检查目标地址，拒绝时返回原因 */
+func (cfg *filterConfig) check(host string, port int) string {
+	if cfg.deny.match(host) {
+		return "denied"
+	} else if !cfg.allow.empty() && !cfg.allow.match(host) {
+		return "not allowed"
+	} else if len(cfg.ports) > 0 {
+		for _, r := range cfg.ports {
+			if port >= r[0] && port <= r[1] {
+				return ""
+			}
+		}
+		return "port not allowed"
+	}
+	return ""
+}
+
+func (h *FilterHook) Name() string {
+	return "filter"
+}
+
+func parseFilterConfig(cfg map[string]interface{}) (*filterConfig, error) {
+	allow, err := newHostList(cfg, "allow")
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newHostList(cfg, "deny")
+	if err != nil {
+		return nil, err
+	}
+	ports, err := util.GetMapStringList(cfg, "ports")
+	if err != nil {
+		return nil, err
+	}
+	fcfg := &filterConfig{allow: allow, deny: deny}
+	for _, s := range ports {
+		min, max, err := util.ParsePortRange(s)
+		if err != nil {
+			return nil, err
+		}
+		fcfg.ports = append(fcfg.ports, [2]int{min, max})
+	}
+	return fcfg, nil
+}
+
+func (h *FilterHook) OnInit(name string, cfg map[string]interface{}) error {
+	fcfg, err := parseFilterConfig(cfg)
+	if err != nil {
+		return err
+	}
+	gFilterConfigs[name] = fcfg
+	return nil
+}
+
+func (*FilterHook) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseFilterConfig(cfg)
+	return err
+}
+
+func (h *FilterHook) OnStart() error {
+	h.cfg = gFilterConfigs[h.BaseHook.Name]
+	if h.cfg == nil {
+		return fmt.Errorf("config not found")
+	}
+	return nil
+}
+
+func (h *FilterHook) OnPackage(p *pkg.Package) (*pkg.Package, error) {
+	switch p.Type() {
+	case pkg.PKG_CONNECT, pkg.PKG_BIND:
+		host, port := p.GetConnectRequest()
+		if reason := h.cfg.check(host, port); reason != "" {
+			return nil, fmt.Errorf("%s is %s", util.JoinHostPort(host, port), reason)
+		}
+	case pkg.PKG_UDP_DATA:
+		host, port, _ := p.GetUDPData()
+		if reason := h.cfg.check(host, port); reason != "" {
+			h.DEBUG("[hook] UDP package to %s is %s", util.JoinHostPort(host, port), reason)
+			return nil, nil
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"errors"
+	"fmt"
+	"skywalker/log"
+	"skywalker/pkg"
+	"strings"
+	"sync"
+)
+
+/*
+ * 钩子
+ * 代理的caHooks作用于客户端一侧，saHooks作用于服务端一侧，
+ * 钩子可以查看、修改或者丢弃CA和SA之间传递的pkg.Package，
+ * 也可以处理远程连接(client/server)上的原始数据，
+ * 比如压缩和混淆，两端的Skywalker配置相同的钩子列表即可互通
+ *
+ *           caHooks                                saHooks
+ * +---+    +-------+    +----+             +----+    +-------+    +---+
+ * | C | => | Read  | => | CA | => Package  | SA | => | Write | => | S |
+ * +---+    +-------+    +----+  (caHooks)  +----+    +-------+    +---+
+ *
+ * 列表中靠后的钩子更靠近网络，写数据时按列表顺序处理，读数据时按相反的顺序处理
+ */
+type (
+	Hook interface {
+		/* 返回钩子名 */
+		Name() string
+		/*
+		 * 程序初始化时调用，该方法全局只调用一次，读取配置
+		 * 第一个参数是代理名，第二个参数是钩子配置
+		 */
+		OnInit(string, map[string]interface{}) error
+		/* 每个连接调用一次，返回错误时连接会被关闭 */
+		OnStart() error
+
+		/* 从远程连接读取到的数据，返回空表示数据不完整，需要等待后续的数据 */
+		OnRead([]byte) ([]byte, error)
+		/* 将要写入远程连接的数据 */
+		OnWrite([]byte) ([]byte, error)
+		/*
+		 * 将要发送给另一端代理的数据包，caHooks处理CA发给SA的数据包，saHooks处理SA发给CA的数据包，
+		 * 返回nil表示丢弃该数据包，返回错误会停止转发并关闭连接
+		 */
+		OnPackage(*pkg.Package) (*pkg.Package, error)
+
+		/* 连接关闭，释放资源 */
+		OnClose()
+	}
+
+	/*
+	 * 可以只检查配置而不初始化的钩子，参数和OnInit相同，
+	 * 和agent.ConfigCheckAgent一样，OnInit可能失败的钩子都需要实现
+	 */
+	ConfigCheckHook interface {
+		CheckConfig(string, map[string]interface{}) error
+	}
+
+	newHookFunc func(string) Hook
+
+	/* 一个连接一侧的钩子，所有方法都会加锁，因为读写和转发数据包在不同的goroutine中 */
+	Chain struct {
+		sync.Mutex
+		hooks []Hook
+	}
+
+	/* 实现不重要的钩子方法，Name是代理名 */
+	BaseHook struct {
+		Name string
+	}
+)
+
+/******************* 钩子构造函数 ********************/
+
+func NewLogHook(name string) Hook {
+	return &LogHook{BaseHook: BaseHook{Name: name}}
+}
+
+func NewCounterHook(name string) Hook {
+	return &CounterHook{BaseHook: BaseHook{Name: name}}
+}
+
+func NewFilterHook(name string) Hook {
+	return &FilterHook{BaseHook: BaseHook{Name: name}}
+}
+
+func NewCompressHook(name string) Hook {
+	return &CompressHook{BaseHook: BaseHook{Name: name}}
+}
+
+func NewObfsHook(name string) Hook {
+	return &ObfsHook{BaseHook: BaseHook{Name: name}}
+}
+
+/* 钩子名和构造函数的映射 */
+var (
+	gHookMap = map[string]newHookFunc{
+		"log":      NewLogHook,
+		"counter":  NewCounterHook,
+		"filter":   NewFilterHook,
+		"compress": NewCompressHook,
+		"obfs":     NewObfsHook,
+	}
+)
+
+/* 钩子初始化，和agent.CAInit一样，每个代理全局调用一次 */
+func Init(hook string, name string, cfg map[string]interface{}) error {
+	f := gHookMap[strings.ToLower(hook)]
+	if f == nil {
+		return errors.New(fmt.Sprintf("Hook %s not found", hook))
+	} else if cfg == nil {
+		cfg = make(map[string]interface{})
+	}
+	return f("init").OnInit(name, cfg)
+}
+
+/* 只检查钩子的配置，不修改钩子的全局配置 */
+func Check(hook string, name string, cfg map[string]interface{}) error {
+	f := gHookMap[strings.ToLower(hook)]
+	if f == nil {
+		return errors.New(fmt.Sprintf("Hook %s not found", hook))
+	} else if cfg == nil {
+		cfg = make(map[string]interface{})
+	}
+	if h, ok := f("check").(ConfigCheckHook); ok {
+		return h.CheckConfig(name, cfg)
+	}
+	return nil
+}
+
+/* 钩子是否存在，检查配置时使用 */
+func Exists(hook string) bool {
+	_, ok := gHookMap[strings.ToLower(hook)]
+	return ok
+}
+
+/* 为一个连接创建钩子，没有配置钩子时返回nil */
+func NewChain(hooks []string, name string) (*Chain, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	c := &Chain{}
+	for _, h := range hooks {
+		f := gHookMap[strings.ToLower(h)]
+		if f == nil {
+			return nil, errors.New(fmt.Sprintf("Hook %s not found", h))
+		}
+		hook := f(name)
+		if err := hook.OnStart(); err != nil {
+			c.Close()
+			return nil, errors.New(fmt.Sprintf("Fail To Start Hook [%s]: %s", hook.Name(), err))
+		}
+		c.hooks = append(c.hooks, hook)
+	}
+	return c, nil
+}
+
+/* 处理从远程连接读取到的数据 */
+func (c *Chain) Read(data []byte) ([]byte, error) {
+	defer c.Unlock()
+	c.Lock()
+
+	var err error
+	for i := len(c.hooks) - 1; i >= 0 && len(data) > 0; i-- {
+		if data, err = c.hooks[i].OnRead(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+/* 处理将要写入远程连接的数据 */
+func (c *Chain) Write(data []byte) ([]byte, error) {
+	defer c.Unlock()
+	c.Lock()
+
+	var err error
+	for i := 0; i < len(c.hooks) && len(data) > 0; i++ {
+		if data, err = c.hooks[i].OnWrite(data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+/* 处理发送给另一端代理的数据包 */
+func (c *Chain) Package(p *pkg.Package) (*pkg.Package, error) {
+	defer c.Unlock()
+	c.Lock()
+
+	var err error
+	for i := 0; i < len(c.hooks) && p != nil; i++ {
+		if p, err = c.hooks[i].OnPackage(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+/* 关闭所有钩子，可以对nil调用 */
+func (c *Chain) Close() {
+	if c == nil {
+		return
+	}
+	defer c.Unlock()
+	c.Lock()
+
+	for _, hook := range c.hooks {
+		hook.OnClose()
+	}
+	c.hooks = nil
+}
+
+/* 日志函数的封装 */
+func (h *BaseHook) WARN(format string, v ...interface{}) {
+	log.WARN(h.Name, format, v...)
+}
+
+func (h *BaseHook) DEBUG(format string, v ...interface{}) {
+	log.DEBUG(h.Name, format, v...)
+}
+
+func (h *BaseHook) ERROR(format string, v ...interface{}) {
+	log.ERROR(h.Name, format, v...)
+}
+
+func (h *BaseHook) INFO(format string, v ...interface{}) {
+	log.INFO(h.Name, format, v...)
+}
+
+func (h *BaseHook) OnStart() error {
+	return nil
+}
+
+func (h *BaseHook) OnRead(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (h *BaseHook) OnWrite(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (h *BaseHook) OnPackage(p *pkg.Package) (*pkg.Package, error) {
+	return p, nil
+}
+
+func (h *BaseHook) OnClose() {
+}
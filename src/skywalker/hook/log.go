@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"fmt"
+	"skywalker/pkg"
+	"skywalker/util"
+	"strings"
+)
+
+/*
+ * 日志钩子，记录经过的数据包和读写的数据大小，
+ * level配置日志级别，可以是debug(默认)和info
+ */
+type LogHook struct {
+	BaseHook
+	info bool
+}
+
+var (
+	gLogConfigs = make(map[string]bool)
+)
+
+/* 数据包的描述 */
+func describePackage(p *pkg.Package) string {
+	size := 0
+	switch p.Type() {
+	case pkg.PKG_CONNECT:
+		host, port := p.GetConnectRequest()
+		return "CONNECT " + util.JoinHostPort(host, port)
+	case pkg.PKG_BIND:
+		host, port := p.GetConnectRequest()
+		return "BIND " + util.JoinHostPort(host, port)
+	case pkg.PKG_UDP_ASSOCIATE:
+		host, port := p.GetConnectRequest()
+		return "UDP ASSOCIATE " + util.JoinHostPort(host, port)
+	case pkg.PKG_CONNECT_RESULT, pkg.PKG_BIND_RESULT, pkg.PKG_UDP_ASSOCIATE_RESULT:
+		result, host, port := p.GetConnectResult()
+		return fmt.Sprintf("RESULT %d %s", result, util.JoinHostPort(host, port))
+	case pkg.PKG_UDP_DATA:
+		host, port, datas := p.GetUDPData()
+		for _, data := range datas {
+			size += len(data)
+		}
+		return fmt.Sprintf("UDP %s %d bytes", util.JoinHostPort(host, port), size)
+	}
+	for _, data := range p.GetData() {
+		size += len(data)
+	}
+	return fmt.Sprintf("DATA %d bytes", size)
+}
+
+func (h *LogHook) Name() string {
+	return "log"
+}
+
+/* 解析配置，返回是否只输出INFO级别的日志 */
+func parseLogConfig(cfg map[string]interface{}) (bool, error) {
+	level := strings.ToLower(util.GetMapStringDefault(cfg, "level", "debug"))
+	if level != "debug" && level != "info" {
+		return false, fmt.Errorf("unknown log level %s", level)
+	}
+	return level == "info", nil
+}
+
+func (h *LogHook) OnInit(name string, cfg map[string]interface{}) error {
+	info, err := parseLogConfig(cfg)
+	if err != nil {
+		return err
+	}
+	gLogConfigs[name] = info
+	return nil
+}
+
+func (*LogHook) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseLogConfig(cfg)
+	return err
+}
+
+func (h *LogHook) OnStart() error {
+	h.info = gLogConfigs[h.BaseHook.Name]
+	return nil
+}
+
+func (h *LogHook) log(format string, v ...interface{}) {
+	if h.info {
+		h.INFO(format, v...)
+	} else {
+		h.DEBUG(format, v...)
+	}
+}
+
+func (h *LogHook) OnRead(data []byte) ([]byte, error) {
+	h.log("[hook] read %d bytes", len(data))
+	return data, nil
+}
+
+func (h *LogHook) OnWrite(data []byte) ([]byte, error) {
+	h.log("[hook] write %d bytes", len(data))
+	return data, nil
+}
+
+func (h *LogHook) OnPackage(p *pkg.Package) (*pkg.Package, error) {
+	h.log("[hook] package %s", describePackage(p))
+	return p, nil
+}
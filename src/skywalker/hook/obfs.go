@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package hook
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"skywalker/cipher"
+	"skywalker/util"
+	"strings"
+)
+
+/*
+ * 混淆钩子，使用流加密算法加密远程连接上的数据，格式为 [IV][加密的数据流]，
+ * 每个方向使用随机的IV，两端需要同时配置相同的method和password
+ * method是cipher包支持的流加密算法，默认为chacha20，密钥是密码的SHA256
+ */
+type (
+	obfsConfig struct {
+		info *cipher.CipherInfo
+		key  []byte
+	}
+
+	ObfsHook struct {
+		BaseHook
+		cfg       *obfsConfig
+		encrypter cipher.Encrypter
+		decrypter cipher.Decrypter
+		iv        []byte /* 读取到的不完整的IV */
+	}
+)
+
+var (
+	gObfsConfigs = make(map[string]*obfsConfig)
+)
+
+func (h *ObfsHook) Name() string {
+	return "obfs"
+}
+
+func parseObfsConfig(cfg map[string]interface{}) (*obfsConfig, error) {
+	method := strings.ToLower(util.GetMapStringDefault(cfg, "method", "chacha20"))
+	password := util.GetMapString(cfg, "password")
+	info := cipher.GetCipherInfo(method)
+	if info == nil {
+		return nil, fmt.Errorf("unknown cipher method %s", method)
+	} else if password == "" {
+		return nil, fmt.Errorf("password is required")
+	}
+	key := sha256.Sum256([]byte(password))
+	return &obfsConfig{
+		info: info,
+		key:  key[:info.KeySize],
+	}, nil
+}
+
+func (h *ObfsHook) OnInit(name string, cfg map[string]interface{}) error {
+	ocfg, err := parseObfsConfig(cfg)
+	if err != nil {
+		return err
+	}
+	gObfsConfigs[name] = ocfg
+	return nil
+}
+
+func (*ObfsHook) CheckConfig(name string, cfg map[string]interface{}) error {
+	_, err := parseObfsConfig(cfg)
+	return err
+}
+
+func (h *ObfsHook) OnStart() error {
+	h.cfg = gObfsConfigs[h.BaseHook.Name]
+	if h.cfg == nil {
+		return fmt.Errorf("config not found")
+	}
+	return nil
+}
+
+/* 加密会修改数据，因此需要复制 */
+func (h *ObfsHook) OnWrite(data []byte) ([]byte, error) {
+	data = util.CopyBytes(data, len(data))
+	if h.encrypter == nil {
+		iv := make([]byte, h.cfg.info.IvSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+		h.encrypter = h.cfg.info.EncrypterFunc(h.cfg.key, iv)
+		return append(iv, h.encrypter.Encrypt(data)...), nil
+	}
+	return h.encrypter.Encrypt(data), nil
+}
+
+func (h *ObfsHook) OnRead(data []byte) ([]byte, error) {
+	if h.decrypter == nil {
+		h.iv = append(h.iv, data...)
+		size := h.cfg.info.IvSize
+		if len(h.iv) < size {
+			return nil, nil
+		}
+		h.decrypter = h.cfg.info.DecrypterFunc(h.cfg.key, h.iv[:size])
+		data = h.iv[size:]
+		h.iv = nil
+	} else {
+		data = util.CopyBytes(data, len(data))
+	}
+	return h.decrypter.Decrypt(data), nil
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 const (
@@ -63,6 +64,10 @@ var (
 		LEVEL_ERROR: "31m",
 	}
 	gLoggers map[string]map[string]*log.Logger = make(map[string]map[string]*log.Logger)
+
+	/* 重新加载配置时会重新初始化日志，已经打开的日志文件不再重复打开 */
+	gLock  sync.RWMutex
+	gFiles = make(map[string]*os.File)
 )
 
 /* 将文件路径转化为绝对路径 */
@@ -93,21 +98,31 @@ func openLogFile(filename string, target *os.File) (*os.File, bool) {
 	} else if filename == STDERR {
 		return os.Stderr, true
 	}
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-	if err != nil { /* 打开日志文件出错 */
-		fmt.Fprintf(os.Stderr, "fail to open log file %s : %s\n", filename, err)
-		return nil, false
+	file := gFiles[filename]
+	if file == nil {
+		var err error
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil { /* 打开日志文件出错 */
+			fmt.Fprintf(os.Stderr, "fail to open log file %s : %s\n", filename, err)
+			return nil, false
+		}
+		gFiles[filename] = file
 	}
 	return file, C.isatty(C.int(file.Fd())) > 0
 }
 
 /*
  * 初始化日志模块
- * 参数分别是日志配置、命名空间和是否输出命名空间
+ * 参数分别是日志配置、命名空间和是否输出命名空间，
+ * 重复初始化时替换命名空间原来的日志配置
  */
 func Init(cfg *Config) {
+	defer gLock.Unlock()
+	gLock.Lock()
+
 	namespace := cfg.Name
 	showNamespace := cfg.ShowName
+	loggers := make(map[string]*log.Logger)
 	for _, logger := range cfg.Loggers {
 		level := strings.ToUpper(logger.Level)
 		fd, isatty := openLogFile(logger.File, logger.Target)
@@ -124,9 +139,7 @@ func Init(cfg *Config) {
 		} else {
 			prefix = fmt.Sprintf("[%s]", name)
 		}
-		if gLoggers[namespace] == nil {
-			gLoggers[namespace] = make(map[string]*log.Logger)
-		}
-		gLoggers[namespace][level] = log.New(fd, prefix, gLogFlag)
+		loggers[level] = log.New(fd, prefix, gLogFlag)
 	}
+	gLoggers[namespace] = loggers
 }
@@ -18,6 +18,7 @@
 package log
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -25,10 +26,13 @@ var (
 	gDefaultName = ""
 )
 
-func output(namespace, level, fmt string, v ...interface{}) {
-	if loggers := gLoggers[namespace]; loggers != nil {
+func output(namespace, level, format string, v ...interface{}) {
+	gLock.RLock()
+	loggers := gLoggers[namespace]
+	gLock.RUnlock()
+	if loggers != nil {
 		if logger := loggers[level]; logger != nil {
-			logger.Printf(fmt, v...)
+			logger.Print(Redact(fmt.Sprintf(format, v...)))
 		}
 	}
 }
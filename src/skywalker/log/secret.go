@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ * 配置中的秘密（密码等），声明为秘密的配置项在代理信息中按配置项隐藏，
+ * 这里只是额外的保护：输出日志和代理信息时把秘密按照子串替换成REDACTED，
+ * 太短的秘密会误伤日志中的其他内容（比如地址和端口），因此不替换
+ */
+
+const (
+	REDACTED          = "******"
+	MIN_SECRET_LENGTH = 8
+)
+
+var (
+	gSecretLock sync.RWMutex
+	gRedactor   *strings.Replacer
+)
+
+/* 设置需要隐藏的秘密，替换之前的所有秘密，配置加载成功之后调用 */
+func SetSecrets(secrets []string) {
+	seen := make(map[string]bool)
+	var list []string
+	for _, s := range secrets {
+		if len(s) >= MIN_SECRET_LENGTH && !seen[s] {
+			seen[s] = true
+			list = append(list, s)
+		}
+	}
+
+	/* 长的优先替换，避免秘密包含另一个秘密时只替换了一部分 */
+	sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	var redactor *strings.Replacer
+	if len(list) > 0 {
+		var oldnew []string
+		for _, s := range list {
+			oldnew = append(oldnew, s, REDACTED)
+		}
+		redactor = strings.NewReplacer(oldnew...)
+	}
+
+	defer gSecretLock.Unlock()
+	gSecretLock.Lock()
+	gRedactor = redactor
+}
+
+/* 隐藏字符串中的秘密 */
+func Redact(s string) string {
+	gSecretLock.RLock()
+	redactor := gRedactor
+	gSecretLock.RUnlock()
+	if redactor == nil {
+		return s
+	}
+	return redactor.Replace(s)
+}
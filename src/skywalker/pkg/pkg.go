@@ -33,6 +33,7 @@ type (
 	connectResult struct {
 		connectRequest
 		code int
+		addr string /* 实际连接的服务器地址，可以为空 */
 	}
 
 	udpData struct {
@@ -54,6 +55,11 @@ const (
 	PKG_CONNECT_RESULT = 1
 	PKG_DATA           = 2
 	PKG_UDP_DATA       = 3
+	PKG_UDP_ASSOCIATE  = 4 /* 建立UDP转发，比如socks5的UDP ASSOCIATE */
+
+	PKG_UDP_ASSOCIATE_RESULT = 5
+	PKG_BIND                 = 6 /* 监听端口等待远程服务器连接，比如socks5的BIND */
+	PKG_BIND_RESULT          = 7
 )
 
 func (c *Package) Type() int {
@@ -99,6 +105,22 @@ func (c *Package) GetConnectResult() (int, string, int) {
 	return result.code, result.connectRequest.host, result.connectRequest.port
 }
 
+/* 设置实际连接的服务器地址，只对连接结果有效 */
+func (c *Package) SetConnectedAddr(addr string) {
+	if result, ok := c.data.(connectResult); ok {
+		result.addr = addr
+		c.data = result
+	}
+}
+
+/* 获取实际连接的服务器地址，没有设置时为空 */
+func (c *Package) GetConnectedAddr() string {
+	if result, ok := c.data.(connectResult); ok {
+		return result.addr
+	}
+	return ""
+}
+
 /* 连接请求 */
 func NewConnectPackage(host string, port int) *Package {
 	data := connectRequest{host: host, port: port}
@@ -133,3 +155,42 @@ func NewUDPDataPackage(host string, port int, data interface{}) *Package {
 	}
 	return &Package{cmd: PKG_UDP_DATA, data: udata}
 }
+
+/* UDP转发请求，参数是客户端发送UDP数据包的地址，可以为空 */
+func NewUDPAssociatePackage(host string, port int) *Package {
+	data := connectRequest{host: host, port: port}
+	return &Package{cmd: PKG_UDP_ASSOCIATE, data: data}
+}
+
+/* UDP转发结果，地址是为客户端分配的UDP转发地址，使用GetConnectResult获取 */
+func NewUDPAssociateResultPackage(code int, host string, port int) *Package {
+	data := connectResult{
+		connectRequest: connectRequest{
+			host: host,
+			port: port,
+		},
+		code: code,
+	}
+	return &Package{cmd: PKG_UDP_ASSOCIATE_RESULT, data: data}
+}
+
+/* 监听请求，参数是预期连接过来的远程服务器地址，可以为空 */
+func NewBindPackage(host string, port int) *Package {
+	data := connectRequest{host: host, port: port}
+	return &Package{cmd: PKG_BIND, data: data}
+}
+
+/*
+ * 监听结果，地址是监听的地址，使用GetConnectResult获取，
+ * 远程服务器连接之后再发送PKG_CONNECT_RESULT，地址是远程服务器的地址
+ */
+func NewBindResultPackage(code int, host string, port int) *Package {
+	data := connectResult{
+		connectRequest: connectRequest{
+			host: host,
+			port: port,
+		},
+		code: code,
+	}
+	return &Package{cmd: PKG_BIND_RESULT, data: data}
+}
@@ -22,10 +22,13 @@ import (
 	"fmt"
 	"net"
 	"skywalker/agent"
+	"skywalker/config"
 	"skywalker/log"
 	"skywalker/pkg"
+	"skywalker/resolver"
 	"skywalker/util"
 	"sync"
+	"time"
 )
 
 const (
@@ -33,10 +36,16 @@ const (
 	STATUS_RUNNING = 1
 	STATUS_ERROR   = 2
 
-	FLAG_UNSET         = -1
-	FLAG_NONE          = 0
-	FLAG_AGENT_CHANGED = 1
-	FLAG_ADDR_CHANGED  = 2
+	FLAG_UNSET          = -1
+	FLAG_NONE           = 0
+	FLAG_AGENT_CHANGED  = 1
+	FLAG_ADDR_CHANGED   = 2 /* 监听相关的配置改变，需要重启 */
+	FLAG_CONFIG_CHANGED = 3 /* 其他配置改变，新的连接直接使用 */
+)
+
+var (
+	/* 连接耗时直方图的区间上限，单位秒 */
+	CONNECT_LATENCY_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 )
 
 type (
@@ -47,6 +56,13 @@ type (
 		RemoteAddr    string /* 服务端地址 */
 		ConnectedTime int64  /* 链接建立时间 */
 		ClosedTime    int64  /* 链接关闭时间 */
+
+		/* 以下数据和ProxyInfo一样需要在ProxyInfo的锁中修改和读取 */
+		Sent           int64           /* 发送给服务端的数据量 */
+		Received       int64           /* 返回给客户端的数据量 */
+		SentQueue      *util.RateQueue /* 用于计算发送速度 */
+		ReceivedQueue  *util.RateQueue /* 用于计算接收速度 */
+		ConnectLatency int64           /* 连接远程服务器的耗时，单位纳秒，0表示还没有连接 */
 	}
 
 	ProxyInfo struct {
@@ -57,6 +73,10 @@ type (
 		SentQueue     *util.RateQueue /* 接收数据队列，用于计算网络速度 */
 		ReceivedQueue *util.RateQueue /* 发送数据队列，用于计算网络速度 */
 		Chains        *list.List      /* 链接记录 */
+
+		Connections    int64           /* 接受的客户端连接数 */
+		ConnectResults map[int]int64   /* 连接远程服务器的结果（pkg.CONNECT_RESULT_*）的次数 */
+		ConnectLatency *util.Histogram /* 连接远程服务器的耗时，单位秒 */
 	}
 
 	Proxy struct {
@@ -65,8 +85,13 @@ type (
 		CAName     string /* ca协议名 */
 		SAName     string /* sa协议名 */
 		Timeout    int    /* 客户端连接的超时时间 */
+		UDPTimeout int    /* UDP会话的空闲超时时间 */
 		Status     int    /* 状态 */
 
+		ConnectTimeout int    /* 连接远程服务器的超时时间 */
+		Prefer         string /* 连接远程服务器时优先的地址类型 */
+		DrainTimeout   int    /* 停止时等待已有连接结束的时间 */
+
 		BindAddr string
 		BindPort int
 
@@ -75,18 +100,48 @@ type (
 		AutoStart bool /* 是否自动启动 */
 		FastOpen  bool
 
+		CAHooks []string /* 客户端一侧的钩子 */
+		SAHooks []string /* 服务端一侧的钩子 */
+
 		tcpListener net.Listener
+		udpListener *net.UDPConn /* CA和SA都支持UDP时才会监听 */
+		tproxy      bool         /* 以TPROXY方式监听，由CA决定 */
+
+		udpLock     sync.Mutex
+		udpSessions map[string]*udpSession /* 以客户端地址区分的UDP会话，TPROXY还要区分目标地址 */
 
-		Flag int
+		connLock sync.Mutex
+		conns    map[net.Conn]bool /* 正在转发的TCP连接（客户端一侧），停止时等待它们结束 */
+
+		Flag   int
+		config *config.ProxyConfig /* 当前使用的配置，重新加载时用于比较 */
 
 		Signal chan bool
 	}
 )
 
+func newChain(clientAddr string) *Chain {
+	return &Chain{
+		ClientAddr:    clientAddr,
+		SentQueue:     util.NewRateQueue(2),
+		ReceivedQueue: util.NewRateQueue(2),
+	}
+}
+
 func (c *Chain) String() string {
 	return fmt.Sprintf("%s <==> %s", c.ClientAddr, c.RemoteAddr)
 }
 
+/* 连接远程服务器的选项 */
+func (p *Proxy) dialOptions() *util.DialOptions {
+	return &util.DialOptions{
+		Resolver: resolver.Get(p.Name),
+		Timeout:  time.Duration(p.ConnectTimeout) * time.Second,
+		Prefer:   p.Prefer,
+		Outbound: util.GetOutbound(p.Name),
+	}
+}
+
 func (p *Proxy) clarifyPackage(data interface{}) []*pkg.Package {
 	switch d := data.(type) {
 	case *pkg.Package:
@@ -119,9 +174,10 @@ func (p *Proxy) clarifyBytes(data interface{}) [][]byte {
  * @conn 远程连接(client/server)
  * @tdata 需要转发的数据(Transfer Data)，将发送给ic
  * @rdata 需要返回给数据(Response Data)，将发送给conn
+ * @chain 数据所属的链接，UDP转发的会话没有链接，为nil
  */
 func (p *Proxy) transferData(ic chan *pkg.Package, conn net.Conn, tdata interface{},
-	rdata interface{}, err error, isClient bool) error {
+	rdata interface{}, err error, isClient bool, chain *Chain) error {
 	for _, p := range p.clarifyPackage(tdata) { /* 转发数据 */
 		ic <- p
 	}
@@ -149,6 +205,15 @@ func (p *Proxy) transferData(ic chan *pkg.Package, conn net.Conn, tdata interfac
 				p.Info.Sent += size
 				p.Info.SentQueue.Push(size)
 			}
+			if chain == nil {
+				return
+			} else if isClient {
+				chain.Received += size
+				chain.ReceivedQueue.Push(size)
+			} else {
+				chain.Sent += size
+				chain.SentQueue.Push(size)
+			}
 		}()
 	}
 
@@ -158,6 +223,17 @@ func (p *Proxy) transferData(ic chan *pkg.Package, conn net.Conn, tdata interfac
 	return e
 }
 
+/* 记录一次连接远程服务器的结果和耗时，只有成功的连接记录耗时 */
+func (p *Proxy) recordConnect(result int, latency time.Duration, chain *Chain) {
+	defer p.Info.Unlock()
+	p.Info.Lock()
+	p.Info.ConnectResults[result]++
+	if result == pkg.CONNECT_RESULT_OK {
+		p.Info.ConnectLatency.Observe(latency.Seconds())
+		chain.ConnectLatency = int64(latency)
+	}
+}
+
 /* 返回CA和SA实例 */
 func (p *Proxy) GetAgents() (agent.ClientAgent, agent.ServerAgent) {
 	ca := agent.GetClientAgent(p.CAName, p.Name)
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+package proxy
+
+import (
+	"net"
+	"skywalker/hook"
+	"skywalker/pkg"
+	"sync"
+)
+
+/*
+ * 钩子
+ * 远程连接被包装成hookConn，读写的原始数据经过钩子处理，每个远程连接使用单独的一组钩子，
+ * 比如SA重新连接服务器时新的连接有自己的加密状态，连接关闭时关闭钩子；
+ * CA和SA之间的数据包经过一个转发的goroutine，由另一组钩子处理，
+ * 转发的goroutine结束时关闭钩子
+ */
+
+type hookConn struct {
+	net.Conn
+	p     *Proxy
+	hooks *hook.Chain
+	rbuf  []byte /* 钩子处理之后还没有读取的数据 */
+	once  sync.Once
+}
+
+func (c *hookConn) Read(b []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			data, herr := c.hooks.Read(append([]byte{}, b[:n]...))
+			if herr != nil {
+				c.p.WARN("Hook Read Error: %s %s", c.RemoteAddr(), herr.Error())
+				return 0, herr
+			}
+			c.rbuf = data
+		}
+		if err != nil {
+			if len(c.rbuf) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *hookConn) Write(b []byte) (int, error) {
+	data, err := c.hooks.Write(b)
+	if err != nil {
+		c.p.WARN("Hook Write Error: %s %s", c.RemoteAddr(), err.Error())
+		return 0, err
+	} else if len(data) > 0 {
+		if _, err := c.Conn.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+/* 关闭连接和钩子，可以多次调用 */
+func (c *hookConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.hooks.Close)
+	return err
+}
+
+/* 获取被钩子包装之前的连接，比如透明代理需要从socket中获取原始目标地址 */
+func rawConn(conn net.Conn) net.Conn {
+	if c, ok := conn.(*hookConn); ok {
+		return c.Conn
+	}
+	return conn
+}
+
+/*
+ * 用names中的钩子包装远程连接，没有钩子时返回原连接，
+ * 钩子属于返回的连接，连接关闭时关闭，创建钩子失败时关闭连接
+ */
+func (p *Proxy) hookConn(conn net.Conn, names []string) (net.Conn, error) {
+	hooks, err := hook.NewChain(names, p.Name)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	} else if hooks == nil {
+		return conn, nil
+	}
+	return &hookConn{Conn: conn, p: p, hooks: hooks}, nil
+}
+
+/* 为一个连接创建客户端和服务端两侧的数据包钩子 */
+func (p *Proxy) getHooks() (*hook.Chain, *hook.Chain, error) {
+	caHooks, err := hook.NewChain(p.CAHooks, p.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	saHooks, err := hook.NewChain(p.SAHooks, p.Name)
+	if err != nil {
+		if caHooks != nil {
+			caHooks.Close()
+		}
+		return nil, nil, err
+	}
+	return caHooks, saHooks, nil
+}
+
+/*
+ * 在CA和SA之间插入数据包钩子，返回钩子处理之后的channel，没有钩子时返回原channel
+ * 钩子返回错误时关闭返回的channel，之后的数据包都会被丢弃
+ */
+func (p *Proxy) hookPackages(in chan *pkg.Package, hooks *hook.Chain) chan *pkg.Package {
+	if hooks == nil {
+		return in
+	}
+	out := make(chan *pkg.Package, cap(in))
+	go func() {
+		defer hooks.Close()
+		vetoed := false
+		for cmd := range in {
+			if vetoed {
+				continue
+			}
+			cmd, err := hooks.Package(cmd)
+			if err != nil {
+				p.WARN("Hook Error: %s", err.Error())
+				vetoed = true
+				close(out)
+			} else if cmd != nil {
+				out <- cmd
+			}
+		}
+		if !vetoed {
+			close(out)
+		}
+	}()
+	return out
+}
@@ -20,6 +20,7 @@ package proxy
 import (
 	"container/list"
 	"net"
+	"skywalker/agent"
 	"skywalker/config"
 	"skywalker/util"
 	"time"
@@ -46,57 +47,93 @@ func New(cfg *config.ProxyConfig) *Proxy {
 	cname := cfg.ClientAgent
 	sname := cfg.ServerAgent
 	p := &Proxy{
-		Name:     name,
-		CAName:   cname,
-		SAName:   sname,
-		Timeout:  cfg.Timeout,
-		Status:   STATUS_STOPPED,
-		BindAddr: cfg.BindAddr,
-		BindPort: int(cfg.BindPort),
+		Name:       name,
+		CAName:     cname,
+		SAName:     sname,
+		Timeout:    cfg.Timeout,
+		UDPTimeout: cfg.UDPTimeout,
+		Status:     STATUS_STOPPED,
+		BindAddr:   cfg.BindAddr,
+		BindPort:   int(cfg.BindPort),
+
+		ConnectTimeout: cfg.ConnectTimeout,
+		Prefer:         cfg.Prefer,
+		DrainTimeout:   cfg.DrainTimeout,
+
 		Info: &ProxyInfo{
 			SentQueue:     util.NewRateQueue(2),
 			ReceivedQueue: util.NewRateQueue(2),
 			Chains:        list.New(),
+
+			ConnectResults: make(map[int]int64),
+			ConnectLatency: util.NewHistogram(CONNECT_LATENCY_BUCKETS),
 		},
-		AutoStart: cfg.AutoStart,
-		FastOpen:  cfg.FastOpen,
-		Signal:    make(chan bool, 1),
+		AutoStart:   cfg.AutoStart,
+		FastOpen:    cfg.FastOpen,
+		CAHooks:     cfg.CAHooks,
+		SAHooks:     cfg.SAHooks,
+		Signal:      make(chan bool, 1),
+		udpSessions: make(map[string]*udpSession),
+		conns:       make(map[net.Conn]bool),
+		config:      cfg,
 	}
 
 	return p
 }
 
-func (p *Proxy) Update(cfg *config.ProxyConfig) bool {
+/*
+ * 更新配置，返回改变了的配置项
+ * CA、SA、钩子和其他配置在建立连接时读取，新的连接直接使用，
+ * 只有监听地址或者监听方式（TPROXY、UDP）改变时才需要重启，此时p.Flag是FLAG_ADDR_CHANGED
+ */
+func (p *Proxy) Update(cfg *config.ProxyConfig) []string {
 	defer p.Unlock()
 	p.Lock()
 
+	changes := cfg.Diff(p.config)
 	p.Flag = FLAG_NONE
-	p.Name = cfg.Name
-	if p.CAName != cfg.ClientAgent {
-		p.CAName = cfg.ClientAgent
-		p.Flag = FLAG_AGENT_CHANGED
+	if len(changes) > 0 {
+		p.Flag = FLAG_CONFIG_CHANGED
 	}
-	if p.SAName != cfg.ServerAgent {
-		p.SAName = cfg.ServerAgent
+	if p.CAName != cfg.ClientAgent || p.SAName != cfg.ServerAgent {
 		p.Flag = FLAG_AGENT_CHANGED
 	}
-	if p.BindAddr != cfg.BindAddr {
-		p.BindAddr = cfg.BindAddr
+	/* CA的配置也可能改变监听方式，和正在使用的监听套接字比较 */
+	if p.Status == STATUS_RUNNING && (p.tproxy != agent.TProxySupported(cfg.ClientAgent) ||
+		(p.udpListener != nil) != agent.UDPSupported(cfg.ClientAgent, cfg.ServerAgent)) {
 		p.Flag = FLAG_ADDR_CHANGED
 	}
-	if p.BindPort != int(cfg.BindPort) {
-		p.BindPort = int(cfg.BindPort)
+	if p.BindAddr != cfg.BindAddr || p.BindPort != int(cfg.BindPort) || p.FastOpen != cfg.FastOpen {
 		p.Flag = FLAG_ADDR_CHANGED
 	}
 
+	p.Name = cfg.Name
+	p.CAName = cfg.ClientAgent
+	p.SAName = cfg.ServerAgent
+	p.BindAddr = cfg.BindAddr
+	p.BindPort = int(cfg.BindPort)
+	p.FastOpen = cfg.FastOpen
 	p.AutoStart = cfg.AutoStart
+	p.Timeout = cfg.Timeout
+	p.UDPTimeout = cfg.UDPTimeout
+	p.CAHooks = cfg.CAHooks
+	p.SAHooks = cfg.SAHooks
+	p.ConnectTimeout = cfg.ConnectTimeout
+	p.Prefer = cfg.Prefer
+	p.DrainTimeout = cfg.DrainTimeout
+	p.config = cfg
 
-	return p.Flag != FLAG_NONE
+	return changes
 }
 
 func (p *Proxy) Close() {
-	p.INFO("%s:%d stopped", p.BindAddr, p.BindPort)
+	/* 重新加载配置时监听地址可能已经改变 */
+	p.INFO("%s stopped", p.tcpListener.Addr())
 	p.tcpListener.Close()
+	if p.udpListener != nil {
+		p.udpListener.Close()
+		p.closeUDPSessions()
+	}
 	p.Status = STATUS_STOPPED
 }
 
@@ -107,14 +144,37 @@ func (p *Proxy) start() error {
 
 	var tcpListener *net.TCPListener
 	var err error
-	if tcpListener, err = util.TCPListen(p.BindAddr, p.BindPort, p.FastOpen); err != nil {
+	tproxy := agent.TProxySupported(p.CAName)
+	if tproxy {
+		tcpListener, err = util.TransparentTCPListen(p.BindAddr, p.BindPort, p.FastOpen)
+	} else {
+		tcpListener, err = util.TCPListen(p.BindAddr, p.BindPort, p.FastOpen)
+	}
+	if err != nil {
 		p.Status = STATUS_ERROR
 		p.ERROR("failed to listen tcp: %s", err)
 		return err
 	}
+	/* CA和SA都支持UDP，同时监听UDP端口 */
+	var udpListener *net.UDPConn
+	if agent.UDPSupported(p.CAName, p.SAName) {
+		if tproxy {
+			udpListener, err = util.TransparentUDPListen(p.BindAddr, p.BindPort)
+		} else {
+			udpListener, err = util.UDPListen(p.BindAddr, p.BindPort)
+		}
+		if err != nil {
+			tcpListener.Close()
+			p.Status = STATUS_ERROR
+			p.ERROR("failed to listen udp: %s", err)
+			return err
+		}
+	}
 
 	p.INFO("%s:%d started", p.BindAddr, p.BindPort)
 	p.tcpListener = tcpListener
+	p.udpListener = udpListener
+	p.tproxy = tproxy
 	p.Status = STATUS_STOPPED
 	p.Info.StartTime = time.Now().Unix()
 	go p.Run()
@@ -129,7 +189,8 @@ func (p *Proxy) Start() error {
 	return p.start()
 }
 
-func (p *Proxy) stop() error {
+/* 停止监听，返回正在转发的连接 */
+func (p *Proxy) stop() []net.Conn {
 	if p.Status != STATUS_RUNNING {
 		return nil
 	}
@@ -138,25 +199,116 @@ func (p *Proxy) stop() error {
 	for p.Status == STATUS_RUNNING {
 		time.Sleep(time.Millisecond * 50)
 	}
-	return nil
+	return p.liveConns()
 }
 
-/* 停止服务 */
-func (p *Proxy) Stop() error {
+/* 停止服务，等待已有的连接结束，返回结束的连接数和被强制关闭的连接数 */
+func (p *Proxy) Stop() (int, int, error) {
+	p.Lock()
+	conns := p.stop()
+	p.Unlock()
+
+	drained, killed := p.Drain(conns)
+	return drained, killed, nil
+}
+
+/* 停止服务，不等待已有的连接结束，返回需要用Drain等待的连接 */
+func (p *Proxy) Shutdown() []net.Conn {
 	defer p.Unlock()
 	p.Lock()
 
 	return p.stop()
 }
 
-func (p *Proxy) Restart() error {
+/* 重新监听，新的连接不用等待旧的连接结束，返回需要用Drain等待的旧连接 */
+func (p *Proxy) Relisten() ([]net.Conn, error) {
 	defer p.Unlock()
 	p.Lock()
 
-	if err := p.stop(); err != nil {
-		return err
+	conns := p.stop()
+	return conns, p.start()
+}
+
+func (p *Proxy) Restart() error {
+	conns, err := p.Relisten()
+	p.Drain(conns)
+	return err
+}
+
+/* 记录正在转发的连接，使用钩子包装之前的连接 */
+func (p *Proxy) addConn(conn net.Conn) {
+	defer p.connLock.Unlock()
+	p.connLock.Lock()
+	p.conns[conn] = true
+}
+
+func (p *Proxy) removeConn(conn net.Conn) {
+	defer p.connLock.Unlock()
+	p.connLock.Lock()
+	delete(p.conns, conn)
+}
+
+/* 关闭还没有开始转发的连接 */
+func (p *Proxy) closeConn(conn net.Conn) {
+	conn.Close()
+	p.removeConn(conn)
+}
+
+/* 正在转发的连接数 */
+func (p *Proxy) ConnCount() int {
+	defer p.connLock.Unlock()
+	p.connLock.Lock()
+	return len(p.conns)
+}
+
+/* 正在转发的连接 */
+func (p *Proxy) liveConns() []net.Conn {
+	defer p.connLock.Unlock()
+	p.connLock.Lock()
+	conns := make([]net.Conn, 0, len(p.conns))
+	for conn := range p.conns {
+		conns = append(conns, conn)
 	}
-	return p.start()
+	return conns
+}
+
+/* conns中还没有结束的连接 */
+func (p *Proxy) remainingConns(conns []net.Conn) []net.Conn {
+	defer p.connLock.Unlock()
+	p.connLock.Lock()
+	var remaining []net.Conn
+	for _, conn := range conns {
+		if p.conns[conn] {
+			remaining = append(remaining, conn)
+		}
+	}
+	return remaining
+}
+
+/*
+ * 等待conns中的连接结束，最多等待DrainTimeout秒，然后强制关闭剩下的连接，
+ * 关闭客户端连接之后caGoroutine和saGoroutine都会退出；
+ * 重启之后新接受的连接不在conns中，不需要等待
+ */
+func (p *Proxy) Drain(conns []net.Conn) (int, int) {
+	total := len(conns)
+	if total == 0 {
+		return 0, 0
+	}
+	p.INFO("waiting for %d connection(s) to finish", total)
+	deadline := time.Now().Add(time.Second * time.Duration(p.DrainTimeout))
+	for len(conns) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 50)
+		conns = p.remainingConns(conns)
+	}
+
+	conns = p.remainingConns(conns)
+	for _, conn := range conns {
+		conn.Close()
+	}
+	killed := len(conns)
+	p.INFO("%d connection(s) drained, %d killed", total-killed, killed)
+	return total - killed, killed
 }
 
 /* 将TCP监听套接字转化为channel的监听 */
@@ -179,15 +331,27 @@ func (p *Proxy) getTCPListener() chan net.Conn {
 type (
 	udpPackage struct {
 		addr *net.UDPAddr
+		dst  *net.UDPAddr /* TPROXY的原始目标地址 */
 		data []byte
 	}
 )
 
+/* 将UDP监听套接字转化为channel的监听，没有监听UDP时返回nil */
+func (p *Proxy) getUDPListener() chan *udpPackage {
+	if p.udpListener == nil {
+		return nil
+	} else if p.tproxy {
+		return p.createTProxyUDPChannel(p.udpListener)
+	}
+	return p.createUDPChannel(p.udpListener)
+}
+
 /* 执行代理 */
 func (p *Proxy) Run() {
 	defer p.Close()
 
 	tcpListener := p.getTCPListener()
+	udpListener := p.getUDPListener()
 
 LOOP:
 	for {
@@ -197,11 +361,23 @@ LOOP:
 			if !ok {
 				break LOOP
 			}
+			/* 在这里记录连接，停止代理时Run返回之后才会等待连接结束，不会遗漏 */
+			p.addConn(conn)
 			go p.handleTCP(conn)
+		case up, ok := <-udpListener:
+			if !ok {
+				break LOOP
+			}
+			p.handleUDP(up)
 		case quit, _ := <-p.Signal:
 			if quit {
 				break LOOP
 			}
 		}
 	}
+	/* 关闭已经接受但是还没有处理的连接，接受连接的goroutine才能退出 */
+	p.tcpListener.Close()
+	for conn := range tcpListener {
+		conn.Close()
+	}
 }
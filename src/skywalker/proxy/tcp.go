@@ -21,39 +21,74 @@ import (
 	"fmt"
 	"net"
 	"skywalker/agent"
+	"skywalker/config"
 	"skywalker/pkg"
+	"skywalker/resolver"
 	"skywalker/util"
 	"time"
 )
 
-/* 启动数据转发流程 */
+/* 启动数据转发流程，conn在接受时已经记录，出错时需要删除 */
 func (p *Proxy) handleTCP(conn net.Conn) {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && p.tproxy && p.isSelf(addr.IP, addr.Port) {
+		p.WARN("connection from %s is not redirected", conn.RemoteAddr())
+		p.closeConn(conn)
+		return
+	}
 	ca, sa := p.GetAgents()
 	if ca == nil || sa == nil {
-		conn.Close()
+		p.closeConn(conn)
+		return
+	}
+	caHooks, saHooks, err := p.getHooks()
+	if err != nil {
+		p.WARN("%s", err.Error())
+		p.closeConn(conn)
+		return
+	}
+	raw := conn
+	if conn, err = p.hookConn(conn, p.CAHooks); err != nil {
+		p.WARN("%s", err.Error())
+		caHooks.Close()
+		saHooks.Close()
+		p.closeConn(raw)
 		return
 	}
+	p.Info.Lock()
+	p.Info.Connections++
+	p.Info.Unlock()
 	c2s := make(chan *pkg.Package, 100)
 	s2c := make(chan *pkg.Package, 100)
-	go p.caGoroutine(ca, c2s, s2c, conn)
-	go p.saGoroutine(sa, c2s, s2c)
+	chain := newChain(conn.RemoteAddr().String())
+	go p.caGoroutine(ca, c2s, p.hookPackages(s2c, saHooks), conn, chain)
+	go p.saGoroutine(sa, p.hookPackages(c2s, caHooks), s2c, conn, chain)
+}
+
+/* TPROXY的目标地址是代理自身，比如直接连接代理端口，转发会形成环路 */
+func (p *Proxy) isSelf(ip net.IP, port int) bool {
+	return port == p.BindPort && util.IsLocalIP(ip)
 }
 
 /* 处理客户端连接的goroutine */
 func (p *Proxy) caGoroutine(ca agent.ClientAgent,
 	c2s chan *pkg.Package,
 	s2c chan *pkg.Package,
-	cConn net.Conn) {
+	cConn net.Conn,
+	chain *Chain) {
+	defer p.removeConn(rawConn(cConn))
 	defer cConn.Close()
 	defer close(c2s)
 
-	cChan := util.CreateConnChannel(cConn, p.Timeout)
+	cChan := util.CreateConnChannel(cConn, -1)
 
-	chain := &Chain{
-		ClientAddr:    cConn.RemoteAddr().String(),
-		RemoteAddr:    "",
-		ConnectedTime: 0,
-		ClosedTime:    0,
+	/* 客户端的超时，UDP转发期间控制连接没有数据，不再计算超时 */
+	timeout := time.Second * time.Duration(p.Timeout)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	timerC := timer.C
+	if p.Timeout <= 0 {
+		timer.Stop()
+		timerC = nil
 	}
 
 	p.Info.Lock()
@@ -61,8 +96,16 @@ func (p *Proxy) caGoroutine(ca agent.ClientAgent,
 	p.Info.Unlock()
 
 	closedByClient := true
+	accepted := true
+	if cca, ok := ca.(agent.ConnClientAgent); ok {
+		cmd, rdata, err := cca.OnAccept(rawConn(cConn))
+		if err := p.transferData(c2s, cConn, cmd, rdata, err, true, chain); err != nil {
+			p.WARN("Accept Client Error: %s %s", cConn.RemoteAddr(), err.Error())
+			accepted = false
+		}
+	}
 RUNNING:
-	for {
+	for accepted {
 		select {
 		case data, ok := <-cChan:
 			/* 来自客户端的数据 */
@@ -70,11 +113,17 @@ RUNNING:
 				break RUNNING
 			}
 			cmd, rdata, err := ca.ReadFromClient(data)
-			if err := p.transferData(c2s, cConn, cmd, rdata, err, true); err != nil {
+			if err := p.transferData(c2s, cConn, cmd, rdata, err, true, chain); err != nil {
 				p.WARN("Read From Client Error: %s %s", cConn.RemoteAddr(),
 					err.Error())
 				break RUNNING
 			}
+			if timerC != nil {
+				timer.Reset(timeout)
+			}
+		case <-timerC:
+			/* 客户端超时 */
+			break RUNNING
 		case cmd, ok := <-s2c:
 			/* 来自服务端代理的数据 */
 			if ok == false {
@@ -83,22 +132,48 @@ RUNNING:
 			} else if cmd.Type() == pkg.PKG_DATA {
 				for _, data := range cmd.GetData() {
 					cmd, rdata, err := ca.ReadFromSA(data)
-					if err := p.transferData(c2s, cConn, cmd, rdata, err, true); err != nil {
+					if err := p.transferData(c2s, cConn, cmd, rdata, err, true, chain); err != nil {
 						closedByClient = false
 						p.WARN("Read From SA Error: %s %s", cConn.RemoteAddr(),
 							err.Error())
 						break RUNNING
 					}
 				}
-			} else if cmd.Type() == pkg.PKG_CONNECT_RESULT {
+			} else if cmd.Type() == pkg.PKG_CONNECT_RESULT || cmd.Type() == pkg.PKG_UDP_ASSOCIATE_RESULT {
 				result, host, port := cmd.GetConnectResult()
 				if result == pkg.CONNECT_RESULT_OK {
 					chain.RemoteAddr = fmt.Sprintf("%s:%v", host, port)
+					/* 实际连接的地址，可能是上游代理的地址 */
+					if addr := cmd.GetConnectedAddr(); addr != "" && addr != chain.RemoteAddr {
+						chain.RemoteAddr += "(" + addr + ")"
+					}
 					chain.ConnectedTime = time.Now().UnixNano()
+					if cmd.Type() == pkg.PKG_UDP_ASSOCIATE_RESULT {
+						chain.RemoteAddr = "udp://" + chain.RemoteAddr
+						timer.Stop()
+						timerC = nil
+					}
 					p.INFO("%s Connected", chain.String())
 				}
 				cmd, rdata, err := ca.OnConnectResult(result, host, port)
-				err = p.transferData(c2s, cConn, cmd, rdata, err, true)
+				err = p.transferData(c2s, cConn, cmd, rdata, err, true, chain)
+				if result != pkg.CONNECT_RESULT_OK || err != nil {
+					closedByClient = false
+					break RUNNING
+				}
+			} else if cmd.Type() == pkg.PKG_BIND_RESULT {
+				bca, ok := ca.(agent.BindClientAgent)
+				if !ok {
+					p.ERROR("Client Agent Doesn't Support BIND! THIS IS A BUG!")
+					closedByClient = false
+					break RUNNING
+				}
+				result, host, port := cmd.GetConnectResult()
+				if result == pkg.CONNECT_RESULT_OK {
+					p.DEBUG("%s Listening On %s", chain.ClientAddr, util.JoinHostPort(host, port))
+				}
+				cmd, rdata, err := bca.OnBindResult(result, host, port)
+				err = p.transferData(c2s, cConn, cmd, rdata, err, true, chain)
 				if result != pkg.CONNECT_RESULT_OK || err != nil {
 					closedByClient = false
 					break RUNNING
@@ -131,16 +206,39 @@ RUNNING:
  * 失败返回nil,nil,"",0
  */
 func (p *Proxy) connectRemote(originalHost string, originalPort int, sa agent.ServerAgent,
-	s2c chan *pkg.Package) (net.Conn, chan []byte, string, int) {
+	s2c chan *pkg.Package, chain *Chain) (net.Conn, chan []byte, string, int) {
 	var conn net.Conn
 	var result int
+	var connectedAddr string
+	var err error
 	/* 获取服务器地址，并链接 */
 	host, port := sa.GetRemoteAddress(originalHost, originalPort)
 	if host == "" {
 		conn = util.NewFakeConn()
 		result = pkg.CONNECT_RESULT_OK
 	} else {
-		conn, result = util.TCPConnect(host, port)
+		start := time.Now()
+		conn, result = util.TCPConnect(host, port, p.dialOptions())
+		/* 连接失败时依次尝试SA的其他候选服务器 */
+		fsa, ok := sa.(agent.FailoverServerAgent)
+		for ok && result != pkg.CONNECT_RESULT_OK {
+			nextHost, nextPort := fsa.NextRemoteAddress(host, port, result)
+			if nextHost == "" {
+				break
+			}
+			p.WARN("connect %s failed(%d), try %s", util.JoinHostPort(host, port), result,
+				util.JoinHostPort(nextHost, nextPort))
+			host, port = nextHost, nextPort
+			conn, result = util.TCPConnect(host, port, p.dialOptions())
+		}
+		p.recordConnect(result, time.Since(start), chain)
+		if result == pkg.CONNECT_RESULT_OK {
+			connectedAddr = conn.RemoteAddr().String()
+			if conn, err = p.hookConn(conn, p.SAHooks); err != nil {
+				p.WARN("%s", err.Error())
+				result = pkg.CONNECT_RESULT_UNKNOWN_ERROR
+			}
+		}
 	}
 	/* 连接结果 */
 	var resultCMD *pkg.Package
@@ -149,6 +247,7 @@ func (p *Proxy) connectRemote(originalHost string, originalPort int, sa agent.Se
 		resultCMD = pkg.NewConnectResultPackage(result, originalHost, originalPort)
 	} else {
 		resultCMD = pkg.NewConnectResultPackage(result, originalHost, originalPort)
+		resultCMD.SetConnectedAddr(connectedAddr)
 	}
 	/* 给客户端代理发送连接结果反馈 */
 	s2c <- resultCMD
@@ -162,7 +261,7 @@ func (p *Proxy) connectRemote(originalHost string, originalPort int, sa agent.Se
 	}
 
 	/* 发送服务端代理的处理后数据 */
-	if err := p.transferData(s2c, conn, cmd, rdata, err, false); err != nil {
+	if err := p.transferData(s2c, conn, cmd, rdata, err, false, chain); err != nil {
 		p.WARN("Server Agent OnConnectResult Error, %s", err.Error())
 		conn.Close()
 		return nil, nil, "", 0
@@ -171,26 +270,227 @@ func (p *Proxy) connectRemote(originalHost string, originalPort int, sa agent.Se
 	return conn, util.CreateConnChannel(conn, -1), host, port
 }
 
+/*
+ * 获取连接远程服务器使用的本地IP，用于监听端口
+ * 无法确定时使用客户端连接的本地IP
+ */
+func (p *Proxy) getBindIP(host string, port int, cConn net.Conn) net.IP {
+	outbound := util.GetOutbound(p.Name)
+	if ip := outbound.IP(); ip != nil {
+		return ip
+	}
+	if port == 0 {
+		port = 1
+	}
+	if ip, err := resolver.Get(p.Name).ResolveHost(host); err == nil && !net.ParseIP(ip).IsUnspecified() {
+		/* UDP连接不会发送数据，只是用来选择本地地址 */
+		if conn, err := outbound.Dialer("udp", 0).Dial("udp", util.JoinHostPort(ip, port)); err == nil {
+			defer conn.Close()
+			return conn.LocalAddr().(*net.UDPAddr).IP
+		}
+	}
+	if addr, ok := cConn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+/*
+ * 监听端口，等待远程服务器连接
+ * SA返回上游服务器地址时连接上游服务器，监听结果和连接结果由SA负责，
+ * 否则在本地监听，只接受一个连接，如果指定了远程服务器的IP，只接受该IP的连接
+ * 成功返回net.Conn和对应的channel，以及等待过程中CA发送的数据包
+ */
+func (p *Proxy) bindRemote(originalHost string, originalPort int, sa agent.ServerAgent,
+	c2s chan *pkg.Package, s2c chan *pkg.Package, cConn net.Conn,
+	chain *Chain) (net.Conn, chan []byte, []*pkg.Package) {
+	bsa, ok := sa.(agent.BindServerAgent)
+	if !ok {
+		p.WARN("BIND is not supported by %s", p.SAName)
+		s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+		return nil, nil, nil
+	}
+
+	host, port := bsa.GetBindAddress(originalHost, originalPort)
+	if host != "" {
+		conn, result := util.TCPConnect(host, port, p.dialOptions())
+		var err error
+		if result != pkg.CONNECT_RESULT_OK {
+			p.DEBUG("tcp connect result %d", result)
+			s2c <- pkg.NewBindResultPackage(result, originalHost, originalPort)
+			return nil, nil, nil
+		}
+		if conn, err = p.hookConn(conn, p.SAHooks); err != nil {
+			p.WARN("%s", err.Error())
+			s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+			return nil, nil, nil
+		}
+		cmd, rdata, err := sa.OnConnectResult(result, host, port)
+		if err := p.transferData(s2c, conn, cmd, rdata, err, false, chain); err != nil {
+			p.WARN("Server Agent OnConnectResult Error, %s", err.Error())
+			s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+			conn.Close()
+			return nil, nil, nil
+		}
+		return conn, util.CreateConnChannel(conn, -1), nil
+	}
+
+	peerIPs, err := p.bindPeers(originalHost)
+	if err != nil {
+		p.WARN("failed to resolve BIND address %s: %s", originalHost, err)
+		s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_HOST, originalHost, originalPort)
+		return nil, nil, nil
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: p.getBindIP(originalHost, originalPort, cConn)})
+	if err != nil {
+		p.WARN("failed to listen tcp: %s", err)
+		s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+		return nil, nil, nil
+	}
+	defer listener.Close()
+	/* 远程服务器一直不连接时不再占用端口 */
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = config.DEFAULT_TIMEOUT
+	}
+	listener.SetDeadline(time.Now().Add(time.Second * time.Duration(timeout)))
+	bindAddr := listener.Addr().(*net.TCPAddr)
+	s2c <- pkg.NewBindResultPackage(pkg.CONNECT_RESULT_OK, bindAddr.IP.String(), bindAddr.Port)
+
+	acceptChan := make(chan net.Conn)
+	go func() {
+		defer close(acceptChan)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					p.DEBUG("no connection to %s in %d seconds", bindAddr, timeout)
+				}
+				return
+			}
+			addr := conn.RemoteAddr().(*net.TCPAddr)
+			if !acceptPeer(peerIPs, addr.IP) {
+				p.DEBUG("connection from %s rejected", addr)
+				conn.Close()
+				continue
+			}
+			acceptChan <- conn
+			return
+		}
+	}()
+
+	/* 远程服务器连接之前，客户端关闭则放弃监听 */
+	var pending []*pkg.Package
+	for {
+		select {
+		case conn, ok := <-acceptChan:
+			if ok == false {
+				s2c <- pkg.NewConnectResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+				return nil, nil, nil
+			}
+			addr := conn.RemoteAddr().(*net.TCPAddr)
+			if conn, err = p.hookConn(conn, p.SAHooks); err != nil {
+				p.WARN("%s", err.Error())
+				s2c <- pkg.NewConnectResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, originalHost, originalPort)
+				return nil, nil, nil
+			}
+			s2c <- pkg.NewConnectResultPackage(pkg.CONNECT_RESULT_OK, addr.IP.String(), addr.Port)
+			cmd, rdata, err := sa.OnConnectResult(pkg.CONNECT_RESULT_OK, addr.IP.String(), addr.Port)
+			if err := p.transferData(s2c, conn, cmd, rdata, err, false, chain); err != nil {
+				p.WARN("Server Agent OnConnectResult Error, %s", err.Error())
+				conn.Close()
+				return nil, nil, nil
+			}
+			return conn, util.CreateConnChannel(conn, -1), pending
+		case cmd, ok := <-c2s:
+			if ok == false {
+				listener.Close()
+				for conn := range acceptChan {
+					conn.Close()
+				}
+				return nil, nil, nil
+			}
+			pending = append(pending, cmd)
+		}
+	}
+}
+
+/*
+ * BIND请求中的远程服务器地址，域名解析成所有的IP，
+ * 返回nil表示地址是未指定的，接受任意地址的连接
+ */
+func (p *Proxy) bindPeers(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return nil, nil
+	}
+	ips, err := resolver.Get(p.Name).Lookup(host)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("no address for %s", host)
+	}
+	return ips, err
+}
+
+/* ip是否是BIND预期的远程服务器 */
+func acceptPeer(peers []net.IP, ip net.IP) bool {
+	if peers == nil {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 /*
  * 处理服务器连接的goroutine
- * 从客户端代理收到的第一个数据包一定是服务器地址，无论该数据包被标志成什么类型
+ * 从客户端代理收到的第一个数据包必须是连接请求、监听请求或者UDP转发请求，
+ * cConn是客户端连接，只用于监听端口和建立UDP转发
  */
 func (p *Proxy) saGoroutine(sa agent.ServerAgent,
 	c2s chan *pkg.Package,
-	s2c chan *pkg.Package) {
+	s2c chan *pkg.Package,
+	cConn net.Conn,
+	chain *Chain) {
 	defer close(s2c)
 
-	/* 第一个数据包必须是连接请求 */
 	cmd, ok := <-c2s
-	if ok == false || cmd.Type() != pkg.PKG_CONNECT {
+	if ok == false {
+		return
+	} else if cmd.Type() == pkg.PKG_UDP_ASSOCIATE {
+		host, port := cmd.GetConnectRequest()
+		p.udpAssociate(sa, c2s, s2c, cConn, host, port, chain)
+		return
+	} else if cmd.Type() != pkg.PKG_CONNECT && cmd.Type() != pkg.PKG_BIND {
 		return
 	}
+	var sConn net.Conn
+	var sChan chan []byte
+	var pending []*pkg.Package
 	host, port := cmd.GetConnectRequest()
-	sConn, sChan, _, _ := p.connectRemote(host, port, sa, s2c)
+	if cmd.Type() == pkg.PKG_BIND {
+		sConn, sChan, pending = p.bindRemote(host, port, sa, c2s, s2c, cConn, chain)
+	} else {
+		sConn, sChan, _, _ = p.connectRemote(host, port, sa, s2c, chain)
+	}
 	if sConn == nil {
 		return
 	}
 
+	/* 监听期间收到的数据 */
+	for _, cmd := range pending {
+		for _, data := range cmd.GetData() {
+			cmd, rdata, err := sa.ReadFromCA(data)
+			if err := p.transferData(s2c, sConn, cmd, rdata, err, false, chain); err != nil {
+				p.WARN("Read From CA Error: %s %s", sConn.RemoteAddr(), err.Error())
+				sConn.Close()
+				sa.OnClose(true)
+				return
+			}
+		}
+	}
+
 	closedByClient := true
 RUNNING:
 	for {
@@ -202,7 +502,7 @@ RUNNING:
 				break RUNNING
 			}
 			cmd, rdata, err := sa.ReadFromServer(data)
-			if err := p.transferData(s2c, sConn, cmd, rdata, err, false); err != nil {
+			if err := p.transferData(s2c, sConn, cmd, rdata, err, false, chain); err != nil {
 				closedByClient = false
 				p.WARN("Read From Server Error: %s %s", sConn.RemoteAddr(),
 					err.Error())
@@ -216,17 +516,21 @@ RUNNING:
 			if cmd.Type() == pkg.PKG_DATA {
 				for _, data := range cmd.GetData() {
 					cmd, rdata, err := sa.ReadFromCA(data)
-					if _err := p.transferData(s2c, sConn, cmd, rdata, err, false); _err != nil {
+					if _err := p.transferData(s2c, sConn, cmd, rdata, err, false, chain); _err != nil {
 						p.WARN("Read From CA Error: %s %s", sConn.RemoteAddr(),
 							_err.Error())
 						break RUNNING
 					}
 				}
 			} else if cmd.Type() == pkg.PKG_CONNECT {
-				/* 需要重新链接服务器 */
+				/* 需要重新链接服务器，丢弃旧连接上剩余的数据 */
 				sConn.Close()
+				go func(c chan []byte) {
+					for _ = range c {
+					}
+				}(sChan)
 				host, port := cmd.GetConnectRequest()
-				if sConn, sChan, _, _ = p.connectRemote(host, port, sa, s2c); sConn == nil {
+				if sConn, sChan, _, _ = p.connectRemote(host, port, sa, s2c, chain); sConn == nil {
 					break RUNNING
 				}
 			} else {
@@ -0,0 +1,455 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package proxy
+
+import (
+	"net"
+	"skywalker/agent"
+	"skywalker/pkg"
+	"skywalker/resolver"
+	"skywalker/util"
+	"time"
+)
+
+/*
+ * UDP转发
+ * 每个客户端地址对应一个UDP会话，每个会话同样包含一个caGoroutine和saGoroutine，
+ * 会话在超过UDPTimeout秒没有收到客户端和服务端的数据后关闭，
+ * TPROXY的每个客户端地址和原始目标地址对应一个UDP会话
+ */
+
+const (
+	UDP_MAX_PACKAGE_SIZE   = 65536
+	UDP_SESSION_QUEUE_SIZE = 100
+)
+
+type (
+	udpSession struct {
+		key     string        /* 在udpSessions中的键，UDP转发的会话为空 */
+		conn    *net.UDPConn  /* 接收客户端数据包的套接字，TPROXY的会话是以原始目标地址连接客户端的套接字 */
+		addr    *net.UDPAddr  /* 客户端地址，UDP转发的会话在收到第一个数据包时确定 */
+		c       chan []byte   /* 来自客户端的数据包 */
+		timeout time.Duration /* 空闲超时时间，0表示不会超时 */
+		tproxy  bool          /* TPROXY的会话，conn在会话结束时关闭 */
+		chain   *Chain        /* UDP转发所属的控制连接，其他会话为nil */
+	}
+
+	/* 把UDP套接字和对端地址包装成net.Conn，以便复用transferData */
+	udpConn struct {
+		*net.UDPConn
+		addr *net.UDPAddr
+	}
+)
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	if c.UDPConn.RemoteAddr() != nil { /* 已经连接的套接字不能指定地址 */
+		return c.UDPConn.Write(b)
+	}
+	return c.UDPConn.WriteToUDP(b, c.addr)
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+/* 套接字是共享的，不能关闭 */
+func (c *udpConn) Close() error {
+	return nil
+}
+
+func (s *udpSession) String() string {
+	if s.addr == nil {
+		return s.conn.LocalAddr().String()
+	}
+	return s.addr.String()
+}
+
+/* 将UDP套接字转化为channel的监听 */
+func (p *Proxy) createUDPChannel(conn *net.UDPConn) chan *udpPackage {
+	c := make(chan *udpPackage)
+	go func(conn *net.UDPConn, c chan *udpPackage) {
+		defer close(c)
+		buf := make([]byte, UDP_MAX_PACKAGE_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			data := make([]byte, n) /* channel会把数据的引用传递出去，因此需要复制 */
+			copy(data, buf[:n])
+			c <- &udpPackage{addr: addr, data: data}
+		}
+	}(conn, c)
+	return c
+}
+
+/* 将TPROXY的UDP监听套接字转化为channel的监听，同时读取原始目标地址 */
+func (p *Proxy) createTProxyUDPChannel(conn *net.UDPConn) chan *udpPackage {
+	c := make(chan *udpPackage)
+	go func(conn *net.UDPConn, c chan *udpPackage) {
+		defer close(c)
+		buf := make([]byte, UDP_MAX_PACKAGE_SIZE)
+		for {
+			n, addr, dst, err := util.ReadFromUDPOrigDst(conn, buf)
+			if err != nil {
+				break
+			} else if dst == nil {
+				p.DEBUG("UDP package from %s has no original destination", addr)
+				continue
+			}
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			c <- &udpPackage{addr: addr, dst: dst, data: data}
+		}
+	}(conn, c)
+	return c
+}
+
+/*
+ * 读取TPROXY会话的套接字，
+ * 套接字创建之后客户端的数据包会被内核交给它，而不是监听套接字
+ */
+func (p *Proxy) readTProxySession(s *udpSession) {
+	buf := make([]byte, UDP_MAX_PACKAGE_SIZE)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			break
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		p.udpLock.Lock()
+		if p.udpSessions[s.key] == s {
+			select {
+			case s.c <- data:
+			default:
+				p.DEBUG("UDP session %s is busy, package dropped", s.key)
+			}
+		}
+		p.udpLock.Unlock()
+	}
+}
+
+/* 把客户端的数据包交给对应的会话，没有则创建新的会话 */
+func (p *Proxy) handleUDP(up *udpPackage) {
+	defer p.udpLock.Unlock()
+	p.udpLock.Lock()
+
+	key := up.addr.String()
+	if up.dst != nil {
+		key += "-" + up.dst.String()
+	}
+	s := p.udpSessions[key]
+	if s == nil {
+		ca, sa := p.GetAgents()
+		if ca == nil || sa == nil {
+			return
+		}
+		uca, caOK := ca.(agent.UDPClientAgent)
+		usa, saOK := sa.(agent.UDPServerAgent)
+		if !caOK || !saOK {
+			return
+		}
+		caHooks, saHooks, err := p.getHooks()
+		if err != nil {
+			p.WARN("%s", err.Error())
+			return
+		}
+		s = &udpSession{
+			key:     key,
+			conn:    p.udpListener,
+			addr:    up.addr,
+			c:       make(chan []byte, UDP_SESSION_QUEUE_SIZE),
+			timeout: time.Second * time.Duration(p.UDPTimeout),
+		}
+		if up.dst != nil {
+			/* 回复客户端的数据包需要以原始目标地址为源地址 */
+			if p.isSelf(up.dst.IP, up.dst.Port) {
+				p.DEBUG("UDP package from %s is not redirected", up.addr)
+				return
+			}
+			conn, err := util.TransparentDialUDP(up.dst, up.addr)
+			if err != nil {
+				p.WARN("failed to create udp socket for %s: %s", up.dst, err)
+				caHooks.Close()
+				saHooks.Close()
+				return
+			}
+			s.conn = conn
+			s.tproxy = true
+			go p.readTProxySession(s)
+		}
+		p.udpSessions[key] = s
+		c2s := make(chan *pkg.Package, 100)
+		s2c := make(chan *pkg.Package, 100)
+		go p.udpCAGoroutine(uca, c2s, p.hookPackages(s2c, saHooks), s)
+		go p.udpSAGoroutine(usa, p.hookPackages(c2s, caHooks), s2c, nil)
+		p.DEBUG("UDP session %s started", s)
+	}
+	select {
+	case s.c <- up.data:
+	default:
+		p.DEBUG("UDP session %s is busy, package dropped", key)
+	}
+}
+
+/* 关闭所有的UDP会话 */
+func (p *Proxy) closeUDPSessions() {
+	defer p.udpLock.Unlock()
+	p.udpLock.Lock()
+
+	for key, s := range p.udpSessions {
+		close(s.c)
+		delete(p.udpSessions, key)
+	}
+}
+
+/* 处理客户端UDP数据包的goroutine */
+func (p *Proxy) udpCAGoroutine(ca agent.UDPClientAgent,
+	c2s chan *pkg.Package,
+	s2c chan *pkg.Package,
+	s *udpSession) {
+	defer close(c2s)
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	timerC := timer.C
+	if s.timeout <= 0 {
+		timer.Stop()
+		timerC = nil
+	}
+
+	closedByClient := true
+	accepted := true
+	if cca, ok := ca.(agent.ConnClientAgent); ok {
+		cmd, rdata, err := cca.OnAccept(&udpConn{s.conn, s.addr})
+		if err := p.transferData(c2s, &udpConn{s.conn, s.addr}, cmd, rdata, err, true, s.chain); err != nil {
+			p.WARN("Accept Client Error: %s %s", s, err.Error())
+			accepted = false
+		}
+	}
+RUNNING:
+	for accepted {
+		select {
+		case data, ok := <-s.c:
+			/* 来自客户端的数据 */
+			if ok == false {
+				break RUNNING
+			}
+			cmd, rdata, err := ca.RecvFromClient(data)
+			if err := p.transferData(c2s, &udpConn{s.conn, s.addr}, cmd, rdata, err, true, s.chain); err != nil {
+				p.WARN("Recv From Client Error: %s %s", s, err.Error())
+				break RUNNING
+			}
+		case cmd, ok := <-s2c:
+			/* 来自服务端代理的数据 */
+			if ok == false {
+				closedByClient = false
+				break RUNNING
+			} else if cmd.Type() == pkg.PKG_UDP_DATA {
+				host, port, datas := cmd.GetUDPData()
+				for _, data := range datas {
+					cmd, rdata, err := ca.RecvFromSA(host, port, data)
+					if err := p.transferData(c2s, &udpConn{s.conn, s.addr}, cmd, rdata, err, true, s.chain); err != nil {
+						closedByClient = false
+						p.WARN("Recv From SA Error: %s %s", s, err.Error())
+						break RUNNING
+					}
+				}
+			} else {
+				p.ERROR("Unknown Package From Server Agent! THIS IS A BUG!")
+			}
+		case <-timerC:
+			/* 会话超时 */
+			break RUNNING
+		}
+		if timerC != nil {
+			timer.Reset(s.timeout)
+		}
+	}
+
+	p.udpLock.Lock()
+	if s.key != "" && p.udpSessions[s.key] == s {
+		delete(p.udpSessions, s.key)
+	}
+	p.udpLock.Unlock()
+	if s.tproxy {
+		s.conn.Close()
+	}
+
+	ca.OnClose(closedByClient)
+	p.DEBUG("UDP session %s closed", s)
+}
+
+/* 处理服务端UDP数据包的goroutine */
+func (p *Proxy) udpSAGoroutine(sa agent.UDPServerAgent,
+	c2s chan *pkg.Package,
+	s2c chan *pkg.Package,
+	chain *Chain) {
+	defer close(s2c)
+
+	sConn, err := util.GetOutbound(p.Name).ListenUDP()
+	if err != nil {
+		p.WARN("failed to create udp socket: %s", err)
+		return
+	}
+	sChan := p.createUDPChannel(sConn)
+
+	closedByClient := true
+RUNNING:
+	for {
+		select {
+		case up, ok := <-sChan:
+			/* 来自服务端的数据 */
+			if ok == false {
+				closedByClient = false
+				break RUNNING
+			}
+			cmd, rdata, err := sa.RecvFromServer(up.addr.IP.String(), up.addr.Port, up.data)
+			if err := p.transferData(s2c, &udpConn{sConn, up.addr}, cmd, rdata, err, false, chain); err != nil {
+				closedByClient = false
+				p.WARN("Recv From Server Error: %s %s", up.addr, err.Error())
+				break RUNNING
+			}
+		case cmd, ok := <-c2s:
+			/* 来自客户端代理的数据 */
+			if ok == false {
+				break RUNNING
+			} else if cmd.Type() == pkg.PKG_UDP_DATA {
+				host, port, datas := cmd.GetUDPData()
+				/* 数据包的发送地址由SA决定 */
+				rhost, rport := sa.GetRemoteAddress(host, port)
+				raddr, err := util.ResolveUDPAddr(resolver.Get(p.Name), rhost, rport)
+				if err != nil {
+					p.DEBUG("failed to resolve %s: %s", host, err)
+					continue
+				}
+				for _, data := range datas {
+					cmd, rdata, err := sa.RecvFromCA(host, port, data)
+					if err := p.transferData(s2c, &udpConn{sConn, raddr}, cmd, rdata, err, false, chain); err != nil {
+						p.WARN("Recv From CA Error: %s %s", raddr, err.Error())
+						break RUNNING
+					}
+				}
+			} else {
+				p.ERROR("Unknown Package From Client Agent! THIS IS A BUG!")
+			}
+		}
+	}
+	sConn.Close()
+	go func() { /* 等待接收数据的goroutine退出 */
+		for _ = range sChan {
+		}
+	}()
+	sa.OnClose(closedByClient)
+}
+
+/*
+ * 建立UDP转发，比如socks5的UDP ASSOCIATE
+ * 在控制连接的本地地址上分配一个UDP端口，通过连接结果返回给CA，
+ * 然后创建一个UDP会话转发客户端发送到该端口的数据包，
+ * 控制连接关闭时UDP转发随之关闭
+ * @host/@port 客户端发送UDP数据包的地址，为空时不检查
+ * @chain 控制连接的链接，UDP转发的数据计入其中
+ */
+func (p *Proxy) udpAssociate(sa agent.ServerAgent,
+	c2s chan *pkg.Package,
+	s2c chan *pkg.Package,
+	cConn net.Conn,
+	host string, port int,
+	chain *Chain) {
+	ca := agent.GetClientAgent(p.CAName, p.Name)
+	uca, caOK := ca.(agent.UDPClientAgent)
+	usa, saOK := sa.(agent.UDPServerAgent)
+	if !caOK || !saOK {
+		p.WARN("UDP associate is not supported by %s/%s", p.CAName, p.SAName)
+		s2c <- pkg.NewUDPAssociateResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, host, port)
+		return
+	}
+
+	laddr, _ := cConn.LocalAddr().(*net.TCPAddr)
+	raddr, _ := cConn.RemoteAddr().(*net.TCPAddr)
+	if laddr == nil || raddr == nil {
+		s2c <- pkg.NewUDPAssociateResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, host, port)
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: laddr.IP})
+	if err != nil {
+		p.WARN("failed to listen udp: %s", err)
+		s2c <- pkg.NewUDPAssociateResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, host, port)
+		return
+	}
+	caHooks, saHooks, err := p.getHooks()
+	if err != nil {
+		p.WARN("%s", err.Error())
+		relay.Close()
+		s2c <- pkg.NewUDPAssociateResultPackage(pkg.CONNECT_RESULT_UNKNOWN_ERROR, host, port)
+		return
+	}
+	bindAddr := relay.LocalAddr().(*net.UDPAddr)
+	s2c <- pkg.NewUDPAssociateResultPackage(pkg.CONNECT_RESULT_OK, bindAddr.IP.String(), bindAddr.Port)
+	p.DEBUG("UDP associate %s started for %s", bindAddr, raddr)
+
+	/* 会话在控制连接关闭时才结束，因此没有超时 */
+	s := &udpSession{
+		conn:  relay,
+		c:     make(chan []byte, UDP_SESSION_QUEUE_SIZE),
+		chain: chain,
+	}
+	uc2s := make(chan *pkg.Package, 100)
+	us2c := make(chan *pkg.Package, 100)
+	go p.udpCAGoroutine(uca, uc2s, p.hookPackages(us2c, saHooks), s)
+	go p.udpSAGoroutine(usa, p.hookPackages(uc2s, caHooks), us2c, chain)
+
+	relayChan := p.createUDPChannel(relay)
+RUNNING:
+	for {
+		select {
+		case up, ok := <-relayChan:
+			if ok == false {
+				break RUNNING
+			}
+			/* 只接受控制连接的客户端发送的数据包 */
+			if !up.addr.IP.Equal(raddr.IP) || (port != 0 && up.addr.Port != port) {
+				p.DEBUG("UDP package from %s dropped", up.addr)
+				continue
+			} else if s.addr == nil {
+				s.addr = up.addr
+			} else if s.addr.Port != up.addr.Port {
+				p.DEBUG("UDP package from %s dropped", up.addr)
+				continue
+			}
+			select {
+			case s.c <- up.data:
+			default:
+				p.DEBUG("UDP associate %s is busy, package dropped", bindAddr)
+			}
+		case _, ok := <-c2s:
+			/* 控制连接上的数据都忽略 */
+			if ok == false {
+				break RUNNING
+			}
+		}
+	}
+	close(s.c)
+	relay.Close()
+	go func() { /* 等待接收数据的goroutine退出 */
+		for _ = range relayChan {
+		}
+	}()
+}
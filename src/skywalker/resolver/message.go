@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package resolver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+/*
+ * DNS消息的编码和解析，只支持查询A和AAAA记录，
+ * 格式见RFC1035
+ */
+
+const (
+	TYPE_A    uint16 = 1
+	TYPE_AAAA uint16 = 28
+
+	dns_CLASS_IN       = 1
+	dns_FLAG_RD        = 0x0100 /* 期望递归 */
+	dns_FLAG_TC        = 0x0200 /* 消息被截断 */
+	dns_RCODE_NXDOMAIN = 3
+	dns_HEADER_SIZE    = 12
+)
+
+var (
+	/* 域名不存在或者没有对应的记录，这两种结果会被缓存 */
+	ErrNotFound = errors.New("no such host")
+	ErrNoData   = errors.New("no address for host")
+
+	errInvalidMessage = errors.New("invalid dns message")
+	errTruncated      = errors.New("dns message is truncated")
+)
+
+/* 构造查询请求 */
+func buildQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dns_HEADER_SIZE, dns_HEADER_SIZE+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dns_FLAG_RD)
+	binary.BigEndian.PutUint16(msg[4:], 1) /* QDCOUNT */
+
+	host = strings.TrimSuffix(host, ".")
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid host %s", host)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, byte(qtype>>8), byte(qtype), 0, dns_CLASS_IN)
+	return msg, nil
+}
+
+/* 跳过消息中的域名，返回域名之后的位置 */
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errInvalidMessage
+		}
+		c := int(msg[off])
+		if c == 0 {
+			return off + 1, nil
+		} else if c&0xC0 == 0xC0 { /* 压缩的域名，指针之后就结束了 */
+			return off + 2, nil
+		}
+		off += c + 1
+	}
+}
+
+/*
+ * 解析应答，返回qtype类型的地址和最小的TTL，
+ * 域名不存在返回ErrNotFound，没有对应的记录返回ErrNoData
+ */
+func parseAnswer(msg []byte, id uint16, qtype uint16) ([]net.IP, uint32, error) {
+	if len(msg) < dns_HEADER_SIZE || binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, 0, errInvalidMessage
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dns_FLAG_TC != 0 {
+		return nil, 0, errTruncated
+	}
+	if rcode := flags & 0x0F; rcode == dns_RCODE_NXDOMAIN {
+		return nil, 0, ErrNotFound
+	} else if rcode != 0 {
+		return nil, 0, fmt.Errorf("dns server responds rcode %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	var err error
+	off := dns_HEADER_SIZE
+	for i := 0; i < qdcount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, 0, err
+		}
+		off += 4 /* QTYPE和QCLASS */
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for i := 0; i < ancount; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return nil, 0, err
+		} else if off+10 > len(msg) {
+			return nil, 0, errInvalidMessage
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rttl := binary.BigEndian.Uint32(msg[off+4:])
+		rdlength := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlength > len(msg) {
+			return nil, 0, errInvalidMessage
+		}
+		rdata := msg[off : off+rdlength]
+		off += rdlength
+		/* CNAME之类的记录不需要，应答中会包含最终的地址 */
+		if rtype != qtype || (rtype == TYPE_A && rdlength != 4) || (rtype == TYPE_AAAA && rdlength != 16) {
+			continue
+		}
+		if len(ips) == 0 || rttl < ttl {
+			ttl = rttl
+		}
+		ips = append(ips, net.IP(append([]byte(nil), rdata...)))
+	}
+	if len(ips) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return ips, ttl, nil
+}
@@ -0,0 +1,470 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * DNS解析器
+ * 按顺序查询上游服务器，同时查询A和AAAA记录，服务器出错或者超时才查询下一个，
+ * 结果按照TTL缓存，域名不存在或者没有记录的结果缓存negativeTTL秒，
+ * 同一个域名同时只有一个查询，其他的查询等待这个查询的结果
+ */
+
+const (
+	DEFAULT = "default" /* 默认解析器的名字 */
+
+	PREFER_IPV4 = "ipv4"
+	PREFER_IPV6 = "ipv6"
+
+	DEFAULT_TIMEOUT      = 5
+	DEFAULT_NEGATIVE_TTL = 30
+	MAX_CACHE_SIZE       = 4096 /* 缓存超过这个数量时清理过期的缓存 */
+)
+
+type (
+	/* 解析器配置 */
+	Config struct {
+		Upstreams   []string `yaml:"upstreams"`   /* 上游服务器，默认使用系统的解析器 */
+		Timeout     int      `yaml:"timeout"`     /* 每次查询的超时时间，单位秒 */
+		Prefer      string   `yaml:"prefer"`      /* 优先使用的地址类型，ipv4或者ipv6 */
+		NegativeTTL int      `yaml:"negativeTTL"` /* 域名不存在的缓存时间 */
+		MinTTL      int      `yaml:"minTTL"`      /* 缓存时间的下限，0表示不限制 */
+		MaxTTL      int      `yaml:"maxTTL"`      /* 缓存时间的上限，0表示不限制 */
+	}
+
+	Resolver struct {
+		name        string
+		upstreams   []upstream
+		ustats      []*upstreamStats
+		timeout     time.Duration
+		prefer      string
+		negativeTTL uint32
+		minTTL      uint32
+		maxTTL      uint32
+
+		lock    sync.Mutex
+		cache   map[string]*cacheEntry
+		flights map[string]*flight
+
+		queries      int64 /* 需要解析的查询数，不包括IP和hosts */
+		hits         int64 /* 命中缓存 */
+		negativeHits int64 /* 命中域名不存在的缓存 */
+		merged       int64 /* 等待相同域名的查询 */
+		misses       int64 /* 查询了上游服务器 */
+		failures     int64 /* 所有上游服务器都出错 */
+	}
+
+	cacheEntry struct {
+		ips    []net.IP
+		err    error
+		expire time.Time
+	}
+
+	/* 正在进行的查询 */
+	flight struct {
+		wg  sync.WaitGroup
+		ips []net.IP
+		err error
+	}
+
+	/* 解析器的统计数据 */
+	Stats struct {
+		Name         string
+		Queries      int64
+		Hits         int64
+		NegativeHits int64
+		Merged       int64
+		Misses       int64
+		Failures     int64
+		Cached       int64
+		Upstreams    []*UpstreamStats
+	}
+
+	UpstreamStats struct {
+		Addr     string
+		Queries  int64
+		Failures int64
+		Latency  time.Duration /* 平均延迟 */
+	}
+)
+
+var (
+	gLock           sync.RWMutex
+	gHosts          = map[string][]net.IP{}
+	gResolvers      = map[string]*Resolver{}
+	gProxyResolvers = map[string]string{} /* 代理使用的解析器 */
+)
+
+func init() {
+	gResolvers[DEFAULT], _ = New(DEFAULT, nil)
+}
+
+/* 创建解析器，cfg为nil时使用系统的解析器 */
+func New(name string, cfg *Config) (*Resolver, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	r := &Resolver{
+		name:        name,
+		timeout:     time.Duration(cfg.Timeout) * time.Second,
+		prefer:      strings.ToLower(cfg.Prefer),
+		negativeTTL: uint32(cfg.NegativeTTL),
+		minTTL:      uint32(cfg.MinTTL),
+		maxTTL:      uint32(cfg.MaxTTL),
+		cache:       make(map[string]*cacheEntry),
+		flights:     make(map[string]*flight),
+	}
+	if cfg.Timeout <= 0 {
+		r.timeout = DEFAULT_TIMEOUT * time.Second
+	}
+	if cfg.NegativeTTL <= 0 {
+		r.negativeTTL = DEFAULT_NEGATIVE_TTL
+	}
+	if r.prefer == "" {
+		r.prefer = PREFER_IPV4
+	} else if r.prefer != PREFER_IPV4 && r.prefer != PREFER_IPV6 {
+		return nil, fmt.Errorf("resolver %s: unknown prefer %s", name, cfg.Prefer)
+	}
+	upstreams := cfg.Upstreams
+	if len(upstreams) == 0 {
+		upstreams = []string{"system"}
+	}
+	for _, s := range upstreams {
+		u, err := newUpstream(s)
+		if err != nil {
+			return nil, fmt.Errorf("resolver %s: %s", name, err)
+		}
+		r.upstreams = append(r.upstreams, u)
+		r.ustats = append(r.ustats, &upstreamStats{})
+	}
+	return r, nil
+}
+
+/*
+ * 初始化全局的解析器和hosts，配置重新加载时也会调用，
+ * def是默认解析器的配置，configs是以名字区分的其他解析器
+ */
+func Init(def *Config, configs map[string]*Config, hosts map[string]string) error {
+	resolvers, h, err := build(def, configs, hosts)
+	if err != nil {
+		return err
+	}
+
+	gLock.Lock()
+	defer gLock.Unlock()
+	gResolvers = resolvers
+	gHosts = h
+	return nil
+}
+
+/* 只检查解析器和hosts的配置，不替换当前使用的解析器 */
+func Check(def *Config, configs map[string]*Config, hosts map[string]string) error {
+	_, _, err := build(def, configs, hosts)
+	return err
+}
+
+func build(def *Config, configs map[string]*Config, hosts map[string]string) (map[string]*Resolver, map[string][]net.IP, error) {
+	resolvers := map[string]*Resolver{}
+	r, err := New(DEFAULT, def)
+	if err != nil {
+		return nil, nil, err
+	}
+	resolvers[DEFAULT] = r
+	for name, cfg := range configs {
+		if name == DEFAULT {
+			return nil, nil, fmt.Errorf("resolver name '%s' is reserved", DEFAULT)
+		}
+		if resolvers[name], err = New(name, cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	h := map[string][]net.IP{}
+	for host, value := range hosts {
+		var ips []net.IP
+		for _, s := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return nil, nil, fmt.Errorf("hosts: invalid ip %s for %s", s, host)
+			}
+			ips = append(ips, ip)
+		}
+		h[normalize(host)] = ips
+	}
+	return resolvers, h, nil
+}
+
+/* 设置代理使用的解析器，name为空时使用默认解析器 */
+func Bind(proxy, name string) error {
+	gLock.Lock()
+	defer gLock.Unlock()
+	if name == "" {
+		delete(gProxyResolvers, proxy)
+		return nil
+	} else if _, ok := gResolvers[name]; !ok {
+		return fmt.Errorf("resolver %s not found", name)
+	}
+	gProxyResolvers[proxy] = name
+	return nil
+}
+
+/* 返回代理使用的解析器 */
+func Get(proxy string) *Resolver {
+	gLock.RLock()
+	defer gLock.RUnlock()
+	if r, ok := gResolvers[gProxyResolvers[proxy]]; ok {
+		return r
+	}
+	return gResolvers[DEFAULT]
+}
+
+func Default() *Resolver {
+	gLock.RLock()
+	defer gLock.RUnlock()
+	return gResolvers[DEFAULT]
+}
+
+/* 清空所有解析器的缓存 */
+func Flush() {
+	gLock.RLock()
+	defer gLock.RUnlock()
+	for _, r := range gResolvers {
+		r.Flush()
+	}
+}
+
+/* 返回所有解析器的统计数据，默认解析器在最前面 */
+func GetStats() []*Stats {
+	gLock.RLock()
+	var names []string
+	for name := range gResolvers {
+		if name != DEFAULT {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	resolvers := []*Resolver{gResolvers[DEFAULT]}
+	for _, name := range names {
+		resolvers = append(resolvers, gResolvers[name])
+	}
+	gLock.RUnlock()
+
+	var stats []*Stats
+	for _, r := range resolvers {
+		stats = append(stats, r.Stats())
+	}
+	return stats
+}
+
+func normalize(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func lookupHosts(host string) []net.IP {
+	gLock.RLock()
+	defer gLock.RUnlock()
+	return gHosts[host]
+}
+
+func (r *Resolver) Name() string {
+	return r.name
+}
+
+func (r *Resolver) Flush() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cache = make(map[string]*cacheEntry)
+}
+
+/* 解析域名，返回所有地址，优先的地址类型在前面 */
+func (r *Resolver) Lookup(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	host = normalize(host)
+	if ips := lookupHosts(host); ips != nil {
+		return ips, nil
+	}
+	atomic.AddInt64(&r.queries, 1)
+
+	r.lock.Lock()
+	if e, ok := r.cache[host]; ok {
+		if time.Now().Before(e.expire) {
+			r.lock.Unlock()
+			atomic.AddInt64(&r.hits, 1)
+			if e.err != nil {
+				atomic.AddInt64(&r.negativeHits, 1)
+			}
+			return e.ips, e.err
+		}
+		delete(r.cache, host)
+	}
+	if f, ok := r.flights[host]; ok { /* 等待正在进行的查询 */
+		r.lock.Unlock()
+		atomic.AddInt64(&r.merged, 1)
+		f.wg.Wait()
+		return f.ips, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	r.flights[host] = f
+	r.lock.Unlock()
+
+	atomic.AddInt64(&r.misses, 1)
+	ips, ttl, err := r.query(host)
+	if err != nil && err != ErrNotFound && err != ErrNoData {
+		atomic.AddInt64(&r.failures, 1)
+		err = fmt.Errorf("lookup %s: %s", host, err)
+	}
+
+	r.lock.Lock()
+	delete(r.flights, host)
+	if err == nil || err == ErrNotFound || err == ErrNoData { /* 只缓存上游服务器的有效应答 */
+		if err != nil {
+			ttl = r.negativeTTL
+		} else if r.minTTL > 0 && ttl < r.minTTL {
+			ttl = r.minTTL
+		} else if r.maxTTL > 0 && ttl > r.maxTTL {
+			ttl = r.maxTTL
+		}
+		if len(r.cache) >= MAX_CACHE_SIZE {
+			r.purge()
+		}
+		if ttl > 0 {
+			r.cache[host] = &cacheEntry{
+				ips:    ips,
+				err:    err,
+				expire: time.Now().Add(time.Duration(ttl) * time.Second),
+			}
+		}
+	}
+	r.lock.Unlock()
+
+	f.ips, f.err = ips, err
+	f.wg.Done()
+	return ips, err
+}
+
+/* 解析域名，返回第一个地址 */
+func (r *Resolver) ResolveHost(host string) (string, error) {
+	ips, err := r.Lookup(host)
+	if err != nil {
+		return "", err
+	}
+	return ips[0].String(), nil
+}
+
+/* 删除过期的缓存，需要在加锁之后调用 */
+func (r *Resolver) purge() {
+	now := time.Now()
+	for host, e := range r.cache {
+		if !now.Before(e.expire) {
+			delete(r.cache, host)
+		}
+	}
+}
+
+/*
+ * 按顺序查询上游服务器，每个服务器同时查询A和AAAA记录，
+ * 域名不存在或者没有记录是有效的应答，不再查询下一个服务器
+ */
+func (r *Resolver) query(host string) ([]net.IP, uint32, error) {
+	type result struct {
+		ips []net.IP
+		ttl uint32
+		err error
+	}
+	var err error
+	for i, u := range r.upstreams {
+		var results [2]result
+		var wg sync.WaitGroup
+		for j, qtype := range []uint16{TYPE_A, TYPE_AAAA} {
+			wg.Add(1)
+			go func(res *result, qtype uint16) {
+				defer wg.Done()
+				start := time.Now()
+				res.ips, res.ttl, res.err = u.query(host, qtype, r.timeout)
+				r.ustats[i].record(start, res.err)
+			}(&results[j], qtype)
+		}
+		wg.Wait()
+
+		v4, v6 := results[0], results[1]
+		if r.prefer == PREFER_IPV6 {
+			v4, v6 = v6, v4
+		}
+		var ips []net.IP
+		var ttl uint32
+		for _, res := range []result{v4, v6} {
+			if res.err == nil {
+				if len(ips) == 0 || res.ttl < ttl {
+					ttl = res.ttl
+				}
+				ips = append(ips, res.ips...)
+			}
+		}
+		if len(ips) > 0 {
+			return ips, ttl, nil
+		}
+		/* 两种记录都没有得到有效的应答时才查询下一个服务器 */
+		if v4.err == ErrNotFound || v6.err == ErrNotFound {
+			return nil, 0, ErrNotFound
+		} else if v4.err == ErrNoData && v6.err == ErrNoData {
+			return nil, 0, ErrNoData
+		} else if v4.err != ErrNoData {
+			err = v4.err
+		} else {
+			err = v6.err
+		}
+	}
+	return nil, 0, err
+}
+
+func (r *Resolver) Stats() *Stats {
+	r.lock.Lock()
+	cached := int64(len(r.cache))
+	r.lock.Unlock()
+	stats := &Stats{
+		Name:         r.name,
+		Queries:      atomic.LoadInt64(&r.queries),
+		Hits:         atomic.LoadInt64(&r.hits),
+		NegativeHits: atomic.LoadInt64(&r.negativeHits),
+		Merged:       atomic.LoadInt64(&r.merged),
+		Misses:       atomic.LoadInt64(&r.misses),
+		Failures:     atomic.LoadInt64(&r.failures),
+		Cached:       cached,
+	}
+	for i, u := range r.upstreams {
+		s := r.ustats[i]
+		stats.Upstreams = append(stats.Upstreams, &UpstreamStats{
+			Addr:     u.String(),
+			Queries:  atomic.LoadInt64(&s.queries),
+			Failures: atomic.LoadInt64(&s.failures),
+			Latency:  s.averageLatency(),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * 上游DNS服务器
+ * 格式为scheme://address，支持以下几种：
+ *   udp://8.8.8.8:53，端口默认53，应答被截断时改用TCP重新查询
+ *   tcp://8.8.8.8:53，端口默认53
+ *   tls://1.1.1.1:853，DNS over TLS，端口默认853
+ *   https://dns.google/dns-query，DNS over HTTPS，使用POST方法
+ *   system，使用系统的解析器，没有TTL信息，结果缓存SYSTEM_TTL秒
+ * 没有scheme时当作udp
+ */
+
+const (
+	SYSTEM_TTL = 300
+
+	dns_MAX_MESSAGE_SIZE = 65535
+)
+
+type (
+	upstream interface {
+		/* 查询一种类型的记录，返回地址和TTL */
+		query(host string, qtype uint16, timeout time.Duration) ([]net.IP, uint32, error)
+		String() string
+	}
+
+	/* 上游服务器的统计数据 */
+	upstreamStats struct {
+		queries  int64
+		failures int64
+		latency  int64 /* 成功查询的总耗时，单位纳秒 */
+	}
+
+	udpUpstream struct {
+		addr string
+	}
+
+	tcpUpstream struct {
+		addr   string
+		tls    bool
+		config *tls.Config
+	}
+
+	httpsUpstream struct {
+		url    string
+		client *http.Client
+	}
+
+	systemUpstream struct{}
+)
+
+/* 解析上游服务器的配置 */
+func newUpstream(s string) (upstream, error) {
+	if s == "system" {
+		return &systemUpstream{}, nil
+	} else if !strings.Contains(s, "://") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	withPort := func(port string) string {
+		if u.Port() == "" {
+			return net.JoinHostPort(u.Hostname(), port)
+		}
+		return u.Host
+	}
+	switch u.Scheme {
+	case "udp":
+		return &udpUpstream{addr: withPort("53")}, nil
+	case "tcp":
+		return &tcpUpstream{addr: withPort("53")}, nil
+	case "tls":
+		return &tcpUpstream{
+			addr:   withPort("853"),
+			tls:    true,
+			config: &tls.Config{ServerName: u.Hostname()},
+		}, nil
+	case "https":
+		return &httpsUpstream{url: s, client: &http.Client{}}, nil
+	}
+	return nil, fmt.Errorf("unsupported dns upstream %s", s)
+}
+
+func (s *upstreamStats) record(start time.Time, err error) {
+	atomic.AddInt64(&s.queries, 1)
+	if err != nil && err != ErrNotFound && err != ErrNoData {
+		atomic.AddInt64(&s.failures, 1)
+	} else {
+		atomic.AddInt64(&s.latency, int64(time.Since(start)))
+	}
+}
+
+/* 平均延迟 */
+func (s *upstreamStats) averageLatency() time.Duration {
+	succeeded := atomic.LoadInt64(&s.queries) - atomic.LoadInt64(&s.failures)
+	if succeeded <= 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&s.latency) / succeeded)
+}
+
+func (u *udpUpstream) String() string {
+	return "udp://" + u.addr
+}
+
+func (u *udpUpstream) query(host string, qtype uint16, timeout time.Duration) ([]net.IP, uint32, error) {
+	id := uint16(rand.Uint32())
+	req, err := buildQuery(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	conn, err := net.DialTimeout("udp", u.addr, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(req); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, dns_MAX_MESSAGE_SIZE)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		ips, ttl, err := parseAnswer(buf[:n], id, qtype)
+		if err == errInvalidMessage { /* 可能是其他查询的应答，忽略 */
+			continue
+		} else if err == errTruncated {
+			return (&tcpUpstream{addr: u.addr}).query(host, qtype, timeout)
+		}
+		return ips, ttl, err
+	}
+}
+
+func (u *tcpUpstream) String() string {
+	if u.tls {
+		return "tls://" + u.addr
+	}
+	return "tcp://" + u.addr
+}
+
+func (u *tcpUpstream) query(host string, qtype uint16, timeout time.Duration) ([]net.IP, uint32, error) {
+	id := uint16(rand.Uint32())
+	req, err := buildQuery(id, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: timeout}
+	if u.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", u.addr, u.config)
+	} else {
+		conn, err = dialer.Dial("tcp", u.addr)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	/* TCP的消息前面有两个字节的长度 */
+	data := make([]byte, 2, len(req)+2)
+	binary.BigEndian.PutUint16(data, uint16(len(req)))
+	if _, err := conn.Write(append(data, req...)); err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, 0, err
+	}
+	rep := make([]byte, binary.BigEndian.Uint16(data))
+	if _, err := io.ReadFull(conn, rep); err != nil {
+		return nil, 0, err
+	}
+	return parseAnswer(rep, id, qtype)
+}
+
+func (u *httpsUpstream) String() string {
+	return u.url
+}
+
+/* RFC8484，消息ID应该为0 */
+func (u *httpsUpstream) query(host string, qtype uint16, timeout time.Duration) ([]net.IP, uint32, error) {
+	req, err := buildQuery(0, host, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	hreq, err := http.NewRequest("POST", u.url, bytes.NewReader(req))
+	if err != nil {
+		return nil, 0, err
+	}
+	hreq.Header.Set("Content-Type", "application/dns-message")
+	hreq.Header.Set("Accept", "application/dns-message")
+	rep, err := u.client.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("dns server responds http status %d", rep.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rep.Body, dns_MAX_MESSAGE_SIZE))
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseAnswer(data, 0, qtype)
+}
+
+func (u *systemUpstream) String() string {
+	return "system"
+}
+
+func (u *systemUpstream) query(host string, qtype uint16, timeout time.Duration) ([]net.IP, uint32, error) {
+	network := "ip4"
+	if qtype == TYPE_AAAA {
+		network = "ip6"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		/* 系统的解析器无法区分域名不存在和没有记录 */
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, 0, ErrNoData
+		} else if _, ok := err.(*net.AddrError); ok { /* 只有另一种类型的地址 */
+			return nil, 0, ErrNoData
+		}
+		return nil, 0, err
+	} else if len(ips) == 0 {
+		return nil, 0, ErrNoData
+	}
+	return ips, SYSTEM_TTL, nil
+}
@@ -31,19 +31,23 @@ const (
 	RequestType_QUIT       RequestType = 7
 	RequestType_CLEARCACHE RequestType = 8
 	RequestType_LIST       RequestType = 9
+	RequestType_RESOLVER   RequestType = 10
+	RequestType_CHECK      RequestType = 11
 )
 
 var RequestType_name = map[int32]string{
-	0: "AUTH",
-	1: "STATUS",
-	2: "START",
-	3: "STOP",
-	4: "RESTART",
-	5: "INFO",
-	6: "RELOAD",
-	7: "QUIT",
-	8: "CLEARCACHE",
-	9: "LIST",
+	0:  "AUTH",
+	1:  "STATUS",
+	2:  "START",
+	3:  "STOP",
+	4:  "RESTART",
+	5:  "INFO",
+	6:  "RELOAD",
+	7:  "QUIT",
+	8:  "CLEARCACHE",
+	9:  "LIST",
+	10: "RESOLVER",
+	11: "CHECK",
 }
 var RequestType_value = map[string]int32{
 	"AUTH":       0,
@@ -56,13 +60,15 @@ var RequestType_value = map[string]int32{
 	"QUIT":       7,
 	"CLEARCACHE": 8,
 	"LIST":       9,
+	"RESOLVER":   10,
+	"CHECK":      11,
 }
 
 func (x RequestType) String() string {
 	return proto.EnumName(RequestType_name, int32(x))
 }
 func (RequestType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_request_cecd5ca6f3fa4b99, []int{0}
+	return fileDescriptor_request_c50f237ae9c48573, []int{0}
 }
 
 type CommonRequest struct {
@@ -76,7 +82,7 @@ func (m *CommonRequest) Reset()         { *m = CommonRequest{} }
 func (m *CommonRequest) String() string { return proto.CompactTextString(m) }
 func (*CommonRequest) ProtoMessage()    {}
 func (*CommonRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_request_cecd5ca6f3fa4b99, []int{0}
+	return fileDescriptor_request_c50f237ae9c48573, []int{0}
 }
 func (m *CommonRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_CommonRequest.Unmarshal(m, b)
@@ -115,7 +121,7 @@ func (m *AuthRequest) Reset()         { *m = AuthRequest{} }
 func (m *AuthRequest) String() string { return proto.CompactTextString(m) }
 func (*AuthRequest) ProtoMessage()    {}
 func (*AuthRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_request_cecd5ca6f3fa4b99, []int{1}
+	return fileDescriptor_request_c50f237ae9c48573, []int{1}
 }
 func (m *AuthRequest) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_AuthRequest.Unmarshal(m, b)
@@ -163,7 +169,7 @@ func (m *Request) Reset()         { *m = Request{} }
 func (m *Request) String() string { return proto.CompactTextString(m) }
 func (*Request) ProtoMessage()    {}
 func (*Request) Descriptor() ([]byte, []int) {
-	return fileDescriptor_request_cecd5ca6f3fa4b99, []int{2}
+	return fileDescriptor_request_c50f237ae9c48573, []int{2}
 }
 func (m *Request) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Request.Unmarshal(m, b)
@@ -219,29 +225,30 @@ func init() {
 }
 
 func init() {
-	proto.RegisterFile("src/skywalker/rpc/request.proto", fileDescriptor_request_cecd5ca6f3fa4b99)
-}
-
-var fileDescriptor_request_cecd5ca6f3fa4b99 = []byte{
-	// 315 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x91, 0xd1, 0x4e, 0xf2, 0x30,
-	0x14, 0xc7, 0xbf, 0xb1, 0xb1, 0xb1, 0xb3, 0x7c, 0xa4, 0xe9, 0xd5, 0xe2, 0x8d, 0x04, 0xbd, 0x20,
-	0x5c, 0x40, 0x82, 0x4f, 0xd0, 0xcc, 0x19, 0x48, 0x88, 0x68, 0x57, 0x1e, 0x60, 0xce, 0x26, 0x18,
-	0x64, 0xad, 0xed, 0x26, 0xd9, 0x95, 0x0f, 0xe2, 0xcb, 0x9a, 0x76, 0x9b, 0xc1, 0xbb, 0x9e, 0xf3,
-	0xfb, 0x9d, 0x7f, 0x7b, 0x52, 0xb8, 0xd6, 0xaa, 0x58, 0xea, 0x63, 0x73, 0xce, 0xdf, 0x8f, 0x5c,
-	0x2d, 0x95, 0x2c, 0x96, 0x8a, 0x7f, 0xd4, 0x5c, 0x57, 0x0b, 0xa9, 0x44, 0x25, 0xb0, 0xab, 0x64,
-	0x31, 0xbd, 0x81, 0xff, 0x89, 0x38, 0x9d, 0x44, 0x49, 0x5b, 0x86, 0x31, 0x78, 0x65, 0x7e, 0xe2,
-	0xb1, 0x33, 0x71, 0x67, 0x21, 0xb5, 0xe7, 0x69, 0x0a, 0x11, 0xa9, 0xab, 0x43, 0xaf, 0x5c, 0xc1,
-	0xa8, 0xd6, 0x5c, 0x75, 0x9a, 0x33, 0x0b, 0xe9, 0x6f, 0x6d, 0x98, 0xcc, 0xb5, 0x3e, 0x0b, 0xf5,
-	0x1a, 0x0f, 0x5a, 0xd6, 0xd7, 0xd3, 0x6f, 0x07, 0x82, 0x3e, 0x23, 0x86, 0xe0, 0x93, 0x2b, 0xfd,
-	0x26, 0x4a, 0x1b, 0x31, 0xa4, 0x7d, 0x89, 0x6f, 0xc1, 0xab, 0x1a, 0xc9, 0xed, 0xf4, 0x78, 0x85,
-	0x16, 0x4a, 0x16, 0x8b, 0x6e, 0x8a, 0x35, 0x92, 0x53, 0x4b, 0xf1, 0x1c, 0xfc, 0xc2, 0xbe, 0x3b,
-	0x76, 0x27, 0xce, 0x2c, 0x5a, 0x61, 0xeb, 0xfd, 0x59, 0x85, 0x76, 0x86, 0x49, 0xcc, 0xeb, 0xea,
-	0x10, 0x7b, 0xd6, 0x6c, 0x13, 0x2f, 0xf6, 0xa1, 0x96, 0xce, 0xbf, 0x20, 0xba, 0xb8, 0x06, 0x8f,
-	0xc0, 0x23, 0x7b, 0xb6, 0x46, 0xff, 0x30, 0x80, 0x9f, 0x31, 0xc2, 0xf6, 0x19, 0x72, 0x70, 0x08,
-	0xc3, 0x8c, 0x11, 0xca, 0xd0, 0xc0, 0x08, 0x19, 0xdb, 0x3d, 0x21, 0x17, 0x47, 0x10, 0xd0, 0xb4,
-	0x6d, 0x7b, 0xa6, 0xbd, 0x79, 0x7c, 0xd8, 0xa1, 0xa1, 0x99, 0xa3, 0xe9, 0x76, 0x47, 0xee, 0x91,
-	0x6f, 0xba, 0xcf, 0xfb, 0x0d, 0x43, 0x01, 0x1e, 0x03, 0x24, 0xdb, 0x94, 0xd0, 0x84, 0x24, 0xeb,
-	0x14, 0x8d, 0x0c, 0xd9, 0x6e, 0x32, 0x86, 0xc2, 0x17, 0xdf, 0x7e, 0xcb, 0xdd, 0x4f, 0x00, 0x00,
-	0x00, 0xff, 0xff, 0x71, 0x0f, 0x94, 0x7a, 0xb9, 0x01, 0x00, 0x00,
+	proto.RegisterFile("src/skywalker/rpc/request.proto", fileDescriptor_request_c50f237ae9c48573)
+}
+
+var fileDescriptor_request_c50f237ae9c48573 = []byte{
+	// 328 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x91, 0xdf, 0x4e, 0xc2, 0x30,
+	0x14, 0x87, 0x1d, 0x1b, 0xfb, 0x73, 0xa6, 0xa4, 0xe9, 0xd5, 0xe2, 0x8d, 0x04, 0xbd, 0x20, 0x5c,
+	0x40, 0x82, 0x4f, 0xb0, 0xcc, 0x1a, 0x88, 0x8b, 0xd3, 0xae, 0x78, 0x3f, 0x67, 0x13, 0x0c, 0xc2,
+	0x6a, 0xbb, 0x49, 0x78, 0x13, 0x13, 0x5f, 0xd6, 0xb4, 0x63, 0x06, 0xef, 0x76, 0xce, 0xf7, 0x9d,
+	0xdf, 0xd9, 0x49, 0xe1, 0x4a, 0xc9, 0x72, 0xa6, 0x36, 0x87, 0x7d, 0xf1, 0xb1, 0xe1, 0x72, 0x26,
+	0x45, 0x39, 0x93, 0xfc, 0xb3, 0xe1, 0xaa, 0x9e, 0x0a, 0x59, 0xd5, 0x15, 0xb6, 0xa5, 0x28, 0x47,
+	0xd7, 0x70, 0x91, 0x54, 0xdb, 0x6d, 0xb5, 0xa3, 0x2d, 0xc3, 0x18, 0x9c, 0x5d, 0xb1, 0xe5, 0x91,
+	0x35, 0xb4, 0xc7, 0x01, 0x35, 0xdf, 0x23, 0x02, 0x61, 0xdc, 0xd4, 0xeb, 0x4e, 0xb9, 0x04, 0xbf,
+	0x51, 0x5c, 0x1e, 0x35, 0x6b, 0x1c, 0xd0, 0xbf, 0x5a, 0x33, 0x51, 0x28, 0xb5, 0xaf, 0xe4, 0x5b,
+	0xd4, 0x6b, 0x59, 0x57, 0x8f, 0x7e, 0x2c, 0xf0, 0xba, 0x8c, 0x08, 0xbc, 0x2f, 0x2e, 0xd5, 0x7b,
+	0xb5, 0x33, 0x11, 0x7d, 0xda, 0x95, 0xf8, 0x06, 0x9c, 0xfa, 0x20, 0xb8, 0x99, 0x1e, 0xcc, 0xd1,
+	0x54, 0x8a, 0x72, 0x7a, 0x9c, 0x62, 0x07, 0xc1, 0xa9, 0xa1, 0x78, 0x02, 0x6e, 0x69, 0xfe, 0x3b,
+	0xb2, 0x87, 0xd6, 0x38, 0x9c, 0x63, 0xe3, 0xfd, 0x3b, 0x85, 0x1e, 0x0d, 0x9d, 0x58, 0x34, 0xf5,
+	0x3a, 0x72, 0x8c, 0xd9, 0x26, 0x9e, 0xdc, 0x43, 0x0d, 0x9d, 0x7c, 0x5b, 0x10, 0x9e, 0xec, 0xc1,
+	0x3e, 0x38, 0xf1, 0x8a, 0x2d, 0xd0, 0x19, 0x06, 0x70, 0x73, 0x16, 0xb3, 0x55, 0x8e, 0x2c, 0x1c,
+	0x40, 0x3f, 0x67, 0x31, 0x65, 0xa8, 0xa7, 0x85, 0x9c, 0x65, 0x4f, 0xc8, 0xc6, 0x21, 0x78, 0x94,
+	0xb4, 0x6d, 0x47, 0xb7, 0x97, 0x8f, 0xf7, 0x19, 0xea, 0xeb, 0x39, 0x4a, 0xd2, 0x2c, 0xbe, 0x43,
+	0xae, 0xee, 0x3e, 0xaf, 0x96, 0x0c, 0x79, 0x78, 0x00, 0x90, 0xa4, 0x24, 0xa6, 0x49, 0x9c, 0x2c,
+	0x08, 0xf2, 0x35, 0x49, 0x97, 0x39, 0x43, 0x01, 0x3e, 0x07, 0x9f, 0x92, 0x3c, 0x4b, 0x5f, 0x08,
+	0x45, 0xa0, 0x37, 0x25, 0x0b, 0x92, 0x3c, 0xa0, 0xf0, 0xd5, 0x35, 0x0f, 0x76, 0xfb, 0x3b, 0x00,
+	0x7e, 0xdd, 0x30, 0x30, 0xd3, 0x01, 0x00, 0x00,
 }
@@ -38,7 +38,7 @@ func (x AuthResponse_Status) String() string {
 	return proto.EnumName(AuthResponse_Status_name, int32(x))
 }
 func (AuthResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{1, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{1, 0}
 }
 
 type StatusResponse_Status int32
@@ -64,7 +64,7 @@ func (x StatusResponse_Status) String() string {
 	return proto.EnumName(StatusResponse_Status_name, int32(x))
 }
 func (StatusResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{2, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{2, 0}
 }
 
 type StartResponse_Status int32
@@ -90,7 +90,7 @@ func (x StartResponse_Status) String() string {
 	return proto.EnumName(StartResponse_Status_name, int32(x))
 }
 func (StartResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{3, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{3, 0}
 }
 
 type StopResponse_Status int32
@@ -116,7 +116,7 @@ func (x StopResponse_Status) String() string {
 	return proto.EnumName(StopResponse_Status_name, int32(x))
 }
 func (StopResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{4, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{4, 0}
 }
 
 type InfoResponse_Status int32
@@ -142,7 +142,7 @@ func (x InfoResponse_Status) String() string {
 	return proto.EnumName(InfoResponse_Status_name, int32(x))
 }
 func (InfoResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{5, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{5, 0}
 }
 
 type QuitResponse_Status int32
@@ -165,7 +165,7 @@ func (x QuitResponse_Status) String() string {
 	return proto.EnumName(QuitResponse_Status_name, int32(x))
 }
 func (QuitResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{7, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{7, 0}
 }
 
 type ClearCacheResponse_Status int32
@@ -185,7 +185,7 @@ func (x ClearCacheResponse_Status) String() string {
 	return proto.EnumName(ClearCacheResponse_Status_name, int32(x))
 }
 func (ClearCacheResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{8, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{8, 0}
 }
 
 type ListResponse_Status int32
@@ -205,7 +205,7 @@ func (x ListResponse_Status) String() string {
 	return proto.EnumName(ListResponse_Status_name, int32(x))
 }
 func (ListResponse_Status) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{9, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{9, 0}
 }
 
 // 出错
@@ -220,7 +220,7 @@ func (m *Error) Reset()         { *m = Error{} }
 func (m *Error) String() string { return proto.CompactTextString(m) }
 func (*Error) ProtoMessage()    {}
 func (*Error) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{0}
 }
 func (m *Error) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Error.Unmarshal(m, b)
@@ -258,7 +258,7 @@ func (m *AuthResponse) Reset()         { *m = AuthResponse{} }
 func (m *AuthResponse) String() string { return proto.CompactTextString(m) }
 func (*AuthResponse) ProtoMessage()    {}
 func (*AuthResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{1}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{1}
 }
 func (m *AuthResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_AuthResponse.Unmarshal(m, b)
@@ -288,6 +288,7 @@ func (m *AuthResponse) GetStatus() AuthResponse_Status {
 // status 命令的返回结果
 type StatusResponse struct {
 	Data                 []*StatusResponse_Data `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
+	ReloadErr            string                 `protobuf:"bytes,2,opt,name=reloadErr,proto3" json:"reloadErr,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
 	XXX_unrecognized     []byte                 `json:"-"`
 	XXX_sizecache        int32                  `json:"-"`
@@ -297,7 +298,7 @@ func (m *StatusResponse) Reset()         { *m = StatusResponse{} }
 func (m *StatusResponse) String() string { return proto.CompactTextString(m) }
 func (*StatusResponse) ProtoMessage()    {}
 func (*StatusResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{2}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{2}
 }
 func (m *StatusResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StatusResponse.Unmarshal(m, b)
@@ -324,6 +325,13 @@ func (m *StatusResponse) GetData() []*StatusResponse_Data {
 	return nil
 }
 
+func (m *StatusResponse) GetReloadErr() string {
+	if m != nil {
+		return m.ReloadErr
+	}
+	return ""
+}
+
 type StatusResponse_Data struct {
 	Name                 string                `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Cname                string                `protobuf:"bytes,2,opt,name=cname,proto3" json:"cname,omitempty"`
@@ -342,7 +350,7 @@ func (m *StatusResponse_Data) Reset()         { *m = StatusResponse_Data{} }
 func (m *StatusResponse_Data) String() string { return proto.CompactTextString(m) }
 func (*StatusResponse_Data) ProtoMessage()    {}
 func (*StatusResponse_Data) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{2, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{2, 0}
 }
 func (m *StatusResponse_Data) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StatusResponse_Data.Unmarshal(m, b)
@@ -430,7 +438,7 @@ func (m *StartResponse) Reset()         { *m = StartResponse{} }
 func (m *StartResponse) String() string { return proto.CompactTextString(m) }
 func (*StartResponse) ProtoMessage()    {}
 func (*StartResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{3}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{3}
 }
 func (m *StartResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StartResponse.Unmarshal(m, b)
@@ -470,7 +478,7 @@ func (m *StartResponse_Data) Reset()         { *m = StartResponse_Data{} }
 func (m *StartResponse_Data) String() string { return proto.CompactTextString(m) }
 func (*StartResponse_Data) ProtoMessage()    {}
 func (*StartResponse_Data) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{3, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{3, 0}
 }
 func (m *StartResponse_Data) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StartResponse_Data.Unmarshal(m, b)
@@ -522,7 +530,7 @@ func (m *StopResponse) Reset()         { *m = StopResponse{} }
 func (m *StopResponse) String() string { return proto.CompactTextString(m) }
 func (*StopResponse) ProtoMessage()    {}
 func (*StopResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{4}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{4}
 }
 func (m *StopResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StopResponse.Unmarshal(m, b)
@@ -553,6 +561,8 @@ type StopResponse_Data struct {
 	Name                 string              `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Status               StopResponse_Status `protobuf:"varint,2,opt,name=status,proto3,enum=rpc.StopResponse_Status" json:"status,omitempty"`
 	Err                  string              `protobuf:"bytes,3,opt,name=err,proto3" json:"err,omitempty"`
+	Drained              int32               `protobuf:"varint,4,opt,name=drained,proto3" json:"drained,omitempty"`
+	Killed               int32               `protobuf:"varint,5,opt,name=killed,proto3" json:"killed,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
 	XXX_unrecognized     []byte              `json:"-"`
 	XXX_sizecache        int32               `json:"-"`
@@ -562,7 +572,7 @@ func (m *StopResponse_Data) Reset()         { *m = StopResponse_Data{} }
 func (m *StopResponse_Data) String() string { return proto.CompactTextString(m) }
 func (*StopResponse_Data) ProtoMessage()    {}
 func (*StopResponse_Data) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{4, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{4, 0}
 }
 func (m *StopResponse_Data) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_StopResponse_Data.Unmarshal(m, b)
@@ -603,6 +613,20 @@ func (m *StopResponse_Data) GetErr() string {
 	return ""
 }
 
+func (m *StopResponse_Data) GetDrained() int32 {
+	if m != nil {
+		return m.Drained
+	}
+	return 0
+}
+
+func (m *StopResponse_Data) GetKilled() int32 {
+	if m != nil {
+		return m.Killed
+	}
+	return 0
+}
+
 type InfoResponse struct {
 	Data                 []*InfoResponse_Data `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
@@ -614,7 +638,7 @@ func (m *InfoResponse) Reset()         { *m = InfoResponse{} }
 func (m *InfoResponse) String() string { return proto.CompactTextString(m) }
 func (*InfoResponse) ProtoMessage()    {}
 func (*InfoResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{5}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{5}
 }
 func (m *InfoResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_InfoResponse.Unmarshal(m, b)
@@ -653,7 +677,7 @@ func (m *InfoResponse_Info) Reset()         { *m = InfoResponse_Info{} }
 func (m *InfoResponse_Info) String() string { return proto.CompactTextString(m) }
 func (*InfoResponse_Info) ProtoMessage()    {}
 func (*InfoResponse_Info) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{5, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{5, 0}
 }
 func (m *InfoResponse_Info) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_InfoResponse_Info.Unmarshal(m, b)
@@ -711,7 +735,7 @@ func (m *InfoResponse_Data) Reset()         { *m = InfoResponse_Data{} }
 func (m *InfoResponse_Data) String() string { return proto.CompactTextString(m) }
 func (*InfoResponse_Data) ProtoMessage()    {}
 func (*InfoResponse_Data) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{5, 1}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{5, 1}
 }
 func (m *InfoResponse_Data) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_InfoResponse_Data.Unmarshal(m, b)
@@ -830,20 +854,22 @@ func (m *InfoResponse_Data) GetErr() string {
 }
 
 type ReloadResponse struct {
-	Unchanged            []string `protobuf:"bytes,1,rep,name=unchanged,proto3" json:"unchanged,omitempty"`
-	Added                []string `protobuf:"bytes,2,rep,name=added,proto3" json:"added,omitempty"`
-	Deleted              []string `protobuf:"bytes,3,rep,name=deleted,proto3" json:"deleted,omitempty"`
-	Updated              []string `protobuf:"bytes,4,rep,name=updated,proto3" json:"updated,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Unchanged            []string                 `protobuf:"bytes,1,rep,name=unchanged,proto3" json:"unchanged,omitempty"`
+	Added                []string                 `protobuf:"bytes,2,rep,name=added,proto3" json:"added,omitempty"`
+	Deleted              []string                 `protobuf:"bytes,3,rep,name=deleted,proto3" json:"deleted,omitempty"`
+	Updated              []string                 `protobuf:"bytes,4,rep,name=updated,proto3" json:"updated,omitempty"`
+	Changes              []*ReloadResponse_Change `protobuf:"bytes,5,rep,name=changes,proto3" json:"changes,omitempty"`
+	Core                 []string                 `protobuf:"bytes,6,rep,name=core,proto3" json:"core,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                 `json:"-"`
+	XXX_unrecognized     []byte                   `json:"-"`
+	XXX_sizecache        int32                    `json:"-"`
 }
 
 func (m *ReloadResponse) Reset()         { *m = ReloadResponse{} }
 func (m *ReloadResponse) String() string { return proto.CompactTextString(m) }
 func (*ReloadResponse) ProtoMessage()    {}
 func (*ReloadResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{6}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{6}
 }
 func (m *ReloadResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ReloadResponse.Unmarshal(m, b)
@@ -891,6 +917,74 @@ func (m *ReloadResponse) GetUpdated() []string {
 	return nil
 }
 
+func (m *ReloadResponse) GetChanges() []*ReloadResponse_Change {
+	if m != nil {
+		return m.Changes
+	}
+	return nil
+}
+
+func (m *ReloadResponse) GetCore() []string {
+	if m != nil {
+		return m.Core
+	}
+	return nil
+}
+
+type ReloadResponse_Change struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Fields               []string `protobuf:"bytes,2,rep,name=fields,proto3" json:"fields,omitempty"`
+	Restarted            bool     `protobuf:"varint,3,opt,name=restarted,proto3" json:"restarted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReloadResponse_Change) Reset()         { *m = ReloadResponse_Change{} }
+func (m *ReloadResponse_Change) String() string { return proto.CompactTextString(m) }
+func (*ReloadResponse_Change) ProtoMessage()    {}
+func (*ReloadResponse_Change) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{6, 0}
+}
+func (m *ReloadResponse_Change) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReloadResponse_Change.Unmarshal(m, b)
+}
+func (m *ReloadResponse_Change) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReloadResponse_Change.Marshal(b, m, deterministic)
+}
+func (dst *ReloadResponse_Change) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReloadResponse_Change.Merge(dst, src)
+}
+func (m *ReloadResponse_Change) XXX_Size() int {
+	return xxx_messageInfo_ReloadResponse_Change.Size(m)
+}
+func (m *ReloadResponse_Change) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReloadResponse_Change.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReloadResponse_Change proto.InternalMessageInfo
+
+func (m *ReloadResponse_Change) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ReloadResponse_Change) GetFields() []string {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+func (m *ReloadResponse_Change) GetRestarted() bool {
+	if m != nil {
+		return m.Restarted
+	}
+	return false
+}
+
 type QuitResponse struct {
 	Status               QuitResponse_Status `protobuf:"varint,1,opt,name=status,proto3,enum=rpc.QuitResponse_Status" json:"status,omitempty"`
 	Pid                  uint32              `protobuf:"varint,2,opt,name=pid,proto3" json:"pid,omitempty"`
@@ -903,7 +997,7 @@ func (m *QuitResponse) Reset()         { *m = QuitResponse{} }
 func (m *QuitResponse) String() string { return proto.CompactTextString(m) }
 func (*QuitResponse) ProtoMessage()    {}
 func (*QuitResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{7}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{7}
 }
 func (m *QuitResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_QuitResponse.Unmarshal(m, b)
@@ -948,7 +1042,7 @@ func (m *ClearCacheResponse) Reset()         { *m = ClearCacheResponse{} }
 func (m *ClearCacheResponse) String() string { return proto.CompactTextString(m) }
 func (*ClearCacheResponse) ProtoMessage()    {}
 func (*ClearCacheResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{8}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{8}
 }
 func (m *ClearCacheResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ClearCacheResponse.Unmarshal(m, b)
@@ -987,7 +1081,7 @@ func (m *ListResponse) Reset()         { *m = ListResponse{} }
 func (m *ListResponse) String() string { return proto.CompactTextString(m) }
 func (*ListResponse) ProtoMessage()    {}
 func (*ListResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{9}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{9}
 }
 func (m *ListResponse) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListResponse.Unmarshal(m, b)
@@ -1033,7 +1127,7 @@ func (m *ListResponse_Data) Reset()         { *m = ListResponse_Data{} }
 func (m *ListResponse_Data) String() string { return proto.CompactTextString(m) }
 func (*ListResponse_Data) ProtoMessage()    {}
 func (*ListResponse_Data) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{9, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{9, 0}
 }
 func (m *ListResponse_Data) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListResponse_Data.Unmarshal(m, b)
@@ -1072,6 +1166,11 @@ type ListResponse_Data_Chain struct {
 	RemoteAddr           string   `protobuf:"bytes,2,opt,name=remoteAddr,proto3" json:"remoteAddr,omitempty"`
 	ConnectedTime        int64    `protobuf:"varint,3,opt,name=connectedTime,proto3" json:"connectedTime,omitempty"`
 	ClosedTime           int64    `protobuf:"varint,4,opt,name=closedTime,proto3" json:"closedTime,omitempty"`
+	Sent                 int64    `protobuf:"varint,5,opt,name=sent,proto3" json:"sent,omitempty"`
+	Received             int64    `protobuf:"varint,6,opt,name=received,proto3" json:"received,omitempty"`
+	SentRate             int64    `protobuf:"varint,7,opt,name=sentRate,proto3" json:"sentRate,omitempty"`
+	ReceivedRate         int64    `protobuf:"varint,8,opt,name=receivedRate,proto3" json:"receivedRate,omitempty"`
+	ConnectLatency       int64    `protobuf:"varint,9,opt,name=connectLatency,proto3" json:"connectLatency,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -1081,7 +1180,7 @@ func (m *ListResponse_Data_Chain) Reset()         { *m = ListResponse_Data_Chain
 func (m *ListResponse_Data_Chain) String() string { return proto.CompactTextString(m) }
 func (*ListResponse_Data_Chain) ProtoMessage()    {}
 func (*ListResponse_Data_Chain) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{9, 0, 0}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{9, 0, 0}
 }
 func (m *ListResponse_Data_Chain) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_ListResponse_Data_Chain.Unmarshal(m, b)
@@ -1129,6 +1228,343 @@ func (m *ListResponse_Data_Chain) GetClosedTime() int64 {
 	return 0
 }
 
+func (m *ListResponse_Data_Chain) GetSent() int64 {
+	if m != nil {
+		return m.Sent
+	}
+	return 0
+}
+
+func (m *ListResponse_Data_Chain) GetReceived() int64 {
+	if m != nil {
+		return m.Received
+	}
+	return 0
+}
+
+func (m *ListResponse_Data_Chain) GetSentRate() int64 {
+	if m != nil {
+		return m.SentRate
+	}
+	return 0
+}
+
+func (m *ListResponse_Data_Chain) GetReceivedRate() int64 {
+	if m != nil {
+		return m.ReceivedRate
+	}
+	return 0
+}
+
+func (m *ListResponse_Data_Chain) GetConnectLatency() int64 {
+	if m != nil {
+		return m.ConnectLatency
+	}
+	return 0
+}
+
+type ResolverResponse struct {
+	Data                 []*ResolverResponse_Data `protobuf:"bytes,1,rep,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                 `json:"-"`
+	XXX_unrecognized     []byte                   `json:"-"`
+	XXX_sizecache        int32                    `json:"-"`
+}
+
+func (m *ResolverResponse) Reset()         { *m = ResolverResponse{} }
+func (m *ResolverResponse) String() string { return proto.CompactTextString(m) }
+func (*ResolverResponse) ProtoMessage()    {}
+func (*ResolverResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{10}
+}
+func (m *ResolverResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolverResponse.Unmarshal(m, b)
+}
+func (m *ResolverResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolverResponse.Marshal(b, m, deterministic)
+}
+func (dst *ResolverResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolverResponse.Merge(dst, src)
+}
+func (m *ResolverResponse) XXX_Size() int {
+	return xxx_messageInfo_ResolverResponse.Size(m)
+}
+func (m *ResolverResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolverResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolverResponse proto.InternalMessageInfo
+
+func (m *ResolverResponse) GetData() []*ResolverResponse_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type ResolverResponse_Upstream struct {
+	Addr                 string   `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	Queries              int64    `protobuf:"varint,2,opt,name=queries,proto3" json:"queries,omitempty"`
+	Failures             int64    `protobuf:"varint,3,opt,name=failures,proto3" json:"failures,omitempty"`
+	Latency              int64    `protobuf:"varint,4,opt,name=latency,proto3" json:"latency,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ResolverResponse_Upstream) Reset()         { *m = ResolverResponse_Upstream{} }
+func (m *ResolverResponse_Upstream) String() string { return proto.CompactTextString(m) }
+func (*ResolverResponse_Upstream) ProtoMessage()    {}
+func (*ResolverResponse_Upstream) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{10, 0}
+}
+func (m *ResolverResponse_Upstream) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolverResponse_Upstream.Unmarshal(m, b)
+}
+func (m *ResolverResponse_Upstream) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolverResponse_Upstream.Marshal(b, m, deterministic)
+}
+func (dst *ResolverResponse_Upstream) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolverResponse_Upstream.Merge(dst, src)
+}
+func (m *ResolverResponse_Upstream) XXX_Size() int {
+	return xxx_messageInfo_ResolverResponse_Upstream.Size(m)
+}
+func (m *ResolverResponse_Upstream) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolverResponse_Upstream.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolverResponse_Upstream proto.InternalMessageInfo
+
+func (m *ResolverResponse_Upstream) GetAddr() string {
+	if m != nil {
+		return m.Addr
+	}
+	return ""
+}
+
+func (m *ResolverResponse_Upstream) GetQueries() int64 {
+	if m != nil {
+		return m.Queries
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Upstream) GetFailures() int64 {
+	if m != nil {
+		return m.Failures
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Upstream) GetLatency() int64 {
+	if m != nil {
+		return m.Latency
+	}
+	return 0
+}
+
+type ResolverResponse_Data struct {
+	Name                 string                       `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Queries              int64                        `protobuf:"varint,2,opt,name=queries,proto3" json:"queries,omitempty"`
+	Hits                 int64                        `protobuf:"varint,3,opt,name=hits,proto3" json:"hits,omitempty"`
+	NegativeHits         int64                        `protobuf:"varint,4,opt,name=negativeHits,proto3" json:"negativeHits,omitempty"`
+	Merged               int64                        `protobuf:"varint,5,opt,name=merged,proto3" json:"merged,omitempty"`
+	Misses               int64                        `protobuf:"varint,6,opt,name=misses,proto3" json:"misses,omitempty"`
+	Failures             int64                        `protobuf:"varint,7,opt,name=failures,proto3" json:"failures,omitempty"`
+	Cached               int64                        `protobuf:"varint,8,opt,name=cached,proto3" json:"cached,omitempty"`
+	Upstreams            []*ResolverResponse_Upstream `protobuf:"bytes,9,rep,name=upstreams,proto3" json:"upstreams,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                     `json:"-"`
+	XXX_unrecognized     []byte                       `json:"-"`
+	XXX_sizecache        int32                        `json:"-"`
+}
+
+func (m *ResolverResponse_Data) Reset()         { *m = ResolverResponse_Data{} }
+func (m *ResolverResponse_Data) String() string { return proto.CompactTextString(m) }
+func (*ResolverResponse_Data) ProtoMessage()    {}
+func (*ResolverResponse_Data) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{10, 1}
+}
+func (m *ResolverResponse_Data) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ResolverResponse_Data.Unmarshal(m, b)
+}
+func (m *ResolverResponse_Data) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ResolverResponse_Data.Marshal(b, m, deterministic)
+}
+func (dst *ResolverResponse_Data) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ResolverResponse_Data.Merge(dst, src)
+}
+func (m *ResolverResponse_Data) XXX_Size() int {
+	return xxx_messageInfo_ResolverResponse_Data.Size(m)
+}
+func (m *ResolverResponse_Data) XXX_DiscardUnknown() {
+	xxx_messageInfo_ResolverResponse_Data.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ResolverResponse_Data proto.InternalMessageInfo
+
+func (m *ResolverResponse_Data) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ResolverResponse_Data) GetQueries() int64 {
+	if m != nil {
+		return m.Queries
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetHits() int64 {
+	if m != nil {
+		return m.Hits
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetNegativeHits() int64 {
+	if m != nil {
+		return m.NegativeHits
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetMerged() int64 {
+	if m != nil {
+		return m.Merged
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetMisses() int64 {
+	if m != nil {
+		return m.Misses
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetFailures() int64 {
+	if m != nil {
+		return m.Failures
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetCached() int64 {
+	if m != nil {
+		return m.Cached
+	}
+	return 0
+}
+
+func (m *ResolverResponse_Data) GetUpstreams() []*ResolverResponse_Upstream {
+	if m != nil {
+		return m.Upstreams
+	}
+	return nil
+}
+
+type CheckResponse struct {
+	File                 string                   `protobuf:"bytes,1,opt,name=file,proto3" json:"file,omitempty"`
+	Problems             []*CheckResponse_Problem `protobuf:"bytes,2,rep,name=problems,proto3" json:"problems,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                 `json:"-"`
+	XXX_unrecognized     []byte                   `json:"-"`
+	XXX_sizecache        int32                    `json:"-"`
+}
+
+func (m *CheckResponse) Reset()         { *m = CheckResponse{} }
+func (m *CheckResponse) String() string { return proto.CompactTextString(m) }
+func (*CheckResponse) ProtoMessage()    {}
+func (*CheckResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{11}
+}
+func (m *CheckResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckResponse.Unmarshal(m, b)
+}
+func (m *CheckResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckResponse.Marshal(b, m, deterministic)
+}
+func (dst *CheckResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckResponse.Merge(dst, src)
+}
+func (m *CheckResponse) XXX_Size() int {
+	return xxx_messageInfo_CheckResponse.Size(m)
+}
+func (m *CheckResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckResponse proto.InternalMessageInfo
+
+func (m *CheckResponse) GetFile() string {
+	if m != nil {
+		return m.File
+	}
+	return ""
+}
+
+func (m *CheckResponse) GetProblems() []*CheckResponse_Problem {
+	if m != nil {
+		return m.Problems
+	}
+	return nil
+}
+
+type CheckResponse_Problem struct {
+	File                 string   `protobuf:"bytes,1,opt,name=file,proto3" json:"file,omitempty"`
+	Line                 int32    `protobuf:"varint,2,opt,name=line,proto3" json:"line,omitempty"`
+	Msg                  string   `protobuf:"bytes,3,opt,name=msg,proto3" json:"msg,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CheckResponse_Problem) Reset()         { *m = CheckResponse_Problem{} }
+func (m *CheckResponse_Problem) String() string { return proto.CompactTextString(m) }
+func (*CheckResponse_Problem) ProtoMessage()    {}
+func (*CheckResponse_Problem) Descriptor() ([]byte, []int) {
+	return fileDescriptor_response_eead83eb22eaadfb, []int{11, 0}
+}
+func (m *CheckResponse_Problem) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckResponse_Problem.Unmarshal(m, b)
+}
+func (m *CheckResponse_Problem) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckResponse_Problem.Marshal(b, m, deterministic)
+}
+func (dst *CheckResponse_Problem) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckResponse_Problem.Merge(dst, src)
+}
+func (m *CheckResponse_Problem) XXX_Size() int {
+	return xxx_messageInfo_CheckResponse_Problem.Size(m)
+}
+func (m *CheckResponse_Problem) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckResponse_Problem.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckResponse_Problem proto.InternalMessageInfo
+
+func (m *CheckResponse_Problem) GetFile() string {
+	if m != nil {
+		return m.File
+	}
+	return ""
+}
+
+func (m *CheckResponse_Problem) GetLine() int32 {
+	if m != nil {
+		return m.Line
+	}
+	return 0
+}
+
+func (m *CheckResponse_Problem) GetMsg() string {
+	if m != nil {
+		return m.Msg
+	}
+	return ""
+}
+
 type Response struct {
 	Type                 RequestType         `protobuf:"varint,1,opt,name=type,proto3,enum=rpc.RequestType" json:"type,omitempty"`
 	Err                  *Error              `protobuf:"bytes,2,opt,name=err,proto3" json:"err,omitempty"`
@@ -1141,6 +1577,8 @@ type Response struct {
 	Quit                 *QuitResponse       `protobuf:"bytes,9,opt,name=quit,proto3" json:"quit,omitempty"`
 	Clear                *ClearCacheResponse `protobuf:"bytes,10,opt,name=clear,proto3" json:"clear,omitempty"`
 	List                 *ListResponse       `protobuf:"bytes,11,opt,name=list,proto3" json:"list,omitempty"`
+	Resolver             *ResolverResponse   `protobuf:"bytes,12,opt,name=resolver,proto3" json:"resolver,omitempty"`
+	Check                *CheckResponse      `protobuf:"bytes,13,opt,name=check,proto3" json:"check,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
 	XXX_unrecognized     []byte              `json:"-"`
 	XXX_sizecache        int32               `json:"-"`
@@ -1150,7 +1588,7 @@ func (m *Response) Reset()         { *m = Response{} }
 func (m *Response) String() string { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()    {}
 func (*Response) Descriptor() ([]byte, []int) {
-	return fileDescriptor_response_8ab824084549083a, []int{10}
+	return fileDescriptor_response_eead83eb22eaadfb, []int{12}
 }
 func (m *Response) XXX_Unmarshal(b []byte) error {
 	return xxx_messageInfo_Response.Unmarshal(m, b)
@@ -1247,6 +1685,20 @@ func (m *Response) GetList() *ListResponse {
 	return nil
 }
 
+func (m *Response) GetResolver() *ResolverResponse {
+	if m != nil {
+		return m.Resolver
+	}
+	return nil
+}
+
+func (m *Response) GetCheck() *CheckResponse {
+	if m != nil {
+		return m.Check
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Error)(nil), "rpc.Error")
 	proto.RegisterType((*AuthResponse)(nil), "rpc.AuthResponse")
@@ -1260,11 +1712,17 @@ func init() {
 	proto.RegisterType((*InfoResponse_Info)(nil), "rpc.InfoResponse.Info")
 	proto.RegisterType((*InfoResponse_Data)(nil), "rpc.InfoResponse.Data")
 	proto.RegisterType((*ReloadResponse)(nil), "rpc.ReloadResponse")
+	proto.RegisterType((*ReloadResponse_Change)(nil), "rpc.ReloadResponse.Change")
 	proto.RegisterType((*QuitResponse)(nil), "rpc.QuitResponse")
 	proto.RegisterType((*ClearCacheResponse)(nil), "rpc.ClearCacheResponse")
 	proto.RegisterType((*ListResponse)(nil), "rpc.ListResponse")
 	proto.RegisterType((*ListResponse_Data)(nil), "rpc.ListResponse.Data")
 	proto.RegisterType((*ListResponse_Data_Chain)(nil), "rpc.ListResponse.Data.Chain")
+	proto.RegisterType((*ResolverResponse)(nil), "rpc.ResolverResponse")
+	proto.RegisterType((*ResolverResponse_Upstream)(nil), "rpc.ResolverResponse.Upstream")
+	proto.RegisterType((*ResolverResponse_Data)(nil), "rpc.ResolverResponse.Data")
+	proto.RegisterType((*CheckResponse)(nil), "rpc.CheckResponse")
+	proto.RegisterType((*CheckResponse_Problem)(nil), "rpc.CheckResponse.Problem")
 	proto.RegisterType((*Response)(nil), "rpc.Response")
 	proto.RegisterEnum("rpc.AuthResponse_Status", AuthResponse_Status_name, AuthResponse_Status_value)
 	proto.RegisterEnum("rpc.StatusResponse_Status", StatusResponse_Status_name, StatusResponse_Status_value)
@@ -1277,69 +1735,93 @@ func init() {
 }
 
 func init() {
-	proto.RegisterFile("src/skywalker/rpc/response.proto", fileDescriptor_response_8ab824084549083a)
-}
-
-var fileDescriptor_response_8ab824084549083a = []byte{
-	// 958 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x56, 0xdd, 0x6e, 0xe3, 0x44,
-	0x14, 0xc6, 0xb1, 0x9d, 0x36, 0x27, 0x49, 0x15, 0x86, 0x3f, 0x6f, 0x54, 0xed, 0x46, 0xd6, 0x22,
-	0x45, 0x2c, 0x9b, 0x96, 0x20, 0x71, 0x5f, 0x65, 0x03, 0xaa, 0xb4, 0xea, 0x76, 0x27, 0xcd, 0x2d,
-	0x68, 0xd6, 0x1e, 0x36, 0x56, 0x13, 0xdb, 0x3b, 0x1e, 0x2f, 0xe4, 0x21, 0x78, 0x17, 0x1e, 0x82,
-	0x3b, 0x7a, 0xc5, 0x03, 0x70, 0x81, 0x78, 0x10, 0x34, 0x67, 0x6c, 0x67, 0x9c, 0xa4, 0xa9, 0x84,
-	0x10, 0x77, 0x39, 0xe7, 0xfb, 0x72, 0x66, 0xbe, 0x33, 0xf3, 0x9d, 0x31, 0x0c, 0x32, 0x11, 0x9c,
-	0x65, 0xb7, 0xeb, 0x9f, 0xd8, 0xf2, 0x96, 0x8b, 0x33, 0x91, 0x06, 0x67, 0x82, 0x67, 0x69, 0x12,
-	0x67, 0x7c, 0x94, 0x8a, 0x44, 0x26, 0xc4, 0x16, 0x69, 0xd0, 0x7f, 0xb2, 0x8f, 0xf6, 0x2e, 0xe7,
-	0x99, 0xd4, 0x2c, 0xff, 0x11, 0xb8, 0x53, 0x21, 0x12, 0x41, 0x7a, 0x60, 0xaf, 0xb2, 0xb7, 0x9e,
-	0x35, 0xb0, 0x86, 0x2d, 0xaa, 0x7e, 0xfa, 0x21, 0x74, 0x2e, 0x72, 0xb9, 0xa0, 0x45, 0x59, 0x72,
-	0x0e, 0xcd, 0x4c, 0x32, 0x99, 0x67, 0x48, 0x3a, 0x19, 0x7b, 0x23, 0x91, 0x06, 0x23, 0x93, 0x32,
-	0x9a, 0x21, 0x4e, 0x0b, 0x9e, 0xef, 0x43, 0x53, 0x67, 0x48, 0x1b, 0x8e, 0x66, 0xf3, 0xc9, 0x64,
-	0x3a, 0x9b, 0xf5, 0x3e, 0x50, 0xc1, 0xb7, 0x17, 0x97, 0x2f, 0xe7, 0x74, 0xda, 0xb3, 0xfc, 0x3f,
-	0x1a, 0x70, 0x52, 0xfc, 0xad, 0x5c, 0xe8, 0x4b, 0x70, 0x42, 0x26, 0x99, 0x67, 0x0d, 0xec, 0x61,
-	0xbb, 0x58, 0xa6, 0x4e, 0x19, 0xbd, 0x60, 0x92, 0x51, 0x64, 0xf5, 0xff, 0xb2, 0xc0, 0x51, 0x21,
-	0x21, 0xe0, 0xc4, 0x6c, 0xc5, 0x0b, 0x09, 0xf8, 0x9b, 0x7c, 0x0c, 0x6e, 0x80, 0xc9, 0x06, 0x26,
-	0x75, 0xa0, 0xb2, 0x19, 0x66, 0x6d, 0x9d, 0xc5, 0x80, 0x8c, 0x2b, 0x7d, 0x0e, 0xea, 0xeb, 0xef,
-	0x5b, 0xb8, 0xae, 0x90, 0xf4, 0xe1, 0xf8, 0x4d, 0x14, 0x87, 0x17, 0x61, 0x28, 0x3c, 0x17, 0x8b,
-	0x55, 0x71, 0x89, 0x5d, 0x27, 0x42, 0x7a, 0xcd, 0x81, 0x35, 0x74, 0x69, 0x15, 0x93, 0x53, 0x68,
-	0x65, 0x92, 0x09, 0x79, 0x13, 0xad, 0xb8, 0x77, 0x34, 0xb0, 0x86, 0x36, 0xdd, 0x24, 0xd4, 0x59,
-	0x70, 0x21, 0xbc, 0x63, 0x7d, 0x16, 0x5c, 0x08, 0xff, 0x79, 0xad, 0x93, 0x37, 0xaf, 0xae, 0xaf,
-	0xa7, 0x2f, 0x74, 0x27, 0xe9, 0xfc, 0xea, 0xea, 0xf2, 0xea, 0xbb, 0x9e, 0x45, 0x5a, 0xe0, 0x4e,
-	0x29, 0x7d, 0x45, 0x7b, 0x0d, 0xff, 0xce, 0x82, 0xee, 0x4c, 0x95, 0xab, 0x7a, 0xfa, 0xac, 0xd6,
-	0xd3, 0xcf, 0x4a, 0x69, 0x1b, 0x86, 0xd9, 0xd2, 0x1f, 0x0e, 0x74, 0xf4, 0xab, 0xaa, 0x4b, 0x0d,
-	0xec, 0xd2, 0xa3, 0x3d, 0xa5, 0xb6, 0x9a, 0x54, 0xc8, 0xb1, 0xef, 0x95, 0x73, 0x41, 0x6f, 0x50,
-	0x4e, 0xa5, 0xc0, 0x32, 0x95, 0x35, 0xfc, 0xdf, 0x2d, 0xe8, 0xcc, 0x64, 0x92, 0x56, 0x6a, 0xbe,
-	0xa8, 0xa9, 0xf9, 0xb4, 0xd8, 0xc2, 0x86, 0x60, 0x8a, 0xf9, 0xfe, 0x80, 0x98, 0xf3, 0x2d, 0x31,
-	0xde, 0x6e, 0xa5, 0x07, 0xb5, 0x9c, 0xed, 0x3f, 0x1a, 0x43, 0x4b, 0x17, 0x5a, 0xf3, 0xab, 0x8d,
-	0x9a, 0x3b, 0x07, 0x3a, 0x97, 0xf1, 0x8f, 0xc9, 0x41, 0x35, 0x26, 0xc1, 0x54, 0x33, 0x02, 0x47,
-	0x41, 0x6a, 0x1f, 0xb7, 0x7c, 0x5d, 0xda, 0xf5, 0x96, 0xaf, 0xd5, 0xa5, 0x7e, 0xcf, 0x96, 0x79,
-	0x75, 0xd5, 0x31, 0xe8, 0xff, 0x6a, 0xff, 0x47, 0xee, 0x38, 0xdf, 0x72, 0x87, 0xb7, 0xbb, 0xcd,
-	0xff, 0xc5, 0x1b, 0x04, 0x9c, 0x8c, 0xc7, 0x12, 0xcd, 0x61, 0x53, 0xfc, 0xad, 0xaa, 0x09, 0x1e,
-	0xf0, 0xe8, 0x3d, 0x0f, 0xbd, 0x16, 0xe6, 0xab, 0x58, 0x61, 0x8a, 0x43, 0x99, 0xe4, 0x1e, 0x68,
-	0xac, 0x8c, 0x89, 0x0f, 0x9d, 0x92, 0x87, 0x78, 0x1b, 0xf1, 0x5a, 0x8e, 0x8c, 0xa0, 0x19, 0x30,
-	0x25, 0xd3, 0xeb, 0xdc, 0x77, 0x3c, 0x18, 0x14, 0x2c, 0xc5, 0xcf, 0x34, 0xbf, 0x7b, 0x98, 0xaf,
-	0x59, 0xe5, 0x85, 0x3a, 0xf9, 0xd7, 0x5e, 0xff, 0x19, 0x4e, 0x28, 0x5f, 0x26, 0x2c, 0xac, 0xee,
-	0xd3, 0x29, 0xb4, 0xf2, 0x38, 0x58, 0xb0, 0xf8, 0x2d, 0x0f, 0xf1, 0x52, 0xb5, 0xe8, 0x26, 0xa1,
-	0x8e, 0x97, 0x85, 0x21, 0x0f, 0xbd, 0x06, 0x22, 0x3a, 0x20, 0x1e, 0x1c, 0x85, 0x7c, 0xc9, 0x25,
-	0x0f, 0x3d, 0x1b, 0xf3, 0x65, 0xa8, 0x90, 0x3c, 0x0d, 0x99, 0x42, 0x1c, 0x8d, 0x14, 0xa1, 0x9f,
-	0x41, 0xe7, 0x75, 0x1e, 0xc9, 0x07, 0x1e, 0x08, 0x93, 0xb2, 0xc7, 0x4d, 0x69, 0x14, 0xe2, 0xf5,
-	0xeb, 0x52, 0xf5, 0xd3, 0x7f, 0x52, 0x89, 0x07, 0x68, 0xbe, 0x9e, 0x5f, 0x6e, 0x0f, 0x06, 0x3f,
-	0x00, 0x32, 0x59, 0x72, 0x26, 0x26, 0x2c, 0x58, 0xf0, 0x6a, 0xe9, 0x6f, 0xb6, 0x96, 0x7e, 0x8c,
-	0x4b, 0xef, 0x12, 0xb7, 0x5f, 0xa8, 0x4f, 0xf6, 0xbe, 0x50, 0xfe, 0xdf, 0x0d, 0xe8, 0xbc, 0x8c,
-	0xb2, 0x87, 0xa4, 0x99, 0x94, 0x6d, 0x69, 0xa5, 0xa9, 0x1b, 0xc6, 0x2d, 0xa8, 0xf1, 0x0d, 0x53,
-	0xff, 0x79, 0xe8, 0x09, 0x1b, 0x83, 0x1b, 0x2c, 0x58, 0x14, 0x17, 0x95, 0x4e, 0xf7, 0x57, 0x1a,
-	0x4d, 0x14, 0x87, 0x6a, 0x6a, 0xff, 0x17, 0x0b, 0x5c, 0x4c, 0x90, 0xc7, 0x00, 0xc1, 0x32, 0xe2,
-	0xb1, 0x44, 0x1b, 0xea, 0xba, 0x46, 0x46, 0xe1, 0x82, 0xaf, 0x12, 0xc9, 0x11, 0xd7, 0x73, 0xc0,
-	0xc8, 0x90, 0xa7, 0xd0, 0x0d, 0x92, 0x38, 0xe6, 0x81, 0xe4, 0x21, 0x1a, 0xd2, 0x46, 0x8f, 0xd4,
-	0x93, 0x7a, 0x95, 0x24, 0x2b, 0x28, 0x0e, 0x52, 0x8c, 0xcc, 0x7d, 0x6d, 0xfe, 0xcd, 0x86, 0xe3,
-	0xaa, 0xc5, 0x4f, 0xc1, 0x91, 0xeb, 0x94, 0x17, 0x0d, 0xee, 0xa1, 0x4c, 0xaa, 0xbf, 0x55, 0x6e,
-	0xd6, 0x29, 0xa7, 0x88, 0x92, 0x53, 0x6d, 0x17, 0xb5, 0xd1, 0xf6, 0x18, 0x90, 0x84, 0xdf, 0x2f,
-	0x68, 0x1d, 0xf2, 0x39, 0x38, 0x2c, 0x97, 0x0b, 0xdc, 0x64, 0x7b, 0xfc, 0xe1, 0xce, 0x07, 0x0a,
-	0x45, 0x98, 0x3c, 0xab, 0xcd, 0xb2, 0xf6, 0xf8, 0xa3, 0x3d, 0x2f, 0x7d, 0x75, 0x90, 0x43, 0x70,
-	0x71, 0xfa, 0xe0, 0x0c, 0x6b, 0x8f, 0xc9, 0xee, 0x7b, 0x47, 0x35, 0x41, 0xad, 0x9e, 0xc9, 0x24,
-	0xc5, 0x81, 0x56, 0xae, 0x6e, 0xbe, 0x25, 0x14, 0x61, 0x45, 0x8b, 0xd4, 0x7c, 0x38, 0x32, 0x68,
-	0xe6, 0x7c, 0xa0, 0x08, 0xab, 0x4d, 0x0a, 0xf4, 0x35, 0x8e, 0xba, 0x72, 0x93, 0x75, 0xab, 0xd3,
-	0x82, 0xa2, 0x6a, 0xbe, 0xcb, 0x23, 0x89, 0xd3, 0xaf, 0xac, 0x69, 0x1a, 0x8f, 0x22, 0x4c, 0x9e,
-	0x83, 0x1b, 0x28, 0x4f, 0xe0, 0x24, 0x2c, 0x3f, 0x03, 0x76, 0x5d, 0x42, 0x35, 0x4b, 0x55, 0x5d,
-	0x46, 0x99, 0xc4, 0xb9, 0x58, 0x56, 0x35, 0x6f, 0x1e, 0x45, 0xf8, 0x4d, 0x13, 0x3f, 0x25, 0xbf,
-	0xfe, 0x27, 0x00, 0x00, 0xff, 0xff, 0xcd, 0x4b, 0x65, 0xbe, 0x94, 0x0a, 0x00, 0x00,
+	proto.RegisterFile("src/skywalker/rpc/response.proto", fileDescriptor_response_eead83eb22eaadfb)
+}
+
+var fileDescriptor_response_eead83eb22eaadfb = []byte{
+	// 1335 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x57, 0xcd, 0x6e, 0xe4, 0x44,
+	0x10, 0xc6, 0x63, 0x7b, 0x7e, 0x6a, 0x26, 0xd1, 0xd0, 0xb0, 0xc1, 0x3b, 0x8a, 0x76, 0x23, 0x6b,
+	0x41, 0x11, 0xcb, 0x4e, 0x76, 0x07, 0xb4, 0x27, 0x2e, 0xd1, 0x6c, 0x80, 0x48, 0xab, 0x6c, 0xb6,
+	0x93, 0x9c, 0x91, 0xd7, 0xee, 0x64, 0xac, 0x78, 0x6c, 0x6f, 0x77, 0x4f, 0x50, 0x5e, 0x02, 0x71,
+	0xe2, 0x0c, 0x07, 0xee, 0xbc, 0x03, 0xd7, 0x7d, 0x0a, 0x9e, 0x80, 0x13, 0x27, 0x84, 0x50, 0x57,
+	0xb7, 0x3d, 0xf6, 0xc4, 0x99, 0x48, 0x08, 0x71, 0xeb, 0xaa, 0xfa, 0x5c, 0xdd, 0x5f, 0x55, 0x75,
+	0x95, 0x1b, 0x76, 0x04, 0x0f, 0xf7, 0xc4, 0xe5, 0xf5, 0x77, 0x41, 0x72, 0xc9, 0xf8, 0x1e, 0xcf,
+	0xc3, 0x3d, 0xce, 0x44, 0x9e, 0xa5, 0x82, 0x8d, 0x73, 0x9e, 0xc9, 0x8c, 0xd8, 0x3c, 0x0f, 0x47,
+	0x0f, 0x9b, 0x60, 0x6f, 0x17, 0x4c, 0x48, 0x8d, 0xf2, 0xef, 0x83, 0x7b, 0xc0, 0x79, 0xc6, 0xc9,
+	0x10, 0xec, 0xb9, 0xb8, 0xf0, 0xac, 0x1d, 0x6b, 0xb7, 0x47, 0xd5, 0xd2, 0x8f, 0x60, 0xb0, 0xbf,
+	0x90, 0x33, 0x6a, 0xdc, 0x92, 0xa7, 0xd0, 0x16, 0x32, 0x90, 0x0b, 0x81, 0xa0, 0xcd, 0x89, 0x37,
+	0xe6, 0x79, 0x38, 0xae, 0x42, 0xc6, 0x27, 0x68, 0xa7, 0x06, 0xe7, 0xfb, 0xd0, 0xd6, 0x1a, 0xd2,
+	0x87, 0xce, 0xc9, 0xd9, 0x74, 0x7a, 0x70, 0x72, 0x32, 0x7c, 0x4f, 0x09, 0x5f, 0xed, 0x1f, 0xbe,
+	0x3c, 0xa3, 0x07, 0x43, 0xcb, 0xff, 0xa3, 0x05, 0x9b, 0xe6, 0xb3, 0x62, 0xa3, 0xcf, 0xc0, 0x89,
+	0x02, 0x19, 0x78, 0xd6, 0x8e, 0xbd, 0xdb, 0x37, 0xdb, 0xd4, 0x21, 0xe3, 0x17, 0x81, 0x0c, 0x28,
+	0xa2, 0xc8, 0x36, 0xf4, 0x38, 0x4b, 0xb2, 0x20, 0x3a, 0xe0, 0xdc, 0x6b, 0xe1, 0xf1, 0x97, 0x8a,
+	0xd1, 0xef, 0x16, 0x38, 0x0a, 0x4c, 0x08, 0x38, 0x69, 0x30, 0x67, 0x86, 0x20, 0xae, 0xc9, 0x87,
+	0xe0, 0x86, 0xa8, 0xd4, 0x9f, 0xb9, 0x61, 0xa1, 0x15, 0xa8, 0xb5, 0xb5, 0x16, 0x05, 0x32, 0x29,
+	0xd9, 0x3b, 0xc8, 0x7e, 0xd4, 0x74, 0xac, 0x3a, 0x7f, 0x32, 0x82, 0xee, 0x9b, 0x38, 0x8d, 0xf6,
+	0xa3, 0x88, 0x7b, 0x2e, 0x3a, 0x2b, 0xe5, 0xc2, 0x76, 0x9c, 0x71, 0xe9, 0xb5, 0x77, 0xac, 0x5d,
+	0x97, 0x96, 0xb2, 0xa2, 0x24, 0x64, 0xc0, 0xe5, 0x69, 0x3c, 0x67, 0x5e, 0x67, 0xc7, 0xda, 0xb5,
+	0xe9, 0x52, 0xa1, 0x32, 0xc5, 0x38, 0xf7, 0xba, 0x3a, 0x53, 0x8c, 0x73, 0xff, 0x49, 0x2d, 0xce,
+	0xa7, 0xaf, 0x8e, 0x8f, 0x0f, 0x5e, 0xe8, 0x38, 0xd3, 0xb3, 0xa3, 0xa3, 0xc3, 0xa3, 0xaf, 0x87,
+	0x16, 0xe9, 0x81, 0x7b, 0x40, 0xe9, 0x2b, 0x3a, 0x6c, 0xf9, 0xef, 0x2c, 0xd8, 0x38, 0x51, 0xee,
+	0xca, 0x88, 0x3f, 0xae, 0x45, 0xfc, 0xa3, 0x82, 0x1a, 0x97, 0x0d, 0x01, 0x1f, 0x7d, 0xbb, 0x26,
+	0xa2, 0xcf, 0xca, 0x28, 0xb5, 0x30, 0x4a, 0xf7, 0x1b, 0x5c, 0xad, 0x04, 0xc9, 0xd0, 0xb1, 0x6f,
+	0xa5, 0xb3, 0x4f, 0x4f, 0x91, 0x4e, 0xc9, 0xc0, 0xaa, 0x32, 0x6b, 0xf9, 0x7f, 0x5b, 0x30, 0x38,
+	0x91, 0x59, 0x5e, 0xb2, 0xf9, 0xb4, 0xc6, 0x66, 0xcb, 0x1c, 0x21, 0xcb, 0x9b, 0xc8, 0xfc, 0xb0,
+	0xae, 0x3e, 0x9e, 0xae, 0xb0, 0xf1, 0x6e, 0xba, 0xba, 0x8b, 0x0c, 0xf1, 0xa0, 0x13, 0xf1, 0x20,
+	0x4e, 0x59, 0x84, 0x85, 0xe3, 0xd2, 0x42, 0x24, 0x5b, 0xd0, 0xbe, 0x8c, 0x93, 0x84, 0x45, 0x58,
+	0x1b, 0x2e, 0x35, 0x92, 0xbf, 0xd7, 0x9c, 0xcd, 0x0a, 0xfd, 0x0d, 0xe8, 0x9d, 0x1d, 0x2d, 0x03,
+	0xf0, 0xce, 0x81, 0xc1, 0x61, 0x7a, 0x9e, 0xad, 0x0d, 0x40, 0x15, 0x50, 0x0d, 0xc0, 0x18, 0x1c,
+	0x65, 0x52, 0x27, 0xbf, 0x64, 0xd7, 0xc5, 0xfd, 0xbf, 0x64, 0xd7, 0xea, 0x1e, 0x5c, 0x05, 0xc9,
+	0xa2, 0xbc, 0x1d, 0x28, 0x8c, 0x7e, 0xb5, 0xff, 0xa3, 0x0b, 0xf5, 0x74, 0xe5, 0x42, 0x79, 0x37,
+	0x8f, 0xf9, 0xbf, 0x5c, 0x27, 0x02, 0x8e, 0x60, 0xa9, 0xc4, 0xfb, 0x64, 0x53, 0x5c, 0x2b, 0x6f,
+	0x9c, 0x85, 0x2c, 0xbe, 0x62, 0x91, 0xd7, 0x43, 0x7d, 0x29, 0x2b, 0x9b, 0xc2, 0xd0, 0x40, 0x32,
+	0x0f, 0xb4, 0xad, 0x90, 0x89, 0x0f, 0x83, 0x02, 0x87, 0xf6, 0x3e, 0xda, 0x6b, 0x3a, 0x32, 0x86,
+	0x76, 0x18, 0x28, 0x9a, 0xde, 0xe0, 0xb6, 0xf4, 0xa0, 0x60, 0x50, 0x0a, 0x2f, 0x34, 0x7e, 0x63,
+	0x3d, 0x5e, 0x04, 0x45, 0x22, 0x55, 0x09, 0x6e, 0xfe, 0xeb, 0xf6, 0xf0, 0x63, 0x0b, 0x36, 0x29,
+	0x36, 0xd0, 0xb2, 0xa0, 0xb6, 0xa1, 0xb7, 0x48, 0xc3, 0x59, 0x90, 0x5e, 0xb0, 0x08, 0xab, 0xaa,
+	0x47, 0x97, 0x0a, 0x95, 0xdf, 0x20, 0x8a, 0x58, 0xe4, 0xb5, 0xd0, 0xa2, 0x05, 0x2c, 0x7c, 0x96,
+	0x30, 0xc9, 0x22, 0xcf, 0x46, 0x7d, 0x21, 0x2a, 0xcb, 0x22, 0x8f, 0x02, 0x89, 0x57, 0x02, 0x2d,
+	0x46, 0x24, 0x5f, 0x40, 0x47, 0x3b, 0x15, 0x9e, 0x8b, 0x64, 0x75, 0x97, 0xad, 0x9f, 0x66, 0x3c,
+	0x45, 0x08, 0x2d, 0xa0, 0x2a, 0x83, 0x61, 0xc6, 0x99, 0xd7, 0x46, 0x67, 0xb8, 0x1e, 0x51, 0x68,
+	0x6b, 0x58, 0x63, 0x9d, 0x6e, 0x41, 0xfb, 0x3c, 0x66, 0x49, 0x24, 0xcc, 0x91, 0x8d, 0xa4, 0x67,
+	0x09, 0x96, 0x06, 0x9e, 0xda, 0xda, 0xed, 0xd2, 0xa5, 0xc2, 0x17, 0x30, 0x78, 0xbd, 0x88, 0xe5,
+	0x1d, 0x03, 0xb1, 0x0a, 0x69, 0x68, 0x0f, 0x79, 0x1c, 0xe1, 0xed, 0xd8, 0xa0, 0x6a, 0xe9, 0x3f,
+	0x2c, 0x73, 0x03, 0xd0, 0x7e, 0x7d, 0x76, 0xb8, 0xda, 0xea, 0xfc, 0x10, 0xc8, 0x34, 0x61, 0x01,
+	0x9f, 0x06, 0xe1, 0x8c, 0x95, 0x5b, 0x3f, 0x5f, 0xd9, 0xfa, 0x01, 0x6e, 0x7d, 0x13, 0xb8, 0x3a,
+	0x91, 0xef, 0x35, 0x4e, 0x64, 0xff, 0x2f, 0x1b, 0x06, 0x2f, 0x63, 0x71, 0x17, 0xb5, 0x2a, 0x64,
+	0x95, 0x5a, 0xd1, 0x73, 0x5a, 0x95, 0x22, 0xad, 0xe1, 0x2b, 0x3d, 0xe7, 0xcf, 0xd6, 0x9a, 0x1e,
+	0x32, 0x01, 0x37, 0x9c, 0x05, 0x71, 0x6a, 0x3c, 0x6d, 0x37, 0x7b, 0x52, 0x45, 0x10, 0xa7, 0x54,
+	0x43, 0x47, 0xbf, 0xb4, 0xc0, 0x45, 0x05, 0x79, 0x00, 0x10, 0x26, 0x31, 0x4b, 0x25, 0x76, 0x09,
+	0xed, 0xb7, 0xa2, 0x51, 0x76, 0xce, 0xe6, 0x99, 0x64, 0x68, 0xd7, 0x6d, 0xaa, 0xa2, 0x21, 0x8f,
+	0x60, 0x23, 0xcc, 0xd2, 0x94, 0x85, 0x92, 0x45, 0xd8, 0x2f, 0x6c, 0xbc, 0xc2, 0x75, 0xa5, 0xde,
+	0x25, 0x13, 0x06, 0xe2, 0x20, 0xa4, 0xa2, 0x29, 0x7b, 0x8a, 0x7b, 0x4b, 0x4f, 0x69, 0xaf, 0xe9,
+	0x29, 0x9d, 0x3b, 0x7a, 0x4a, 0xb7, 0xa1, 0xa7, 0x7c, 0x02, 0x9b, 0xe6, 0x80, 0x2f, 0x03, 0xc9,
+	0xd2, 0xf0, 0xda, 0x74, 0xad, 0x15, 0xed, 0x6d, 0xe9, 0xff, 0xcd, 0x86, 0x21, 0x65, 0x22, 0x4b,
+	0xae, 0x18, 0x2f, 0x4b, 0x60, 0x5c, 0x1b, 0x22, 0xc5, 0x45, 0xac, 0x83, 0xaa, 0x49, 0x4d, 0xa1,
+	0x7b, 0x96, 0x0b, 0xc9, 0x59, 0x30, 0x57, 0xfc, 0x83, 0x65, 0xfc, 0x71, 0xad, 0x6e, 0xfd, 0xdb,
+	0x05, 0xe3, 0x31, 0xd3, 0xd3, 0xd4, 0xa6, 0x85, 0xa8, 0xd8, 0x9f, 0x07, 0x71, 0xb2, 0xe0, 0x4c,
+	0x98, 0x70, 0x97, 0xb2, 0xfa, 0x2a, 0x31, 0x94, 0x74, 0x98, 0x0b, 0x71, 0xf4, 0xfd, 0xba, 0x22,
+	0xba, 0x7d, 0x33, 0x02, 0xce, 0x2c, 0x96, 0xc5, 0x46, 0xb8, 0x56, 0x21, 0x4e, 0xd9, 0x45, 0x20,
+	0xe3, 0x2b, 0xf6, 0x8d, 0xb2, 0xe9, 0x9d, 0x6a, 0x3a, 0xd5, 0x32, 0xe6, 0x8c, 0x5f, 0x98, 0x69,
+	0x6d, 0x53, 0x23, 0xa1, 0x3e, 0x16, 0x82, 0x09, 0x93, 0x54, 0x23, 0xd5, 0x48, 0x75, 0x56, 0x48,
+	0x6d, 0xa9, 0x11, 0x10, 0xce, 0x58, 0x64, 0x92, 0x69, 0x24, 0xf2, 0x25, 0xf4, 0x16, 0x26, 0x84,
+	0xc2, 0xeb, 0x61, 0xdc, 0x1f, 0x34, 0xc7, 0xbd, 0x88, 0x34, 0x5d, 0x7e, 0xe0, 0xff, 0x64, 0xc1,
+	0xc6, 0x74, 0xc6, 0xc2, 0xcb, 0x32, 0x85, 0x04, 0x9c, 0xf3, 0x38, 0x29, 0x23, 0xa3, 0xd6, 0xe4,
+	0x39, 0x74, 0x73, 0x9e, 0xbd, 0x49, 0xd8, 0x5c, 0x78, 0xad, 0x4a, 0x6a, 0x6b, 0x5f, 0x8e, 0x8f,
+	0x35, 0x84, 0x96, 0xd8, 0xd1, 0x14, 0x3a, 0x46, 0xd9, 0xe8, 0x96, 0x80, 0x93, 0xc4, 0xa9, 0x1e,
+	0xfc, 0x2e, 0xc5, 0x75, 0xf1, 0xa4, 0xb0, 0x97, 0x4f, 0x8a, 0x9f, 0x1d, 0xe8, 0x96, 0xa7, 0x7b,
+	0x04, 0x8e, 0xbc, 0xce, 0x99, 0xe9, 0x30, 0x43, 0x43, 0x14, 0x1f, 0x27, 0xa7, 0xd7, 0x39, 0xa3,
+	0x68, 0x25, 0xdb, 0x7a, 0x9c, 0x29, 0xbf, 0xfd, 0x09, 0x20, 0x08, 0x1f, 0x2c, 0xfa, 0xef, 0xea,
+	0x63, 0x70, 0x82, 0x85, 0x9c, 0xe1, 0x1e, 0xfd, 0xc9, 0xfb, 0x37, 0x5e, 0x24, 0x14, 0xcd, 0xe4,
+	0x71, 0xed, 0x5f, 0xa3, 0x3f, 0xf9, 0xa0, 0xe1, 0xe7, 0xbd, 0xec, 0x64, 0xbb, 0xe0, 0x62, 0xc7,
+	0xc7, 0x44, 0xf7, 0x27, 0xe4, 0xe6, 0x2f, 0x2c, 0xd5, 0x00, 0xb5, 0xbb, 0x90, 0x59, 0xee, 0xb5,
+	0x2b, 0xbb, 0x57, 0xff, 0x0e, 0x29, 0x9a, 0x15, 0x2c, 0x56, 0xf3, 0xbb, 0x53, 0x81, 0x55, 0xe7,
+	0x37, 0x45, 0xb3, 0x3a, 0xa4, 0x7e, 0xb7, 0x78, 0xdd, 0xca, 0x21, 0xeb, 0xb3, 0x8f, 0x1a, 0x88,
+	0xf2, 0xf9, 0x76, 0x11, 0x4b, 0xaf, 0x57, 0xf1, 0x59, 0x9d, 0x3c, 0x14, 0xcd, 0xe4, 0x09, 0xb8,
+	0xa1, 0x1a, 0x0a, 0xf8, 0xa7, 0x52, 0xfc, 0xd9, 0xdf, 0x1c, 0x13, 0x54, 0xa3, 0x94, 0xd7, 0x24,
+	0x16, 0xd2, 0xeb, 0x57, 0xbc, 0x56, 0x5b, 0x2f, 0x45, 0x33, 0x79, 0xa6, 0x5a, 0x99, 0xae, 0x48,
+	0x6f, 0x80, 0xd0, 0x7b, 0x8d, 0x65, 0x4a, 0x4b, 0x98, 0x0a, 0x6a, 0xa8, 0x2a, 0xcc, 0xdb, 0xa8,
+	0x04, 0xb5, 0x56, 0x73, 0x54, 0x03, 0xde, 0xb4, 0xf1, 0x61, 0xfa, 0xf9, 0x3f, 0x03, 0x00, 0xb4,
+	0x57, 0x31, 0xf3, 0xe2, 0x0e, 0x00, 0x00,
 }
@@ -22,7 +22,10 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"skywalker/config"
 	"skywalker/core"
+	"skywalker/util"
+	"syscall"
 )
 
 /* 生成ASCII图形 http://patorjk.com/software/taag */
@@ -32,7 +35,28 @@ func init() {
 	fmt.Printf("%s\n\n", logo)
 }
 
+/* 检查配置文件，返回进程的退出码 */
+func checkConfig() int {
+	cfile := config.GetConfigFilePath()
+	if len(cfile) == 0 {
+		util.FatalError("No Config Found!")
+	}
+	problems := config.Check(cfile)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%s: %d problem(s) found\n", cfile, len(problems))
+		return 1
+	}
+	fmt.Printf("%s: config OK\n", cfile)
+	return 0
+}
+
 func main() {
+	if config.GetFlag().Test {
+		os.Exit(checkConfig())
+	}
 	force := core.Run()
 	if force == nil {
 		return
@@ -40,6 +64,11 @@ func main() {
 	defer force.Finish()
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGHUP)
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		force.RequestReload("SIGHUP")
+	}
 }
@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package util
+
+import (
+	"context"
+	"net"
+	"skywalker/pkg"
+	"skywalker/resolver"
+	"time"
+)
+
+/*
+ * 连接远程服务器，RFC8305(Happy Eyeballs)方式
+ * 解析域名的所有地址，IPv4和IPv6的地址交替排列，优先的地址类型在前，
+ * 依次发起连接，每个连接等待HAPPY_EYEBALLS_DELAY，上一个连接失败或者超时就开始下一个，
+ * 最先成功的连接被使用，其他的连接被关闭
+ */
+
+const (
+	HAPPY_EYEBALLS_DELAY = 250 * time.Millisecond
+)
+
+type (
+	/* 连接远程服务器的选项 */
+	DialOptions struct {
+		Resolver *resolver.Resolver /* 解析域名使用的解析器，nil表示默认的解析器 */
+		Timeout  time.Duration      /* 整个连接过程的超时时间，0表示TCP_CONNECT_TIMEOUT */
+		Prefer   string             /* 优先的地址类型，ipv4或者ipv6，为空时使用解析器返回的顺序 */
+		Outbound *Outbound          /* 出站的本地地址、网卡和标记，nil表示使用系统默认 */
+	}
+
+	dialResult struct {
+		conn net.Conn
+		err  error
+	}
+)
+
+/*
+ * 连接远程服务器，解析DNS会阻塞，opts为nil时使用默认选项
+ * 实际连接的地址可以通过net.Conn的RemoteAddr获取
+ */
+func TCPConnect(host string, port int, opts *DialOptions) (net.Conn, int) {
+	if opts == nil {
+		opts = &DialOptions{}
+	}
+	r := opts.Resolver
+	if r == nil {
+		r = resolver.Default()
+	}
+	ips, err := r.Lookup(host)
+	if err != nil {
+		return nil, pkg.CONNECT_RESULT_UNKNOWN_HOST
+	}
+	/* 绑定了本地地址时，不同类型的地址无法连接 */
+	var matched []net.IP
+	for _, ip := range ips {
+		if opts.Outbound.Match(ip) {
+			matched = append(matched, ip)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, pkg.CONNECT_RESULT_UNREACHABLE
+	}
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = TCP_CONNECT_TIMEOUT * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dialer := opts.Outbound.Dialer("tcp", 0)
+	conn, err := dialParallel(ctx, dialer, sortAddresses(matched, opts.Prefer), port)
+	if err != nil {
+		return nil, pkg.CONNECT_RESULT_UNREACHABLE
+	}
+	return conn, pkg.CONNECT_RESULT_OK
+}
+
+/* IPv4和IPv6的地址交替排列，prefer类型的地址在前，prefer为空时以第一个地址的类型优先 */
+func sortAddresses(ips []net.IP, prefer string) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v4, v6
+	if prefer == resolver.PREFER_IPV6 || (prefer == "" && len(ips) > 0 && ips[0].To4() == nil) {
+		first, second = v6, v4
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+/* 依次错开发起连接，返回最先成功的连接，全部失败时返回最后一个错误 */
+func dialParallel(ctx context.Context, dialer *net.Dialer, ips []net.IP, port int) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	/* 有缓冲，返回之后剩下的连接结果不会阻塞 */
+	results := make(chan dialResult, len(ips))
+	pending := 0
+	next := 0
+	var delay <-chan time.Time
+	startNext := func() {
+		if next >= len(ips) {
+			delay = nil
+			return
+		}
+		go func(addr string) {
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			results <- dialResult{conn, err}
+		}(JoinHostPort(ips[next].String(), port))
+		next += 1
+		pending += 1
+		delay = time.After(HAPPY_EYEBALLS_DELAY)
+	}
+
+	var err error
+	startNext()
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending -= 1
+			if res.err == nil {
+				/* 关闭其他已经成功的连接 */
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if res := <-results; res.conn != nil {
+							res.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			err = res.err
+			startNext()
+		case <-delay:
+			startNext()
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package util
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+ * 直方图，记录观测值落在每个区间的次数，用于统计延迟的分布，
+ * Buckets是每个区间的上限，从小到大排列，超过最大上限的值只计入总数
+ */
+type Histogram struct {
+	sync.Mutex
+	Buckets []float64
+	counts  []int64
+	count   int64
+	sum     float64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		Buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	defer h.Unlock()
+	h.Lock()
+	if i := sort.SearchFloat64s(h.Buckets, v); i < len(h.Buckets) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+/* 返回小于等于每个上限的累计次数，以及总的次数和总和 */
+func (h *Histogram) Snapshot() ([]int64, int64, float64) {
+	defer h.Unlock()
+	h.Lock()
+	cumulative := make([]int64, len(h.counts))
+	var n int64
+	for i, c := range h.counts {
+		n += c
+		cumulative[i] = n
+	}
+	return cumulative, h.count, h.sum
+}
@@ -18,40 +18,23 @@
 package util
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net"
-	"skywalker/pkg"
+	"skywalker/resolver"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
-/* 默认缓存半个小时 */
 const (
-	CACHE_DEFAULT_TIMEOUT int64 = 1800
-	TCP_FASTOPEN          int   = 23
-	TCP_CONNECT_TIMEOUT         = 10
+	TCP_FASTOPEN        int = 23
+	TCP_CONNECT_TIMEOUT     = 10
 )
 
-/*  DNS缓存 */
-var (
-	DNSCache ICache = NewCache(CACHE_DEFAULT_TIMEOUT)
-)
-
-/* 从缓存中获取DNS结果，如果没找到则发起解析 */
-func ResolveHost(host string) (string, error) {
-	ip := DNSCache.GetString(host)
-	if len(ip) == 0 {
-		ips, err := net.LookupIP(host)
-		if err != nil || len(ips) == 0 {
-			return "", errors.New("Invalid Host")
-		}
-		ip = ips[0].String()
-		DNSCache.Set(host, ip)
-	}
-	return ip, nil
-}
-
 func JoinHostPort(ip string, port int) string {
 	return net.JoinHostPort(ip, strconv.Itoa(port))
 }
@@ -60,22 +43,6 @@ func TCPConnectTo(addr string) (net.Conn, error) {
 	return net.DialTimeout("tcp", addr, TCP_CONNECT_TIMEOUT*time.Second)
 }
 
-/*
- * 连接远程服务器，解析DNS会阻塞
- */
-func TCPConnect(host string, port int) (net.Conn, int) {
-	ip, err := ResolveHost(host)
-	if err != nil {
-		return nil, pkg.CONNECT_RESULT_UNKNOWN_HOST
-	}
-	addr := JoinHostPort(ip, port)
-	conn, err := TCPConnectTo(addr)
-	if err != nil {
-		return nil, pkg.CONNECT_RESULT_UNREACHABLE
-	}
-	return conn, pkg.CONNECT_RESULT_OK
-}
-
 /* 监听TCP端口 */
 func TCPListen(ip string, port int, fastOpen bool) (*net.TCPListener, error) {
 	addr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(ip, strconv.Itoa(port)))
@@ -122,6 +89,257 @@ func UDPListen(addr string, port int) (*net.UDPConn, error) {
 	return net.ListenUDP("udp", &laddr)
 }
 
+/* 解析端口范围，比如443或者8000-9000，返回范围的两端 */
+func ParsePortRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	max := min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, err
+		}
+	}
+	if min <= 0 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %s", s)
+	}
+	return min, max, nil
+}
+
+/* 解析CIDR，单个IP当作只包含自己的网段 */
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %s", s)
+		} else if ip.To4() != nil {
+			return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+/* 解析UDP地址，解析DNS会阻塞，r为nil时使用默认的解析器 */
+func ResolveUDPAddr(r *resolver.Resolver, host string, port int) (*net.UDPAddr, error) {
+	if r == nil {
+		r = resolver.Default()
+	}
+	ip, err := r.ResolveHost(host)
+	if err != nil {
+		return nil, err
+	}
+	return &net.UDPAddr{IP: net.ParseIP(ip), Port: port}, nil
+}
+
+/* netfilter的socket选项，linux/netfilter_ipv4.h和linux/netfilter_ipv6/ip6_tables.h */
+const (
+	SO_ORIGINAL_DST      = 80
+	IP6T_SO_ORIGINAL_DST = 80
+)
+
+/*
+ * 获取被iptables REDIRECT/DNAT之前的原始目标地址，只支持linux
+ * 连接没有经过NAT时返回错误
+ */
+func GetOriginalDst(conn net.Conn) (string, int, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", 0, errors.New("not a tcp connection")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", 0, err
+	}
+	isIPv6 := false
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		isIPv6 = true
+	}
+
+	var ip net.IP
+	var port int
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if isIPv6 {
+			/* IPv6MTUInfo的第一个字段正好是sockaddr_in6 */
+			info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, IP6T_SO_ORIGINAL_DST)
+			if err != nil {
+				serr = err
+				return
+			}
+			p := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			ip = net.IP(append([]byte{}, info.Addr.Addr[:]...))
+			port = int(p[0])<<8 + int(p[1])
+		} else {
+			/* sockaddr_in的长度是16个字节，IPv6Mreq有20个字节 */
+			mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, SO_ORIGINAL_DST)
+			if err != nil {
+				serr = err
+				return
+			}
+			ip = net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7])
+			port = int(mreq.Multiaddr[2])<<8 + int(mreq.Multiaddr[3])
+		}
+	})
+	if err != nil {
+		return "", 0, err
+	} else if serr != nil {
+		return "", 0, serr
+	}
+	return ip.String(), port, nil
+}
+
+/* TPROXY相关的socket选项，syscall中没有IPv6的定义 */
+const (
+	IPV6_TRANSPARENT     = 75
+	IPV6_RECVORIGDSTADDR = 74
+)
+
+/* 设置IP_TRANSPARENT，允许监听和绑定非本机地址 */
+func setTransparent(fd int, network string) error {
+	if network == "tcp6" || network == "udp6" {
+		return syscall.SetsockoptInt(fd, syscall.SOL_IPV6, IPV6_TRANSPARENT, 1)
+	}
+	return syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+}
+
+/* 把setsockopt包装成net.ListenConfig和net.Dialer需要的Control函数 */
+func transparentControl(extra func(int, string) error) func(string, string, syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if serr = setTransparent(int(fd), network); serr == nil && extra != nil {
+				serr = extra(int(fd), network)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+/*
+ * 监听TPROXY的TCP端口，只支持linux，需要CAP_NET_ADMIN权限
+ * 被iptables TPROXY转发过来的连接的本地地址就是原始目标地址
+ */
+func TransparentTCPListen(ip string, port int, fastOpen bool) (*net.TCPListener, error) {
+	lc := net.ListenConfig{
+		Control: transparentControl(func(fd int, network string) error {
+			if fastOpen {
+				return syscall.SetsockoptInt(fd, syscall.SOL_TCP, TCP_FASTOPEN, 1)
+			}
+			return nil
+		}),
+	}
+	listener, err := lc.Listen(context.Background(), "tcp", JoinHostPort(ip, port))
+	if err != nil {
+		return nil, err
+	}
+	return listener.(*net.TCPListener), nil
+}
+
+/*
+ * 监听TPROXY的UDP端口，只支持linux，需要CAP_NET_ADMIN权限
+ * 数据包的原始目标地址需要用ReadFromUDPOrigDst读取
+ */
+func TransparentUDPListen(ip string, port int) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: transparentControl(func(fd int, network string) error {
+			/* 回复客户端的套接字可能绑定相同的端口 */
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+				return err
+			}
+			if network == "udp6" {
+				/* 双栈的套接字也会收到IPv4的数据包 */
+				syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1)
+				return syscall.SetsockoptInt(fd, syscall.SOL_IPV6, IPV6_RECVORIGDSTADDR, 1)
+			}
+			return syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1)
+		}),
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp", JoinHostPort(ip, port))
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+/*
+ * 创建一个绑定在@laddr并连接到@raddr的UDP套接字，
+ * @laddr可以是非本机地址，用于以原始目标地址的身份回复TPROXY的客户端
+ */
+func TransparentDialUDP(laddr, raddr *net.UDPAddr) (*net.UDPConn, error) {
+	dialer := net.Dialer{
+		LocalAddr: laddr,
+		/* 同一个目标地址可能对应多个客户端 */
+		Control: transparentControl(func(fd int, network string) error {
+			return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		}),
+	}
+	conn, err := dialer.Dial("udp", raddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+/*
+ * 读取TPROXY转发过来的UDP数据包，同时返回数据包的来源地址和原始目标地址，
+ * 套接字需要由TransparentUDPListen创建，没有原始目标地址时返回nil
+ */
+func ReadFromUDPOrigDst(conn *net.UDPConn, b []byte) (int, *net.UDPAddr, *net.UDPAddr, error) {
+	oob := make([]byte, 64)
+	n, oobn, _, src, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, src, nil, nil
+	}
+	for _, msg := range msgs {
+		data := msg.Data
+		/* IP_ORIGDSTADDR和IP_RECVORIGDSTADDR的值相同，数据是sockaddr_in/sockaddr_in6 */
+		if msg.Header.Level == syscall.SOL_IP &&
+			msg.Header.Type == syscall.IP_RECVORIGDSTADDR && len(data) >= 8 {
+			dst := &net.UDPAddr{
+				IP:   net.IPv4(data[4], data[5], data[6], data[7]),
+				Port: int(data[2])<<8 + int(data[3]),
+			}
+			return n, src, dst, nil
+		} else if msg.Header.Level == syscall.SOL_IPV6 &&
+			msg.Header.Type == IPV6_RECVORIGDSTADDR && len(data) >= 24 {
+			dst := &net.UDPAddr{
+				IP:   net.IP(append([]byte{}, data[8:24]...)),
+				Port: int(data[2])<<8 + int(data[3]),
+			}
+			return n, src, dst, nil
+		}
+	}
+	return n, src, nil, nil
+}
+
+/* ip是否是本机网卡的地址 */
+func IsLocalIP(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 /*
  * 启动一个goroutine来接收网络数据，并转发给一个channel
  * 将对网络链接的监听转化为对channel的监听
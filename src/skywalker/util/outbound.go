@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+ * 出站连接的本地地址、网卡和防火墙标记，
+ * 有多条上行线路时，每个代理可以选择使用哪一条，
+ * 绑定网卡(SO_BINDTODEVICE)和设置标记(SO_MARK)只支持linux，需要CAP_NET_ADMIN或者CAP_NET_RAW权限
+ */
+
+type Outbound struct {
+	Addr      net.IP /* 本地地址，nil表示由系统选择 */
+	Interface string /* 网卡名，为空表示不绑定网卡 */
+	Mark      int    /* 防火墙标记，用于策略路由，0表示不设置 */
+}
+
+var (
+	gOutboundLock sync.RWMutex
+	gOutbounds    = map[string]*Outbound{} /* 以代理名区分的出站配置 */
+)
+
+/* 解析出站配置，都没有设置时返回nil */
+func ParseOutbound(addr, iface string, mark int) (*Outbound, error) {
+	if addr == "" && iface == "" && mark == 0 {
+		return nil, nil
+	}
+	o := &Outbound{Interface: iface, Mark: mark}
+	if addr != "" {
+		if o.Addr = net.ParseIP(addr); o.Addr == nil {
+			return nil, fmt.Errorf("invalid outbound address %s", addr)
+		}
+	}
+	if iface != "" {
+		if _, err := net.InterfaceByName(iface); err != nil {
+			return nil, fmt.Errorf("invalid outbound interface %s: %s", iface, err)
+		}
+	}
+	if mark < 0 {
+		return nil, fmt.Errorf("invalid fwmark %d", mark)
+	}
+	return o, nil
+}
+
+/* 设置代理的出站配置，o为nil表示使用系统默认 */
+func SetOutbound(proxy string, o *Outbound) {
+	gOutboundLock.Lock()
+	defer gOutboundLock.Unlock()
+	if o == nil {
+		delete(gOutbounds, proxy)
+	} else {
+		gOutbounds[proxy] = o
+	}
+}
+
+/* 返回代理的出站配置，没有配置时返回nil，nil也可以直接使用 */
+func GetOutbound(proxy string) *Outbound {
+	gOutboundLock.RLock()
+	defer gOutboundLock.RUnlock()
+	return gOutbounds[proxy]
+}
+
+func (o *Outbound) String() string {
+	if o == nil {
+		return "default"
+	}
+	s := "*"
+	if o.Addr != nil {
+		s = o.Addr.String()
+	}
+	if o.Interface != "" {
+		s += "%" + o.Interface
+	}
+	if o.Mark != 0 {
+		s += fmt.Sprintf(" mark 0x%x", o.Mark)
+	}
+	return s
+}
+
+/* 设置了本地地址时，只能连接相同类型的地址 */
+func (o *Outbound) Match(ip net.IP) bool {
+	if o == nil || o.Addr == nil {
+		return true
+	}
+	return (o.Addr.To4() == nil) == (ip.To4() == nil)
+}
+
+/* 本地IP，没有设置时返回nil */
+func (o *Outbound) IP() net.IP {
+	if o == nil {
+		return nil
+	}
+	return o.Addr
+}
+
+/* 创建连接之前设置网卡和标记 */
+func (o *Outbound) control(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if o.Interface != "" {
+			if serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, o.Interface); serr != nil {
+				return
+			}
+		}
+		if o.Mark != 0 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, o.Mark)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+/* 返回使用出站配置的Dialer，network是tcp或者udp */
+func (o *Outbound) Dialer(network string, timeout time.Duration) *net.Dialer {
+	dialer := &net.Dialer{Timeout: timeout}
+	if o == nil {
+		return dialer
+	}
+	dialer.Control = o.control
+	if o.Addr != nil {
+		/* LocalAddr的类型需要和network一致，否则会被忽略 */
+		if network == "udp" {
+			dialer.LocalAddr = &net.UDPAddr{IP: o.Addr}
+		} else {
+			dialer.LocalAddr = &net.TCPAddr{IP: o.Addr}
+		}
+	}
+	return dialer
+}
+
+/* 创建一个使用出站配置的未连接的UDP套接字，用于向多个地址发送数据 */
+func (o *Outbound) ListenUDP() (*net.UDPConn, error) {
+	if o == nil {
+		return net.ListenUDP("udp", nil)
+	}
+	lc := net.ListenConfig{Control: o.control}
+	addr := ":0"
+	if o.Addr != nil {
+		addr = JoinHostPort(o.Addr.String(), 0)
+	}
+	conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
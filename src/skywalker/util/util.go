@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"strconv"
 )
 
 func CopyBytes(src []byte, size int) []byte {
@@ -80,6 +81,30 @@ func GetMapIntDefault(m map[string]interface{}, name string, def int) int {
 	return def
 }
 
+/* 读取字符串列表，配置可以是单个值也可以是数组，数字会转换成字符串 */
+func GetMapStringList(m map[string]interface{}, name string) ([]string, error) {
+	val, ok := m[name]
+	if !ok {
+		return nil, nil
+	}
+	array, ok := val.([]interface{})
+	if !ok {
+		array = []interface{}{val}
+	}
+	var list []string
+	for _, e := range array {
+		switch v := e.(type) {
+		case string:
+			list = append(list, v)
+		case int:
+			list = append(list, strconv.Itoa(v))
+		default:
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+	}
+	return list, nil
+}
+
 /* 如果路径中已~开头，则将其展开成用户主目录 */
 func ResolveHomePath(fpath string) string {
 	if user, err := user.Current(); err == nil {
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2015 - 2017 Wiky Lyu
+ *
+ * This program is free software: you can redistribute it and/or modify it
+ * under the terms of the GNU General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful, but
+ * WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+ * See the GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.";
+ */
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+/*
+ * 用inotify监视文件的改变，只支持linux
+ * 监视的是文件所在的目录，编辑器先写临时文件再重命名的方式也能收到通知；
+ * 添加的路径的文件名可以是filepath.Match的模式，目录中符合模式的文件改变时都会通知
+ */
+
+const (
+	watch_MASK = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+		syscall.IN_CREATE | syscall.IN_DELETE
+)
+
+type FileWatcher struct {
+	C chan string /* 改变了的文件路径 */
+
+	lock     sync.Mutex
+	fd       int
+	file     *os.File        /* 用于读取事件，调用Fd()会把fd变成阻塞模式，因此另外保存fd */
+	dirs     map[int]string  /* 监视的目录 */
+	paths    map[string]bool /* 监视的文件的绝对路径 */
+	patterns map[string]bool /* 监视的模式的绝对路径 */
+}
+
+func NewFileWatcher() (*FileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &FileWatcher{
+		C:  make(chan string, 16),
+		fd: fd,
+		/* 非阻塞的fd交给runtime的poller，Close时Read会返回 */
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		dirs:     make(map[int]string),
+		paths:    make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+	go w.run()
+	return w, nil
+}
+
+/* 添加监视的文件或者模式，文件可以暂时不存在，但是所在的目录必须存在 */
+func (w *FileWatcher) Add(path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if _, err := filepath.Match(path, ""); err != nil {
+		return err
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watch_MASK)
+	if err != nil {
+		return err
+	}
+
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	w.dirs[wd] = dir
+	if strings.ContainsAny(filepath.Base(path), "*?[") {
+		w.patterns[path] = true
+	} else {
+		w.paths[path] = true
+	}
+	return nil
+}
+
+func (w *FileWatcher) Close() error {
+	return w.file.Close()
+}
+
+/* 读取inotify事件，关闭之后关闭C */
+func (w *FileWatcher) run() {
+	defer close(w.C)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			if path := w.match(int(event.Wd), name); path != "" {
+				select {
+				case w.C <- path:
+				default: /* 还没有处理的通知足够多了 */
+				}
+			}
+		}
+	}
+}
+
+/* 返回事件对应的文件，不是监视的文件时返回空字符串 */
+func (w *FileWatcher) match(wd int, name []byte) string {
+	defer w.lock.Unlock()
+	w.lock.Lock()
+	dir, ok := w.dirs[wd]
+	if !ok {
+		return ""
+	}
+	/* 文件名以\0结尾并且对齐填充 */
+	for i, c := range name {
+		if c == 0 {
+			name = name[:i]
+			break
+		}
+	}
+	path := filepath.Join(dir, string(name))
+	if w.paths[path] {
+		return path
+	}
+	for pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return path
+		}
+	}
+	return ""
+}